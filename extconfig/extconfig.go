@@ -12,6 +12,16 @@ import (
 // through environment variables. Learn more through the documentation of the envconfig package.
 // https://github.com/kelseyhightower/envconfig
 type Specification struct {
+	// ChaosImageUri is the image deployed to container image functions by the chaos code attack.
+	ChaosImageUri string `json:"chaosImageUri" split_words:"true" required:"false"`
+	// FailureInjectionLayerArn is the layer version attached to functions that are not wrapped with failure-lambda.
+	FailureInjectionLayerArn string `json:"failureInjectionLayerArn" split_words:"true" required:"false"`
+	// FailureInjectionWrapper is the wrapper script of the failure injection layer.
+	FailureInjectionWrapper string `json:"failureInjectionWrapper" split_words:"true" required:"false" default:"/opt/failure-injection-wrapper"`
+	// FailureInjectionParamPrefix is prepended to the function name to build the SSM parameter of attached functions.
+	FailureInjectionParamPrefix string `json:"failureInjectionParamPrefix" split_words:"true" required:"false" default:"/steadybit/failure-injection/"`
+	// FisRoleArn is the IAM role AWS FIS assumes to run the experiments of the FIS actions.
+	FisRoleArn string `json:"fisRoleArn" split_words:"true" required:"false"`
 }
 
 var (
@@ -28,6 +28,29 @@ func RegisterActionHandlers() {
 	exthttp.RegisterHttpHandler(actionBasePath+"/prepare", prepare)
 	exthttp.RegisterHttpHandler(actionBasePath+"/start", start)
 	exthttp.RegisterHttpHandler(actionBasePath+"/stop", stop)
+	registerEsmConfigActionHandlers()
+	registerEsmFilterActionHandlers()
+	registerCanaryActionHandlers()
+	registerAliasRollbackActionHandlers()
+	registerColdStartActionHandlers()
+	registerEgressBlackholeActionHandlers()
+	registerZoneOutageActionHandlers()
+	registerDnsFirewallActionHandlers()
+	registerDenyPolicyActionHandlers()
+	registerRevokePermissionActionHandlers()
+	registerAlbDeregisterActionHandlers()
+	registerDisableSchedulesActionHandlers()
+	registerSuspendPushTriggersActionHandlers()
+	registerRemoveDestinationsActionHandlers()
+	registerLayerSwapActionHandlers()
+	registerChaosCodeActionHandlers()
+	registerConcurrencyExhaustionActionHandlers()
+	registerFunctionUrlActionHandlers()
+	registerApiGatewayIntegrationActionHandlers()
+	registerFisActionHandlers()
+	registerObservabilityBlackoutActionHandlers()
+	registerPoisonMessagesActionHandlers()
+	registerPayloadFuzzingActionHandlers()
 }
 
 func GetActionEndpoints() action_kit_api.ActionList {
@@ -37,6 +60,106 @@ func GetActionEndpoints() action_kit_api.ActionList {
 				Method: "GET",
 				Path:   actionBasePath,
 			},
+			{
+				Method: "GET",
+				Path:   esmConfigActionBasePath,
+			},
+			{
+				Method: "GET",
+				Path:   esmFilterActionBasePath,
+			},
+			{
+				Method: "GET",
+				Path:   canaryActionBasePath,
+			},
+			{
+				Method: "GET",
+				Path:   aliasRollbackActionBasePath,
+			},
+			{
+				Method: "GET",
+				Path:   coldStartActionBasePath,
+			},
+			{
+				Method: "GET",
+				Path:   egressBlackholeActionBasePath,
+			},
+			{
+				Method: "GET",
+				Path:   zoneOutageActionBasePath,
+			},
+			{
+				Method: "GET",
+				Path:   dnsFirewallActionBasePath,
+			},
+			{
+				Method: "GET",
+				Path:   denyPolicyActionBasePath,
+			},
+			{
+				Method: "GET",
+				Path:   revokePermissionActionBasePath,
+			},
+			{
+				Method: "GET",
+				Path:   albDeregisterActionBasePath,
+			},
+			{
+				Method: "GET",
+				Path:   disableSchedulesActionBasePath,
+			},
+			{
+				Method: "GET",
+				Path:   suspendPushTriggersActionBasePath,
+			},
+			{
+				Method: "GET",
+				Path:   removeDestinationsActionBasePath,
+			},
+			{
+				Method: "GET",
+				Path:   layerSwapActionBasePath,
+			},
+			{
+				Method: "GET",
+				Path:   chaosCodeActionBasePath,
+			},
+			{
+				Method: "GET",
+				Path:   concurrencyExhaustionActionBasePath,
+			},
+			{
+				Method: "GET",
+				Path:   functionUrlActionBasePath,
+			},
+			{
+				Method: "GET",
+				Path:   apiGatewayIntegrationActionBasePath,
+			},
+			{
+				Method: "GET",
+				Path:   fisDelayActionBasePath,
+			},
+			{
+				Method: "GET",
+				Path:   fisErrorActionBasePath,
+			},
+			{
+				Method: "GET",
+				Path:   fisHttpResponseActionBasePath,
+			},
+			{
+				Method: "GET",
+				Path:   observabilityBlackoutActionBasePath,
+			},
+			{
+				Method: "GET",
+				Path:   poisonMessagesActionBasePath,
+			},
+			{
+				Method: "GET",
+				Path:   payloadFuzzingActionBasePath,
+			},
 		},
 	}
 }
@@ -102,6 +225,16 @@ func getActionDescription() action_kit_api.ActionDescription {
 				Required:     extutil.Ptr(true),
 				Order:        extutil.Ptr(1),
 			},
+			{
+				Name:         "attachLayer",
+				Label:        "Attach Failure Injection Layer",
+				Description:  extutil.Ptr("Temporarily attach the configured failure injection layer to functions that are not wrapped with failure-lambda."),
+				Type:         action_kit_api.Boolean,
+				DefaultValue: extutil.Ptr("false"),
+				Required:     extutil.Ptr(false),
+				Advanced:     extutil.Ptr(true),
+				Order:        extutil.Ptr(2),
+			},
 		},
 		Prepare: action_kit_api.MutatingEndpointReference{
 			Method: "POST",
@@ -128,9 +261,13 @@ type failureInjectionConfig struct {
 type LambdaActionState struct {
 	Param  string                 `json:"param"`
 	Config failureInjectionConfig `json:"config"`
+	// Attachment is set if the failure injection layer is attached for the duration of the attack
+	Attachment *failureInjectionAttachment `json:"attachment,omitempty"`
+	// ResetParam is set for the parameter created by the onboarding, which is disabled instead of deleted after the attack
+	ResetParam bool `json:"resetParam,omitempty"`
 }
 
-func prepare(w http.ResponseWriter, _ *http.Request, body []byte) {
+func prepare(w http.ResponseWriter, r *http.Request, body []byte) {
 	var request action_kit_api.PrepareActionRequestBody
 	err := json.Unmarshal(body, &request)
 	if err != nil {
@@ -138,9 +275,10 @@ func prepare(w http.ResponseWriter, _ *http.Request, body []byte) {
 		return
 	}
 
-	state, extErr := prepareState(&request)
+	state, extErr := prepareState(r.Context(), &request)
 	if extErr != nil {
 		exthttp.WriteError(w, *extErr)
+		return
 	}
 
 	var convertedState action_kit_api.ActionState
@@ -155,14 +293,25 @@ func prepare(w http.ResponseWriter, _ *http.Request, body []byte) {
 	})
 }
 
-func prepareState(request *action_kit_api.PrepareActionRequestBody) (*LambdaActionState, *extension_kit.ExtensionError) {
-	failureInjectionParam := request.Target.Attributes["aws.lambda.failure-injection-param"]
-	if failureInjectionParam == nil || len(failureInjectionParam) == 0 {
-		return nil, extutil.Ptr(extension_kit.ToError("Target is missing the 'aws.lambda.failure-injection-param' attribute. Did you wrap the lambda with https://github.com/gunnargrosch/failure-lambda ?", nil))
+func prepareState(ctx context.Context, request *action_kit_api.PrepareActionRequestBody) (*LambdaActionState, *extension_kit.ExtensionError) {
+	failureInjectionParam := targetAttribute(request.Target, "aws.lambda.failure-injection-param")
+	var attachment *failureInjectionAttachment
+	if failureInjectionParam == "" {
+		if !configBool(request.Config, "attachLayer") {
+			return nil, extutil.Ptr(extension_kit.ToError("Target is missing the 'aws.lambda.failure-injection-param' attribute. Did you wrap the lambda with https://github.com/gunnargrosch/failure-lambda ?", nil))
+		}
+		var extErr *extension_kit.ExtensionError
+		attachment, extErr = prepareFailureInjectionAttachment(ctx, request.Target)
+		if extErr != nil {
+			return nil, extErr
+		}
+		failureInjectionParam = attachment.Param
 	}
 
 	state := &LambdaActionState{
-		Param: failureInjectionParam[0],
+		Attachment: attachment,
+		Param:      failureInjectionParam,
+		ResetParam: attachment == nil && failureInjectionParam == onboardedParam(targetAttribute(request.Target, "aws.lambda.function-name")),
 		Config: failureInjectionConfig{
 			FailureMode: "statuscode",
 			Rate:        request.Config["rate"].(float64) / 100.0,
@@ -191,6 +340,18 @@ func start(w http.ResponseWriter, r *http.Request, body []byte) {
 	extErr := putFailureInjectionParameter(r.Context(), state)
 	if extErr != nil {
 		exthttp.WriteError(w, *extErr)
+		return
+	}
+
+	if state.Attachment != nil {
+		err = attachFailureInjection(r.Context(), *state.Attachment)
+		if err != nil {
+			// The update may have been applied even though waiting for it failed
+			_ = detachFailureInjection(r.Context(), *state.Attachment)
+			_ = deleteFailureInjectionParameter(r.Context(), state)
+			exthttp.WriteError(w, extension_kit.ToError("Failed to attach failure injection layer", err))
+			return
+		}
 	}
 
 	exthttp.WriteBody(w, action_kit_api.StartResult{})
@@ -245,6 +406,14 @@ func stop(w http.ResponseWriter, r *http.Request, body []byte) {
 		return
 	}
 
+	if state.Attachment != nil {
+		err = detachFailureInjection(r.Context(), *state.Attachment)
+		if err != nil {
+			exthttp.WriteError(w, extension_kit.ToError("Failed to detach failure injection layer", err))
+			return
+		}
+	}
+
 	extErr := deleteFailureInjectionParameter(r.Context(), state)
 	if extErr != nil {
 		exthttp.WriteError(w, *extErr)
@@ -260,6 +429,14 @@ func deleteFailureInjectionParameter(ctx context.Context, state LambdaActionStat
 		return extutil.Ptr(extension_kit.ToError("Failed to create ssm client", err))
 	}
 
+	if state.ResetParam {
+		_, err = putBaselineParameter(ctx, client, state.Param, true)
+		if err != nil {
+			return extutil.Ptr(extension_kit.ToError("Failed to reset ssm parameter", err))
+		}
+		return nil
+	}
+
 	_, err = client.DeleteParameter(ctx, &ssm.DeleteParameterInput{
 		Name: extutil.Ptr(state.Param),
 	})
@@ -0,0 +1,280 @@
+/*
+ * Copyright 2023 steadybit GmbH. All rights reserved.
+ */
+
+package extlambda
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	elb "github.com/aws/aws-sdk-go-v2/service/elasticloadbalancingv2"
+	elbtypes "github.com/aws/aws-sdk-go-v2/service/elasticloadbalancingv2/types"
+	"github.com/rs/zerolog/log"
+	"github.com/steadybit/action-kit/go/action_kit_api/v2"
+	extension_kit "github.com/steadybit/extension-kit"
+	"github.com/steadybit/extension-kit/extbuild"
+	"github.com/steadybit/extension-kit/extconversion"
+	"github.com/steadybit/extension-kit/exthttp"
+	"github.com/steadybit/extension-kit/extutil"
+	"net/http"
+	"strings"
+)
+
+const albDeregisterActionBasePath = basePath + "/actions/alb-deregister"
+
+func registerAlbDeregisterActionHandlers() {
+	exthttp.RegisterHttpHandler(albDeregisterActionBasePath, exthttp.GetterAsHandler(getAlbDeregisterActionDescription))
+	exthttp.RegisterHttpHandler(albDeregisterActionBasePath+"/prepare", prepareAlbDeregister)
+	exthttp.RegisterHttpHandler(albDeregisterActionBasePath+"/start", startAlbDeregister)
+	exthttp.RegisterHttpHandler(albDeregisterActionBasePath+"/stop", stopAlbDeregister)
+}
+
+func getAlbDeregisterActionDescription() action_kit_api.ActionDescription {
+	return action_kit_api.ActionDescription{
+		Id:          fmt.Sprintf("%s.albDeregister", targetID),
+		Version:     extbuild.GetSemverVersionStringOrUnknown(),
+		Label:       "Deregister from Load Balancer",
+		Description: "Deregisters the function from all Application Load Balancer target groups it is registered with.",
+		Icon:        extutil.Ptr(targetIcon),
+		TargetType:  extutil.Ptr(targetID),
+		TargetSelectionTemplates: extutil.Ptr([]action_kit_api.TargetSelectionTemplate{
+			{
+				Label: "by function name",
+				Query: "aws.lambda.function-name=\"\"",
+			},
+		}),
+		Category:    extutil.Ptr("cloud"),
+		Kind:        action_kit_api.Attack,
+		TimeControl: action_kit_api.External,
+		Parameters: []action_kit_api.ActionParameter{
+			{
+				Label:        "Duration",
+				Name:         "duration",
+				Type:         action_kit_api.Duration,
+				Description:  extutil.Ptr("The duration of the attack."),
+				Advanced:     extutil.Ptr(false),
+				Required:     extutil.Ptr(true),
+				DefaultValue: extutil.Ptr("60s"),
+				Order:        extutil.Ptr(0),
+			},
+		},
+		Prepare: action_kit_api.MutatingEndpointReference{
+			Method: "POST",
+			Path:   albDeregisterActionBasePath + "/prepare",
+		},
+		Start: action_kit_api.MutatingEndpointReference{
+			Method: "POST",
+			Path:   albDeregisterActionBasePath + "/start",
+		},
+		Stop: extutil.Ptr(action_kit_api.MutatingEndpointReference{
+			Method: "POST",
+			Path:   albDeregisterActionBasePath + "/stop",
+		}),
+	}
+}
+
+type albRegistration struct {
+	TargetGroupArn string `json:"targetGroupArn"`
+	TargetId       string `json:"targetId"`
+}
+
+type AlbDeregisterActionState struct {
+	FunctionArn   string            `json:"functionArn"`
+	Registrations []albRegistration `json:"registrations"`
+}
+
+func prepareAlbDeregister(w http.ResponseWriter, r *http.Request, body []byte) {
+	var request action_kit_api.PrepareActionRequestBody
+	err := json.Unmarshal(body, &request)
+	if err != nil {
+		exthttp.WriteError(w, extension_kit.ToError("Failed to parse request body", err))
+		return
+	}
+
+	state, extErr := prepareAlbDeregisterState(r.Context(), &request)
+	if extErr != nil {
+		exthttp.WriteError(w, *extErr)
+		return
+	}
+
+	var convertedState action_kit_api.ActionState
+	err = extconversion.Convert(state, &convertedState)
+	if err != nil {
+		exthttp.WriteError(w, extension_kit.ToError("Failed to encode action state", err))
+		return
+	}
+
+	exthttp.WriteBody(w, action_kit_api.PrepareResult{
+		State: convertedState,
+	})
+}
+
+func prepareAlbDeregisterState(ctx context.Context, request *action_kit_api.PrepareActionRequestBody) (*AlbDeregisterActionState, *extension_kit.ExtensionError) {
+	functionArn := targetAttribute(request.Target, "aws.arn")
+	if functionArn == "" {
+		return nil, extutil.Ptr(extension_kit.ToError("Target is missing the 'aws.arn' attribute.", nil))
+	}
+
+	client, err := createElbClient(ctx)
+	if err != nil {
+		return nil, extutil.Ptr(extension_kit.ToError("Failed to create elb client", err))
+	}
+
+	registrations, err := findAlbRegistrations(ctx, client, functionArn)
+	if err != nil {
+		return nil, extutil.Ptr(extension_kit.ToError("Failed to find target groups of the function", err))
+	}
+	if len(registrations) == 0 {
+		return nil, extutil.Ptr(extension_kit.ToError(fmt.Sprintf("Function %s is not registered with any target group.", functionArn), nil))
+	}
+
+	return &AlbDeregisterActionState{
+		FunctionArn:   functionArn,
+		Registrations: registrations,
+	}, nil
+}
+
+// findAlbRegistrations returns all lambda target group registrations of the function, including registrations of its aliases and versions.
+func findAlbRegistrations(ctx context.Context, client *elb.Client, functionArn string) ([]albRegistration, error) {
+	result := make([]albRegistration, 0)
+	var marker *string = nil
+	for {
+		output, err := client.DescribeTargetGroups(ctx, &elb.DescribeTargetGroupsInput{
+			Marker: marker,
+		})
+		if err != nil {
+			return result, err
+		}
+
+		for _, targetGroup := range output.TargetGroups {
+			if targetGroup.TargetType != elbtypes.TargetTypeEnumLambda {
+				continue
+			}
+			health, err := client.DescribeTargetHealth(ctx, &elb.DescribeTargetHealthInput{
+				TargetGroupArn: targetGroup.TargetGroupArn,
+			})
+			if err != nil {
+				return result, err
+			}
+			result = append(result, functionRegistrations(aws.ToString(targetGroup.TargetGroupArn), health.TargetHealthDescriptions, functionArn)...)
+		}
+
+		if output.NextMarker == nil {
+			break
+		} else {
+			marker = output.NextMarker
+		}
+	}
+	return result, nil
+}
+
+// functionRegistrations selects the targets of a lambda target group which are the function or one of its aliases and versions.
+func functionRegistrations(targetGroupArn string, descriptions []elbtypes.TargetHealthDescription, functionArn string) []albRegistration {
+	result := make([]albRegistration, 0)
+	for _, description := range descriptions {
+		if description.Target == nil {
+			continue
+		}
+		targetId := aws.ToString(description.Target.Id)
+		if targetId == functionArn || strings.HasPrefix(targetId, functionArn+":") {
+			result = append(result, albRegistration{
+				TargetGroupArn: targetGroupArn,
+				TargetId:       targetId,
+			})
+		}
+	}
+	return result
+}
+
+func registerAlbTarget(ctx context.Context, client *elb.Client, registration albRegistration) error {
+	_, err := client.RegisterTargets(ctx, &elb.RegisterTargetsInput{
+		TargetGroupArn: extutil.Ptr(registration.TargetGroupArn),
+		Targets:        []elbtypes.TargetDescription{{Id: extutil.Ptr(registration.TargetId)}},
+	})
+	return err
+}
+
+func startAlbDeregister(w http.ResponseWriter, r *http.Request, body []byte) {
+	var request action_kit_api.StartActionRequestBody
+	err := json.Unmarshal(body, &request)
+	if err != nil {
+		exthttp.WriteError(w, extension_kit.ToError("Failed to parse request body", err))
+		return
+	}
+
+	var state AlbDeregisterActionState
+	err = extconversion.Convert(request.State, &state)
+	if err != nil {
+		exthttp.WriteError(w, extension_kit.ToError("Failed to convert action state", err))
+		return
+	}
+
+	client, err := createElbClient(r.Context())
+	if err != nil {
+		exthttp.WriteError(w, extension_kit.ToError("Failed to create elb client", err))
+		return
+	}
+
+	for i, registration := range state.Registrations {
+		_, err = client.DeregisterTargets(r.Context(), &elb.DeregisterTargetsInput{
+			TargetGroupArn: extutil.Ptr(registration.TargetGroupArn),
+			Targets:        []elbtypes.TargetDescription{{Id: extutil.Ptr(registration.TargetId)}},
+		})
+		if err != nil {
+			// Roll back the registrations removed so far, as stop is not called for a failed start
+			for _, deregistered := range state.Registrations[:i] {
+				if err := registerAlbTarget(r.Context(), client, deregistered); err != nil {
+					log.Error().Err(err).Msgf("Failed to roll back deregistration of %s from target group %s.", deregistered.TargetId, deregistered.TargetGroupArn)
+				}
+			}
+			exthttp.WriteError(w, extension_kit.ToError(fmt.Sprintf("Failed to deregister from target group %s", registration.TargetGroupArn), err))
+			return
+		}
+	}
+
+	exthttp.WriteBody(w, action_kit_api.StartResult{})
+}
+
+func stopAlbDeregister(w http.ResponseWriter, r *http.Request, body []byte) {
+	var request action_kit_api.StopActionRequestBody
+	err := json.Unmarshal(body, &request)
+	if err != nil {
+		exthttp.WriteError(w, extension_kit.ToError("Failed to parse request body", err))
+		return
+	}
+
+	var state AlbDeregisterActionState
+	err = extconversion.Convert(request.State, &state)
+	if err != nil {
+		exthttp.WriteError(w, extension_kit.ToError("Failed to convert action state", err))
+		return
+	}
+
+	client, err := createElbClient(r.Context())
+	if err != nil {
+		exthttp.WriteError(w, extension_kit.ToError("Failed to create elb client", err))
+		return
+	}
+
+	for _, registration := range state.Registrations {
+		err = registerAlbTarget(r.Context(), client, registration)
+		if err != nil {
+			exthttp.WriteError(w, extension_kit.ToError(fmt.Sprintf("Failed to re-register with target group %s", registration.TargetGroupArn), err))
+			return
+		}
+	}
+
+	exthttp.WriteBody(w, action_kit_api.StopResult{})
+}
+
+func createElbClient(ctx context.Context) (*elb.Client, error) {
+	awsConfig, err := config.LoadDefaultConfig(ctx)
+	if err != nil {
+		return nil, err
+	}
+	client := elb.NewFromConfig(awsConfig)
+	return client, err
+}
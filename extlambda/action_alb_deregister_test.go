@@ -0,0 +1,53 @@
+/*
+ * Copyright 2023 steadybit GmbH. All rights reserved.
+ */
+
+package extlambda
+
+import (
+	elbtypes "github.com/aws/aws-sdk-go-v2/service/elasticloadbalancingv2/types"
+	"github.com/steadybit/extension-kit/extutil"
+	"reflect"
+	"testing"
+)
+
+func TestFunctionRegistrations(t *testing.T) {
+	functionArn := "arn:aws:lambda:eu-central-1:123456789012:function:my-function"
+	targetGroupArn := "arn:aws:elasticloadbalancing:eu-central-1:123456789012:targetgroup/my-group/0123456789abcdef"
+	tests := []struct {
+		name      string
+		targetIds []string
+		want      []albRegistration
+	}{
+		{name: "no targets", targetIds: nil, want: []albRegistration{}},
+		{
+			name:      "function",
+			targetIds: []string{functionArn},
+			want:      []albRegistration{{TargetGroupArn: targetGroupArn, TargetId: functionArn}},
+		},
+		{
+			name:      "alias and version",
+			targetIds: []string{functionArn + ":live", functionArn + ":3"},
+			want: []albRegistration{
+				{TargetGroupArn: targetGroupArn, TargetId: functionArn + ":live"},
+				{TargetGroupArn: targetGroupArn, TargetId: functionArn + ":3"},
+			},
+		},
+		{
+			name:      "ignores functions with the same prefix",
+			targetIds: []string{functionArn + "-v2", "arn:aws:lambda:eu-central-1:123456789012:function:other-function"},
+			want:      []albRegistration{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			descriptions := make([]elbtypes.TargetHealthDescription, 0)
+			for _, targetId := range tt.targetIds {
+				descriptions = append(descriptions, elbtypes.TargetHealthDescription{Target: &elbtypes.TargetDescription{Id: extutil.Ptr(targetId)}})
+			}
+			if got := functionRegistrations(targetGroupArn, descriptions, functionArn); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("functionRegistrations() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
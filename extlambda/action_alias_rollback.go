@@ -0,0 +1,374 @@
+/*
+ * Copyright 2023 steadybit GmbH. All rights reserved.
+ */
+
+package extlambda
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/lambda"
+	"github.com/aws/aws-sdk-go-v2/service/lambda/types"
+	"github.com/steadybit/action-kit/go/action_kit_api/v2"
+	extension_kit "github.com/steadybit/extension-kit"
+	"github.com/steadybit/extension-kit/extbuild"
+	"github.com/steadybit/extension-kit/extconversion"
+	"github.com/steadybit/extension-kit/exthttp"
+	"github.com/steadybit/extension-kit/extutil"
+	"net/http"
+	"sort"
+	"strconv"
+)
+
+const aliasRollbackActionBasePath = basePath + "/actions/alias-rollback"
+
+func registerAliasRollbackActionHandlers() {
+	exthttp.RegisterHttpHandler(aliasRollbackActionBasePath, exthttp.GetterAsHandler(getAliasRollbackActionDescription))
+	exthttp.RegisterHttpHandler(aliasRollbackActionBasePath+"/prepare", prepareAliasRollback)
+	exthttp.RegisterHttpHandler(aliasRollbackActionBasePath+"/start", startAliasRollback)
+	exthttp.RegisterHttpHandler(aliasRollbackActionBasePath+"/stop", stopAliasRollback)
+}
+
+func getAliasRollbackActionDescription() action_kit_api.ActionDescription {
+	return action_kit_api.ActionDescription{
+		Id:          fmt.Sprintf("%s.aliasRollback", targetID),
+		Version:     extbuild.GetSemverVersionStringOrUnknown(),
+		Label:       "Roll Back Alias",
+		Description: "Points an alias to an older published version of the function.",
+		Icon:        extutil.Ptr(targetIcon),
+		TargetType:  extutil.Ptr(targetID),
+		TargetSelectionTemplates: extutil.Ptr([]action_kit_api.TargetSelectionTemplate{
+			{
+				Label: "by function name",
+				Query: "aws.lambda.function-name=\"\"",
+			},
+		}),
+		Category:    extutil.Ptr("cloud"),
+		Kind:        action_kit_api.Attack,
+		TimeControl: action_kit_api.External,
+		Parameters: []action_kit_api.ActionParameter{
+			{
+				Label:        "Duration",
+				Name:         "duration",
+				Type:         action_kit_api.Duration,
+				Description:  extutil.Ptr("The duration of the attack."),
+				Advanced:     extutil.Ptr(false),
+				Required:     extutil.Ptr(true),
+				DefaultValue: extutil.Ptr("30s"),
+				Order:        extutil.Ptr(0),
+			},
+			{
+				Name:        "alias",
+				Label:       "Alias",
+				Description: extutil.Ptr("The alias to roll back."),
+				Type:        action_kit_api.String,
+				Required:    extutil.Ptr(true),
+				Order:       extutil.Ptr(1),
+				Options: extutil.Ptr([]action_kit_api.ParameterOption{
+					action_kit_api.ParameterOptionsFromTargetAttribute{Attribute: "aws.lambda.alias"},
+				}),
+			},
+			{
+				Name:         "offset",
+				Label:        "Versions Back",
+				Description:  extutil.Ptr("How many published versions to go back from the alias' current version."),
+				Type:         action_kit_api.Integer,
+				DefaultValue: extutil.Ptr("1"),
+				Required:     extutil.Ptr(false),
+				Order:        extutil.Ptr(2),
+			},
+			{
+				Name:        "version",
+				Label:       "Version",
+				Description: extutil.Ptr("Roll back to this version number instead. Overrides 'Versions Back'."),
+				Type:        action_kit_api.String,
+				Required:    extutil.Ptr(false),
+				Advanced:    extutil.Ptr(true),
+				Order:       extutil.Ptr(3),
+			},
+		},
+		Prepare: action_kit_api.MutatingEndpointReference{
+			Method: "POST",
+			Path:   aliasRollbackActionBasePath + "/prepare",
+		},
+		Start: action_kit_api.MutatingEndpointReference{
+			Method: "POST",
+			Path:   aliasRollbackActionBasePath + "/start",
+		},
+		Stop: extutil.Ptr(action_kit_api.MutatingEndpointReference{
+			Method: "POST",
+			Path:   aliasRollbackActionBasePath + "/stop",
+		}),
+	}
+}
+
+type AliasRollbackActionState struct {
+	FunctionArn     string             `json:"functionArn"`
+	Alias           string             `json:"alias"`
+	OriginalVersion string             `json:"originalVersion"`
+	OriginalWeights map[string]float64 `json:"originalWeights"`
+	TargetVersion   string             `json:"targetVersion"`
+	RevisionId      string             `json:"revisionId"`
+}
+
+func prepareAliasRollback(w http.ResponseWriter, r *http.Request, body []byte) {
+	var request action_kit_api.PrepareActionRequestBody
+	err := json.Unmarshal(body, &request)
+	if err != nil {
+		exthttp.WriteError(w, extension_kit.ToError("Failed to parse request body", err))
+		return
+	}
+
+	state, extErr := prepareAliasRollbackState(r.Context(), &request)
+	if extErr != nil {
+		exthttp.WriteError(w, *extErr)
+		return
+	}
+
+	var convertedState action_kit_api.ActionState
+	err = extconversion.Convert(state, &convertedState)
+	if err != nil {
+		exthttp.WriteError(w, extension_kit.ToError("Failed to encode action state", err))
+		return
+	}
+
+	exthttp.WriteBody(w, action_kit_api.PrepareResult{
+		State: convertedState,
+	})
+}
+
+func prepareAliasRollbackState(ctx context.Context, request *action_kit_api.PrepareActionRequestBody) (*AliasRollbackActionState, *extension_kit.ExtensionError) {
+	functionArn := targetAttribute(request.Target, "aws.arn")
+	if functionArn == "" {
+		return nil, extutil.Ptr(extension_kit.ToError("Target is missing the 'aws.arn' attribute.", nil))
+	}
+	alias := configString(request.Config, "alias")
+	if alias == "" {
+		return nil, extutil.Ptr(extension_kit.ToError("The alias must not be empty.", nil))
+	}
+
+	client, err := createLambdaClient(ctx)
+	if err != nil {
+		return nil, extutil.Ptr(extension_kit.ToError("Failed to create lambda client", err))
+	}
+
+	aliasConfig, err := client.GetAlias(ctx, &lambda.GetAliasInput{
+		FunctionName: extutil.Ptr(functionArn),
+		Name:         extutil.Ptr(alias),
+	})
+	if err != nil {
+		return nil, extutil.Ptr(extension_kit.ToError(fmt.Sprintf("Failed to get alias %s", alias), err))
+	}
+	currentVersion := aws.ToString(aliasConfig.FunctionVersion)
+
+	versions, err := listPublishedVersions(ctx, client, functionArn)
+	if err != nil {
+		return nil, extutil.Ptr(extension_kit.ToError("Failed to list function versions", err))
+	}
+
+	targetVersion := configString(request.Config, "version")
+	if targetVersion == "" {
+		offset := 1
+		if configured := configInt32(request.Config, "offset"); configured != nil {
+			offset = int(*configured)
+		}
+		targetVersion, err = previousVersion(versions, currentVersion, offset)
+		if err != nil {
+			return nil, extutil.Ptr(extension_kit.ToError(fmt.Sprintf("Failed to determine the version to roll alias %s back to", alias), err))
+		}
+	} else if !containsVersion(versions, targetVersion) {
+		return nil, extutil.Ptr(extension_kit.ToError(fmt.Sprintf("Function %s has no published version %s.", functionArn, targetVersion), nil))
+	}
+
+	originalWeights := map[string]float64{}
+	if aliasConfig.RoutingConfig != nil && aliasConfig.RoutingConfig.AdditionalVersionWeights != nil {
+		originalWeights = aliasConfig.RoutingConfig.AdditionalVersionWeights
+	}
+
+	return &AliasRollbackActionState{
+		FunctionArn:     functionArn,
+		Alias:           alias,
+		OriginalVersion: currentVersion,
+		OriginalWeights: originalWeights,
+		TargetVersion:   targetVersion,
+		RevisionId:      aws.ToString(aliasConfig.RevisionId),
+	}, nil
+}
+
+// listPublishedVersions returns the function's published versions in ascending order, excluding $LATEST.
+func listPublishedVersions(ctx context.Context, client *lambda.Client, functionArn string) ([]int, error) {
+	result := make([]int, 0)
+	var marker *string = nil
+	for {
+		output, err := client.ListVersionsByFunction(ctx, &lambda.ListVersionsByFunctionInput{
+			FunctionName: extutil.Ptr(functionArn),
+			Marker:       marker,
+		})
+		if err != nil {
+			return result, err
+		}
+
+		for _, version := range output.Versions {
+			if number, err := strconv.Atoi(aws.ToString(version.Version)); err == nil {
+				result = append(result, number)
+			}
+		}
+
+		if output.NextMarker == nil {
+			break
+		} else {
+			marker = output.NextMarker
+		}
+	}
+	sort.Ints(result)
+	return result, nil
+}
+
+func previousVersion(versions []int, current string, offset int) (string, error) {
+	currentNumber, err := strconv.Atoi(current)
+	if err != nil {
+		return "", fmt.Errorf("alias points to %s, which is not a published version", current)
+	}
+	index := sort.SearchInts(versions, currentNumber)
+	if index-offset < 0 || offset < 1 {
+		return "", fmt.Errorf("there is no published version %d versions before %s", offset, current)
+	}
+	return strconv.Itoa(versions[index-offset]), nil
+}
+
+func containsVersion(versions []int, version string) bool {
+	number, err := strconv.Atoi(version)
+	if err != nil {
+		return false
+	}
+	index := sort.SearchInts(versions, number)
+	return index < len(versions) && versions[index] == number
+}
+
+func startAliasRollback(w http.ResponseWriter, r *http.Request, body []byte) {
+	var request action_kit_api.StartActionRequestBody
+	err := json.Unmarshal(body, &request)
+	if err != nil {
+		exthttp.WriteError(w, extension_kit.ToError("Failed to parse request body", err))
+		return
+	}
+
+	var state AliasRollbackActionState
+	err = extconversion.Convert(request.State, &state)
+	if err != nil {
+		exthttp.WriteError(w, extension_kit.ToError("Failed to convert action state", err))
+		return
+	}
+
+	client, err := createLambdaClient(r.Context())
+	if err != nil {
+		exthttp.WriteError(w, extension_kit.ToError("Failed to create lambda client", err))
+		return
+	}
+
+	// Weighted routing is dropped during the attack, the whole alias traffic goes to the old version.
+	output, err := client.UpdateAlias(r.Context(), &lambda.UpdateAliasInput{
+		FunctionName:    extutil.Ptr(state.FunctionArn),
+		Name:            extutil.Ptr(state.Alias),
+		FunctionVersion: extutil.Ptr(state.TargetVersion),
+		RoutingConfig:   &types.AliasRoutingConfiguration{AdditionalVersionWeights: map[string]float64{}},
+		RevisionId:      extutil.Ptr(state.RevisionId),
+	})
+	if err != nil {
+		exthttp.WriteError(w, extension_kit.ToError(fmt.Sprintf("Failed to point alias %s to version %s", state.Alias, state.TargetVersion), err))
+		return
+	}
+	state.RevisionId = aws.ToString(output.RevisionId)
+
+	var convertedState action_kit_api.ActionState
+	err = extconversion.Convert(state, &convertedState)
+	if err != nil {
+		exthttp.WriteError(w, extension_kit.ToError("Failed to encode action state", err))
+		return
+	}
+
+	exthttp.WriteBody(w, action_kit_api.StartResult{
+		State: &convertedState,
+	})
+}
+
+func stopAliasRollback(w http.ResponseWriter, r *http.Request, body []byte) {
+	var request action_kit_api.StopActionRequestBody
+	err := json.Unmarshal(body, &request)
+	if err != nil {
+		exthttp.WriteError(w, extension_kit.ToError("Failed to parse request body", err))
+		return
+	}
+
+	var state AliasRollbackActionState
+	err = extconversion.Convert(request.State, &state)
+	if err != nil {
+		exthttp.WriteError(w, extension_kit.ToError("Failed to convert action state", err))
+		return
+	}
+
+	client, err := createLambdaClient(r.Context())
+	if err != nil {
+		exthttp.WriteError(w, extension_kit.ToError("Failed to create lambda client", err))
+		return
+	}
+
+	// The alias is re-read, as it may have been changed after the start or the start may not have happened at all
+	current, err := client.GetAlias(r.Context(), &lambda.GetAliasInput{
+		FunctionName: extutil.Ptr(state.FunctionArn),
+		Name:         extutil.Ptr(state.Alias),
+	})
+	if err != nil {
+		exthttp.WriteError(w, extension_kit.ToError(fmt.Sprintf("Failed to get alias %s", state.Alias), err))
+		return
+	}
+	if isAliasRouting(current, state.OriginalVersion, state.OriginalWeights) {
+		exthttp.WriteBody(w, action_kit_api.StopResult{})
+		return
+	}
+	if !isAliasRouting(current, state.TargetVersion, nil) {
+		exthttp.WriteBody(w, action_kit_api.StopResult{
+			Messages: extutil.Ptr([]action_kit_api.Message{{
+				Level:   extutil.Ptr(action_kit_api.Warn),
+				Message: fmt.Sprintf("Alias %s was changed during the attack and is not restored to version %s.", state.Alias, state.OriginalVersion),
+			}}),
+		})
+		return
+	}
+
+	_, err = client.UpdateAlias(r.Context(), &lambda.UpdateAliasInput{
+		FunctionName:    extutil.Ptr(state.FunctionArn),
+		Name:            extutil.Ptr(state.Alias),
+		FunctionVersion: extutil.Ptr(state.OriginalVersion),
+		RoutingConfig:   &types.AliasRoutingConfiguration{AdditionalVersionWeights: state.OriginalWeights},
+		RevisionId:      current.RevisionId,
+	})
+	if err != nil {
+		exthttp.WriteError(w, extension_kit.ToError(fmt.Sprintf("Failed to restore alias %s to version %s", state.Alias, state.OriginalVersion), err))
+		return
+	}
+
+	exthttp.WriteBody(w, action_kit_api.StopResult{})
+}
+
+// isAliasRouting checks whether the alias routes its traffic exactly as given.
+func isAliasRouting(alias *lambda.GetAliasOutput, version string, weights map[string]float64) bool {
+	if aws.ToString(alias.FunctionVersion) != version {
+		return false
+	}
+	current := map[string]float64{}
+	if alias.RoutingConfig != nil && alias.RoutingConfig.AdditionalVersionWeights != nil {
+		current = alias.RoutingConfig.AdditionalVersionWeights
+	}
+	if len(current) != len(weights) {
+		return false
+	}
+	for key, weight := range weights {
+		if existing, ok := current[key]; !ok || existing != weight {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,92 @@
+/*
+ * Copyright 2023 steadybit GmbH. All rights reserved.
+ */
+
+package extlambda
+
+import (
+	"github.com/aws/aws-sdk-go-v2/service/lambda"
+	"github.com/aws/aws-sdk-go-v2/service/lambda/types"
+	"github.com/steadybit/extension-kit/extutil"
+	"testing"
+)
+
+func TestPreviousVersion(t *testing.T) {
+	versions := []int{1, 2, 5, 7}
+	tests := []struct {
+		name    string
+		current string
+		offset  int
+		want    string
+		wantErr bool
+	}{
+		{name: "previous version", current: "7", offset: 1, want: "5"},
+		{name: "skips gaps", current: "5", offset: 2, want: "1"},
+		{name: "first version has no predecessor", current: "1", offset: 1, wantErr: true},
+		{name: "offset beyond the first version", current: "5", offset: 3, wantErr: true},
+		{name: "offset must be positive", current: "5", offset: 0, wantErr: true},
+		{name: "alias on $LATEST", current: "$LATEST", offset: 1, wantErr: true},
+		{name: "deleted current version", current: "6", offset: 1, want: "5"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := previousVersion(versions, tt.current, tt.offset)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("previousVersion() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("previousVersion() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestContainsVersion(t *testing.T) {
+	versions := []int{1, 2, 5, 7}
+	for version, want := range map[string]bool{"1": true, "5": true, "7": true, "3": false, "8": false, "$LATEST": false, "": false} {
+		if got := containsVersion(versions, version); got != want {
+			t.Errorf("containsVersion(%q) = %v, want %v", version, got, want)
+		}
+	}
+}
+
+func TestIsAliasRouting(t *testing.T) {
+	tests := []struct {
+		name    string
+		alias   lambda.GetAliasOutput
+		version string
+		weights map[string]float64
+		want    bool
+	}{
+		{name: "same version without weights", alias: lambda.GetAliasOutput{FunctionVersion: extutil.Ptr("3")}, version: "3", want: true},
+		{name: "empty routing config", alias: lambda.GetAliasOutput{FunctionVersion: extutil.Ptr("3"), RoutingConfig: &types.AliasRoutingConfiguration{}}, version: "3", want: true},
+		{name: "other version", alias: lambda.GetAliasOutput{FunctionVersion: extutil.Ptr("4")}, version: "3", want: false},
+		{
+			name:    "same weights",
+			alias:   lambda.GetAliasOutput{FunctionVersion: extutil.Ptr("3"), RoutingConfig: &types.AliasRoutingConfiguration{AdditionalVersionWeights: map[string]float64{"4": 0.1}}},
+			version: "3",
+			weights: map[string]float64{"4": 0.1},
+			want:    true,
+		},
+		{
+			name:    "changed weight",
+			alias:   lambda.GetAliasOutput{FunctionVersion: extutil.Ptr("3"), RoutingConfig: &types.AliasRoutingConfiguration{AdditionalVersionWeights: map[string]float64{"4": 0.2}}},
+			version: "3",
+			weights: map[string]float64{"4": 0.1},
+			want:    false,
+		},
+		{
+			name:    "added weights",
+			alias:   lambda.GetAliasOutput{FunctionVersion: extutil.Ptr("3"), RoutingConfig: &types.AliasRoutingConfiguration{AdditionalVersionWeights: map[string]float64{"4": 0.1}}},
+			version: "3",
+			want:    false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := isAliasRouting(&tt.alias, tt.version, tt.weights); got != tt.want {
+				t.Errorf("isAliasRouting() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
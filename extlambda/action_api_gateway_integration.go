@@ -0,0 +1,923 @@
+/*
+ * Copyright 2023 steadybit GmbH. All rights reserved.
+ */
+
+package extlambda
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	apigw "github.com/aws/aws-sdk-go-v2/service/apigateway"
+	apigwtypes "github.com/aws/aws-sdk-go-v2/service/apigateway/types"
+	apigwv2 "github.com/aws/aws-sdk-go-v2/service/apigatewayv2"
+	apigwv2types "github.com/aws/aws-sdk-go-v2/service/apigatewayv2/types"
+	"github.com/rs/zerolog/log"
+	"github.com/steadybit/action-kit/go/action_kit_api/v2"
+	extension_kit "github.com/steadybit/extension-kit"
+	"github.com/steadybit/extension-kit/extbuild"
+	"github.com/steadybit/extension-kit/extconversion"
+	"github.com/steadybit/extension-kit/exthttp"
+	"github.com/steadybit/extension-kit/extutil"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+const (
+	apiGatewayIntegrationActionBasePath = basePath + "/actions/api-gateway-integration"
+	// minimumIntegrationTimeout is the lowest integration timeout API Gateway accepts.
+	minimumIntegrationTimeout = 50
+	// defaultRestIntegrationTimeout and defaultHttpIntegrationTimeout apply when an integration reports no timeout.
+	defaultRestIntegrationTimeout = 29000
+	defaultHttpIntegrationTimeout = 30000
+)
+
+func registerApiGatewayIntegrationActionHandlers() {
+	exthttp.RegisterHttpHandler(apiGatewayIntegrationActionBasePath, exthttp.GetterAsHandler(getApiGatewayIntegrationActionDescription))
+	exthttp.RegisterHttpHandler(apiGatewayIntegrationActionBasePath+"/prepare", prepareApiGatewayIntegration)
+	exthttp.RegisterHttpHandler(apiGatewayIntegrationActionBasePath+"/start", startApiGatewayIntegration)
+	exthttp.RegisterHttpHandler(apiGatewayIntegrationActionBasePath+"/stop", stopApiGatewayIntegration)
+}
+
+func getApiGatewayIntegrationActionDescription() action_kit_api.ActionDescription {
+	return action_kit_api.ActionDescription{
+		Id:          fmt.Sprintf("%s.apiGatewayIntegration", targetID),
+		Version:     extbuild.GetSemverVersionStringOrUnknown(),
+		Label:       "Break API Gateway Integration",
+		Description: "Repoints the API Gateway integrations of the function to a mock returning a status code or shortens their timeout, then deploys the selected stages. Deploying also publishes other undeployed changes of the APIs. On stop the stages are pointed back to their previous deployments.",
+		Icon:        extutil.Ptr(targetIcon),
+		TargetType:  extutil.Ptr(targetID),
+		TargetSelectionTemplates: extutil.Ptr([]action_kit_api.TargetSelectionTemplate{
+			{
+				Label: "by function name",
+				Query: "aws.lambda.function-name=\"\"",
+			},
+		}),
+		Category:    extutil.Ptr("cloud"),
+		Kind:        action_kit_api.Attack,
+		TimeControl: action_kit_api.External,
+		Parameters: []action_kit_api.ActionParameter{
+			{
+				Label:        "Duration",
+				Name:         "duration",
+				Type:         action_kit_api.Duration,
+				Description:  extutil.Ptr("The duration of the attack."),
+				Advanced:     extutil.Ptr(false),
+				Required:     extutil.Ptr(true),
+				DefaultValue: extutil.Ptr("60s"),
+				Order:        extutil.Ptr(0),
+			},
+			{
+				Name:         "mode",
+				Label:        "Mode",
+				Description:  extutil.Ptr("Mock status codes are only supported by REST APIs, HTTP APIs are skipped in this mode. Shortening the integration timeout fails only invocations running longer than the timeout with 504, it does not delay responses."),
+				Type:         action_kit_api.String,
+				DefaultValue: extutil.Ptr("status"),
+				Required:     extutil.Ptr(true),
+				Order:        extutil.Ptr(1),
+				Options: extutil.Ptr([]action_kit_api.ParameterOption{
+					action_kit_api.ExplicitParameterOption{Label: "Mock status code", Value: "status"},
+					action_kit_api.ExplicitParameterOption{Label: "Shorten integration timeout", Value: "shortenTimeout"},
+				}),
+			},
+			{
+				Name:         "statusCode",
+				Label:        "Status Code",
+				Description:  extutil.Ptr("The status code returned by the mock integration."),
+				Type:         action_kit_api.Integer,
+				DefaultValue: extutil.Ptr("503"),
+				Required:     extutil.Ptr(false),
+				Order:        extutil.Ptr(2),
+			},
+			{
+				Name:         "timeout",
+				Label:        "Integration Timeout",
+				Description:  extutil.Ptr("The shortened integration timeout, at least 50ms. API Gateway responds with 504 to invocations running longer, faster invocations are not delayed."),
+				Type:         action_kit_api.Duration,
+				DefaultValue: extutil.Ptr("50ms"),
+				Required:     extutil.Ptr(false),
+				Order:        extutil.Ptr(3),
+			},
+			{
+				Name:        "stages",
+				Label:       "Stages",
+				Description: extutil.Ptr("The stages to deploy the changed integrations to. HTTP API stages with auto deploy receive the changes regardless."),
+				Type:        action_kit_api.StringArray,
+				Required:    extutil.Ptr(true),
+				Order:       extutil.Ptr(4),
+			},
+		},
+		Prepare: action_kit_api.MutatingEndpointReference{
+			Method: "POST",
+			Path:   apiGatewayIntegrationActionBasePath + "/prepare",
+		},
+		Start: action_kit_api.MutatingEndpointReference{
+			Method: "POST",
+			Path:   apiGatewayIntegrationActionBasePath + "/start",
+		},
+		Stop: extutil.Ptr(action_kit_api.MutatingEndpointReference{
+			Method: "POST",
+			Path:   apiGatewayIntegrationActionBasePath + "/stop",
+		}),
+	}
+}
+
+type restIntegration struct {
+	RestApiId  string                 `json:"restApiId"`
+	ResourceId string                 `json:"resourceId"`
+	Path       string                 `json:"path"`
+	HttpMethod string                 `json:"httpMethod"`
+	Original   apigwtypes.Integration `json:"original"`
+	// CreatedMethodResponse is set if the method had no response for the mocked status code
+	CreatedMethodResponse bool `json:"createdMethodResponse"`
+}
+
+type httpIntegration struct {
+	ApiId           string `json:"apiId"`
+	IntegrationId   string `json:"integrationId"`
+	TimeoutInMillis int32  `json:"timeoutInMillis"`
+}
+
+type stageDeployment struct {
+	ApiId     string `json:"apiId"`
+	Http      bool   `json:"http"`
+	StageName string `json:"stageName"`
+	// OriginalDeploymentId is the deployment the stage pointed to before the attack
+	OriginalDeploymentId string `json:"originalDeploymentId"`
+	// DeploymentId is the deployment created by the attack
+	DeploymentId string `json:"deploymentId"`
+}
+
+type ApiGatewayIntegrationActionState struct {
+	FunctionArn      string            `json:"functionArn"`
+	Mode             string            `json:"mode"`
+	StatusCode       string            `json:"statusCode"`
+	TimeoutInMillis  int32             `json:"timeoutInMillis"`
+	Stages           []string          `json:"stages"`
+	RestIntegrations []restIntegration `json:"restIntegrations"`
+	HttpIntegrations []httpIntegration `json:"httpIntegrations"`
+	Deployments      []stageDeployment `json:"deployments"`
+}
+
+func prepareApiGatewayIntegration(w http.ResponseWriter, r *http.Request, body []byte) {
+	var request action_kit_api.PrepareActionRequestBody
+	err := json.Unmarshal(body, &request)
+	if err != nil {
+		exthttp.WriteError(w, extension_kit.ToError("Failed to parse request body", err))
+		return
+	}
+
+	state, messages, extErr := prepareApiGatewayIntegrationState(r.Context(), &request)
+	if extErr != nil {
+		exthttp.WriteError(w, *extErr)
+		return
+	}
+
+	var convertedState action_kit_api.ActionState
+	err = extconversion.Convert(state, &convertedState)
+	if err != nil {
+		exthttp.WriteError(w, extension_kit.ToError("Failed to encode action state", err))
+		return
+	}
+
+	exthttp.WriteBody(w, action_kit_api.PrepareResult{
+		State:    convertedState,
+		Messages: extutil.Ptr(messages),
+	})
+}
+
+func prepareApiGatewayIntegrationState(ctx context.Context, request *action_kit_api.PrepareActionRequestBody) (*ApiGatewayIntegrationActionState, []action_kit_api.Message, *extension_kit.ExtensionError) {
+	functionArn := targetAttribute(request.Target, "aws.arn")
+	if functionArn == "" {
+		return nil, nil, extutil.Ptr(extension_kit.ToError("Target is missing the 'aws.arn' attribute.", nil))
+	}
+
+	state := &ApiGatewayIntegrationActionState{
+		FunctionArn:      functionArn,
+		Mode:             configString(request.Config, "mode"),
+		Stages:           configStrings(request.Config, "stages"),
+		RestIntegrations: make([]restIntegration, 0),
+		HttpIntegrations: make([]httpIntegration, 0),
+		Deployments:      make([]stageDeployment, 0),
+	}
+	if len(state.Stages) == 0 {
+		return nil, nil, extutil.Ptr(extension_kit.ToError("At least one stage must be selected.", nil))
+	}
+	switch state.Mode {
+	case "status":
+		statusCode := aws.ToInt32(configInt32(request.Config, "statusCode"))
+		if statusCode < 100 || statusCode > 599 {
+			return nil, nil, extutil.Ptr(extension_kit.ToError(fmt.Sprintf("Invalid status code %d.", statusCode), nil))
+		}
+		state.StatusCode = strconv.Itoa(int(statusCode))
+	case "shortenTimeout":
+		state.TimeoutInMillis = int32(configDuration(request.Config, "timeout").Milliseconds())
+		if state.TimeoutInMillis < minimumIntegrationTimeout {
+			state.TimeoutInMillis = minimumIntegrationTimeout
+		}
+	default:
+		return nil, nil, extutil.Ptr(extension_kit.ToError(fmt.Sprintf("Unknown mode '%s'.", state.Mode), nil))
+	}
+
+	restIntegrations, err := findRestIntegrations(ctx, functionArn)
+	if err != nil {
+		return nil, nil, extutil.Ptr(extension_kit.ToError("Failed to find REST API integrations", err))
+	}
+	state.RestIntegrations = restIntegrations
+
+	messages := make([]action_kit_api.Message, 0)
+	httpIntegrations, err := findHttpIntegrations(ctx, functionArn)
+	if err != nil {
+		return nil, nil, extutil.Ptr(extension_kit.ToError("Failed to find HTTP API integrations", err))
+	}
+	if state.Mode == "shortenTimeout" {
+		state.HttpIntegrations = httpIntegrations
+	} else if len(httpIntegrations) > 0 {
+		messages = append(messages, action_kit_api.Message{
+			Level:   extutil.Ptr(action_kit_api.Warn),
+			Message: fmt.Sprintf("Skipping %d HTTP API integrations, HTTP APIs do not support mock integrations.", len(httpIntegrations)),
+		})
+	}
+
+	if len(state.RestIntegrations) == 0 && len(state.HttpIntegrations) == 0 {
+		return nil, nil, extutil.Ptr(extension_kit.ToError(fmt.Sprintf("No API Gateway integration invokes %s.", functionArn), nil))
+	}
+
+	deployments, stageMessages, err := findStageDeployments(ctx, state)
+	if err != nil {
+		return nil, nil, extutil.Ptr(extension_kit.ToError("Failed to find API Gateway stages", err))
+	}
+	messages = append(messages, stageMessages...)
+	if len(deployments) == 0 {
+		return nil, nil, extutil.Ptr(extension_kit.ToError(fmt.Sprintf("None of the stages %s exists in the affected APIs.", strings.Join(state.Stages, ", ")), nil))
+	}
+	state.Deployments = deployments
+	return state, messages, nil
+}
+
+// integrationTimeout returns the timeout to restore, integrations without an explicit timeout report 0, which API Gateway rejects.
+func integrationTimeout(timeoutInMillis int32, defaultTimeoutInMillis int32) int32 {
+	if timeoutInMillis < minimumIntegrationTimeout {
+		return defaultTimeoutInMillis
+	}
+	return timeoutInMillis
+}
+
+// isLambdaIntegrationUri reports whether the integration URI invokes the function or one of its aliases and versions.
+// URIs are either the plain function ARN or 'arn:aws:apigateway:<region>:lambda:path/2015-03-31/functions/<function ARN>/invocations'.
+func isLambdaIntegrationUri(uri string, functionArn string) bool {
+	index := strings.Index(uri, functionArn)
+	if index < 0 {
+		return false
+	}
+	rest := uri[index+len(functionArn):]
+	return rest == "" || strings.HasPrefix(rest, ":") || strings.HasPrefix(rest, "/")
+}
+
+func findRestIntegrations(ctx context.Context, functionArn string) ([]restIntegration, error) {
+	client, err := createApiGatewayClient(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]restIntegration, 0)
+	var apiPosition *string = nil
+	for {
+		apis, err := client.GetRestApis(ctx, &apigw.GetRestApisInput{Position: apiPosition})
+		if err != nil {
+			return nil, err
+		}
+
+		for _, api := range apis.Items {
+			var position *string = nil
+			for {
+				resources, err := client.GetResources(ctx, &apigw.GetResourcesInput{
+					RestApiId: api.Id,
+					Embed:     []string{"methods"},
+					Position:  position,
+				})
+				if err != nil {
+					return nil, err
+				}
+
+				for _, resource := range resources.Items {
+					for httpMethod, method := range resource.ResourceMethods {
+						if method.MethodIntegration == nil || !isLambdaIntegrationUri(aws.ToString(method.MethodIntegration.Uri), functionArn) {
+							continue
+						}
+						// The embedded integration may lack the integration responses, which are needed to restore them
+						original, err := getRestIntegration(ctx, client, aws.ToString(api.Id), aws.ToString(resource.Id), httpMethod)
+						if err != nil {
+							return nil, err
+						}
+						result = append(result, restIntegration{
+							RestApiId:  aws.ToString(api.Id),
+							ResourceId: aws.ToString(resource.Id),
+							Path:       aws.ToString(resource.Path),
+							HttpMethod: httpMethod,
+							Original:   *original,
+						})
+					}
+				}
+
+				if resources.Position == nil {
+					break
+				} else {
+					position = resources.Position
+				}
+			}
+		}
+
+		if apis.Position == nil {
+			break
+		} else {
+			apiPosition = apis.Position
+		}
+	}
+	return result, nil
+}
+
+func getRestIntegration(ctx context.Context, client *apigw.Client, restApiId string, resourceId string, httpMethod string) (*apigwtypes.Integration, error) {
+	output, err := client.GetIntegration(ctx, &apigw.GetIntegrationInput{
+		RestApiId:  extutil.Ptr(restApiId),
+		ResourceId: extutil.Ptr(resourceId),
+		HttpMethod: extutil.Ptr(httpMethod),
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &apigwtypes.Integration{
+		CacheKeyParameters:   output.CacheKeyParameters,
+		CacheNamespace:       output.CacheNamespace,
+		ConnectionId:         output.ConnectionId,
+		ConnectionType:       output.ConnectionType,
+		ContentHandling:      output.ContentHandling,
+		Credentials:          output.Credentials,
+		HttpMethod:           output.HttpMethod,
+		IntegrationResponses: output.IntegrationResponses,
+		PassthroughBehavior:  output.PassthroughBehavior,
+		RequestParameters:    output.RequestParameters,
+		RequestTemplates:     output.RequestTemplates,
+		TimeoutInMillis:      output.TimeoutInMillis,
+		TlsConfig:            output.TlsConfig,
+		Type:                 output.Type,
+		Uri:                  output.Uri,
+	}, nil
+}
+
+func findHttpIntegrations(ctx context.Context, functionArn string) ([]httpIntegration, error) {
+	client, err := createApiGatewayV2Client(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]httpIntegration, 0)
+	var apiToken *string = nil
+	for {
+		apis, err := client.GetApis(ctx, &apigwv2.GetApisInput{NextToken: apiToken})
+		if err != nil {
+			return nil, err
+		}
+
+		for _, api := range apis.Items {
+			if api.ProtocolType != apigwv2types.ProtocolTypeHttp {
+				continue
+			}
+			var token *string = nil
+			for {
+				integrations, err := client.GetIntegrations(ctx, &apigwv2.GetIntegrationsInput{
+					ApiId:     api.ApiId,
+					NextToken: token,
+				})
+				if err != nil {
+					return nil, err
+				}
+
+				for _, integration := range integrations.Items {
+					if integration.IntegrationType == apigwv2types.IntegrationTypeAwsProxy && isLambdaIntegrationUri(aws.ToString(integration.IntegrationUri), functionArn) {
+						result = append(result, httpIntegration{
+							ApiId:           aws.ToString(api.ApiId),
+							IntegrationId:   aws.ToString(integration.IntegrationId),
+							TimeoutInMillis: aws.ToInt32(integration.TimeoutInMillis),
+						})
+					}
+				}
+
+				if integrations.NextToken == nil {
+					break
+				} else {
+					token = integrations.NextToken
+				}
+			}
+		}
+
+		if apis.NextToken == nil {
+			break
+		} else {
+			apiToken = apis.NextToken
+		}
+	}
+	return result, nil
+}
+
+// findStageDeployments records the current deployment of the selected stages of all APIs in the state.
+func findStageDeployments(ctx context.Context, state *ApiGatewayIntegrationActionState) ([]stageDeployment, []action_kit_api.Message, error) {
+	result := make([]stageDeployment, 0)
+	messages := make([]action_kit_api.Message, 0)
+
+	if len(state.RestIntegrations) > 0 {
+		client, err := createApiGatewayClient(ctx)
+		if err != nil {
+			return nil, nil, err
+		}
+		restApis := make([]string, 0)
+		for _, integration := range state.RestIntegrations {
+			if !contains(restApis, integration.RestApiId) {
+				restApis = append(restApis, integration.RestApiId)
+			}
+		}
+		for _, restApiId := range restApis {
+			stages, err := client.GetStages(ctx, &apigw.GetStagesInput{RestApiId: extutil.Ptr(restApiId)})
+			if err != nil {
+				return nil, nil, err
+			}
+			for _, stage := range stages.Item {
+				if contains(state.Stages, aws.ToString(stage.StageName)) {
+					result = append(result, stageDeployment{
+						ApiId:                restApiId,
+						StageName:            aws.ToString(stage.StageName),
+						OriginalDeploymentId: aws.ToString(stage.DeploymentId),
+					})
+				}
+			}
+		}
+	}
+
+	if len(state.HttpIntegrations) > 0 {
+		client, err := createApiGatewayV2Client(ctx)
+		if err != nil {
+			return nil, nil, err
+		}
+		httpApis := make([]string, 0)
+		for _, integration := range state.HttpIntegrations {
+			if !contains(httpApis, integration.ApiId) {
+				httpApis = append(httpApis, integration.ApiId)
+			}
+		}
+		for _, apiId := range httpApis {
+			var token *string = nil
+			for {
+				stages, err := client.GetStages(ctx, &apigwv2.GetStagesInput{ApiId: extutil.Ptr(apiId), NextToken: token})
+				if err != nil {
+					return nil, nil, err
+				}
+				for _, stage := range stages.Items {
+					stageName := aws.ToString(stage.StageName)
+					if aws.ToBool(stage.AutoDeploy) {
+						// Changes are deployed to these stages right away and cannot be pointed back to an earlier deployment
+						messages = append(messages, action_kit_api.Message{
+							Level:   extutil.Ptr(action_kit_api.Warn),
+							Message: fmt.Sprintf("Stage %s of HTTP API %s deploys automatically and receives the changes whether selected or not.", stageName, apiId),
+						})
+					} else if contains(state.Stages, stageName) && stage.DeploymentId == nil {
+						messages = append(messages, action_kit_api.Message{
+							Level:   extutil.Ptr(action_kit_api.Warn),
+							Message: fmt.Sprintf("Skipping stage %s of HTTP API %s, it has no deployment to restore.", stageName, apiId),
+						})
+					} else if contains(state.Stages, stageName) {
+						result = append(result, stageDeployment{
+							ApiId:                apiId,
+							Http:                 true,
+							StageName:            stageName,
+							OriginalDeploymentId: aws.ToString(stage.DeploymentId),
+						})
+					}
+				}
+
+				if stages.NextToken == nil {
+					break
+				} else {
+					token = stages.NextToken
+				}
+			}
+		}
+	}
+	return result, messages, nil
+}
+
+func startApiGatewayIntegration(w http.ResponseWriter, r *http.Request, body []byte) {
+	var request action_kit_api.StartActionRequestBody
+	err := json.Unmarshal(body, &request)
+	if err != nil {
+		exthttp.WriteError(w, extension_kit.ToError("Failed to parse request body", err))
+		return
+	}
+
+	var state ApiGatewayIntegrationActionState
+	err = extconversion.Convert(request.State, &state)
+	if err != nil {
+		exthttp.WriteError(w, extension_kit.ToError("Failed to convert action state", err))
+		return
+	}
+
+	restClient, err := createApiGatewayClient(r.Context())
+	if err != nil {
+		exthttp.WriteError(w, extension_kit.ToError("Failed to create api gateway client", err))
+		return
+	}
+	httpClient, err := createApiGatewayV2Client(r.Context())
+	if err != nil {
+		exthttp.WriteError(w, extension_kit.ToError("Failed to create api gateway v2 client", err))
+		return
+	}
+
+	// On failure the already changed integrations are reverted right away, as the state of a failed start is lost
+	applied := ApiGatewayIntegrationActionState{FunctionArn: state.FunctionArn, Mode: state.Mode, StatusCode: state.StatusCode}
+	revert := func() {
+		if err := restoreApiGatewayIntegrations(r.Context(), restClient, httpClient, applied); err != nil {
+			log.Error().Err(err).Msgf("Failed to revert API Gateway integrations of %s.", state.FunctionArn)
+		}
+	}
+
+	for i := range state.RestIntegrations {
+		err = breakRestIntegration(r.Context(), restClient, &state.RestIntegrations[i], state)
+		if err != nil {
+			applied.RestIntegrations = append(applied.RestIntegrations, state.RestIntegrations[i])
+			revert()
+			exthttp.WriteError(w, extension_kit.ToError(fmt.Sprintf("Failed to change integration %s %s", state.RestIntegrations[i].HttpMethod, state.RestIntegrations[i].Path), err))
+			return
+		}
+		applied.RestIntegrations = append(applied.RestIntegrations, state.RestIntegrations[i])
+	}
+	for _, integration := range state.HttpIntegrations {
+		_, err = httpClient.UpdateIntegration(r.Context(), &apigwv2.UpdateIntegrationInput{
+			ApiId:           extutil.Ptr(integration.ApiId),
+			IntegrationId:   extutil.Ptr(integration.IntegrationId),
+			TimeoutInMillis: extutil.Ptr(state.TimeoutInMillis),
+		})
+		if err != nil {
+			revert()
+			exthttp.WriteError(w, extension_kit.ToError(fmt.Sprintf("Failed to change integration %s", integration.IntegrationId), err))
+			return
+		}
+		applied.HttpIntegrations = append(applied.HttpIntegrations, integration)
+	}
+
+	for i := range state.Deployments {
+		err = deployApiGatewayStage(r.Context(), restClient, httpClient, &state.Deployments[i])
+		if err != nil {
+			revert()
+			exthttp.WriteError(w, extension_kit.ToError(fmt.Sprintf("Failed to deploy stage %s of %s", state.Deployments[i].StageName, state.Deployments[i].ApiId), err))
+			return
+		}
+		applied.Deployments = append(applied.Deployments, state.Deployments[i])
+	}
+
+	var convertedState action_kit_api.ActionState
+	err = extconversion.Convert(state, &convertedState)
+	if err != nil {
+		exthttp.WriteError(w, extension_kit.ToError("Failed to encode action state", err))
+		return
+	}
+
+	exthttp.WriteBody(w, action_kit_api.StartResult{
+		State: &convertedState,
+	})
+}
+
+func breakRestIntegration(ctx context.Context, client *apigw.Client, integration *restIntegration, state ApiGatewayIntegrationActionState) error {
+	if state.Mode == "shortenTimeout" {
+		_, err := client.UpdateIntegration(ctx, &apigw.UpdateIntegrationInput{
+			RestApiId:  extutil.Ptr(integration.RestApiId),
+			ResourceId: extutil.Ptr(integration.ResourceId),
+			HttpMethod: extutil.Ptr(integration.HttpMethod),
+			PatchOperations: []apigwtypes.PatchOperation{{
+				Op:    apigwtypes.OpReplace,
+				Path:  extutil.Ptr("/timeoutInMillis"),
+				Value: extutil.Ptr(strconv.Itoa(int(state.TimeoutInMillis))),
+			}},
+		})
+		return err
+	}
+
+	_, err := client.GetMethodResponse(ctx, &apigw.GetMethodResponseInput{
+		RestApiId:  extutil.Ptr(integration.RestApiId),
+		ResourceId: extutil.Ptr(integration.ResourceId),
+		HttpMethod: extutil.Ptr(integration.HttpMethod),
+		StatusCode: extutil.Ptr(state.StatusCode),
+	})
+	if err != nil {
+		var notFound *apigwtypes.NotFoundException
+		if !errors.As(err, &notFound) {
+			return err
+		}
+		_, err = client.PutMethodResponse(ctx, &apigw.PutMethodResponseInput{
+			RestApiId:  extutil.Ptr(integration.RestApiId),
+			ResourceId: extutil.Ptr(integration.ResourceId),
+			HttpMethod: extutil.Ptr(integration.HttpMethod),
+			StatusCode: extutil.Ptr(state.StatusCode),
+		})
+		if err != nil {
+			return err
+		}
+		integration.CreatedMethodResponse = true
+	}
+
+	_, err = client.PutIntegration(ctx, &apigw.PutIntegrationInput{
+		RestApiId:        extutil.Ptr(integration.RestApiId),
+		ResourceId:       extutil.Ptr(integration.ResourceId),
+		HttpMethod:       extutil.Ptr(integration.HttpMethod),
+		Type:             apigwtypes.IntegrationTypeMock,
+		RequestTemplates: map[string]string{"application/json": fmt.Sprintf("{\"statusCode\": %s}", state.StatusCode)},
+	})
+	if err != nil {
+		return err
+	}
+
+	// Other integration responses could be selected instead of the mocked one, they are put back on restore
+	for statusCode := range integration.Original.IntegrationResponses {
+		if statusCode == state.StatusCode {
+			continue
+		}
+		_, err = client.DeleteIntegrationResponse(ctx, &apigw.DeleteIntegrationResponseInput{
+			RestApiId:  extutil.Ptr(integration.RestApiId),
+			ResourceId: extutil.Ptr(integration.ResourceId),
+			HttpMethod: extutil.Ptr(integration.HttpMethod),
+			StatusCode: extutil.Ptr(statusCode),
+		})
+		var notFound *apigwtypes.NotFoundException
+		if err != nil && !errors.As(err, &notFound) {
+			return err
+		}
+	}
+
+	_, err = client.PutIntegrationResponse(ctx, &apigw.PutIntegrationResponseInput{
+		RestApiId:         extutil.Ptr(integration.RestApiId),
+		ResourceId:        extutil.Ptr(integration.ResourceId),
+		HttpMethod:        extutil.Ptr(integration.HttpMethod),
+		StatusCode:        extutil.Ptr(state.StatusCode),
+		ResponseTemplates: map[string]string{"application/json": "{\"message\": \"Injected by steadybit\"}"},
+	})
+	return err
+}
+
+func stopApiGatewayIntegration(w http.ResponseWriter, r *http.Request, body []byte) {
+	var request action_kit_api.StopActionRequestBody
+	err := json.Unmarshal(body, &request)
+	if err != nil {
+		exthttp.WriteError(w, extension_kit.ToError("Failed to parse request body", err))
+		return
+	}
+
+	var state ApiGatewayIntegrationActionState
+	err = extconversion.Convert(request.State, &state)
+	if err != nil {
+		exthttp.WriteError(w, extension_kit.ToError("Failed to convert action state", err))
+		return
+	}
+
+	restClient, err := createApiGatewayClient(r.Context())
+	if err != nil {
+		exthttp.WriteError(w, extension_kit.ToError("Failed to create api gateway client", err))
+		return
+	}
+	httpClient, err := createApiGatewayV2Client(r.Context())
+	if err != nil {
+		exthttp.WriteError(w, extension_kit.ToError("Failed to create api gateway v2 client", err))
+		return
+	}
+
+	err = restoreApiGatewayIntegrations(r.Context(), restClient, httpClient, state)
+	if err != nil {
+		exthttp.WriteError(w, extension_kit.ToError("Failed to restore API Gateway integrations", err))
+		return
+	}
+
+	exthttp.WriteBody(w, action_kit_api.StopResult{})
+}
+
+// restoreApiGatewayIntegrations reverts all integrations of the state and points the deployed stages back to their original deployments.
+func restoreApiGatewayIntegrations(ctx context.Context, restClient *apigw.Client, httpClient *apigwv2.Client, state ApiGatewayIntegrationActionState) error {
+	for _, integration := range state.RestIntegrations {
+		err := restoreRestIntegration(ctx, restClient, integration, state)
+		if err != nil {
+			return fmt.Errorf("failed to restore integration %s %s: %w", integration.HttpMethod, integration.Path, err)
+		}
+	}
+	for _, integration := range state.HttpIntegrations {
+		_, err := httpClient.UpdateIntegration(ctx, &apigwv2.UpdateIntegrationInput{
+			ApiId:           extutil.Ptr(integration.ApiId),
+			IntegrationId:   extutil.Ptr(integration.IntegrationId),
+			TimeoutInMillis: extutil.Ptr(integrationTimeout(integration.TimeoutInMillis, defaultHttpIntegrationTimeout)),
+		})
+		if err != nil {
+			return fmt.Errorf("failed to restore integration %s: %w", integration.IntegrationId, err)
+		}
+	}
+	for _, deployment := range state.Deployments {
+		err := restoreApiGatewayStage(ctx, restClient, httpClient, deployment)
+		if err != nil {
+			return fmt.Errorf("failed to restore stage %s of %s: %w", deployment.StageName, deployment.ApiId, err)
+		}
+	}
+	return nil
+}
+
+func restoreRestIntegration(ctx context.Context, client *apigw.Client, integration restIntegration, state ApiGatewayIntegrationActionState) error {
+	original := integration.Original
+	if state.Mode == "shortenTimeout" {
+		_, err := client.UpdateIntegration(ctx, &apigw.UpdateIntegrationInput{
+			RestApiId:  extutil.Ptr(integration.RestApiId),
+			ResourceId: extutil.Ptr(integration.ResourceId),
+			HttpMethod: extutil.Ptr(integration.HttpMethod),
+			PatchOperations: []apigwtypes.PatchOperation{{
+				Op:    apigwtypes.OpReplace,
+				Path:  extutil.Ptr("/timeoutInMillis"),
+				Value: extutil.Ptr(strconv.Itoa(int(integrationTimeout(original.TimeoutInMillis, defaultRestIntegrationTimeout)))),
+			}},
+		})
+		return err
+	}
+
+	_, err := client.PutIntegration(ctx, &apigw.PutIntegrationInput{
+		RestApiId:             extutil.Ptr(integration.RestApiId),
+		ResourceId:            extutil.Ptr(integration.ResourceId),
+		HttpMethod:            extutil.Ptr(integration.HttpMethod),
+		Type:                  original.Type,
+		IntegrationHttpMethod: original.HttpMethod,
+		Uri:                   original.Uri,
+		Credentials:           original.Credentials,
+		RequestParameters:     original.RequestParameters,
+		RequestTemplates:      original.RequestTemplates,
+		PassthroughBehavior:   original.PassthroughBehavior,
+		ContentHandling:       original.ContentHandling,
+		TimeoutInMillis:       extutil.Ptr(integrationTimeout(original.TimeoutInMillis, defaultRestIntegrationTimeout)),
+		CacheNamespace:        original.CacheNamespace,
+		CacheKeyParameters:    original.CacheKeyParameters,
+		ConnectionType:        original.ConnectionType,
+		ConnectionId:          original.ConnectionId,
+		TlsConfig:             original.TlsConfig,
+	})
+	if err != nil {
+		return err
+	}
+
+	if _, ok := original.IntegrationResponses[state.StatusCode]; !ok {
+		_, err = client.DeleteIntegrationResponse(ctx, &apigw.DeleteIntegrationResponseInput{
+			RestApiId:  extutil.Ptr(integration.RestApiId),
+			ResourceId: extutil.Ptr(integration.ResourceId),
+			HttpMethod: extutil.Ptr(integration.HttpMethod),
+			StatusCode: extutil.Ptr(state.StatusCode),
+		})
+		var notFound *apigwtypes.NotFoundException
+		if err != nil && !errors.As(err, &notFound) {
+			return err
+		}
+	}
+	for statusCode, response := range original.IntegrationResponses {
+		_, err = client.PutIntegrationResponse(ctx, &apigw.PutIntegrationResponseInput{
+			RestApiId:          extutil.Ptr(integration.RestApiId),
+			ResourceId:         extutil.Ptr(integration.ResourceId),
+			HttpMethod:         extutil.Ptr(integration.HttpMethod),
+			StatusCode:         extutil.Ptr(statusCode),
+			ContentHandling:    response.ContentHandling,
+			ResponseParameters: response.ResponseParameters,
+			ResponseTemplates:  response.ResponseTemplates,
+			SelectionPattern:   response.SelectionPattern,
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	if integration.CreatedMethodResponse {
+		_, err = client.DeleteMethodResponse(ctx, &apigw.DeleteMethodResponseInput{
+			RestApiId:  extutil.Ptr(integration.RestApiId),
+			ResourceId: extutil.Ptr(integration.ResourceId),
+			HttpMethod: extutil.Ptr(integration.HttpMethod),
+			StatusCode: extutil.Ptr(state.StatusCode),
+		})
+		var notFound *apigwtypes.NotFoundException
+		if err != nil && !errors.As(err, &notFound) {
+			return err
+		}
+	}
+	return nil
+}
+
+// deployApiGatewayStage deploys the current state of the API to the stage and records the created deployment.
+func deployApiGatewayStage(ctx context.Context, restClient *apigw.Client, httpClient *apigwv2.Client, deployment *stageDeployment) error {
+	if deployment.Http {
+		output, err := httpClient.CreateDeployment(ctx, &apigwv2.CreateDeploymentInput{
+			ApiId:       extutil.Ptr(deployment.ApiId),
+			StageName:   extutil.Ptr(deployment.StageName),
+			Description: extutil.Ptr("Deployed by steadybit"),
+		})
+		if err != nil {
+			return err
+		}
+		deployment.DeploymentId = aws.ToString(output.DeploymentId)
+		return nil
+	}
+
+	output, err := restClient.CreateDeployment(ctx, &apigw.CreateDeploymentInput{
+		RestApiId:   extutil.Ptr(deployment.ApiId),
+		StageName:   extutil.Ptr(deployment.StageName),
+		Description: extutil.Ptr("Deployed by steadybit"),
+	})
+	if err != nil {
+		return err
+	}
+	deployment.DeploymentId = aws.ToString(output.Id)
+	return nil
+}
+
+// restoreApiGatewayStage points the stage back to its original deployment and deletes the deployment of the attack.
+// If the stage was deployed again in the meantime, that deployment may contain the changed integrations, so the restored API is deployed instead.
+func restoreApiGatewayStage(ctx context.Context, restClient *apigw.Client, httpClient *apigwv2.Client, deployment stageDeployment) error {
+	if deployment.DeploymentId == "" {
+		return nil
+	}
+
+	if deployment.Http {
+		stage, err := httpClient.GetStage(ctx, &apigwv2.GetStageInput{ApiId: extutil.Ptr(deployment.ApiId), StageName: extutil.Ptr(deployment.StageName)})
+		if err != nil {
+			return err
+		}
+		if aws.ToString(stage.DeploymentId) == deployment.DeploymentId {
+			_, err = httpClient.UpdateStage(ctx, &apigwv2.UpdateStageInput{
+				ApiId:        extutil.Ptr(deployment.ApiId),
+				StageName:    extutil.Ptr(deployment.StageName),
+				DeploymentId: extutil.Ptr(deployment.OriginalDeploymentId),
+			})
+		} else {
+			log.Info().Msgf("Stage %s of %s was deployed during the attack, deploying the restored API.", deployment.StageName, deployment.ApiId)
+			_, err = httpClient.CreateDeployment(ctx, &apigwv2.CreateDeploymentInput{
+				ApiId:       extutil.Ptr(deployment.ApiId),
+				StageName:   extutil.Ptr(deployment.StageName),
+				Description: extutil.Ptr("Deployed by steadybit"),
+			})
+		}
+		if err != nil {
+			return err
+		}
+		_, err = httpClient.DeleteDeployment(ctx, &apigwv2.DeleteDeploymentInput{ApiId: extutil.Ptr(deployment.ApiId), DeploymentId: extutil.Ptr(deployment.DeploymentId)})
+		if err != nil {
+			log.Warn().Err(err).Msgf("Failed to delete deployment %s of %s.", deployment.DeploymentId, deployment.ApiId)
+		}
+		return nil
+	}
+
+	stage, err := restClient.GetStage(ctx, &apigw.GetStageInput{RestApiId: extutil.Ptr(deployment.ApiId), StageName: extutil.Ptr(deployment.StageName)})
+	if err != nil {
+		return err
+	}
+	if aws.ToString(stage.DeploymentId) == deployment.DeploymentId {
+		_, err = restClient.UpdateStage(ctx, &apigw.UpdateStageInput{
+			RestApiId: extutil.Ptr(deployment.ApiId),
+			StageName: extutil.Ptr(deployment.StageName),
+			PatchOperations: []apigwtypes.PatchOperation{{
+				Op:    apigwtypes.OpReplace,
+				Path:  extutil.Ptr("/deploymentId"),
+				Value: extutil.Ptr(deployment.OriginalDeploymentId),
+			}},
+		})
+	} else {
+		log.Info().Msgf("Stage %s of %s was deployed during the attack, deploying the restored API.", deployment.StageName, deployment.ApiId)
+		_, err = restClient.CreateDeployment(ctx, &apigw.CreateDeploymentInput{
+			RestApiId:   extutil.Ptr(deployment.ApiId),
+			StageName:   extutil.Ptr(deployment.StageName),
+			Description: extutil.Ptr("Deployed by steadybit"),
+		})
+	}
+	if err != nil {
+		return err
+	}
+	_, err = restClient.DeleteDeployment(ctx, &apigw.DeleteDeploymentInput{RestApiId: extutil.Ptr(deployment.ApiId), DeploymentId: extutil.Ptr(deployment.DeploymentId)})
+	if err != nil {
+		log.Warn().Err(err).Msgf("Failed to delete deployment %s of %s.", deployment.DeploymentId, deployment.ApiId)
+	}
+	return nil
+}
+
+func createApiGatewayClient(ctx context.Context) (*apigw.Client, error) {
+	awsConfig, err := config.LoadDefaultConfig(ctx)
+	if err != nil {
+		return nil, err
+	}
+	client := apigw.NewFromConfig(awsConfig)
+	return client, err
+}
+
+func createApiGatewayV2Client(ctx context.Context) (*apigwv2.Client, error) {
+	awsConfig, err := config.LoadDefaultConfig(ctx)
+	if err != nil {
+		return nil, err
+	}
+	client := apigwv2.NewFromConfig(awsConfig)
+	return client, err
+}
@@ -0,0 +1,52 @@
+/*
+ * Copyright 2023 steadybit GmbH. All rights reserved.
+ */
+
+package extlambda
+
+import "testing"
+
+func TestIsLambdaIntegrationUri(t *testing.T) {
+	functionArn := "arn:aws:lambda:eu-central-1:123456789012:function:my-function"
+	tests := []struct {
+		uri  string
+		want bool
+	}{
+		{uri: functionArn, want: true},
+		{uri: functionArn + ":live", want: true},
+		{uri: "arn:aws:apigateway:eu-central-1:lambda:path/2015-03-31/functions/" + functionArn + "/invocations", want: true},
+		{uri: "arn:aws:apigateway:eu-central-1:lambda:path/2015-03-31/functions/" + functionArn + ":3/invocations", want: true},
+		{uri: "arn:aws:apigateway:eu-central-1:lambda:path/2015-03-31/functions/" + functionArn + "-v2/invocations", want: false},
+		{uri: functionArn + "-v2", want: false},
+		{uri: "arn:aws:lambda:eu-central-1:123456789012:function:other-function", want: false},
+		{uri: "https://example.com/my-function", want: false},
+		{uri: "", want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.uri, func(t *testing.T) {
+			if got := isLambdaIntegrationUri(tt.uri, functionArn); got != tt.want {
+				t.Errorf("isLambdaIntegrationUri() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestIntegrationTimeout(t *testing.T) {
+	tests := []struct {
+		name            string
+		timeoutInMillis int32
+		want            int32
+	}{
+		{name: "unset", timeoutInMillis: 0, want: defaultRestIntegrationTimeout},
+		{name: "below minimum", timeoutInMillis: 10, want: defaultRestIntegrationTimeout},
+		{name: "minimum", timeoutInMillis: minimumIntegrationTimeout, want: minimumIntegrationTimeout},
+		{name: "explicit", timeoutInMillis: 5000, want: 5000},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := integrationTimeout(tt.timeoutInMillis, defaultRestIntegrationTimeout); got != tt.want {
+				t.Errorf("integrationTimeout() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
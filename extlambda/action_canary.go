@@ -0,0 +1,453 @@
+/*
+ * Copyright 2023 steadybit GmbH. All rights reserved.
+ */
+
+package extlambda
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/lambda"
+	"github.com/aws/aws-sdk-go-v2/service/lambda/types"
+	"github.com/rs/zerolog/log"
+	"github.com/steadybit/action-kit/go/action_kit_api/v2"
+	extension_kit "github.com/steadybit/extension-kit"
+	"github.com/steadybit/extension-kit/extbuild"
+	"github.com/steadybit/extension-kit/extconversion"
+	"github.com/steadybit/extension-kit/exthttp"
+	"github.com/steadybit/extension-kit/extutil"
+	"net/http"
+)
+
+const canaryActionBasePath = basePath + "/actions/canary"
+
+func registerCanaryActionHandlers() {
+	exthttp.RegisterHttpHandler(canaryActionBasePath, exthttp.GetterAsHandler(getCanaryActionDescription))
+	exthttp.RegisterHttpHandler(canaryActionBasePath+"/prepare", prepareCanary)
+	exthttp.RegisterHttpHandler(canaryActionBasePath+"/start", startCanary)
+	exthttp.RegisterHttpHandler(canaryActionBasePath+"/stop", stopCanary)
+}
+
+func getCanaryActionDescription() action_kit_api.ActionDescription {
+	return action_kit_api.ActionDescription{
+		Id:          fmt.Sprintf("%s.canary", targetID),
+		Version:     extbuild.GetSemverVersionStringOrUnknown(),
+		Label:       "Inject Status Code via Canary",
+		Description: "Publishes a faulty copy of the version an alias points to and shifts a share of the alias' traffic to it. $LATEST has to run the same code as that version.",
+		Icon:        extutil.Ptr(targetIcon),
+		TargetType:  extutil.Ptr(targetID),
+		TargetSelectionTemplates: extutil.Ptr([]action_kit_api.TargetSelectionTemplate{
+			{
+				Label: "by function name",
+				Query: "aws.lambda.function-name=\"\"",
+			},
+		}),
+		Category:    extutil.Ptr("cloud"),
+		Kind:        action_kit_api.Attack,
+		TimeControl: action_kit_api.External,
+		Parameters: []action_kit_api.ActionParameter{
+			{
+				Label:        "Duration",
+				Name:         "duration",
+				Type:         action_kit_api.Duration,
+				Description:  extutil.Ptr("The duration of the attack."),
+				Advanced:     extutil.Ptr(false),
+				Required:     extutil.Ptr(true),
+				DefaultValue: extutil.Ptr("30s"),
+				Order:        extutil.Ptr(0),
+			},
+			{
+				Name:         "alias",
+				Label:        "Alias",
+				Description:  extutil.Ptr("The alias whose traffic is shifted to the faulty version."),
+				Type:         action_kit_api.String,
+				DefaultValue: extutil.Ptr("live"),
+				Required:     extutil.Ptr(true),
+				Order:        extutil.Ptr(1),
+			},
+			{
+				Name:         "weight",
+				Label:        "Traffic Share",
+				Description:  extutil.Ptr("The share of the alias' invocations routed to the faulty version."),
+				Type:         action_kit_api.Percentage,
+				DefaultValue: extutil.Ptr("10"),
+				Required:     extutil.Ptr(true),
+				Order:        extutil.Ptr(2),
+			},
+			{
+				Name:         "statuscode",
+				Label:        "Status Code",
+				Description:  extutil.Ptr("The status code returned by the faulty version."),
+				Type:         action_kit_api.Integer,
+				DefaultValue: extutil.Ptr("500"),
+				Required:     extutil.Ptr(true),
+				Order:        extutil.Ptr(3),
+			},
+		},
+		Prepare: action_kit_api.MutatingEndpointReference{
+			Method: "POST",
+			Path:   canaryActionBasePath + "/prepare",
+		},
+		Start: action_kit_api.MutatingEndpointReference{
+			Method: "POST",
+			Path:   canaryActionBasePath + "/start",
+		},
+		Stop: extutil.Ptr(action_kit_api.MutatingEndpointReference{
+			Method: "POST",
+			Path:   canaryActionBasePath + "/stop",
+		}),
+	}
+}
+
+type CanaryActionState struct {
+	FunctionArn string                 `json:"functionArn"`
+	Alias       string                 `json:"alias"`
+	Weight      float64                `json:"weight"`
+	Param       string                 `json:"param"`
+	Config      failureInjectionConfig `json:"config"`
+	// PrimaryVersion is the version the alias pointed to when the attack was prepared, the canary is a copy of it
+	PrimaryVersion string `json:"primaryVersion"`
+	Version        string `json:"version,omitempty"`
+}
+
+func prepareCanary(w http.ResponseWriter, r *http.Request, body []byte) {
+	var request action_kit_api.PrepareActionRequestBody
+	err := json.Unmarshal(body, &request)
+	if err != nil {
+		exthttp.WriteError(w, extension_kit.ToError("Failed to parse request body", err))
+		return
+	}
+
+	state, extErr := prepareCanaryState(r.Context(), &request)
+	if extErr != nil {
+		exthttp.WriteError(w, *extErr)
+		return
+	}
+
+	var convertedState action_kit_api.ActionState
+	err = extconversion.Convert(state, &convertedState)
+	if err != nil {
+		exthttp.WriteError(w, extension_kit.ToError("Failed to encode action state", err))
+		return
+	}
+
+	exthttp.WriteBody(w, action_kit_api.PrepareResult{
+		State: convertedState,
+	})
+}
+
+func prepareCanaryState(ctx context.Context, request *action_kit_api.PrepareActionRequestBody) (*CanaryActionState, *extension_kit.ExtensionError) {
+	functionArn := targetAttribute(request.Target, "aws.arn")
+	if functionArn == "" {
+		return nil, extutil.Ptr(extension_kit.ToError("Target is missing the 'aws.arn' attribute.", nil))
+	}
+	failureInjectionParam := targetAttribute(request.Target, "aws.lambda.failure-injection-param")
+	if failureInjectionParam == "" {
+		return nil, extutil.Ptr(extension_kit.ToError("Target is missing the 'aws.lambda.failure-injection-param' attribute. Did you wrap the lambda with https://github.com/gunnargrosch/failure-lambda ?", nil))
+	}
+	alias := configString(request.Config, "alias")
+	if alias == "" {
+		return nil, extutil.Ptr(extension_kit.ToError("The alias must not be empty.", nil))
+	}
+
+	client, err := createLambdaClient(ctx)
+	if err != nil {
+		return nil, extutil.Ptr(extension_kit.ToError("Failed to create lambda client", err))
+	}
+
+	output, err := client.GetAlias(ctx, &lambda.GetAliasInput{
+		FunctionName: extutil.Ptr(functionArn),
+		Name:         extutil.Ptr(alias),
+	})
+	if err != nil {
+		return nil, extutil.Ptr(extension_kit.ToError(fmt.Sprintf("Failed to get alias %s", alias), err))
+	}
+	if output.RoutingConfig != nil && len(output.RoutingConfig.AdditionalVersionWeights) > 0 {
+		// Lambda supports only one additional version per alias
+		return nil, extutil.Ptr(extension_kit.ToError(fmt.Sprintf("Alias %s already routes traffic to an additional version.", alias), nil))
+	}
+
+	// Versions can only be published from $LATEST, which therefore has to run the code of the alias' version
+	latest, err := client.GetFunctionConfiguration(ctx, &lambda.GetFunctionConfigurationInput{
+		FunctionName: extutil.Ptr(functionArn),
+	})
+	if err != nil {
+		return nil, extutil.Ptr(extension_kit.ToError("Failed to get function configuration", err))
+	}
+	primary, err := client.GetFunctionConfiguration(ctx, &lambda.GetFunctionConfigurationInput{
+		FunctionName: extutil.Ptr(functionArn),
+		Qualifier:    output.FunctionVersion,
+	})
+	if err != nil {
+		return nil, extutil.Ptr(extension_kit.ToError(fmt.Sprintf("Failed to get configuration of version %s", aws.ToString(output.FunctionVersion)), err))
+	}
+	if aws.ToString(latest.CodeSha256) != aws.ToString(primary.CodeSha256) {
+		return nil, extutil.Ptr(extension_kit.ToError(fmt.Sprintf("The code of $LATEST differs from version %s of alias %s, the canary cannot be published from it.", aws.ToString(output.FunctionVersion), alias), nil))
+	}
+
+	return &CanaryActionState{
+		FunctionArn: functionArn,
+		Alias:       alias,
+		Weight:      request.Config["weight"].(float64) / 100.0,
+		// The faulty version reads its own parameter, so that the alias' primary version stays untouched.
+		Param: fmt.Sprintf("%s-canary-%s", failureInjectionParam, request.ExecutionId.String()[:8]),
+		Config: failureInjectionConfig{
+			FailureMode: "statuscode",
+			Rate:        1.0,
+			StatusCode:  int(request.Config["statuscode"].(float64)),
+			IsEnabled:   true,
+		},
+		PrimaryVersion: aws.ToString(output.FunctionVersion),
+	}, nil
+}
+
+func startCanary(w http.ResponseWriter, r *http.Request, body []byte) {
+	var request action_kit_api.StartActionRequestBody
+	err := json.Unmarshal(body, &request)
+	if err != nil {
+		exthttp.WriteError(w, extension_kit.ToError("Failed to parse request body", err))
+		return
+	}
+
+	var state CanaryActionState
+	err = extconversion.Convert(request.State, &state)
+	if err != nil {
+		exthttp.WriteError(w, extension_kit.ToError("Failed to convert action state", err))
+		return
+	}
+
+	client, err := createLambdaClient(r.Context())
+	if err != nil {
+		exthttp.WriteError(w, extension_kit.ToError("Failed to create lambda client", err))
+		return
+	}
+
+	version, err := publishCanaryVersion(r.Context(), client, state)
+	if err != nil {
+		exthttp.WriteError(w, extension_kit.ToError("Failed to publish faulty version", err))
+		return
+	}
+	state.Version = version
+
+	// Stop is not called for a failed start, so the faulty version and the parameter are removed right away
+	rollback := func() {
+		_, err := client.DeleteFunction(r.Context(), &lambda.DeleteFunctionInput{
+			FunctionName: extutil.Ptr(state.FunctionArn),
+			Qualifier:    extutil.Ptr(version),
+		})
+		if err != nil {
+			log.Error().Err(err).Msgf("Failed to delete faulty version %s of %s.", version, state.FunctionArn)
+		}
+		if extErr := deleteFailureInjectionParameter(r.Context(), LambdaActionState{Param: state.Param}); extErr != nil {
+			log.Error().Msgf("Failed to delete ssm parameter %s: %s", state.Param, extErr.Title)
+		}
+	}
+
+	extErr := putFailureInjectionParameter(r.Context(), LambdaActionState{Param: state.Param, Config: state.Config})
+	if extErr != nil {
+		rollback()
+		exthttp.WriteError(w, *extErr)
+		return
+	}
+
+	_, err = client.UpdateAlias(r.Context(), &lambda.UpdateAliasInput{
+		FunctionName: extutil.Ptr(state.FunctionArn),
+		Name:         extutil.Ptr(state.Alias),
+		RoutingConfig: &types.AliasRoutingConfiguration{
+			AdditionalVersionWeights: map[string]float64{version: state.Weight},
+		},
+	})
+	if err != nil {
+		rollback()
+		exthttp.WriteError(w, extension_kit.ToError(fmt.Sprintf("Failed to shift traffic of alias %s", state.Alias), err))
+		return
+	}
+
+	var convertedState action_kit_api.ActionState
+	err = extconversion.Convert(state, &convertedState)
+	if err != nil {
+		exthttp.WriteError(w, extension_kit.ToError("Failed to encode action state", err))
+		return
+	}
+
+	exthttp.WriteBody(w, action_kit_api.StartResult{
+		State: &convertedState,
+	})
+}
+
+// publishCanaryVersion applies the configuration of the alias' primary version and the canary parameter to $LATEST,
+// publishes it as a new version and reverts $LATEST afterwards.
+func publishCanaryVersion(ctx context.Context, client *lambda.Client, state CanaryActionState) (string, error) {
+	current, err := waitForFunctionUpdated(ctx, client, state.FunctionArn)
+	if err != nil {
+		return "", err
+	}
+	primary, err := client.GetFunctionConfiguration(ctx, &lambda.GetFunctionConfigurationInput{
+		FunctionName: extutil.Ptr(state.FunctionArn),
+		Qualifier:    extutil.Ptr(state.PrimaryVersion),
+	})
+	if err != nil {
+		return "", err
+	}
+	if aws.ToString(current.CodeSha256) != aws.ToString(primary.CodeSha256) {
+		return "", fmt.Errorf("the code of $LATEST differs from version %s", state.PrimaryVersion)
+	}
+
+	input := functionConfigurationInput(primary)
+	input.FunctionName = extutil.Ptr(state.FunctionArn)
+	input.RevisionId = current.RevisionId
+	input.Environment.Variables["FAILURE_INJECTION_PARAM"] = state.Param
+	_, err = client.UpdateFunctionConfiguration(ctx, input)
+	if err != nil {
+		return "", err
+	}
+	updatedConfig, err := waitForFunctionUpdated(ctx, client, state.FunctionArn)
+	if err != nil {
+		return "", err
+	}
+
+	published, publishErr := client.PublishVersion(ctx, &lambda.PublishVersionInput{
+		FunctionName: extutil.Ptr(state.FunctionArn),
+		Description:  extutil.Ptr("faulty canary version - created by steadybit"),
+		CodeSha256:   primary.CodeSha256,
+		RevisionId:   updatedConfig.RevisionId,
+	})
+
+	// Revert $LATEST even if publishing failed, the published version keeps the canary configuration.
+	err = revertCanaryConfiguration(ctx, client, state, current)
+	if err != nil {
+		log.Error().Err(err).Msgf("Failed to revert configuration of %s.", state.FunctionArn)
+		return "", err
+	}
+	if publishErr != nil {
+		return "", publishErr
+	}
+	return aws.ToString(published.Version), nil
+}
+
+// revertCanaryConfiguration restores the original configuration of $LATEST, unless someone else changed it in the meantime.
+func revertCanaryConfiguration(ctx context.Context, client *lambda.Client, state CanaryActionState, original *lambda.GetFunctionConfigurationOutput) error {
+	current, err := waitForFunctionUpdated(ctx, client, state.FunctionArn)
+	if err != nil {
+		return err
+	}
+	if current.Environment == nil || current.Environment.Variables["FAILURE_INJECTION_PARAM"] != state.Param {
+		log.Warn().Msgf("The configuration of %s was changed while publishing the canary and is not reverted.", state.FunctionArn)
+		return nil
+	}
+
+	input := functionConfigurationInput(original)
+	input.FunctionName = extutil.Ptr(state.FunctionArn)
+	input.RevisionId = current.RevisionId
+	_, err = client.UpdateFunctionConfiguration(ctx, input)
+	return err
+}
+
+// functionConfigurationInput builds an update which sets all version specific settings to the given configuration.
+// Unset settings are sent empty, so that they are removed from the updated function.
+func functionConfigurationInput(config *lambda.GetFunctionConfigurationOutput) *lambda.UpdateFunctionConfigurationInput {
+	input := &lambda.UpdateFunctionConfigurationInput{
+		Description:       extutil.Ptr(aws.ToString(config.Description)),
+		Role:              config.Role,
+		MemorySize:        config.MemorySize,
+		Timeout:           config.Timeout,
+		EphemeralStorage:  config.EphemeralStorage,
+		KMSKeyArn:         extutil.Ptr(aws.ToString(config.KMSKeyArn)),
+		DeadLetterConfig:  &types.DeadLetterConfig{TargetArn: extutil.Ptr("")},
+		Environment:       &types.Environment{Variables: map[string]string{}},
+		Layers:            make([]string, 0, len(config.Layers)),
+		FileSystemConfigs: make([]types.FileSystemConfig, 0, len(config.FileSystemConfigs)),
+		VpcConfig:         &types.VpcConfig{SubnetIds: []string{}, SecurityGroupIds: []string{}},
+	}
+	if config.PackageType == types.PackageTypeImage {
+		input.ImageConfig = &types.ImageConfig{}
+		if config.ImageConfigResponse != nil && config.ImageConfigResponse.ImageConfig != nil {
+			input.ImageConfig = config.ImageConfigResponse.ImageConfig
+		}
+	} else {
+		input.Handler = config.Handler
+		input.Runtime = config.Runtime
+	}
+	if config.DeadLetterConfig != nil && config.DeadLetterConfig.TargetArn != nil {
+		input.DeadLetterConfig.TargetArn = config.DeadLetterConfig.TargetArn
+	}
+	if config.Environment != nil {
+		for key, value := range config.Environment.Variables {
+			input.Environment.Variables[key] = value
+		}
+	}
+	for _, layer := range config.Layers {
+		input.Layers = append(input.Layers, aws.ToString(layer.Arn))
+	}
+	input.FileSystemConfigs = append(input.FileSystemConfigs, config.FileSystemConfigs...)
+	if config.VpcConfig != nil {
+		input.VpcConfig.SubnetIds = append(input.VpcConfig.SubnetIds, config.VpcConfig.SubnetIds...)
+		input.VpcConfig.SecurityGroupIds = append(input.VpcConfig.SecurityGroupIds, config.VpcConfig.SecurityGroupIds...)
+		input.VpcConfig.Ipv6AllowedForDualStack = config.VpcConfig.Ipv6AllowedForDualStack
+	}
+	if config.TracingConfig != nil {
+		input.TracingConfig = &types.TracingConfig{Mode: config.TracingConfig.Mode}
+	}
+	if config.LoggingConfig != nil {
+		input.LoggingConfig = originalLoggingConfig(*config.LoggingConfig)
+	}
+	if config.SnapStart != nil {
+		input.SnapStart = &types.SnapStart{ApplyOn: config.SnapStart.ApplyOn}
+	}
+	return input
+}
+
+func stopCanary(w http.ResponseWriter, r *http.Request, body []byte) {
+	var request action_kit_api.StopActionRequestBody
+	err := json.Unmarshal(body, &request)
+	if err != nil {
+		exthttp.WriteError(w, extension_kit.ToError("Failed to parse request body", err))
+		return
+	}
+
+	var state CanaryActionState
+	err = extconversion.Convert(request.State, &state)
+	if err != nil {
+		exthttp.WriteError(w, extension_kit.ToError("Failed to convert action state", err))
+		return
+	}
+
+	client, err := createLambdaClient(r.Context())
+	if err != nil {
+		exthttp.WriteError(w, extension_kit.ToError("Failed to create lambda client", err))
+		return
+	}
+
+	_, err = client.UpdateAlias(r.Context(), &lambda.UpdateAliasInput{
+		FunctionName:  extutil.Ptr(state.FunctionArn),
+		Name:          extutil.Ptr(state.Alias),
+		RoutingConfig: &types.AliasRoutingConfiguration{AdditionalVersionWeights: map[string]float64{}},
+	})
+	if err != nil {
+		exthttp.WriteError(w, extension_kit.ToError(fmt.Sprintf("Failed to restore routing of alias %s", state.Alias), err))
+		return
+	}
+
+	if state.Version != "" {
+		_, err = client.DeleteFunction(r.Context(), &lambda.DeleteFunctionInput{
+			FunctionName: extutil.Ptr(state.FunctionArn),
+			Qualifier:    extutil.Ptr(state.Version),
+		})
+		var notFound *types.ResourceNotFoundException
+		if err != nil && !errors.As(err, &notFound) {
+			exthttp.WriteError(w, extension_kit.ToError(fmt.Sprintf("Failed to delete faulty version %s", state.Version), err))
+			return
+		}
+	}
+
+	extErr := deleteFailureInjectionParameter(r.Context(), LambdaActionState{Param: state.Param})
+	if extErr != nil {
+		exthttp.WriteError(w, *extErr)
+		return
+	}
+
+	exthttp.WriteBody(w, action_kit_api.StopResult{})
+}
@@ -0,0 +1,116 @@
+/*
+ * Copyright 2023 steadybit GmbH. All rights reserved.
+ */
+
+package extlambda
+
+import (
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/lambda"
+	"github.com/aws/aws-sdk-go-v2/service/lambda/types"
+	"reflect"
+	"testing"
+)
+
+func TestFunctionConfigurationInput(t *testing.T) {
+	tests := []struct {
+		name   string
+		config lambda.GetFunctionConfigurationOutput
+		check  func(t *testing.T, input *lambda.UpdateFunctionConfigurationInput)
+	}{
+		{
+			name: "copies the settings of a zip function",
+			config: lambda.GetFunctionConfigurationOutput{
+				PackageType:      types.PackageTypeZip,
+				Handler:          aws.String("index.handler"),
+				Runtime:          types.RuntimeNodejs18x,
+				MemorySize:       int32Ptr(256),
+				Timeout:          int32Ptr(30),
+				Environment:      &types.EnvironmentResponse{Variables: map[string]string{"KEY": "value"}},
+				Layers:           []types.Layer{{Arn: aws.String("arn:aws:lambda:eu-central-1:123456789012:layer:l:3")}},
+				DeadLetterConfig: &types.DeadLetterConfig{TargetArn: aws.String("arn:aws:sqs:eu-central-1:123456789012:dlq")},
+				VpcConfig:        &types.VpcConfigResponse{SubnetIds: []string{"subnet-1"}, SecurityGroupIds: []string{"sg-1"}},
+				TracingConfig:    &types.TracingConfigResponse{Mode: types.TracingModeActive},
+			},
+			check: func(t *testing.T, input *lambda.UpdateFunctionConfigurationInput) {
+				if aws.ToString(input.Handler) != "index.handler" || input.Runtime != types.RuntimeNodejs18x {
+					t.Errorf("handler %q and runtime %q not copied", aws.ToString(input.Handler), input.Runtime)
+				}
+				if deref(input.MemorySize) != int32(256) || deref(input.Timeout) != int32(30) {
+					t.Errorf("memory %v and timeout %v not copied", deref(input.MemorySize), deref(input.Timeout))
+				}
+				if !reflect.DeepEqual(input.Environment.Variables, map[string]string{"KEY": "value"}) {
+					t.Errorf("Environment = %v", input.Environment.Variables)
+				}
+				if !reflect.DeepEqual(input.Layers, []string{"arn:aws:lambda:eu-central-1:123456789012:layer:l:3"}) {
+					t.Errorf("Layers = %v", input.Layers)
+				}
+				if aws.ToString(input.DeadLetterConfig.TargetArn) != "arn:aws:sqs:eu-central-1:123456789012:dlq" {
+					t.Errorf("DeadLetterConfig = %q", aws.ToString(input.DeadLetterConfig.TargetArn))
+				}
+				if !reflect.DeepEqual(input.VpcConfig.SubnetIds, []string{"subnet-1"}) || !reflect.DeepEqual(input.VpcConfig.SecurityGroupIds, []string{"sg-1"}) {
+					t.Errorf("VpcConfig = %v", input.VpcConfig)
+				}
+				if input.TracingConfig == nil || input.TracingConfig.Mode != types.TracingModeActive {
+					t.Errorf("TracingConfig = %v", input.TracingConfig)
+				}
+				if input.ImageConfig != nil {
+					t.Errorf("ImageConfig = %v, want none for zip functions", input.ImageConfig)
+				}
+			},
+		},
+		{
+			name:   "clears settings the configuration does not have",
+			config: lambda.GetFunctionConfigurationOutput{PackageType: types.PackageTypeZip},
+			check: func(t *testing.T, input *lambda.UpdateFunctionConfigurationInput) {
+				if input.Environment == nil || len(input.Environment.Variables) != 0 {
+					t.Errorf("Environment = %v, want empty", input.Environment)
+				}
+				if input.Layers == nil || len(input.Layers) != 0 {
+					t.Errorf("Layers = %v, want empty", input.Layers)
+				}
+				if input.FileSystemConfigs == nil || len(input.FileSystemConfigs) != 0 {
+					t.Errorf("FileSystemConfigs = %v, want empty", input.FileSystemConfigs)
+				}
+				if input.VpcConfig == nil || len(input.VpcConfig.SubnetIds) != 0 || input.VpcConfig.SecurityGroupIds == nil {
+					t.Errorf("VpcConfig = %v, want empty", input.VpcConfig)
+				}
+				if aws.ToString(input.DeadLetterConfig.TargetArn) != "" || input.KMSKeyArn == nil || input.Description == nil {
+					t.Errorf("dead letter target, key and description must be sent empty")
+				}
+			},
+		},
+		{
+			name: "sends the image config instead of handler and runtime for images",
+			config: lambda.GetFunctionConfigurationOutput{
+				PackageType:         types.PackageTypeImage,
+				ImageConfigResponse: &types.ImageConfigResponse{ImageConfig: &types.ImageConfig{Command: []string{"app.handler"}}},
+			},
+			check: func(t *testing.T, input *lambda.UpdateFunctionConfigurationInput) {
+				if input.Handler != nil || input.Runtime != "" {
+					t.Errorf("handler %v and runtime %q must not be sent for images", input.Handler, input.Runtime)
+				}
+				if input.ImageConfig == nil || !reflect.DeepEqual(input.ImageConfig.Command, []string{"app.handler"}) {
+					t.Errorf("ImageConfig = %v", input.ImageConfig)
+				}
+			},
+		},
+		{
+			name: "drops log levels of text logging",
+			config: lambda.GetFunctionConfigurationOutput{
+				LoggingConfig: &types.LoggingConfig{LogFormat: types.LogFormatText, ApplicationLogLevel: types.ApplicationLogLevelInfo, LogGroup: aws.String("group")},
+			},
+			check: func(t *testing.T, input *lambda.UpdateFunctionConfigurationInput) {
+				want := &types.LoggingConfig{LogFormat: types.LogFormatText, LogGroup: aws.String("group")}
+				if !reflect.DeepEqual(input.LoggingConfig, want) {
+					t.Errorf("LoggingConfig = %v, want %v", input.LoggingConfig, want)
+				}
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.check(t, functionConfigurationInput(&tt.config))
+		})
+	}
+}
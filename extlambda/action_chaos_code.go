@@ -0,0 +1,575 @@
+/*
+ * Copyright 2023 steadybit GmbH. All rights reserved.
+ */
+
+package extlambda
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/lambda"
+	"github.com/aws/aws-sdk-go-v2/service/lambda/types"
+	"github.com/rs/zerolog/log"
+	"github.com/steadybit/action-kit/go/action_kit_api/v2"
+	"github.com/steadybit/extension-blog/extconfig"
+	extension_kit "github.com/steadybit/extension-kit"
+	"github.com/steadybit/extension-kit/extbuild"
+	"github.com/steadybit/extension-kit/extconversion"
+	"github.com/steadybit/extension-kit/exthttp"
+	"github.com/steadybit/extension-kit/extutil"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+const (
+	chaosCodeActionBasePath = basePath + "/actions/chaos-code"
+	chaosConfigVariable     = "STEADYBIT_CHAOS_CONFIG"
+	// maxDirectUploadSize is the largest deployment package UpdateFunctionCode accepts without staging it in S3
+	maxDirectUploadSize = 50 * 1024 * 1024
+)
+
+const chaosNodeHandler = `const config = JSON.parse(process.env.STEADYBIT_CHAOS_CONFIG || '{}');
+const sleep = (ms) => new Promise((resolve) => setTimeout(resolve, ms));
+
+exports[%q] = async (event, context) => {
+  if (config.mode === 'timeout') {
+    await sleep(context.getRemainingTimeInMillis() + 1000);
+  }
+  await sleep(config.delay || 0);
+  if (config.mode === 'error') {
+    throw new Error(config.message);
+  }
+  return JSON.parse(config.response || 'null');
+};
+`
+
+const chaosPythonHandler = `import json
+import os
+import time
+
+config = json.loads(os.environ.get('STEADYBIT_CHAOS_CONFIG', '{}'))
+
+
+def handle(event, context):
+    if config.get('mode') == 'timeout':
+        time.sleep(context.get_remaining_time_in_millis() / 1000 + 1)
+    time.sleep(config.get('delay', 0) / 1000)
+    if config.get('mode') == 'error':
+        raise Exception(config.get('message'))
+    return json.loads(config.get('response') or 'null')
+
+
+globals()[%q] = handle
+`
+
+func registerChaosCodeActionHandlers() {
+	exthttp.RegisterHttpHandler(chaosCodeActionBasePath, exthttp.GetterAsHandler(getChaosCodeActionDescription))
+	exthttp.RegisterHttpHandler(chaosCodeActionBasePath+"/prepare", prepareChaosCode)
+	exthttp.RegisterHttpHandler(chaosCodeActionBasePath+"/start", startChaosCode)
+	exthttp.RegisterHttpHandler(chaosCodeActionBasePath+"/stop", stopChaosCode)
+}
+
+func getChaosCodeActionDescription() action_kit_api.ActionDescription {
+	return action_kit_api.ActionDescription{
+		Id:          fmt.Sprintf("%s.chaosCode", targetID),
+		Version:     extbuild.GetSemverVersionStringOrUnknown(),
+		Label:       "Deploy Chaos Code",
+		Description: "Replaces the function's code with a package that fails, times out or responds slowly and redeploys the original code afterwards. Works without wrapping the function in failure-lambda. Zip packages larger than 50 MB are not supported.",
+		Icon:        extutil.Ptr(targetIcon),
+		TargetType:  extutil.Ptr(targetID),
+		TargetSelectionTemplates: extutil.Ptr([]action_kit_api.TargetSelectionTemplate{
+			{
+				Label: "by function name",
+				Query: "aws.lambda.function-name=\"\"",
+			},
+		}),
+		Category:    extutil.Ptr("cloud"),
+		Kind:        action_kit_api.Attack,
+		TimeControl: action_kit_api.External,
+		Parameters: []action_kit_api.ActionParameter{
+			{
+				Label:        "Duration",
+				Name:         "duration",
+				Type:         action_kit_api.Duration,
+				Description:  extutil.Ptr("The duration of the attack."),
+				Advanced:     extutil.Ptr(false),
+				Required:     extutil.Ptr(true),
+				DefaultValue: extutil.Ptr("60s"),
+				Order:        extutil.Ptr(0),
+			},
+			{
+				Name:         "mode",
+				Label:        "Mode",
+				Description:  extutil.Ptr("How the chaos code responds to invocations."),
+				Type:         action_kit_api.String,
+				DefaultValue: extutil.Ptr("error"),
+				Required:     extutil.Ptr(true),
+				Order:        extutil.Ptr(1),
+				Options: extutil.Ptr([]action_kit_api.ParameterOption{
+					action_kit_api.ExplicitParameterOption{Label: "Fail", Value: "error"},
+					action_kit_api.ExplicitParameterOption{Label: "Time out", Value: "timeout"},
+					action_kit_api.ExplicitParameterOption{Label: "Delay", Value: "delay"},
+				}),
+			},
+			{
+				Name:         "delay",
+				Label:        "Delay",
+				Description:  extutil.Ptr("The latency added to each invocation before responding."),
+				Type:         action_kit_api.Duration,
+				DefaultValue: extutil.Ptr("0s"),
+				Required:     extutil.Ptr(false),
+				Order:        extutil.Ptr(2),
+			},
+			{
+				Name:         "message",
+				Label:        "Error Message",
+				Description:  extutil.Ptr("The message of the error raised in fail mode."),
+				Type:         action_kit_api.String,
+				DefaultValue: extutil.Ptr("Injected by steadybit"),
+				Required:     extutil.Ptr(false),
+				Order:        extutil.Ptr(3),
+			},
+			{
+				Name:         "response",
+				Label:        "Response",
+				Description:  extutil.Ptr("The JSON document returned in delay mode."),
+				Type:         action_kit_api.String,
+				DefaultValue: extutil.Ptr("{\"statusCode\": 200}"),
+				Required:     extutil.Ptr(false),
+				Advanced:     extutil.Ptr(true),
+				Order:        extutil.Ptr(4),
+			},
+		},
+		Prepare: action_kit_api.MutatingEndpointReference{
+			Method: "POST",
+			Path:   chaosCodeActionBasePath + "/prepare",
+		},
+		Start: action_kit_api.MutatingEndpointReference{
+			Method: "POST",
+			Path:   chaosCodeActionBasePath + "/start",
+		},
+		Stop: extutil.Ptr(action_kit_api.MutatingEndpointReference{
+			Method: "POST",
+			Path:   chaosCodeActionBasePath + "/stop",
+		}),
+	}
+}
+
+type chaosConfig struct {
+	Mode     string `json:"mode"`
+	Delay    int64  `json:"delay"`
+	Message  string `json:"message"`
+	Response string `json:"response"`
+}
+
+type ChaosCodeActionState struct {
+	FunctionArn string `json:"functionArn"`
+	PackageType string `json:"packageType"`
+	Runtime     string `json:"runtime"`
+	Handler     string `json:"handler"`
+	CodeSha256  string `json:"codeSha256"`
+	ImageUri    string `json:"imageUri,omitempty"`
+	Config      string `json:"config"`
+	Snapshot    string `json:"snapshot,omitempty"`
+	// SnapshotCreated is false if the original code had already been published as the snapshot version
+	SnapshotCreated bool   `json:"snapshotCreated"`
+	ChaosCodeSha256 string `json:"chaosCodeSha256,omitempty"`
+}
+
+func prepareChaosCode(w http.ResponseWriter, r *http.Request, body []byte) {
+	var request action_kit_api.PrepareActionRequestBody
+	err := json.Unmarshal(body, &request)
+	if err != nil {
+		exthttp.WriteError(w, extension_kit.ToError("Failed to parse request body", err))
+		return
+	}
+
+	state, extErr := prepareChaosCodeState(r.Context(), &request)
+	if extErr != nil {
+		exthttp.WriteError(w, *extErr)
+		return
+	}
+
+	var convertedState action_kit_api.ActionState
+	err = extconversion.Convert(state, &convertedState)
+	if err != nil {
+		exthttp.WriteError(w, extension_kit.ToError("Failed to encode action state", err))
+		return
+	}
+
+	exthttp.WriteBody(w, action_kit_api.PrepareResult{
+		State: convertedState,
+	})
+}
+
+func prepareChaosCodeState(ctx context.Context, request *action_kit_api.PrepareActionRequestBody) (*ChaosCodeActionState, *extension_kit.ExtensionError) {
+	functionArn := targetAttribute(request.Target, "aws.arn")
+	if functionArn == "" {
+		return nil, extutil.Ptr(extension_kit.ToError("Target is missing the 'aws.arn' attribute.", nil))
+	}
+
+	chaos := chaosConfig{
+		Mode:     configString(request.Config, "mode"),
+		Delay:    configDuration(request.Config, "delay").Milliseconds(),
+		Message:  configString(request.Config, "message"),
+		Response: configString(request.Config, "response"),
+	}
+	if chaos.Mode != "error" && chaos.Mode != "timeout" && chaos.Mode != "delay" {
+		return nil, extutil.Ptr(extension_kit.ToError(fmt.Sprintf("Unknown mode '%s'.", chaos.Mode), nil))
+	}
+	if chaos.Response != "" && !json.Valid([]byte(chaos.Response)) {
+		return nil, extutil.Ptr(extension_kit.ToError("The response has to be a valid JSON document.", nil))
+	}
+	encodedConfig, err := json.Marshal(chaos)
+	if err != nil {
+		return nil, extutil.Ptr(extension_kit.ToError("Failed to encode chaos config", err))
+	}
+
+	client, err := createLambdaClient(ctx)
+	if err != nil {
+		return nil, extutil.Ptr(extension_kit.ToError("Failed to create lambda client", err))
+	}
+
+	function, err := client.GetFunction(ctx, &lambda.GetFunctionInput{
+		FunctionName: extutil.Ptr(functionArn),
+	})
+	if err != nil {
+		return nil, extutil.Ptr(extension_kit.ToError("Failed to get function", err))
+	}
+
+	state := &ChaosCodeActionState{
+		FunctionArn: functionArn,
+		PackageType: string(function.Configuration.PackageType),
+		Runtime:     string(function.Configuration.Runtime),
+		Handler:     aws.ToString(function.Configuration.Handler),
+		CodeSha256:  aws.ToString(function.Configuration.CodeSha256),
+		Config:      string(encodedConfig),
+	}
+
+	if function.Configuration.PackageType == types.PackageTypeImage {
+		if extconfig.Config.ChaosImageUri == "" {
+			return nil, extutil.Ptr(extension_kit.ToError("Function is packaged as container image, but no chaos image is configured. Set STEADYBIT_EXTENSION_CHAOS_IMAGE_URI.", nil))
+		}
+		// The resolved URI pins the digest, so the exact same image is redeployed even if the tag moved meanwhile
+		state.ImageUri = aws.ToString(function.Code.ResolvedImageUri)
+		if state.ImageUri == "" {
+			state.ImageUri = aws.ToString(function.Code.ImageUri)
+		}
+	} else {
+		if _, err := chaosPackage(state.Runtime, state.Handler); err != nil {
+			return nil, extutil.Ptr(extension_kit.ToError(err.Error(), nil))
+		}
+		// The original package is restored by a direct upload
+		if function.Configuration.CodeSize > maxDirectUploadSize {
+			return nil, extutil.Ptr(extension_kit.ToError(fmt.Sprintf("The deployment package of %s is larger than 50 MB and could not be restored after the attack.", functionArn), nil))
+		}
+	}
+	return state, nil
+}
+
+// chaosPackage builds a deployment package serving the function's handler with the built-in chaos code.
+func chaosPackage(runtime string, handler string) ([]byte, error) {
+	separator := strings.LastIndex(handler, ".")
+	if separator <= 0 {
+		return nil, fmt.Errorf("handler '%s' is not supported by the chaos code", handler)
+	}
+	module, function := handler[:separator], handler[separator+1:]
+
+	var name, content string
+	switch {
+	case strings.HasPrefix(runtime, "nodejs"):
+		name = module + ".js"
+		content = fmt.Sprintf(chaosNodeHandler, function)
+	case strings.HasPrefix(runtime, "python"):
+		name = strings.ReplaceAll(module, ".", "/") + ".py"
+		content = fmt.Sprintf(chaosPythonHandler, function)
+	default:
+		return nil, fmt.Errorf("runtime '%s' is not supported by the chaos code, only Node.js and Python functions are", runtime)
+	}
+
+	buffer := new(bytes.Buffer)
+	writer := zip.NewWriter(buffer)
+	file, err := writer.Create(name)
+	if err != nil {
+		return nil, err
+	}
+	_, err = file.Write([]byte(content))
+	if err != nil {
+		return nil, err
+	}
+	err = writer.Close()
+	if err != nil {
+		return nil, err
+	}
+	return buffer.Bytes(), nil
+}
+
+func startChaosCode(w http.ResponseWriter, r *http.Request, body []byte) {
+	var request action_kit_api.StartActionRequestBody
+	err := json.Unmarshal(body, &request)
+	if err != nil {
+		exthttp.WriteError(w, extension_kit.ToError("Failed to parse request body", err))
+		return
+	}
+
+	var state ChaosCodeActionState
+	err = extconversion.Convert(request.State, &state)
+	if err != nil {
+		exthttp.WriteError(w, extension_kit.ToError("Failed to convert action state", err))
+		return
+	}
+
+	client, err := createLambdaClient(r.Context())
+	if err != nil {
+		exthttp.WriteError(w, extension_kit.ToError("Failed to create lambda client", err))
+		return
+	}
+
+	extErr := deployChaosCode(r.Context(), client, &state)
+	if extErr != nil {
+		// The state of a failed start is lost, so everything done so far has to be reverted right away
+		if state.ChaosCodeSha256 != "" {
+			if _, err := restoreOriginalCode(r.Context(), client, state); err != nil {
+				log.Error().Err(err).Msgf("Failed to restore original code of %s after failed start, keeping version %s.", state.FunctionArn, state.Snapshot)
+				exthttp.WriteError(w, *extErr)
+				return
+			}
+		}
+		if err := removeChaosCodeLeftovers(r.Context(), client, state); err != nil {
+			log.Error().Err(err).Msgf("Failed to clean up after failed chaos code deployment to %s.", state.FunctionArn)
+		}
+		exthttp.WriteError(w, *extErr)
+		return
+	}
+
+	var convertedState action_kit_api.ActionState
+	err = extconversion.Convert(state, &convertedState)
+	if err != nil {
+		exthttp.WriteError(w, extension_kit.ToError("Failed to encode action state", err))
+		return
+	}
+
+	exthttp.WriteBody(w, action_kit_api.StartResult{
+		State: &convertedState,
+	})
+}
+
+// deployChaosCode snapshots the original code, configures the chaos code and deploys it. Each step only proceeds
+// if the function still runs the code recorded in prepare, so parallel deployments are never overwritten.
+func deployChaosCode(ctx context.Context, client *lambda.Client, state *ChaosCodeActionState) *extension_kit.ExtensionError {
+	current, err := waitForFunctionUpdated(ctx, client, state.FunctionArn)
+	if err != nil {
+		return extutil.Ptr(extension_kit.ToError("Failed to wait for function update", err))
+	}
+	if aws.ToString(current.CodeSha256) != state.CodeSha256 {
+		return extutil.Ptr(extension_kit.ToError(fmt.Sprintf("The code of %s was changed after the attack was prepared.", state.FunctionArn), nil))
+	}
+
+	if state.PackageType != string(types.PackageTypeImage) {
+		versions, err := listPublishedVersions(ctx, client, state.FunctionArn)
+		if err != nil {
+			return extutil.Ptr(extension_kit.ToError("Failed to list function versions", err))
+		}
+		// Deployment packages cannot be read back from their original location, so a version keeps them for the restore
+		snapshot, err := client.PublishVersion(ctx, &lambda.PublishVersionInput{
+			FunctionName: extutil.Ptr(state.FunctionArn),
+			CodeSha256:   extutil.Ptr(state.CodeSha256),
+			RevisionId:   current.RevisionId,
+			Description:  extutil.Ptr("Original code, kept by steadybit while chaos code is deployed"),
+		})
+		if err != nil {
+			return extutil.Ptr(extension_kit.ToError("Failed to snapshot the original code", err))
+		}
+		state.Snapshot = aws.ToString(snapshot.Version)
+		state.SnapshotCreated = !containsVersion(versions, state.Snapshot)
+	}
+
+	err = setEnvironmentVariables(ctx, client, state.FunctionArn, map[string]*string{chaosConfigVariable: extutil.Ptr(state.Config)})
+	if err != nil {
+		return extutil.Ptr(extension_kit.ToError(fmt.Sprintf("Failed to set %s", chaosConfigVariable), err))
+	}
+	current, err = waitForFunctionUpdated(ctx, client, state.FunctionArn)
+	if err != nil {
+		return extutil.Ptr(extension_kit.ToError("Failed to wait for function update", err))
+	}
+	if aws.ToString(current.CodeSha256) != state.CodeSha256 {
+		return extutil.Ptr(extension_kit.ToError(fmt.Sprintf("The code of %s was changed during the attack's start.", state.FunctionArn), nil))
+	}
+
+	input := &lambda.UpdateFunctionCodeInput{
+		FunctionName: extutil.Ptr(state.FunctionArn),
+		RevisionId:   current.RevisionId,
+	}
+	if state.PackageType == string(types.PackageTypeImage) {
+		input.ImageUri = extutil.Ptr(extconfig.Config.ChaosImageUri)
+	} else {
+		input.ZipFile, err = chaosPackage(state.Runtime, state.Handler)
+		if err != nil {
+			return extutil.Ptr(extension_kit.ToError("Failed to build chaos code", err))
+		}
+	}
+	deployed, err := client.UpdateFunctionCode(ctx, input)
+	if err != nil {
+		return extutil.Ptr(extension_kit.ToError("Failed to deploy chaos code", err))
+	}
+	state.ChaosCodeSha256 = aws.ToString(deployed.CodeSha256)
+
+	_, err = waitForFunctionUpdated(ctx, client, state.FunctionArn)
+	if err != nil {
+		return extutil.Ptr(extension_kit.ToError("Failed to wait for chaos code deployment", err))
+	}
+	return nil
+}
+
+func stopChaosCode(w http.ResponseWriter, r *http.Request, body []byte) {
+	var request action_kit_api.StopActionRequestBody
+	err := json.Unmarshal(body, &request)
+	if err != nil {
+		exthttp.WriteError(w, extension_kit.ToError("Failed to parse request body", err))
+		return
+	}
+
+	var state ChaosCodeActionState
+	err = extconversion.Convert(request.State, &state)
+	if err != nil {
+		exthttp.WriteError(w, extension_kit.ToError("Failed to convert action state", err))
+		return
+	}
+
+	client, err := createLambdaClient(r.Context())
+	if err != nil {
+		exthttp.WriteError(w, extension_kit.ToError("Failed to create lambda client", err))
+		return
+	}
+
+	messages := make([]action_kit_api.Message, 0)
+	if state.ChaosCodeSha256 != "" {
+		restored, err := restoreOriginalCode(r.Context(), client, state)
+		if err != nil {
+			exthttp.WriteError(w, extension_kit.ToError("Failed to redeploy the original code", err))
+			return
+		}
+		if !restored {
+			messages = append(messages, action_kit_api.Message{
+				Level:   extutil.Ptr(action_kit_api.Warn),
+				Message: fmt.Sprintf("The code of %s was redeployed during the attack, the original code was not restored.", state.FunctionArn),
+			})
+		}
+	}
+
+	err = removeChaosCodeLeftovers(r.Context(), client, state)
+	if err != nil {
+		exthttp.WriteError(w, extension_kit.ToError("Failed to clean up chaos code configuration", err))
+		return
+	}
+
+	exthttp.WriteBody(w, action_kit_api.StopResult{
+		Messages: extutil.Ptr(messages),
+	})
+}
+
+// restoreOriginalCode redeploys the original code unless someone else deployed in the meantime, which is reported by returning false.
+func restoreOriginalCode(ctx context.Context, client *lambda.Client, state ChaosCodeActionState) (bool, error) {
+	current, err := waitForFunctionUpdated(ctx, client, state.FunctionArn)
+	if err != nil {
+		return false, err
+	}
+	if aws.ToString(current.CodeSha256) != state.ChaosCodeSha256 {
+		return false, nil
+	}
+
+	input := &lambda.UpdateFunctionCodeInput{
+		FunctionName: extutil.Ptr(state.FunctionArn),
+		RevisionId:   current.RevisionId,
+	}
+	if state.PackageType == string(types.PackageTypeImage) {
+		input.ImageUri = extutil.Ptr(state.ImageUri)
+	} else {
+		input.ZipFile, err = downloadFunctionCode(ctx, client, state.FunctionArn, state.Snapshot)
+		if err != nil {
+			return false, err
+		}
+	}
+	_, err = client.UpdateFunctionCode(ctx, input)
+	if err != nil {
+		var preconditionFailed *types.PreconditionFailedException
+		if errors.As(err, &preconditionFailed) {
+			return false, nil
+		}
+		return false, err
+	}
+
+	current, err = waitForFunctionUpdated(ctx, client, state.FunctionArn)
+	if err != nil {
+		return false, err
+	}
+	if aws.ToString(current.CodeSha256) != state.CodeSha256 {
+		log.Warn().Msgf("Restored code of %s has checksum %s instead of %s.", state.FunctionArn, aws.ToString(current.CodeSha256), state.CodeSha256)
+	}
+	return true, nil
+}
+
+// downloadFunctionCode fetches the deployment package of a published version.
+func downloadFunctionCode(ctx context.Context, client *lambda.Client, functionArn string, version string) ([]byte, error) {
+	function, err := client.GetFunction(ctx, &lambda.GetFunctionInput{
+		FunctionName: extutil.Ptr(functionArn),
+		Qualifier:    extutil.Ptr(version),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, aws.ToString(function.Code.Location), nil)
+	if err != nil {
+		return nil, err
+	}
+	response, err := http.DefaultClient.Do(request)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = response.Body.Close() }()
+	if response.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to download code of version %s: %s", version, response.Status)
+	}
+	return io.ReadAll(response.Body)
+}
+
+// removeChaosCodeLeftovers removes the chaos config variable and the snapshot version, if the attack created it.
+func removeChaosCodeLeftovers(ctx context.Context, client *lambda.Client, state ChaosCodeActionState) error {
+	_, err := waitForFunctionUpdated(ctx, client, state.FunctionArn)
+	if err != nil {
+		return err
+	}
+	err = setEnvironmentVariables(ctx, client, state.FunctionArn, map[string]*string{chaosConfigVariable: nil})
+	if err != nil {
+		return err
+	}
+	_, err = waitForFunctionUpdated(ctx, client, state.FunctionArn)
+	if err != nil {
+		return err
+	}
+
+	if state.Snapshot != "" && state.SnapshotCreated {
+		if _, err := strconv.Atoi(state.Snapshot); err != nil {
+			return fmt.Errorf("refusing to delete non-numeric version '%s'", state.Snapshot)
+		}
+		_, err = client.DeleteFunction(ctx, &lambda.DeleteFunctionInput{
+			FunctionName: extutil.Ptr(state.FunctionArn),
+			Qualifier:    extutil.Ptr(state.Snapshot),
+		})
+		if err != nil {
+			var notFound *types.ResourceNotFoundException
+			if !errors.As(err, &notFound) {
+				return err
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,67 @@
+/*
+ * Copyright 2023 steadybit GmbH. All rights reserved.
+ */
+
+package extlambda
+
+import (
+	"archive/zip"
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestChaosPackage(t *testing.T) {
+	tests := []struct {
+		name        string
+		runtime     string
+		handler     string
+		wantFile    string
+		wantExport  string
+		wantErr     bool
+		errContains string
+	}{
+		{name: "node module", runtime: "nodejs18.x", handler: "index.handler", wantFile: "index.js", wantExport: `exports["handler"]`},
+		{name: "node module in a directory", runtime: "nodejs16.x", handler: "dist/app.main", wantFile: "dist/app.js", wantExport: `exports["main"]`},
+		{name: "python module", runtime: "python3.11", handler: "app.lambda_handler", wantFile: "app.py", wantExport: `globals()["lambda_handler"]`},
+		{name: "python package", runtime: "python3.9", handler: "service.api.handle", wantFile: "service/api.py", wantExport: `globals()["handle"]`},
+		{name: "unsupported runtime", runtime: "java17", handler: "com.example.Handler::handleRequest", wantErr: true, errContains: "runtime 'java17'"},
+		{name: "handler without module", runtime: "nodejs18.x", handler: "handler", wantErr: true, errContains: "handler 'handler'"},
+		{name: "handler without function", runtime: "python3.11", handler: ".handler", wantErr: true, errContains: "handler '.handler'"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			content, err := chaosPackage(tt.runtime, tt.handler)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("chaosPackage() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				if !strings.Contains(err.Error(), tt.errContains) {
+					t.Errorf("chaosPackage() error = %q, want it to mention %q", err.Error(), tt.errContains)
+				}
+				return
+			}
+
+			archive, err := zip.NewReader(bytes.NewReader(content), int64(len(content)))
+			if err != nil {
+				t.Fatalf("package is no zip archive: %v", err)
+			}
+			if len(archive.File) != 1 || archive.File[0].Name != tt.wantFile {
+				t.Fatalf("package contains %v, want only %s", archive.File, tt.wantFile)
+			}
+			file, err := archive.File[0].Open()
+			if err != nil {
+				t.Fatalf("failed to open %s: %v", tt.wantFile, err)
+			}
+			source, _ := io.ReadAll(file)
+			_ = file.Close()
+			if !strings.Contains(string(source), tt.wantExport) {
+				t.Errorf("%s does not define %q:\n%s", tt.wantFile, tt.wantExport, source)
+			}
+			if !strings.Contains(string(source), chaosConfigVariable) {
+				t.Errorf("%s does not read %s", tt.wantFile, chaosConfigVariable)
+			}
+		})
+	}
+}
@@ -0,0 +1,339 @@
+/*
+ * Copyright 2023 steadybit GmbH. All rights reserved.
+ */
+
+package extlambda
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/aws/aws-sdk-go-v2/service/lambda"
+	"github.com/aws/aws-sdk-go-v2/service/lambda/types"
+	"github.com/rs/zerolog/log"
+	"github.com/steadybit/action-kit/go/action_kit_api/v2"
+	extension_kit "github.com/steadybit/extension-kit"
+	"github.com/steadybit/extension-kit/extbuild"
+	"github.com/steadybit/extension-kit/extconversion"
+	"github.com/steadybit/extension-kit/exthttp"
+	"github.com/steadybit/extension-kit/extutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const coldStartActionBasePath = basePath + "/actions/cold-start"
+
+// coldStartVariable is changed to make Lambda discard all warm execution environments of the function.
+const coldStartVariable = "STEADYBIT_COLD_START"
+
+// coldStartStatusInterval is how often the status endpoint is called, repeating more often is not possible.
+const coldStartStatusInterval = 5 * time.Second
+
+func registerColdStartActionHandlers() {
+	exthttp.RegisterHttpHandler(coldStartActionBasePath, exthttp.GetterAsHandler(getColdStartActionDescription))
+	exthttp.RegisterHttpHandler(coldStartActionBasePath+"/prepare", prepareColdStart)
+	exthttp.RegisterHttpHandler(coldStartActionBasePath+"/start", startColdStart)
+	exthttp.RegisterHttpHandler(coldStartActionBasePath+"/status", statusColdStart)
+	exthttp.RegisterHttpHandler(coldStartActionBasePath+"/stop", stopColdStart)
+}
+
+func getColdStartActionDescription() action_kit_api.ActionDescription {
+	return action_kit_api.ActionDescription{
+		Id:          fmt.Sprintf("%s.coldStart", targetID),
+		Version:     extbuild.GetSemverVersionStringOrUnknown(),
+		Label:       "Force Cold Starts",
+		Description: "Makes Lambda discard all warm execution environments of the function's $LATEST version, optionally repeated in an interval. Published versions, and the aliases pointing to them, keep their execution environments.",
+		Icon:        extutil.Ptr(targetIcon),
+		TargetType:  extutil.Ptr(targetID),
+		TargetSelectionTemplates: extutil.Ptr([]action_kit_api.TargetSelectionTemplate{
+			{
+				Label: "by function name",
+				Query: "aws.lambda.function-name=\"\"",
+			},
+		}),
+		Category:    extutil.Ptr("cloud"),
+		Kind:        action_kit_api.Attack,
+		TimeControl: action_kit_api.External,
+		Parameters: []action_kit_api.ActionParameter{
+			{
+				Label:        "Duration",
+				Name:         "duration",
+				Type:         action_kit_api.Duration,
+				Description:  extutil.Ptr("The duration of the attack."),
+				Advanced:     extutil.Ptr(false),
+				Required:     extutil.Ptr(true),
+				DefaultValue: extutil.Ptr("60s"),
+				Order:        extutil.Ptr(0),
+			},
+			{
+				Name:         "repeat",
+				Label:        "Repeat",
+				Description:  extutil.Ptr("Keep discarding the execution environments throughout the attack."),
+				Type:         action_kit_api.Boolean,
+				DefaultValue: extutil.Ptr("true"),
+				Required:     extutil.Ptr(true),
+				Order:        extutil.Ptr(1),
+			},
+			{
+				Name:         "interval",
+				Label:        "Interval",
+				Description:  extutil.Ptr(fmt.Sprintf("How often the execution environments are discarded when repeating, at least every %s.", coldStartStatusInterval)),
+				Type:         action_kit_api.Duration,
+				DefaultValue: extutil.Ptr("30s"),
+				Required:     extutil.Ptr(true),
+				Order:        extutil.Ptr(2),
+			},
+		},
+		Prepare: action_kit_api.MutatingEndpointReference{
+			Method: "POST",
+			Path:   coldStartActionBasePath + "/prepare",
+		},
+		Start: action_kit_api.MutatingEndpointReference{
+			Method: "POST",
+			Path:   coldStartActionBasePath + "/start",
+		},
+		Status: extutil.Ptr(action_kit_api.MutatingEndpointReferenceWithCallInterval{
+			Method:       "POST",
+			Path:         coldStartActionBasePath + "/status",
+			CallInterval: extutil.Ptr(coldStartStatusInterval.String()),
+		}),
+		Stop: extutil.Ptr(action_kit_api.MutatingEndpointReference{
+			Method: "POST",
+			Path:   coldStartActionBasePath + "/stop",
+		}),
+	}
+}
+
+type ColdStartActionState struct {
+	FunctionArn string `json:"functionArn"`
+	Repeat      bool   `json:"repeat"`
+	IntervalMs  int64  `json:"intervalMs"`
+	LastTouch   int64  `json:"lastTouch"`
+	Touches     int    `json:"touches"`
+}
+
+func prepareColdStart(w http.ResponseWriter, r *http.Request, body []byte) {
+	var request action_kit_api.PrepareActionRequestBody
+	err := json.Unmarshal(body, &request)
+	if err != nil {
+		exthttp.WriteError(w, extension_kit.ToError("Failed to parse request body", err))
+		return
+	}
+
+	functionArn := targetAttribute(request.Target, "aws.arn")
+	if functionArn == "" {
+		exthttp.WriteError(w, extension_kit.ToError("Target is missing the 'aws.arn' attribute.", nil))
+		return
+	}
+
+	state := ColdStartActionState{
+		FunctionArn: functionArn,
+		Repeat:      configBool(request.Config, "repeat"),
+		IntervalMs:  configDuration(request.Config, "interval").Milliseconds(),
+	}
+	if extErr := validateColdStartInterval(state); extErr != nil {
+		exthttp.WriteError(w, *extErr)
+		return
+	}
+
+	client, err := createLambdaClient(r.Context())
+	if err != nil {
+		exthttp.WriteError(w, extension_kit.ToError("Failed to create lambda client", err))
+		return
+	}
+	aliases, err := getAliasAttributes(r.Context(), client, functionArn)
+	if err != nil {
+		exthttp.WriteError(w, extension_kit.ToError("Failed to list aliases", err))
+		return
+	}
+	// Only the configuration of $LATEST can be changed, published versions are immutable
+	messages := make([]action_kit_api.Message, 0)
+	unaffected := versionedAliases(aliases["aws.lambda.alias-version"])
+	if len(unaffected) > 0 {
+		messages = append(messages, action_kit_api.Message{
+			Level:   extutil.Ptr(action_kit_api.Warn),
+			Message: fmt.Sprintf("Only $LATEST is cold started, invocations of the aliases %s keep using warm execution environments.", strings.Join(unaffected, ", ")),
+		})
+	}
+
+	var convertedState action_kit_api.ActionState
+	err = extconversion.Convert(state, &convertedState)
+	if err != nil {
+		exthttp.WriteError(w, extension_kit.ToError("Failed to encode action state", err))
+		return
+	}
+
+	exthttp.WriteBody(w, action_kit_api.PrepareResult{
+		State:    convertedState,
+		Messages: extutil.Ptr(messages),
+	})
+}
+
+// validateColdStartInterval rejects repeat intervals shorter than the status call interval, which would update
+// the configuration with every status call.
+func validateColdStartInterval(state ColdStartActionState) *extension_kit.ExtensionError {
+	if state.Repeat && time.Duration(state.IntervalMs)*time.Millisecond < coldStartStatusInterval {
+		return extutil.Ptr(extension_kit.ToError(fmt.Sprintf("The interval must be at least %s.", coldStartStatusInterval), nil))
+	}
+	return nil
+}
+
+// versionedAliases returns the names of the aliases which point to a published version, given as "alias:version".
+func versionedAliases(aliasVersions []string) []string {
+	result := make([]string, 0)
+	for _, aliasVersion := range aliasVersions {
+		separator := strings.LastIndex(aliasVersion, ":")
+		if separator > 0 && aliasVersion[separator+1:] != "$LATEST" {
+			result = append(result, aliasVersion[:separator])
+		}
+	}
+	return result
+}
+
+func startColdStart(w http.ResponseWriter, r *http.Request, body []byte) {
+	var request action_kit_api.StartActionRequestBody
+	err := json.Unmarshal(body, &request)
+	if err != nil {
+		exthttp.WriteError(w, extension_kit.ToError("Failed to parse request body", err))
+		return
+	}
+
+	var state ColdStartActionState
+	err = extconversion.Convert(request.State, &state)
+	if err != nil {
+		exthttp.WriteError(w, extension_kit.ToError("Failed to convert action state", err))
+		return
+	}
+
+	client, err := createLambdaClient(r.Context())
+	if err != nil {
+		exthttp.WriteError(w, extension_kit.ToError("Failed to create lambda client", err))
+		return
+	}
+
+	now := time.Now()
+	_, err = waitForFunctionUpdated(r.Context(), client, state.FunctionArn)
+	if err == nil {
+		err = setEnvironmentVariables(r.Context(), client, state.FunctionArn, map[string]*string{coldStartVariable: extutil.Ptr(strconv.FormatInt(now.UnixMilli(), 10))})
+	}
+	if err != nil {
+		exthttp.WriteError(w, extension_kit.ToError("Failed to discard execution environments", err))
+		return
+	}
+	state.LastTouch = now.UnixMilli()
+	state.Touches = 1
+
+	var convertedState action_kit_api.ActionState
+	err = extconversion.Convert(state, &convertedState)
+	if err != nil {
+		exthttp.WriteError(w, extension_kit.ToError("Failed to encode action state", err))
+		return
+	}
+
+	exthttp.WriteBody(w, action_kit_api.StartResult{
+		State: &convertedState,
+	})
+}
+
+func statusColdStart(w http.ResponseWriter, r *http.Request, body []byte) {
+	var request action_kit_api.ActionStatusRequestBody
+	err := json.Unmarshal(body, &request)
+	if err != nil {
+		exthttp.WriteError(w, extension_kit.ToError("Failed to parse request body", err))
+		return
+	}
+
+	var state ColdStartActionState
+	err = extconversion.Convert(request.State, &state)
+	if err != nil {
+		exthttp.WriteError(w, extension_kit.ToError("Failed to convert action state", err))
+		return
+	}
+
+	now := time.Now()
+	if !state.Repeat || now.UnixMilli()-state.LastTouch < state.IntervalMs {
+		exthttp.WriteBody(w, action_kit_api.StatusResult{Completed: false})
+		return
+	}
+
+	client, err := createLambdaClient(r.Context())
+	if err != nil {
+		exthttp.WriteError(w, extension_kit.ToError("Failed to create lambda client", err))
+		return
+	}
+
+	current, err := client.GetFunctionConfiguration(r.Context(), &lambda.GetFunctionConfigurationInput{
+		FunctionName: extutil.Ptr(state.FunctionArn),
+	})
+	if err != nil {
+		exthttp.WriteError(w, extension_kit.ToError("Failed to get function configuration", err))
+		return
+	}
+	if current.LastUpdateStatus == types.LastUpdateStatusInProgress {
+		// The previous update is still rolling out, try again with the next status call.
+		log.Debug().Msgf("Update of %s still in progress, postponing cold start.", state.FunctionArn)
+		exthttp.WriteBody(w, action_kit_api.StatusResult{Completed: false})
+		return
+	}
+
+	err = setEnvironmentVariables(r.Context(), client, state.FunctionArn, map[string]*string{coldStartVariable: extutil.Ptr(strconv.FormatInt(now.UnixMilli(), 10))})
+	if err != nil {
+		exthttp.WriteError(w, extension_kit.ToError("Failed to discard execution environments", err))
+		return
+	}
+	state.LastTouch = now.UnixMilli()
+	state.Touches++
+
+	var convertedState action_kit_api.ActionState
+	err = extconversion.Convert(state, &convertedState)
+	if err != nil {
+		exthttp.WriteError(w, extension_kit.ToError("Failed to encode action state", err))
+		return
+	}
+
+	exthttp.WriteBody(w, action_kit_api.StatusResult{
+		Completed: false,
+		State:     &convertedState,
+	})
+}
+
+func stopColdStart(w http.ResponseWriter, r *http.Request, body []byte) {
+	var request action_kit_api.StopActionRequestBody
+	err := json.Unmarshal(body, &request)
+	if err != nil {
+		exthttp.WriteError(w, extension_kit.ToError("Failed to parse request body", err))
+		return
+	}
+
+	var state ColdStartActionState
+	err = extconversion.Convert(request.State, &state)
+	if err != nil {
+		exthttp.WriteError(w, extension_kit.ToError("Failed to convert action state", err))
+		return
+	}
+
+	client, err := createLambdaClient(r.Context())
+	if err != nil {
+		exthttp.WriteError(w, extension_kit.ToError("Failed to create lambda client", err))
+		return
+	}
+
+	_, err = waitForFunctionUpdated(r.Context(), client, state.FunctionArn)
+	if err == nil {
+		err = setEnvironmentVariables(r.Context(), client, state.FunctionArn, map[string]*string{coldStartVariable: nil})
+	}
+	if err != nil {
+		exthttp.WriteError(w, extension_kit.ToError(fmt.Sprintf("Failed to remove %s from the function's environment", coldStartVariable), err))
+		return
+	}
+
+	exthttp.WriteBody(w, action_kit_api.StopResult{
+		Messages: extutil.Ptr([]action_kit_api.Message{
+			{
+				Level:   extutil.Ptr(action_kit_api.Info),
+				Message: fmt.Sprintf("Discarded the execution environments of %s %d times.", state.FunctionArn, state.Touches),
+			},
+		}),
+	})
+}
@@ -0,0 +1,65 @@
+/*
+ * Copyright 2023 steadybit GmbH. All rights reserved.
+ */
+
+package extlambda
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestVersionedAliases(t *testing.T) {
+	tests := []struct {
+		name          string
+		aliasVersions []string
+		want          []string
+	}{
+		{name: "no aliases", aliasVersions: nil, want: []string{}},
+		{name: "published versions", aliasVersions: []string{"live:12", "beta:13"}, want: []string{"live", "beta"}},
+		{name: "skips aliases on $LATEST", aliasVersions: []string{"dev:$LATEST", "live:12"}, want: []string{"live"}},
+		{name: "skips malformed entries", aliasVersions: []string{"live", ":3"}, want: []string{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := versionedAliases(tt.aliasVersions); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("versionedAliases() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestConfigDuration(t *testing.T) {
+	config := map[string]interface{}{"interval": float64(30000), "text": "30s"}
+	if got := configDuration(config, "interval"); got != 30*time.Second {
+		t.Errorf("configDuration(interval) = %v", got)
+	}
+	if got := configDuration(config, "text"); got != 0 {
+		t.Errorf("configDuration(text) = %v", got)
+	}
+	if got := configDuration(config, "absent"); got != 0 {
+		t.Errorf("configDuration(absent) = %v", got)
+	}
+}
+
+func TestValidateColdStartInterval(t *testing.T) {
+	tests := []struct {
+		name    string
+		state   ColdStartActionState
+		wantErr bool
+	}{
+		{name: "zero interval", state: ColdStartActionState{Repeat: true, IntervalMs: 0}, wantErr: true},
+		{name: "below status interval", state: ColdStartActionState{Repeat: true, IntervalMs: 4999}, wantErr: true},
+		{name: "status interval", state: ColdStartActionState{Repeat: true, IntervalMs: 5000}, wantErr: false},
+		{name: "default interval", state: ColdStartActionState{Repeat: true, IntervalMs: 30000}, wantErr: false},
+		{name: "ignored without repeat", state: ColdStartActionState{Repeat: false, IntervalMs: 0}, wantErr: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := validateColdStartInterval(tt.state); (got != nil) != tt.wantErr {
+				t.Errorf("validateColdStartInterval() = %v, want error %v", got, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,294 @@
+/*
+ * Copyright 2023 steadybit GmbH. All rights reserved.
+ */
+
+package extlambda
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/lambda"
+	"github.com/steadybit/action-kit/go/action_kit_api/v2"
+	extension_kit "github.com/steadybit/extension-kit"
+	"github.com/steadybit/extension-kit/extbuild"
+	"github.com/steadybit/extension-kit/extconversion"
+	"github.com/steadybit/extension-kit/exthttp"
+	"github.com/steadybit/extension-kit/extutil"
+	"net/http"
+)
+
+const (
+	concurrencyExhaustionActionBasePath = basePath + "/actions/concurrency-exhaustion"
+	// minimumUnreservedConcurrency is the part of the account's concurrency AWS never allows to be reserved.
+	minimumUnreservedConcurrency = 100
+)
+
+func registerConcurrencyExhaustionActionHandlers() {
+	exthttp.RegisterHttpHandler(concurrencyExhaustionActionBasePath, exthttp.GetterAsHandler(getConcurrencyExhaustionActionDescription))
+	exthttp.RegisterHttpHandler(concurrencyExhaustionActionBasePath+"/prepare", prepareConcurrencyExhaustion)
+	exthttp.RegisterHttpHandler(concurrencyExhaustionActionBasePath+"/start", startConcurrencyExhaustion)
+	exthttp.RegisterHttpHandler(concurrencyExhaustionActionBasePath+"/stop", stopConcurrencyExhaustion)
+}
+
+func getConcurrencyExhaustionActionDescription() action_kit_api.ActionDescription {
+	return action_kit_api.ActionDescription{
+		Id:          fmt.Sprintf("%s.concurrencyExhaustion", targetID),
+		Version:     extbuild.GetSemverVersionStringOrUnknown(),
+		Label:       "Exhaust Account Concurrency",
+		Description: "Reserves the region's unreserved concurrency for the given functions, like a noisy neighbour would consume it. AWS always keeps 100 executions unreserved.",
+		Icon:        extutil.Ptr(targetIcon),
+		Category:    extutil.Ptr("cloud"),
+		Kind:        action_kit_api.Attack,
+		TimeControl: action_kit_api.External,
+		Parameters: []action_kit_api.ActionParameter{
+			{
+				Label:        "Duration",
+				Name:         "duration",
+				Type:         action_kit_api.Duration,
+				Description:  extutil.Ptr("The duration of the attack."),
+				Advanced:     extutil.Ptr(false),
+				Required:     extutil.Ptr(true),
+				DefaultValue: extutil.Ptr("60s"),
+				Order:        extutil.Ptr(0),
+			},
+			{
+				Name:        "functions",
+				Label:       "Functions",
+				Description: extutil.Ptr("The names of the functions the concurrency is reserved for, e.g. a single idle placeholder function. The concurrency is spread evenly across them."),
+				Type:        action_kit_api.StringArray,
+				Required:    extutil.Ptr(true),
+				Order:       extutil.Ptr(1),
+			},
+			{
+				Name:         "remaining",
+				Label:        "Remaining Concurrency",
+				Description:  extutil.Ptr("The unreserved concurrency left for all other functions, at least 100."),
+				Type:         action_kit_api.Integer,
+				DefaultValue: extutil.Ptr("100"),
+				Required:     extutil.Ptr(true),
+				Order:        extutil.Ptr(2),
+			},
+		},
+		Prepare: action_kit_api.MutatingEndpointReference{
+			Method: "POST",
+			Path:   concurrencyExhaustionActionBasePath + "/prepare",
+		},
+		Start: action_kit_api.MutatingEndpointReference{
+			Method: "POST",
+			Path:   concurrencyExhaustionActionBasePath + "/start",
+		},
+		Stop: extutil.Ptr(action_kit_api.MutatingEndpointReference{
+			Method: "POST",
+			Path:   concurrencyExhaustionActionBasePath + "/stop",
+		}),
+	}
+}
+
+type concurrencyReservation struct {
+	FunctionName string `json:"functionName"`
+	// Original is nil if the function had no reserved concurrency
+	Original *int32 `json:"original,omitempty"`
+	Reserved *int32 `json:"reserved,omitempty"`
+}
+
+type ConcurrencyExhaustionActionState struct {
+	Remaining    int32                    `json:"remaining"`
+	Reservations []concurrencyReservation `json:"reservations"`
+}
+
+func prepareConcurrencyExhaustion(w http.ResponseWriter, r *http.Request, body []byte) {
+	var request action_kit_api.PrepareActionRequestBody
+	err := json.Unmarshal(body, &request)
+	if err != nil {
+		exthttp.WriteError(w, extension_kit.ToError("Failed to parse request body", err))
+		return
+	}
+
+	state, extErr := prepareConcurrencyExhaustionState(r.Context(), &request)
+	if extErr != nil {
+		exthttp.WriteError(w, *extErr)
+		return
+	}
+
+	var convertedState action_kit_api.ActionState
+	err = extconversion.Convert(state, &convertedState)
+	if err != nil {
+		exthttp.WriteError(w, extension_kit.ToError("Failed to encode action state", err))
+		return
+	}
+
+	exthttp.WriteBody(w, action_kit_api.PrepareResult{
+		State: convertedState,
+	})
+}
+
+func prepareConcurrencyExhaustionState(ctx context.Context, request *action_kit_api.PrepareActionRequestBody) (*ConcurrencyExhaustionActionState, *extension_kit.ExtensionError) {
+	functions := configStrings(request.Config, "functions")
+	if len(functions) == 0 {
+		return nil, extutil.Ptr(extension_kit.ToError("At least one function has to be given.", nil))
+	}
+	remaining := aws.ToInt32(configInt32(request.Config, "remaining"))
+	if remaining < minimumUnreservedConcurrency {
+		remaining = minimumUnreservedConcurrency
+	}
+
+	client, err := createLambdaClient(ctx)
+	if err != nil {
+		return nil, extutil.Ptr(extension_kit.ToError("Failed to create lambda client", err))
+	}
+
+	state := &ConcurrencyExhaustionActionState{
+		Remaining:    remaining,
+		Reservations: make([]concurrencyReservation, 0, len(functions)),
+	}
+	for _, function := range functions {
+		output, err := client.GetFunctionConcurrency(ctx, &lambda.GetFunctionConcurrencyInput{
+			FunctionName: extutil.Ptr(function),
+		})
+		if err != nil {
+			return nil, extutil.Ptr(extension_kit.ToError(fmt.Sprintf("Failed to get reserved concurrency of %s", function), err))
+		}
+		state.Reservations = append(state.Reservations, concurrencyReservation{
+			FunctionName: function,
+			Original:     output.ReservedConcurrentExecutions,
+		})
+	}
+	return state, nil
+}
+
+func startConcurrencyExhaustion(w http.ResponseWriter, r *http.Request, body []byte) {
+	var request action_kit_api.StartActionRequestBody
+	err := json.Unmarshal(body, &request)
+	if err != nil {
+		exthttp.WriteError(w, extension_kit.ToError("Failed to parse request body", err))
+		return
+	}
+
+	var state ConcurrencyExhaustionActionState
+	err = extconversion.Convert(request.State, &state)
+	if err != nil {
+		exthttp.WriteError(w, extension_kit.ToError("Failed to convert action state", err))
+		return
+	}
+
+	client, err := createLambdaClient(r.Context())
+	if err != nil {
+		exthttp.WriteError(w, extension_kit.ToError("Failed to create lambda client", err))
+		return
+	}
+
+	// The pool is read at start, as other reservations may have changed since prepare
+	settings, err := client.GetAccountSettings(r.Context(), &lambda.GetAccountSettingsInput{})
+	if err != nil {
+		exthttp.WriteError(w, extension_kit.ToError("Failed to get account settings", err))
+		return
+	}
+	available := aws.ToInt32(settings.AccountLimit.UnreservedConcurrentExecutions) - state.Remaining
+	if available <= 0 {
+		exthttp.WriteError(w, extension_kit.ToError(fmt.Sprintf("Only %d executions are unreserved, nothing left to exhaust.", aws.ToInt32(settings.AccountLimit.UnreservedConcurrentExecutions)), nil))
+		return
+	}
+
+	shares := concurrencyShares(available, len(state.Reservations))
+	for i, share := range shares {
+		if share == 0 {
+			continue
+		}
+		reserved := aws.ToInt32(state.Reservations[i].Original) + share
+		_, err = client.PutFunctionConcurrency(r.Context(), &lambda.PutFunctionConcurrencyInput{
+			FunctionName:                 extutil.Ptr(state.Reservations[i].FunctionName),
+			ReservedConcurrentExecutions: extutil.Ptr(reserved),
+		})
+		if err != nil {
+			_ = releaseConcurrency(r.Context(), client, state)
+			exthttp.WriteError(w, extension_kit.ToError(fmt.Sprintf("Failed to reserve concurrency for %s", state.Reservations[i].FunctionName), err))
+			return
+		}
+		state.Reservations[i].Reserved = extutil.Ptr(reserved)
+	}
+
+	var convertedState action_kit_api.ActionState
+	err = extconversion.Convert(state, &convertedState)
+	if err != nil {
+		exthttp.WriteError(w, extension_kit.ToError("Failed to encode action state", err))
+		return
+	}
+
+	exthttp.WriteBody(w, action_kit_api.StartResult{
+		State: &convertedState,
+		Messages: extutil.Ptr([]action_kit_api.Message{
+			{
+				Level:   extutil.Ptr(action_kit_api.Info),
+				Message: fmt.Sprintf("Reserved %d executions, %d remain unreserved.", available, state.Remaining),
+			},
+		}),
+	})
+}
+
+// concurrencyShares splits the available executions evenly across the functions, the first ones get the remainder.
+func concurrencyShares(available int32, functions int) []int32 {
+	shares := make([]int32, functions)
+	for i := range shares {
+		shares[i] = available / int32(functions)
+		if int32(i) < available%int32(functions) {
+			shares[i]++
+		}
+	}
+	return shares
+}
+
+func stopConcurrencyExhaustion(w http.ResponseWriter, r *http.Request, body []byte) {
+	var request action_kit_api.StopActionRequestBody
+	err := json.Unmarshal(body, &request)
+	if err != nil {
+		exthttp.WriteError(w, extension_kit.ToError("Failed to parse request body", err))
+		return
+	}
+
+	var state ConcurrencyExhaustionActionState
+	err = extconversion.Convert(request.State, &state)
+	if err != nil {
+		exthttp.WriteError(w, extension_kit.ToError("Failed to convert action state", err))
+		return
+	}
+
+	client, err := createLambdaClient(r.Context())
+	if err != nil {
+		exthttp.WriteError(w, extension_kit.ToError("Failed to create lambda client", err))
+		return
+	}
+
+	err = releaseConcurrency(r.Context(), client, state)
+	if err != nil {
+		exthttp.WriteError(w, extension_kit.ToError("Failed to release reserved concurrency", err))
+		return
+	}
+
+	exthttp.WriteBody(w, action_kit_api.StopResult{})
+}
+
+// releaseConcurrency restores the original reserved concurrency of all functions the attack reserved concurrency for.
+func releaseConcurrency(ctx context.Context, client *lambda.Client, state ConcurrencyExhaustionActionState) error {
+	for _, reservation := range state.Reservations {
+		if reservation.Reserved == nil {
+			continue
+		}
+		var err error
+		if reservation.Original == nil {
+			_, err = client.DeleteFunctionConcurrency(ctx, &lambda.DeleteFunctionConcurrencyInput{
+				FunctionName: extutil.Ptr(reservation.FunctionName),
+			})
+		} else {
+			_, err = client.PutFunctionConcurrency(ctx, &lambda.PutFunctionConcurrencyInput{
+				FunctionName:                 extutil.Ptr(reservation.FunctionName),
+				ReservedConcurrentExecutions: reservation.Original,
+			})
+		}
+		if err != nil {
+			return fmt.Errorf("failed to restore reserved concurrency of %s: %w", reservation.FunctionName, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,39 @@
+/*
+ * Copyright 2023 steadybit GmbH. All rights reserved.
+ */
+
+package extlambda
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestConcurrencyShares(t *testing.T) {
+	tests := []struct {
+		name      string
+		available int32
+		functions int
+		want      []int32
+	}{
+		{name: "single function", available: 900, functions: 1, want: []int32{900}},
+		{name: "even split", available: 900, functions: 3, want: []int32{300, 300, 300}},
+		{name: "remainder goes to the first functions", available: 11, functions: 3, want: []int32{4, 4, 3}},
+		{name: "fewer executions than functions", available: 2, functions: 3, want: []int32{1, 1, 0}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := concurrencyShares(tt.available, tt.functions)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("concurrencyShares() = %v, want %v", got, tt.want)
+			}
+			sum := int32(0)
+			for _, share := range got {
+				sum += share
+			}
+			if sum != tt.available {
+				t.Errorf("concurrencyShares() reserves %d executions, want %d", sum, tt.available)
+			}
+		})
+	}
+}
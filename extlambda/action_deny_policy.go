@@ -0,0 +1,318 @@
+/*
+ * Copyright 2023 steadybit GmbH. All rights reserved.
+ */
+
+package extlambda
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/iam"
+	iamtypes "github.com/aws/aws-sdk-go-v2/service/iam/types"
+	"github.com/aws/aws-sdk-go-v2/service/lambda"
+	"github.com/steadybit/action-kit/go/action_kit_api/v2"
+	extension_kit "github.com/steadybit/extension-kit"
+	"github.com/steadybit/extension-kit/extbuild"
+	"github.com/steadybit/extension-kit/extconversion"
+	"github.com/steadybit/extension-kit/exthttp"
+	"github.com/steadybit/extension-kit/extutil"
+	"net/http"
+	"strings"
+)
+
+const denyPolicyActionBasePath = basePath + "/actions/deny-policy"
+
+func registerDenyPolicyActionHandlers() {
+	exthttp.RegisterHttpHandler(denyPolicyActionBasePath, exthttp.GetterAsHandler(getDenyPolicyActionDescription))
+	exthttp.RegisterHttpHandler(denyPolicyActionBasePath+"/prepare", prepareDenyPolicy)
+	exthttp.RegisterHttpHandler(denyPolicyActionBasePath+"/start", startDenyPolicy)
+	exthttp.RegisterHttpHandler(denyPolicyActionBasePath+"/stop", stopDenyPolicy)
+}
+
+func getDenyPolicyActionDescription() action_kit_api.ActionDescription {
+	return action_kit_api.ActionDescription{
+		Id:          fmt.Sprintf("%s.denyPolicy", targetID),
+		Version:     extbuild.GetSemverVersionStringOrUnknown(),
+		Label:       "Deny Dependency Access",
+		Description: "Attaches an inline policy to the function's execution role that denies the given actions.",
+		Icon:        extutil.Ptr(targetIcon),
+		TargetType:  extutil.Ptr(targetID),
+		TargetSelectionTemplates: extutil.Ptr([]action_kit_api.TargetSelectionTemplate{
+			{
+				Label: "by function name",
+				Query: "aws.lambda.function-name=\"\"",
+			},
+		}),
+		Category:    extutil.Ptr("cloud"),
+		Kind:        action_kit_api.Attack,
+		TimeControl: action_kit_api.External,
+		Parameters: []action_kit_api.ActionParameter{
+			{
+				Label:        "Duration",
+				Name:         "duration",
+				Type:         action_kit_api.Duration,
+				Description:  extutil.Ptr("The duration of the attack."),
+				Advanced:     extutil.Ptr(false),
+				Required:     extutil.Ptr(true),
+				DefaultValue: extutil.Ptr("60s"),
+				Order:        extutil.Ptr(0),
+			},
+			{
+				Name:        "actions",
+				Label:       "Actions",
+				Description: extutil.Ptr("The IAM actions to deny, e.g. 'dynamodb:*'."),
+				Type:        action_kit_api.StringArray,
+				Required:    extutil.Ptr(true),
+				Order:       extutil.Ptr(1),
+			},
+			{
+				Name:        "resources",
+				Label:       "Resources",
+				Description: extutil.Ptr("The resource ARNs to deny access to. Leave empty to deny access to all resources."),
+				Type:        action_kit_api.StringArray,
+				Required:    extutil.Ptr(false),
+				Order:       extutil.Ptr(2),
+			},
+		},
+		Prepare: action_kit_api.MutatingEndpointReference{
+			Method: "POST",
+			Path:   denyPolicyActionBasePath + "/prepare",
+		},
+		Start: action_kit_api.MutatingEndpointReference{
+			Method: "POST",
+			Path:   denyPolicyActionBasePath + "/start",
+		},
+		Stop: extutil.Ptr(action_kit_api.MutatingEndpointReference{
+			Method: "POST",
+			Path:   denyPolicyActionBasePath + "/stop",
+		}),
+	}
+}
+
+type DenyPolicyActionState struct {
+	RoleName   string   `json:"roleName"`
+	PolicyName string   `json:"policyName"`
+	Actions    []string `json:"actions"`
+	Resources  []string `json:"resources"`
+}
+
+type policyDocument struct {
+	Version   string            `json:"Version"`
+	Statement []policyStatement `json:"Statement"`
+}
+
+type policyStatement struct {
+	Sid      string   `json:"Sid,omitempty"`
+	Effect   string   `json:"Effect"`
+	Action   []string `json:"Action"`
+	Resource []string `json:"Resource"`
+}
+
+func prepareDenyPolicy(w http.ResponseWriter, r *http.Request, body []byte) {
+	var request action_kit_api.PrepareActionRequestBody
+	err := json.Unmarshal(body, &request)
+	if err != nil {
+		exthttp.WriteError(w, extension_kit.ToError("Failed to parse request body", err))
+		return
+	}
+
+	state, extErr := prepareDenyPolicyState(&request)
+	if extErr != nil {
+		exthttp.WriteError(w, *extErr)
+		return
+	}
+
+	var convertedState action_kit_api.ActionState
+	err = extconversion.Convert(state, &convertedState)
+	if err != nil {
+		exthttp.WriteError(w, extension_kit.ToError("Failed to encode action state", err))
+		return
+	}
+
+	// IAM roles are global, but functions can only be listed per region
+	messages := make([]action_kit_api.Message, 0)
+	sharedWith, err := listFunctionsWithRole(r.Context(), targetAttribute(request.Target, "aws.role"))
+	if err != nil {
+		messages = append(messages, action_kit_api.Message{
+			Level:   extutil.Ptr(action_kit_api.Warn),
+			Message: fmt.Sprintf("Failed to check whether role %s is shared with other functions of this region: %s", state.RoleName, err.Error()),
+		})
+	}
+	for _, functionArn := range sharedWith {
+		if functionArn != targetAttribute(request.Target, "aws.arn") {
+			messages = append(messages, action_kit_api.Message{
+				Level:   extutil.Ptr(action_kit_api.Warn),
+				Message: fmt.Sprintf("Role %s is shared with %s, which is affected as well.", state.RoleName, functionArn),
+			})
+		}
+	}
+	messages = append(messages, action_kit_api.Message{
+		Level:   extutil.Ptr(action_kit_api.Info),
+		Message: fmt.Sprintf("Only functions in this region were checked for sharing role %s, users of the role in other regions are affected as well.", state.RoleName),
+	})
+
+	exthttp.WriteBody(w, action_kit_api.PrepareResult{
+		State:    convertedState,
+		Messages: extutil.Ptr(messages),
+	})
+}
+
+func prepareDenyPolicyState(request *action_kit_api.PrepareActionRequestBody) (*DenyPolicyActionState, *extension_kit.ExtensionError) {
+	roleArn := targetAttribute(request.Target, "aws.role")
+	if roleArn == "" {
+		return nil, extutil.Ptr(extension_kit.ToError("Target is missing the 'aws.role' attribute.", nil))
+	}
+	actions := configStrings(request.Config, "actions")
+	if len(actions) == 0 {
+		return nil, extutil.Ptr(extension_kit.ToError("At least one action has to be given.", nil))
+	}
+	resources := configStrings(request.Config, "resources")
+	if len(resources) == 0 {
+		resources = []string{"*"}
+	}
+
+	return &DenyPolicyActionState{
+		// Role ARNs may contain a path, the role name is always the last segment
+		RoleName:   roleArn[strings.LastIndex(roleArn, "/")+1:],
+		PolicyName: denyPolicyName(request.ExecutionId.String(), targetAttribute(request.Target, "aws.arn")),
+		Actions:    actions,
+		Resources:  resources,
+	}, nil
+}
+
+// denyPolicyName names the inline policy of one target. Functions sharing a role get a policy each, so stopping one target keeps the others denied.
+func denyPolicyName(executionId string, functionArn string) string {
+	hash := sha256.Sum256([]byte(functionArn))
+	return fmt.Sprintf("steadybit-deny-%s-%s", executionId[:8], hex.EncodeToString(hash[:])[:12])
+}
+
+// listFunctionsWithRole returns the ARNs of all functions using the given execution role.
+func listFunctionsWithRole(ctx context.Context, roleArn string) ([]string, error) {
+	client, err := createLambdaClient(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]string, 0)
+	var marker *string = nil
+	for {
+		output, err := client.ListFunctions(ctx, &lambda.ListFunctionsInput{
+			Marker: marker,
+		})
+		if err != nil {
+			return result, err
+		}
+
+		for _, function := range output.Functions {
+			if aws.ToString(function.Role) == roleArn {
+				result = append(result, aws.ToString(function.FunctionArn))
+			}
+		}
+
+		if output.NextMarker == nil {
+			break
+		} else {
+			marker = output.NextMarker
+		}
+	}
+	return result, nil
+}
+
+func startDenyPolicy(w http.ResponseWriter, r *http.Request, body []byte) {
+	var request action_kit_api.StartActionRequestBody
+	err := json.Unmarshal(body, &request)
+	if err != nil {
+		exthttp.WriteError(w, extension_kit.ToError("Failed to parse request body", err))
+		return
+	}
+
+	var state DenyPolicyActionState
+	err = extconversion.Convert(request.State, &state)
+	if err != nil {
+		exthttp.WriteError(w, extension_kit.ToError("Failed to convert action state", err))
+		return
+	}
+
+	document, err := json.Marshal(policyDocument{
+		Version: "2012-10-17",
+		Statement: []policyStatement{{
+			Sid:      "SteadybitDeny",
+			Effect:   "Deny",
+			Action:   state.Actions,
+			Resource: state.Resources,
+		}},
+	})
+	if err != nil {
+		exthttp.WriteError(w, extension_kit.ToError("Failed to encode policy document", err))
+		return
+	}
+
+	client, err := createIamClient(r.Context())
+	if err != nil {
+		exthttp.WriteError(w, extension_kit.ToError("Failed to create iam client", err))
+		return
+	}
+
+	_, err = client.PutRolePolicy(r.Context(), &iam.PutRolePolicyInput{
+		RoleName:       extutil.Ptr(state.RoleName),
+		PolicyName:     extutil.Ptr(state.PolicyName),
+		PolicyDocument: extutil.Ptr(string(document)),
+	})
+	if err != nil {
+		exthttp.WriteError(w, extension_kit.ToError(fmt.Sprintf("Failed to put deny policy on role %s", state.RoleName), err))
+		return
+	}
+
+	exthttp.WriteBody(w, action_kit_api.StartResult{})
+}
+
+func stopDenyPolicy(w http.ResponseWriter, r *http.Request, body []byte) {
+	var request action_kit_api.StopActionRequestBody
+	err := json.Unmarshal(body, &request)
+	if err != nil {
+		exthttp.WriteError(w, extension_kit.ToError("Failed to parse request body", err))
+		return
+	}
+
+	var state DenyPolicyActionState
+	err = extconversion.Convert(request.State, &state)
+	if err != nil {
+		exthttp.WriteError(w, extension_kit.ToError("Failed to convert action state", err))
+		return
+	}
+
+	client, err := createIamClient(r.Context())
+	if err != nil {
+		exthttp.WriteError(w, extension_kit.ToError("Failed to create iam client", err))
+		return
+	}
+
+	_, err = client.DeleteRolePolicy(r.Context(), &iam.DeleteRolePolicyInput{
+		RoleName:   extutil.Ptr(state.RoleName),
+		PolicyName: extutil.Ptr(state.PolicyName),
+	})
+	if err != nil {
+		var notFound *iamtypes.NoSuchEntityException
+		if !errors.As(err, &notFound) {
+			exthttp.WriteError(w, extension_kit.ToError(fmt.Sprintf("Failed to delete deny policy from role %s", state.RoleName), err))
+			return
+		}
+	}
+
+	exthttp.WriteBody(w, action_kit_api.StopResult{})
+}
+
+func createIamClient(ctx context.Context) (*iam.Client, error) {
+	awsConfig, err := config.LoadDefaultConfig(ctx)
+	if err != nil {
+		return nil, err
+	}
+	client := iam.NewFromConfig(awsConfig)
+	return client, err
+}
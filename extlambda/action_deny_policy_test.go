@@ -0,0 +1,89 @@
+/*
+ * Copyright 2023 steadybit GmbH. All rights reserved.
+ */
+
+package extlambda
+
+import (
+	"github.com/steadybit/action-kit/go/action_kit_api/v2"
+	"reflect"
+	"testing"
+)
+
+func TestPrepareDenyPolicyState(t *testing.T) {
+	functionArn := "arn:aws:lambda:eu-central-1:123456789012:function:my-function"
+	tests := []struct {
+		name       string
+		attributes map[string][]string
+		config     map[string]interface{}
+		want       *DenyPolicyActionState
+		wantErr    bool
+	}{
+		{
+			name:       "missing role",
+			attributes: map[string][]string{"aws.arn": {functionArn}},
+			config:     map[string]interface{}{"actions": []interface{}{"dynamodb:*"}},
+			wantErr:    true,
+		},
+		{
+			name:       "missing actions",
+			attributes: map[string][]string{"aws.arn": {functionArn}, "aws.role": {"arn:aws:iam::123456789012:role/my-role"}},
+			config:     map[string]interface{}{},
+			wantErr:    true,
+		},
+		{
+			name:       "denies all resources by default",
+			attributes: map[string][]string{"aws.arn": {functionArn}, "aws.role": {"arn:aws:iam::123456789012:role/my-role"}},
+			config:     map[string]interface{}{"actions": []interface{}{"dynamodb:*"}},
+			want: &DenyPolicyActionState{
+				RoleName:   "my-role",
+				PolicyName: denyPolicyName("00000000-0000-0000-0000-000000000000", functionArn),
+				Actions:    []string{"dynamodb:*"},
+				Resources:  []string{"*"},
+			},
+		},
+		{
+			name:       "role with path",
+			attributes: map[string][]string{"aws.arn": {functionArn}, "aws.role": {"arn:aws:iam::123456789012:role/service-role/my-role"}},
+			config:     map[string]interface{}{"actions": []interface{}{"s3:GetObject"}, "resources": []interface{}{"arn:aws:s3:::my-bucket/*"}},
+			want: &DenyPolicyActionState{
+				RoleName:   "my-role",
+				PolicyName: denyPolicyName("00000000-0000-0000-0000-000000000000", functionArn),
+				Actions:    []string{"s3:GetObject"},
+				Resources:  []string{"arn:aws:s3:::my-bucket/*"},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			request := action_kit_api.PrepareActionRequestBody{
+				Target: &action_kit_api.Target{Attributes: tt.attributes},
+				Config: tt.config,
+			}
+			got, extErr := prepareDenyPolicyState(&request)
+			if (extErr != nil) != tt.wantErr {
+				t.Fatalf("prepareDenyPolicyState() error = %v, want error %v", extErr, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("prepareDenyPolicyState() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDenyPolicyName(t *testing.T) {
+	executionId := "3f2b1c4d-5e6f-4a7b-8c9d-0e1f2a3b4c5d"
+	first := denyPolicyName(executionId, "arn:aws:lambda:eu-central-1:123456789012:function:first")
+	second := denyPolicyName(executionId, "arn:aws:lambda:eu-central-1:123456789012:function:second")
+
+	if first == second {
+		t.Errorf("targets sharing a role share the policy name %q", first)
+	}
+	if first != denyPolicyName(executionId, "arn:aws:lambda:eu-central-1:123456789012:function:first") {
+		t.Errorf("names are not stable")
+	}
+	// IAM limits inline policy names to 128 characters
+	if len(first) > 128 {
+		t.Errorf("%q is longer than 128 characters", first)
+	}
+}
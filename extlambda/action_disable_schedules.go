@@ -0,0 +1,459 @@
+/*
+ * Copyright 2023 steadybit GmbH. All rights reserved.
+ */
+
+package extlambda
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/eventbridge"
+	ebtypes "github.com/aws/aws-sdk-go-v2/service/eventbridge/types"
+	"github.com/aws/aws-sdk-go-v2/service/scheduler"
+	schedulertypes "github.com/aws/aws-sdk-go-v2/service/scheduler/types"
+	"github.com/rs/zerolog/log"
+	"github.com/steadybit/action-kit/go/action_kit_api/v2"
+	extension_kit "github.com/steadybit/extension-kit"
+	"github.com/steadybit/extension-kit/extbuild"
+	"github.com/steadybit/extension-kit/extconversion"
+	"github.com/steadybit/extension-kit/exthttp"
+	"github.com/steadybit/extension-kit/extutil"
+	"net/http"
+)
+
+const disableSchedulesActionBasePath = basePath + "/actions/disable-schedules"
+
+func registerDisableSchedulesActionHandlers() {
+	exthttp.RegisterHttpHandler(disableSchedulesActionBasePath, exthttp.GetterAsHandler(getDisableSchedulesActionDescription))
+	exthttp.RegisterHttpHandler(disableSchedulesActionBasePath+"/prepare", prepareDisableSchedules)
+	exthttp.RegisterHttpHandler(disableSchedulesActionBasePath+"/start", startDisableSchedules)
+	exthttp.RegisterHttpHandler(disableSchedulesActionBasePath+"/stop", stopDisableSchedules)
+}
+
+func getDisableSchedulesActionDescription() action_kit_api.ActionDescription {
+	return action_kit_api.ActionDescription{
+		Id:          fmt.Sprintf("%s.disableSchedules", targetID),
+		Version:     extbuild.GetSemverVersionStringOrUnknown(),
+		Label:       "Disable EventBridge Rules and Schedules",
+		Description: "Disables the EventBridge rules and EventBridge Scheduler schedules targeting the function.",
+		Icon:        extutil.Ptr(targetIcon),
+		TargetType:  extutil.Ptr(targetID),
+		TargetSelectionTemplates: extutil.Ptr([]action_kit_api.TargetSelectionTemplate{
+			{
+				Label: "by function name",
+				Query: "aws.lambda.function-name=\"\"",
+			},
+		}),
+		Category:    extutil.Ptr("cloud"),
+		Kind:        action_kit_api.Attack,
+		TimeControl: action_kit_api.External,
+		Parameters: []action_kit_api.ActionParameter{
+			{
+				Label:        "Duration",
+				Name:         "duration",
+				Type:         action_kit_api.Duration,
+				Description:  extutil.Ptr("The duration of the attack."),
+				Advanced:     extutil.Ptr(false),
+				Required:     extutil.Ptr(true),
+				DefaultValue: extutil.Ptr("5m"),
+				Order:        extutil.Ptr(0),
+			},
+			{
+				Name:         "rules",
+				Label:        "EventBridge Rules",
+				Description:  extutil.Ptr("Disable EventBridge rules targeting the function."),
+				Type:         action_kit_api.Boolean,
+				DefaultValue: extutil.Ptr("true"),
+				Required:     extutil.Ptr(true),
+				Order:        extutil.Ptr(1),
+			},
+			{
+				Name:         "schedules",
+				Label:        "EventBridge Schedules",
+				Description:  extutil.Ptr("Disable EventBridge Scheduler schedules targeting the function."),
+				Type:         action_kit_api.Boolean,
+				DefaultValue: extutil.Ptr("true"),
+				Required:     extutil.Ptr(true),
+				Order:        extutil.Ptr(2),
+			},
+		},
+		Prepare: action_kit_api.MutatingEndpointReference{
+			Method: "POST",
+			Path:   disableSchedulesActionBasePath + "/prepare",
+		},
+		Start: action_kit_api.MutatingEndpointReference{
+			Method: "POST",
+			Path:   disableSchedulesActionBasePath + "/start",
+		},
+		Stop: extutil.Ptr(action_kit_api.MutatingEndpointReference{
+			Method: "POST",
+			Path:   disableSchedulesActionBasePath + "/stop",
+		}),
+	}
+}
+
+type eventBridgeRule struct {
+	Name         string `json:"name"`
+	EventBusName string `json:"eventBusName"`
+}
+
+type eventBridgeSchedule struct {
+	Name      string `json:"name"`
+	GroupName string `json:"groupName"`
+}
+
+// DisableSchedulesActionState only holds rules and schedules which were enabled, so that stop does not enable previously disabled ones.
+type DisableSchedulesActionState struct {
+	FunctionArn string                `json:"functionArn"`
+	Rules       []eventBridgeRule     `json:"rules"`
+	Schedules   []eventBridgeSchedule `json:"schedules"`
+}
+
+func prepareDisableSchedules(w http.ResponseWriter, r *http.Request, body []byte) {
+	var request action_kit_api.PrepareActionRequestBody
+	err := json.Unmarshal(body, &request)
+	if err != nil {
+		exthttp.WriteError(w, extension_kit.ToError("Failed to parse request body", err))
+		return
+	}
+
+	state, extErr := prepareDisableSchedulesState(r.Context(), &request)
+	if extErr != nil {
+		exthttp.WriteError(w, *extErr)
+		return
+	}
+
+	var convertedState action_kit_api.ActionState
+	err = extconversion.Convert(state, &convertedState)
+	if err != nil {
+		exthttp.WriteError(w, extension_kit.ToError("Failed to encode action state", err))
+		return
+	}
+
+	exthttp.WriteBody(w, action_kit_api.PrepareResult{
+		State: convertedState,
+	})
+}
+
+func prepareDisableSchedulesState(ctx context.Context, request *action_kit_api.PrepareActionRequestBody) (*DisableSchedulesActionState, *extension_kit.ExtensionError) {
+	functionArn := targetAttribute(request.Target, "aws.arn")
+	if functionArn == "" {
+		return nil, extutil.Ptr(extension_kit.ToError("Target is missing the 'aws.arn' attribute.", nil))
+	}
+
+	state := &DisableSchedulesActionState{
+		FunctionArn: functionArn,
+		Rules:       make([]eventBridgeRule, 0),
+		Schedules:   make([]eventBridgeSchedule, 0),
+	}
+
+	if configBool(request.Config, "rules") {
+		rules, err := findEnabledRules(ctx, functionArn)
+		if err != nil {
+			return nil, extutil.Ptr(extension_kit.ToError("Failed to find EventBridge rules targeting the function", err))
+		}
+		state.Rules = rules
+	}
+
+	if configBool(request.Config, "schedules") {
+		schedules, err := findEnabledSchedules(ctx, functionArn)
+		if err != nil {
+			return nil, extutil.Ptr(extension_kit.ToError("Failed to find EventBridge schedules targeting the function", err))
+		}
+		state.Schedules = schedules
+	}
+
+	if len(state.Rules) == 0 && len(state.Schedules) == 0 {
+		return nil, extutil.Ptr(extension_kit.ToError(fmt.Sprintf("No enabled EventBridge rule or schedule targets %s.", functionArn), nil))
+	}
+	return state, nil
+}
+
+func findEnabledRules(ctx context.Context, functionArn string) ([]eventBridgeRule, error) {
+	client, err := createEventBridgeClient(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	eventBuses := make([]string, 0)
+	var nextToken *string = nil
+	for {
+		output, err := client.ListEventBuses(ctx, &eventbridge.ListEventBusesInput{NextToken: nextToken})
+		if err != nil {
+			return nil, err
+		}
+		for _, eventBus := range output.EventBuses {
+			eventBuses = append(eventBuses, aws.ToString(eventBus.Name))
+		}
+		if output.NextToken == nil {
+			break
+		} else {
+			nextToken = output.NextToken
+		}
+	}
+
+	// Rules are looked up by their targets, as ListRuleNamesByTarget only finds exact ARNs and misses versions
+	result := make([]eventBridgeRule, 0)
+	for _, eventBus := range eventBuses {
+		var nextToken *string = nil
+		for {
+			output, err := client.ListRules(ctx, &eventbridge.ListRulesInput{
+				EventBusName: extutil.Ptr(eventBus),
+				NextToken:    nextToken,
+			})
+			if err != nil {
+				return nil, err
+			}
+			for _, rule := range output.Rules {
+				if rule.State != ebtypes.RuleStateEnabled {
+					continue
+				}
+				targeted, err := ruleTargetsFunction(ctx, client, eventBus, aws.ToString(rule.Name), functionArn)
+				if err != nil {
+					return nil, err
+				}
+				if targeted {
+					result = append(result, eventBridgeRule{Name: aws.ToString(rule.Name), EventBusName: eventBus})
+				}
+			}
+			if output.NextToken == nil {
+				break
+			} else {
+				nextToken = output.NextToken
+			}
+		}
+	}
+	return result, nil
+}
+
+func ruleTargetsFunction(ctx context.Context, client *eventbridge.Client, eventBus string, rule string, functionArn string) (bool, error) {
+	var nextToken *string = nil
+	for {
+		output, err := client.ListTargetsByRule(ctx, &eventbridge.ListTargetsByRuleInput{
+			Rule:         extutil.Ptr(rule),
+			EventBusName: extutil.Ptr(eventBus),
+			NextToken:    nextToken,
+		})
+		if err != nil {
+			return false, err
+		}
+		if anyTargetIsFunction(output.Targets, functionArn) {
+			return true, nil
+		}
+		if output.NextToken == nil {
+			return false, nil
+		} else {
+			nextToken = output.NextToken
+		}
+	}
+}
+
+func findEnabledSchedules(ctx context.Context, functionArn string) ([]eventBridgeSchedule, error) {
+	client, err := createSchedulerClient(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]eventBridgeSchedule, 0)
+	var nextToken *string = nil
+	for {
+		output, err := client.ListSchedules(ctx, &scheduler.ListSchedulesInput{
+			State:     schedulertypes.ScheduleStateEnabled,
+			NextToken: nextToken,
+		})
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, schedulesTargetingFunction(output.Schedules, functionArn)...)
+		if output.NextToken == nil {
+			break
+		} else {
+			nextToken = output.NextToken
+		}
+	}
+	return result, nil
+}
+
+// anyTargetIsFunction returns whether one of the rule targets is the function or one of its aliases and versions.
+func anyTargetIsFunction(targets []ebtypes.Target, functionArn string) bool {
+	for _, target := range targets {
+		if isFunctionOrQualifier(aws.ToString(target.Arn), functionArn) {
+			return true
+		}
+	}
+	return false
+}
+
+// schedulesTargetingFunction selects the schedules targeting the function or one of its aliases and versions.
+func schedulesTargetingFunction(schedules []schedulertypes.ScheduleSummary, functionArn string) []eventBridgeSchedule {
+	result := make([]eventBridgeSchedule, 0)
+	for _, schedule := range schedules {
+		if schedule.Target == nil {
+			continue
+		}
+		if isFunctionOrQualifier(aws.ToString(schedule.Target.Arn), functionArn) {
+			result = append(result, eventBridgeSchedule{
+				Name:      aws.ToString(schedule.Name),
+				GroupName: aws.ToString(schedule.GroupName),
+			})
+		}
+	}
+	return result
+}
+
+func startDisableSchedules(w http.ResponseWriter, r *http.Request, body []byte) {
+	var request action_kit_api.StartActionRequestBody
+	err := json.Unmarshal(body, &request)
+	if err != nil {
+		exthttp.WriteError(w, extension_kit.ToError("Failed to parse request body", err))
+		return
+	}
+
+	var state DisableSchedulesActionState
+	err = extconversion.Convert(request.State, &state)
+	if err != nil {
+		exthttp.WriteError(w, extension_kit.ToError("Failed to convert action state", err))
+		return
+	}
+
+	extErr := setRulesAndSchedulesEnabled(r.Context(), state, false)
+	if extErr != nil {
+		exthttp.WriteError(w, *extErr)
+		return
+	}
+
+	exthttp.WriteBody(w, action_kit_api.StartResult{})
+}
+
+func stopDisableSchedules(w http.ResponseWriter, r *http.Request, body []byte) {
+	var request action_kit_api.StopActionRequestBody
+	err := json.Unmarshal(body, &request)
+	if err != nil {
+		exthttp.WriteError(w, extension_kit.ToError("Failed to parse request body", err))
+		return
+	}
+
+	var state DisableSchedulesActionState
+	err = extconversion.Convert(request.State, &state)
+	if err != nil {
+		exthttp.WriteError(w, extension_kit.ToError("Failed to convert action state", err))
+		return
+	}
+
+	extErr := setRulesAndSchedulesEnabled(r.Context(), state, true)
+	if extErr != nil {
+		exthttp.WriteError(w, *extErr)
+		return
+	}
+
+	exthttp.WriteBody(w, action_kit_api.StopResult{})
+}
+
+// setRulesAndSchedulesEnabled enables or disables all rules and schedules of the state. If disabling fails, the ones disabled so far are enabled again.
+func setRulesAndSchedulesEnabled(ctx context.Context, state DisableSchedulesActionState, enabled bool) *extension_kit.ExtensionError {
+	changed := DisableSchedulesActionState{
+		FunctionArn: state.FunctionArn,
+		Rules:       make([]eventBridgeRule, 0),
+		Schedules:   make([]eventBridgeSchedule, 0),
+	}
+	fail := func(message string, err error) *extension_kit.ExtensionError {
+		if !enabled && (len(changed.Rules) > 0 || len(changed.Schedules) > 0) {
+			// Roll back the rules and schedules disabled so far, as stop is not called for a failed start
+			if extErr := setRulesAndSchedulesEnabled(ctx, changed, true); extErr != nil {
+				log.Error().Msgf("Failed to roll back disabled rules and schedules of %s: %s", state.FunctionArn, extErr.Title)
+			}
+		}
+		return extutil.Ptr(extension_kit.ToError(message, err))
+	}
+
+	if len(state.Rules) > 0 {
+		client, err := createEventBridgeClient(ctx)
+		if err != nil {
+			return fail("Failed to create eventbridge client", err)
+		}
+		for _, rule := range state.Rules {
+			if enabled {
+				_, err = client.EnableRule(ctx, &eventbridge.EnableRuleInput{
+					Name:         extutil.Ptr(rule.Name),
+					EventBusName: extutil.Ptr(rule.EventBusName),
+				})
+			} else {
+				_, err = client.DisableRule(ctx, &eventbridge.DisableRuleInput{
+					Name:         extutil.Ptr(rule.Name),
+					EventBusName: extutil.Ptr(rule.EventBusName),
+				})
+			}
+			if err != nil {
+				return fail(fmt.Sprintf("Failed to change state of rule %s", rule.Name), err)
+			}
+			changed.Rules = append(changed.Rules, rule)
+		}
+	}
+
+	if len(state.Schedules) > 0 {
+		client, err := createSchedulerClient(ctx)
+		if err != nil {
+			return fail("Failed to create scheduler client", err)
+		}
+		scheduleState := schedulertypes.ScheduleStateDisabled
+		if enabled {
+			scheduleState = schedulertypes.ScheduleStateEnabled
+		}
+		for _, schedule := range state.Schedules {
+			err = setScheduleState(ctx, client, schedule, scheduleState)
+			if err != nil {
+				return fail(fmt.Sprintf("Failed to change state of schedule %s", schedule.Name), err)
+			}
+			changed.Schedules = append(changed.Schedules, schedule)
+		}
+	}
+	return nil
+}
+
+// setScheduleState changes the state of a schedule. UpdateSchedule replaces the whole schedule, so all other settings are copied over.
+func setScheduleState(ctx context.Context, client *scheduler.Client, schedule eventBridgeSchedule, state schedulertypes.ScheduleState) error {
+	current, err := client.GetSchedule(ctx, &scheduler.GetScheduleInput{
+		Name:      extutil.Ptr(schedule.Name),
+		GroupName: extutil.Ptr(schedule.GroupName),
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = client.UpdateSchedule(ctx, &scheduler.UpdateScheduleInput{
+		Name:                       current.Name,
+		ActionAfterCompletion:      current.ActionAfterCompletion,
+		GroupName:                  current.GroupName,
+		Description:                current.Description,
+		FlexibleTimeWindow:         current.FlexibleTimeWindow,
+		ScheduleExpression:         current.ScheduleExpression,
+		ScheduleExpressionTimezone: current.ScheduleExpressionTimezone,
+		StartDate:                  current.StartDate,
+		EndDate:                    current.EndDate,
+		KmsKeyArn:                  current.KmsKeyArn,
+		Target:                     current.Target,
+		State:                      state,
+	})
+	return err
+}
+
+func createEventBridgeClient(ctx context.Context) (*eventbridge.Client, error) {
+	awsConfig, err := config.LoadDefaultConfig(ctx)
+	if err != nil {
+		return nil, err
+	}
+	client := eventbridge.NewFromConfig(awsConfig)
+	return client, err
+}
+
+func createSchedulerClient(ctx context.Context) (*scheduler.Client, error) {
+	awsConfig, err := config.LoadDefaultConfig(ctx)
+	if err != nil {
+		return nil, err
+	}
+	client := scheduler.NewFromConfig(awsConfig)
+	return client, err
+}
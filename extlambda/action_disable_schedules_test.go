@@ -0,0 +1,57 @@
+/*
+ * Copyright 2023 steadybit GmbH. All rights reserved.
+ */
+
+package extlambda
+
+import (
+	ebtypes "github.com/aws/aws-sdk-go-v2/service/eventbridge/types"
+	schedulertypes "github.com/aws/aws-sdk-go-v2/service/scheduler/types"
+	"github.com/steadybit/extension-kit/extutil"
+	"reflect"
+	"testing"
+)
+
+func TestAnyTargetIsFunction(t *testing.T) {
+	functionArn := "arn:aws:lambda:eu-central-1:123456789012:function:my-function"
+	tests := []struct {
+		name       string
+		targetArns []string
+		want       bool
+	}{
+		{name: "no targets", targetArns: nil, want: false},
+		{name: "function", targetArns: []string{"arn:aws:sqs:eu-central-1:123456789012:my-queue", functionArn}, want: true},
+		{name: "alias", targetArns: []string{functionArn + ":live"}, want: true},
+		{name: "function with the same prefix", targetArns: []string{functionArn + "-v2"}, want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			targets := make([]ebtypes.Target, 0)
+			for _, targetArn := range tt.targetArns {
+				targets = append(targets, ebtypes.Target{Arn: extutil.Ptr(targetArn)})
+			}
+			if got := anyTargetIsFunction(targets, functionArn); got != tt.want {
+				t.Errorf("anyTargetIsFunction() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSchedulesTargetingFunction(t *testing.T) {
+	functionArn := "arn:aws:lambda:eu-central-1:123456789012:function:my-function"
+	schedules := []schedulertypes.ScheduleSummary{
+		{Name: extutil.Ptr("nightly"), GroupName: extutil.Ptr("default"), Target: &schedulertypes.TargetSummary{Arn: extutil.Ptr(functionArn)}},
+		{Name: extutil.Ptr("hourly"), GroupName: extutil.Ptr("jobs"), Target: &schedulertypes.TargetSummary{Arn: extutil.Ptr(functionArn + ":3")}},
+		{Name: extutil.Ptr("other"), GroupName: extutil.Ptr("default"), Target: &schedulertypes.TargetSummary{Arn: extutil.Ptr(functionArn + "-v2")}},
+		{Name: extutil.Ptr("no-target"), GroupName: extutil.Ptr("default")},
+	}
+
+	got := schedulesTargetingFunction(schedules, functionArn)
+	want := []eventBridgeSchedule{
+		{Name: "nightly", GroupName: "default"},
+		{Name: "hourly", GroupName: "jobs"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("schedulesTargetingFunction() = %v, want %v", got, want)
+	}
+}
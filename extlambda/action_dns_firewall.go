@@ -0,0 +1,500 @@
+/*
+ * Copyright 2023 steadybit GmbH. All rights reserved.
+ */
+
+package extlambda
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/lambda"
+	"github.com/aws/aws-sdk-go-v2/service/route53resolver"
+	resolvertypes "github.com/aws/aws-sdk-go-v2/service/route53resolver/types"
+	"github.com/rs/zerolog/log"
+	"github.com/steadybit/action-kit/go/action_kit_api/v2"
+	extension_kit "github.com/steadybit/extension-kit"
+	"github.com/steadybit/extension-kit/extbuild"
+	"github.com/steadybit/extension-kit/extconversion"
+	"github.com/steadybit/extension-kit/exthttp"
+	"github.com/steadybit/extension-kit/extutil"
+	"net/http"
+	"time"
+)
+
+const dnsFirewallActionBasePath = basePath + "/actions/dns-firewall"
+
+func registerDnsFirewallActionHandlers() {
+	exthttp.RegisterHttpHandler(dnsFirewallActionBasePath, exthttp.GetterAsHandler(getDnsFirewallActionDescription))
+	exthttp.RegisterHttpHandler(dnsFirewallActionBasePath+"/prepare", prepareDnsFirewall)
+	exthttp.RegisterHttpHandler(dnsFirewallActionBasePath+"/start", startDnsFirewall)
+	exthttp.RegisterHttpHandler(dnsFirewallActionBasePath+"/stop", stopDnsFirewall)
+}
+
+func getDnsFirewallActionDescription() action_kit_api.ActionDescription {
+	return action_kit_api.ActionDescription{
+		Id:          fmt.Sprintf("%s.dnsFirewall", targetID),
+		Version:     extbuild.GetSemverVersionStringOrUnknown(),
+		Label:       "Block DNS Resolution",
+		Description: "Blocks the resolution of domains in the function's VPC with a Route 53 Resolver DNS Firewall rule group. All resources in the VPC are affected. Every target associates its own rule group, so the domains stay blocked until the last target in the VPC is stopped.",
+		Icon:        extutil.Ptr(targetIcon),
+		TargetType:  extutil.Ptr(targetID),
+		TargetSelectionTemplates: extutil.Ptr([]action_kit_api.TargetSelectionTemplate{
+			{
+				Label: "by function name",
+				Query: "aws.lambda.function-name=\"\"",
+			},
+		}),
+		Category:    extutil.Ptr("cloud"),
+		Kind:        action_kit_api.Attack,
+		TimeControl: action_kit_api.External,
+		Parameters: []action_kit_api.ActionParameter{
+			{
+				Label:        "Duration",
+				Name:         "duration",
+				Type:         action_kit_api.Duration,
+				Description:  extutil.Ptr("The duration of the attack."),
+				Advanced:     extutil.Ptr(false),
+				Required:     extutil.Ptr(true),
+				DefaultValue: extutil.Ptr("60s"),
+				Order:        extutil.Ptr(0),
+			},
+			{
+				Name:        "domains",
+				Label:       "Domains",
+				Description: extutil.Ptr("The domains to block, wildcards like '*.example.com' are supported."),
+				Type:        action_kit_api.StringArray,
+				Required:    extutil.Ptr(true),
+				Order:       extutil.Ptr(1),
+			},
+			{
+				Name:         "blockResponse",
+				Label:        "Response",
+				Description:  extutil.Ptr("The response returned for blocked queries."),
+				Type:         action_kit_api.String,
+				DefaultValue: extutil.Ptr(string(resolvertypes.BlockResponseNxdomain)),
+				Required:     extutil.Ptr(true),
+				Order:        extutil.Ptr(2),
+				Options: extutil.Ptr([]action_kit_api.ParameterOption{
+					action_kit_api.ExplicitParameterOption{Label: "NXDOMAIN", Value: string(resolvertypes.BlockResponseNxdomain)},
+					action_kit_api.ExplicitParameterOption{Label: "NODATA", Value: string(resolvertypes.BlockResponseNodata)},
+				}),
+			},
+			{
+				Name:         "priority",
+				Label:        "Association Priority",
+				Description:  extutil.Ptr("The priority of the rule group association in the VPC, between 101 and 9899. If it is used by another association, the next free priority is taken."),
+				Type:         action_kit_api.Integer,
+				DefaultValue: extutil.Ptr("101"),
+				Required:     extutil.Ptr(true),
+				Advanced:     extutil.Ptr(true),
+				Order:        extutil.Ptr(3),
+			},
+		},
+		Prepare: action_kit_api.MutatingEndpointReference{
+			Method: "POST",
+			Path:   dnsFirewallActionBasePath + "/prepare",
+		},
+		Start: action_kit_api.MutatingEndpointReference{
+			Method: "POST",
+			Path:   dnsFirewallActionBasePath + "/start",
+		},
+		Stop: extutil.Ptr(action_kit_api.MutatingEndpointReference{
+			Method: "POST",
+			Path:   dnsFirewallActionBasePath + "/stop",
+		}),
+	}
+}
+
+type DnsFirewallActionState struct {
+	ExecutionId   string   `json:"executionId"`
+	FunctionArn   string   `json:"functionArn"`
+	VpcId         string   `json:"vpcId"`
+	Domains       []string `json:"domains"`
+	BlockResponse string   `json:"blockResponse"`
+	Priority      int32    `json:"priority"`
+	DomainListId  string   `json:"domainListId,omitempty"`
+	RuleGroupId   string   `json:"ruleGroupId,omitempty"`
+	AssociationId string   `json:"associationId,omitempty"`
+}
+
+func prepareDnsFirewall(w http.ResponseWriter, r *http.Request, body []byte) {
+	var request action_kit_api.PrepareActionRequestBody
+	err := json.Unmarshal(body, &request)
+	if err != nil {
+		exthttp.WriteError(w, extension_kit.ToError("Failed to parse request body", err))
+		return
+	}
+
+	state, extErr := prepareDnsFirewallState(r.Context(), &request)
+	if extErr != nil {
+		exthttp.WriteError(w, *extErr)
+		return
+	}
+
+	var convertedState action_kit_api.ActionState
+	err = extconversion.Convert(state, &convertedState)
+	if err != nil {
+		exthttp.WriteError(w, extension_kit.ToError("Failed to encode action state", err))
+		return
+	}
+
+	exthttp.WriteBody(w, action_kit_api.PrepareResult{
+		State: convertedState,
+	})
+}
+
+func prepareDnsFirewallState(ctx context.Context, request *action_kit_api.PrepareActionRequestBody) (*DnsFirewallActionState, *extension_kit.ExtensionError) {
+	functionArn := targetAttribute(request.Target, "aws.arn")
+	if functionArn == "" {
+		return nil, extutil.Ptr(extension_kit.ToError("Target is missing the 'aws.arn' attribute.", nil))
+	}
+	domains := configStrings(request.Config, "domains")
+	if len(domains) == 0 {
+		return nil, extutil.Ptr(extension_kit.ToError("At least one domain has to be given.", nil))
+	}
+	priority := configInt32(request.Config, "priority")
+	if priority == nil {
+		priority = extutil.Ptr(int32(101))
+	}
+
+	client, err := createLambdaClient(ctx)
+	if err != nil {
+		return nil, extutil.Ptr(extension_kit.ToError("Failed to create lambda client", err))
+	}
+
+	function, err := client.GetFunctionConfiguration(ctx, &lambda.GetFunctionConfigurationInput{
+		FunctionName: extutil.Ptr(functionArn),
+	})
+	if err != nil {
+		return nil, extutil.Ptr(extension_kit.ToError("Failed to get function configuration", err))
+	}
+	if function.VpcConfig == nil || aws.ToString(function.VpcConfig.VpcId) == "" {
+		return nil, extutil.Ptr(extension_kit.ToError(fmt.Sprintf("Function %s is not attached to a VPC.", functionArn), nil))
+	}
+
+	return &DnsFirewallActionState{
+		ExecutionId:   request.ExecutionId.String(),
+		FunctionArn:   functionArn,
+		VpcId:         aws.ToString(function.VpcConfig.VpcId),
+		Domains:       domains,
+		BlockResponse: configString(request.Config, "blockResponse"),
+		Priority:      *priority,
+	}, nil
+}
+
+func startDnsFirewall(w http.ResponseWriter, r *http.Request, body []byte) {
+	var request action_kit_api.StartActionRequestBody
+	err := json.Unmarshal(body, &request)
+	if err != nil {
+		exthttp.WriteError(w, extension_kit.ToError("Failed to parse request body", err))
+		return
+	}
+
+	var state DnsFirewallActionState
+	err = extconversion.Convert(request.State, &state)
+	if err != nil {
+		exthttp.WriteError(w, extension_kit.ToError("Failed to convert action state", err))
+		return
+	}
+
+	client, err := createRoute53ResolverClient(r.Context())
+	if err != nil {
+		exthttp.WriteError(w, extension_kit.ToError("Failed to create route53 resolver client", err))
+		return
+	}
+
+	err = createDnsFirewall(r.Context(), client, &state)
+	if err != nil {
+		if cleanupErr := deleteDnsFirewall(r.Context(), client, state); cleanupErr != nil {
+			log.Error().Err(cleanupErr).Msgf("Failed to clean up DNS firewall resources of %s.", state.ExecutionId)
+		}
+		exthttp.WriteError(w, extension_kit.ToError(fmt.Sprintf("Failed to associate DNS firewall with %s", state.VpcId), err))
+		return
+	}
+
+	var convertedState action_kit_api.ActionState
+	err = extconversion.Convert(state, &convertedState)
+	if err != nil {
+		exthttp.WriteError(w, extension_kit.ToError("Failed to encode action state", err))
+		return
+	}
+
+	exthttp.WriteBody(w, action_kit_api.StartResult{
+		State: &convertedState,
+	})
+}
+
+// dnsFirewallName names the resources of one target. Lambda functions sharing a VPC each associate their own rule group,
+// so the names are unique per target and start with the execution id.
+func dnsFirewallName(executionId string, functionArn string) string {
+	hash := sha256.Sum256([]byte(functionArn))
+	return fmt.Sprintf("%s%s", dnsFirewallNamePrefix(executionId), hex.EncodeToString(hash[:])[:12])
+}
+
+func dnsFirewallNamePrefix(executionId string) string {
+	return fmt.Sprintf("steadybit-%s-", executionId)
+}
+
+// maxDnsFirewallPriority is the highest priority Route 53 Resolver accepts for rule group associations.
+const maxDnsFirewallPriority = 9899
+
+// listUsedFirewallPriorities returns the priorities of all rule group associations of the VPC.
+func listUsedFirewallPriorities(ctx context.Context, client *route53resolver.Client, vpcId string) ([]int32, error) {
+	result := make([]int32, 0)
+	var nextToken *string = nil
+	for {
+		output, err := client.ListFirewallRuleGroupAssociations(ctx, &route53resolver.ListFirewallRuleGroupAssociationsInput{
+			VpcId:     extutil.Ptr(vpcId),
+			NextToken: nextToken,
+		})
+		if err != nil {
+			return nil, err
+		}
+		for _, association := range output.FirewallRuleGroupAssociations {
+			result = append(result, aws.ToInt32(association.Priority))
+		}
+
+		if output.NextToken == nil {
+			break
+		} else {
+			nextToken = output.NextToken
+		}
+	}
+	return result, nil
+}
+
+// nextFreePriority returns the lowest priority starting at the given one which is not used, or 0 if none is left.
+func nextFreePriority(used []int32, priority int32) int32 {
+	for ; priority <= maxDnsFirewallPriority; priority++ {
+		free := true
+		for _, usedPriority := range used {
+			if usedPriority == priority {
+				free = false
+				break
+			}
+		}
+		if free {
+			return priority
+		}
+	}
+	return 0
+}
+
+// createDnsFirewall creates domain list, rule group and VPC association. The ids of created resources are recorded in the state.
+// It returns once the association is complete and queries are blocked.
+func createDnsFirewall(ctx context.Context, client *route53resolver.Client, state *DnsFirewallActionState) error {
+	name := dnsFirewallName(state.ExecutionId, state.FunctionArn)
+	tags := []resolvertypes.Tag{{Key: extutil.Ptr("created-by"), Value: extutil.Ptr("steadybit")}}
+
+	domainList, err := client.CreateFirewallDomainList(ctx, &route53resolver.CreateFirewallDomainListInput{
+		CreatorRequestId: extutil.Ptr(name + "-domains"),
+		Name:             extutil.Ptr(name),
+		Tags:             tags,
+	})
+	if err != nil {
+		return err
+	}
+	state.DomainListId = aws.ToString(domainList.FirewallDomainList.Id)
+
+	_, err = client.UpdateFirewallDomains(ctx, &route53resolver.UpdateFirewallDomainsInput{
+		FirewallDomainListId: extutil.Ptr(state.DomainListId),
+		Operation:            resolvertypes.FirewallDomainUpdateOperationAdd,
+		Domains:              state.Domains,
+	})
+	if err != nil {
+		return err
+	}
+
+	ruleGroup, err := client.CreateFirewallRuleGroup(ctx, &route53resolver.CreateFirewallRuleGroupInput{
+		CreatorRequestId: extutil.Ptr(name + "-rules"),
+		Name:             extutil.Ptr(name),
+		Tags:             tags,
+	})
+	if err != nil {
+		return err
+	}
+	state.RuleGroupId = aws.ToString(ruleGroup.FirewallRuleGroup.Id)
+
+	_, err = client.CreateFirewallRule(ctx, &route53resolver.CreateFirewallRuleInput{
+		CreatorRequestId:     extutil.Ptr(name + "-rule"),
+		Name:                 extutil.Ptr(name),
+		FirewallRuleGroupId:  extutil.Ptr(state.RuleGroupId),
+		FirewallDomainListId: extutil.Ptr(state.DomainListId),
+		Priority:             extutil.Ptr(int32(1)),
+		Action:               resolvertypes.ActionBlock,
+		BlockResponse:        resolvertypes.BlockResponse(state.BlockResponse),
+	})
+	if err != nil {
+		return err
+	}
+
+	// Priorities are unique per VPC. Targets starting concurrently may take the same free priority, the losing ones retry.
+	var association *route53resolver.AssociateFirewallRuleGroupOutput
+	for attempt := 0; ; attempt++ {
+		used, err := listUsedFirewallPriorities(ctx, client, state.VpcId)
+		if err != nil {
+			return err
+		}
+		priority := nextFreePriority(used, state.Priority)
+		if priority == 0 {
+			return fmt.Errorf("no free rule group association priority from %d in %s", state.Priority, state.VpcId)
+		}
+		association, err = client.AssociateFirewallRuleGroup(ctx, &route53resolver.AssociateFirewallRuleGroupInput{
+			CreatorRequestId:    extutil.Ptr(fmt.Sprintf("%s-association-%d", name, priority)),
+			Name:                extutil.Ptr(name),
+			FirewallRuleGroupId: extutil.Ptr(state.RuleGroupId),
+			VpcId:               extutil.Ptr(state.VpcId),
+			Priority:            extutil.Ptr(priority),
+			Tags:                tags,
+		})
+		var conflict *resolvertypes.ConflictException
+		if err != nil && errors.As(err, &conflict) && attempt < 5 {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		break
+	}
+	state.AssociationId = aws.ToString(association.FirewallRuleGroupAssociation.Id)
+	return waitForFirewallAssociation(ctx, client, state.AssociationId)
+}
+
+func stopDnsFirewall(w http.ResponseWriter, r *http.Request, body []byte) {
+	var request action_kit_api.StopActionRequestBody
+	err := json.Unmarshal(body, &request)
+	if err != nil {
+		exthttp.WriteError(w, extension_kit.ToError("Failed to parse request body", err))
+		return
+	}
+
+	var state DnsFirewallActionState
+	err = extconversion.Convert(request.State, &state)
+	if err != nil {
+		exthttp.WriteError(w, extension_kit.ToError("Failed to convert action state", err))
+		return
+	}
+
+	client, err := createRoute53ResolverClient(r.Context())
+	if err != nil {
+		exthttp.WriteError(w, extension_kit.ToError("Failed to create route53 resolver client", err))
+		return
+	}
+
+	err = deleteDnsFirewall(r.Context(), client, state)
+	if err != nil {
+		exthttp.WriteError(w, extension_kit.ToError("Failed to remove DNS firewall", err))
+		return
+	}
+
+	exthttp.WriteBody(w, action_kit_api.StopResult{})
+}
+
+// deleteDnsFirewall removes all resources recorded in the state. Resources which are already gone are ignored.
+func deleteDnsFirewall(ctx context.Context, client *route53resolver.Client, state DnsFirewallActionState) error {
+	var notFound *resolvertypes.ResourceNotFoundException
+
+	if state.AssociationId != "" {
+		_, err := client.DisassociateFirewallRuleGroup(ctx, &route53resolver.DisassociateFirewallRuleGroupInput{
+			FirewallRuleGroupAssociationId: extutil.Ptr(state.AssociationId),
+		})
+		if err != nil && !errors.As(err, &notFound) {
+			return err
+		}
+		err = waitForFirewallDisassociation(ctx, client, state.AssociationId)
+		if err != nil {
+			return err
+		}
+	}
+
+	if state.RuleGroupId != "" {
+		if state.DomainListId != "" {
+			_, err := client.DeleteFirewallRule(ctx, &route53resolver.DeleteFirewallRuleInput{
+				FirewallRuleGroupId:  extutil.Ptr(state.RuleGroupId),
+				FirewallDomainListId: extutil.Ptr(state.DomainListId),
+			})
+			if err != nil && !errors.As(err, &notFound) {
+				return err
+			}
+		}
+		_, err := client.DeleteFirewallRuleGroup(ctx, &route53resolver.DeleteFirewallRuleGroupInput{
+			FirewallRuleGroupId: extutil.Ptr(state.RuleGroupId),
+		})
+		if err != nil && !errors.As(err, &notFound) {
+			return err
+		}
+	}
+
+	if state.DomainListId != "" {
+		_, err := client.DeleteFirewallDomainList(ctx, &route53resolver.DeleteFirewallDomainListInput{
+			FirewallDomainListId: extutil.Ptr(state.DomainListId),
+		})
+		if err != nil && !errors.As(err, &notFound) {
+			return err
+		}
+	}
+	return nil
+}
+
+// waitForFirewallAssociation polls until the association is complete, as queries are not blocked before.
+func waitForFirewallAssociation(ctx context.Context, client *route53resolver.Client, associationId string) error {
+	ctx, cancel := context.WithTimeout(ctx, 2*time.Minute)
+	defer cancel()
+	for {
+		output, err := client.GetFirewallRuleGroupAssociation(ctx, &route53resolver.GetFirewallRuleGroupAssociationInput{
+			FirewallRuleGroupAssociationId: extutil.Ptr(associationId),
+		})
+		if err != nil {
+			return err
+		}
+		switch output.FirewallRuleGroupAssociation.Status {
+		case resolvertypes.FirewallRuleGroupAssociationStatusComplete:
+			return nil
+		case resolvertypes.FirewallRuleGroupAssociationStatusDeleting:
+			return fmt.Errorf("firewall rule group association %s is being deleted: %s", associationId, aws.ToString(output.FirewallRuleGroupAssociation.StatusMessage))
+		}
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("firewall rule group association %s did not complete: %w", associationId, ctx.Err())
+		case <-time.After(2 * time.Second):
+		}
+	}
+}
+
+// waitForFirewallDisassociation polls until the association is gone, as the rule group cannot be deleted before.
+func waitForFirewallDisassociation(ctx context.Context, client *route53resolver.Client, associationId string) error {
+	ctx, cancel := context.WithTimeout(ctx, 2*time.Minute)
+	defer cancel()
+	for {
+		_, err := client.GetFirewallRuleGroupAssociation(ctx, &route53resolver.GetFirewallRuleGroupAssociationInput{
+			FirewallRuleGroupAssociationId: extutil.Ptr(associationId),
+		})
+		var notFound *resolvertypes.ResourceNotFoundException
+		if errors.As(err, &notFound) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("firewall rule group association %s was not removed: %w", associationId, ctx.Err())
+		case <-time.After(2 * time.Second):
+		}
+	}
+}
+
+func createRoute53ResolverClient(ctx context.Context) (*route53resolver.Client, error) {
+	awsConfig, err := config.LoadDefaultConfig(ctx)
+	if err != nil {
+		return nil, err
+	}
+	client := route53resolver.NewFromConfig(awsConfig)
+	return client, err
+}
@@ -0,0 +1,55 @@
+/*
+ * Copyright 2023 steadybit GmbH. All rights reserved.
+ */
+
+package extlambda
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestDnsFirewallName(t *testing.T) {
+	executionId := "3f2b1c4d-5e6f-4a7b-8c9d-0e1f2a3b4c5d"
+	first := dnsFirewallName(executionId, "arn:aws:lambda:eu-central-1:123456789012:function:first")
+	second := dnsFirewallName(executionId, "arn:aws:lambda:eu-central-1:123456789012:function:second")
+
+	if first == second {
+		t.Errorf("targets of one execution share the name %q", first)
+	}
+	if first != dnsFirewallName(executionId, "arn:aws:lambda:eu-central-1:123456789012:function:first") {
+		t.Errorf("names are not stable")
+	}
+	for _, name := range []string{first, second} {
+		if !strings.HasPrefix(name, dnsFirewallNamePrefix(executionId)) {
+			t.Errorf("%q does not start with the execution's prefix", name)
+		}
+		// Route 53 Resolver limits names to 64 characters
+		if len(name) > 64 {
+			t.Errorf("%q is longer than 64 characters", name)
+		}
+	}
+}
+
+func TestNextFreePriority(t *testing.T) {
+	tests := []struct {
+		name     string
+		used     []int32
+		priority int32
+		want     int32
+	}{
+		{name: "unused", used: []int32{200}, priority: 101, want: 101},
+		{name: "no associations", used: nil, priority: 150, want: 150},
+		{name: "skips used priorities", used: []int32{101, 102, 104}, priority: 101, want: 103},
+		{name: "ignores lower priorities", used: []int32{100, 101}, priority: 101, want: 102},
+		{name: "highest priority", used: []int32{9898}, priority: 9898, want: maxDnsFirewallPriority},
+		{name: "none left", used: []int32{9899}, priority: 9899, want: 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := nextFreePriority(tt.used, tt.priority); got != tt.want {
+				t.Errorf("nextFreePriority() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,323 @@
+/*
+ * Copyright 2023 steadybit GmbH. All rights reserved.
+ */
+
+package extlambda
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	ec2types "github.com/aws/aws-sdk-go-v2/service/ec2/types"
+	"github.com/aws/aws-sdk-go-v2/service/lambda"
+	"github.com/aws/aws-sdk-go-v2/service/lambda/types"
+	"github.com/steadybit/action-kit/go/action_kit_api/v2"
+	extension_kit "github.com/steadybit/extension-kit"
+	"github.com/steadybit/extension-kit/extbuild"
+	"github.com/steadybit/extension-kit/extconversion"
+	"github.com/steadybit/extension-kit/exthttp"
+	"github.com/steadybit/extension-kit/extutil"
+	"net/http"
+)
+
+const egressBlackholeActionBasePath = basePath + "/actions/egress-blackhole"
+
+// blackholeSecurityGroupName is the name of the security group without egress rules. It is created once per VPC and reused.
+const blackholeSecurityGroupName = "steadybit-lambda-egress-blackhole"
+
+func registerEgressBlackholeActionHandlers() {
+	exthttp.RegisterHttpHandler(egressBlackholeActionBasePath, exthttp.GetterAsHandler(getEgressBlackholeActionDescription))
+	exthttp.RegisterHttpHandler(egressBlackholeActionBasePath+"/prepare", prepareEgressBlackhole)
+	exthttp.RegisterHttpHandler(egressBlackholeActionBasePath+"/start", startEgressBlackhole)
+	exthttp.RegisterHttpHandler(egressBlackholeActionBasePath+"/stop", stopEgressBlackhole)
+}
+
+func getEgressBlackholeActionDescription() action_kit_api.ActionDescription {
+	return action_kit_api.ActionDescription{
+		Id:          fmt.Sprintf("%s.egressBlackhole", targetID),
+		Version:     extbuild.GetSemverVersionStringOrUnknown(),
+		Label:       "Block Egress Traffic",
+		Description: "Swaps the security groups of a VPC function for a security group without egress rules.",
+		Icon:        extutil.Ptr(targetIcon),
+		TargetType:  extutil.Ptr(targetID),
+		TargetSelectionTemplates: extutil.Ptr([]action_kit_api.TargetSelectionTemplate{
+			{
+				Label: "by function name",
+				Query: "aws.lambda.function-name=\"\"",
+			},
+		}),
+		Category:    extutil.Ptr("cloud"),
+		Kind:        action_kit_api.Attack,
+		TimeControl: action_kit_api.External,
+		Parameters: []action_kit_api.ActionParameter{
+			{
+				Label:        "Duration",
+				Name:         "duration",
+				Type:         action_kit_api.Duration,
+				Description:  extutil.Ptr("The duration of the attack."),
+				Advanced:     extutil.Ptr(false),
+				Required:     extutil.Ptr(true),
+				DefaultValue: extutil.Ptr("60s"),
+				Order:        extutil.Ptr(0),
+			},
+		},
+		Prepare: action_kit_api.MutatingEndpointReference{
+			Method: "POST",
+			Path:   egressBlackholeActionBasePath + "/prepare",
+		},
+		Start: action_kit_api.MutatingEndpointReference{
+			Method: "POST",
+			Path:   egressBlackholeActionBasePath + "/start",
+		},
+		Stop: extutil.Ptr(action_kit_api.MutatingEndpointReference{
+			Method: "POST",
+			Path:   egressBlackholeActionBasePath + "/stop",
+		}),
+	}
+}
+
+type EgressBlackholeActionState struct {
+	FunctionArn            string   `json:"functionArn"`
+	VpcId                  string   `json:"vpcId"`
+	SubnetIds              []string `json:"subnetIds"`
+	OriginalSecurityGroups []string `json:"originalSecurityGroups"`
+	// Ipv6AllowedForDualStack is passed along with every VPC config update, as omitting it disables dual stack
+	Ipv6AllowedForDualStack *bool  `json:"ipv6AllowedForDualStack,omitempty"`
+	BlackholeSecurityGroup  string `json:"blackholeSecurityGroup,omitempty"`
+}
+
+func prepareEgressBlackhole(w http.ResponseWriter, r *http.Request, body []byte) {
+	var request action_kit_api.PrepareActionRequestBody
+	err := json.Unmarshal(body, &request)
+	if err != nil {
+		exthttp.WriteError(w, extension_kit.ToError("Failed to parse request body", err))
+		return
+	}
+
+	state, extErr := prepareEgressBlackholeState(r.Context(), &request)
+	if extErr != nil {
+		exthttp.WriteError(w, *extErr)
+		return
+	}
+
+	var convertedState action_kit_api.ActionState
+	err = extconversion.Convert(state, &convertedState)
+	if err != nil {
+		exthttp.WriteError(w, extension_kit.ToError("Failed to encode action state", err))
+		return
+	}
+
+	exthttp.WriteBody(w, action_kit_api.PrepareResult{
+		State: convertedState,
+	})
+}
+
+func prepareEgressBlackholeState(ctx context.Context, request *action_kit_api.PrepareActionRequestBody) (*EgressBlackholeActionState, *extension_kit.ExtensionError) {
+	functionArn := targetAttribute(request.Target, "aws.arn")
+	if functionArn == "" {
+		return nil, extutil.Ptr(extension_kit.ToError("Target is missing the 'aws.arn' attribute.", nil))
+	}
+
+	client, err := createLambdaClient(ctx)
+	if err != nil {
+		return nil, extutil.Ptr(extension_kit.ToError("Failed to create lambda client", err))
+	}
+
+	function, err := client.GetFunctionConfiguration(ctx, &lambda.GetFunctionConfigurationInput{
+		FunctionName: extutil.Ptr(functionArn),
+	})
+	if err != nil {
+		return nil, extutil.Ptr(extension_kit.ToError("Failed to get function configuration", err))
+	}
+	return egressBlackholeState(functionArn, function.VpcConfig)
+}
+
+func egressBlackholeState(functionArn string, vpcConfig *types.VpcConfigResponse) (*EgressBlackholeActionState, *extension_kit.ExtensionError) {
+	if vpcConfig == nil || aws.ToString(vpcConfig.VpcId) == "" {
+		return nil, extutil.Ptr(extension_kit.ToError(fmt.Sprintf("Function %s is not attached to a VPC.", functionArn), nil))
+	}
+
+	return &EgressBlackholeActionState{
+		FunctionArn:             functionArn,
+		VpcId:                   aws.ToString(vpcConfig.VpcId),
+		SubnetIds:               vpcConfig.SubnetIds,
+		OriginalSecurityGroups:  vpcConfig.SecurityGroupIds,
+		Ipv6AllowedForDualStack: vpcConfig.Ipv6AllowedForDualStack,
+	}, nil
+}
+
+func startEgressBlackhole(w http.ResponseWriter, r *http.Request, body []byte) {
+	var request action_kit_api.StartActionRequestBody
+	err := json.Unmarshal(body, &request)
+	if err != nil {
+		exthttp.WriteError(w, extension_kit.ToError("Failed to parse request body", err))
+		return
+	}
+
+	var state EgressBlackholeActionState
+	err = extconversion.Convert(request.State, &state)
+	if err != nil {
+		exthttp.WriteError(w, extension_kit.ToError("Failed to convert action state", err))
+		return
+	}
+
+	ec2Client, err := createEc2Client(r.Context())
+	if err != nil {
+		exthttp.WriteError(w, extension_kit.ToError("Failed to create ec2 client", err))
+		return
+	}
+
+	groupId, err := ensureBlackholeSecurityGroup(r.Context(), ec2Client, state.VpcId)
+	if err != nil {
+		exthttp.WriteError(w, extension_kit.ToError(fmt.Sprintf("Failed to provide blackhole security group in %s", state.VpcId), err))
+		return
+	}
+	state.BlackholeSecurityGroup = groupId
+
+	client, err := createLambdaClient(r.Context())
+	if err != nil {
+		exthttp.WriteError(w, extension_kit.ToError("Failed to create lambda client", err))
+		return
+	}
+
+	err = updateFunctionVpcConfig(r.Context(), client, state.FunctionArn, functionVpcConfig(state.SubnetIds, []string{groupId}, state.Ipv6AllowedForDualStack))
+	if err != nil {
+		exthttp.WriteError(w, extension_kit.ToError("Failed to swap security groups", err))
+		return
+	}
+
+	var convertedState action_kit_api.ActionState
+	err = extconversion.Convert(state, &convertedState)
+	if err != nil {
+		exthttp.WriteError(w, extension_kit.ToError("Failed to encode action state", err))
+		return
+	}
+
+	exthttp.WriteBody(w, action_kit_api.StartResult{
+		State: &convertedState,
+	})
+}
+
+func stopEgressBlackhole(w http.ResponseWriter, r *http.Request, body []byte) {
+	var request action_kit_api.StopActionRequestBody
+	err := json.Unmarshal(body, &request)
+	if err != nil {
+		exthttp.WriteError(w, extension_kit.ToError("Failed to parse request body", err))
+		return
+	}
+
+	var state EgressBlackholeActionState
+	err = extconversion.Convert(request.State, &state)
+	if err != nil {
+		exthttp.WriteError(w, extension_kit.ToError("Failed to convert action state", err))
+		return
+	}
+
+	client, err := createLambdaClient(r.Context())
+	if err != nil {
+		exthttp.WriteError(w, extension_kit.ToError("Failed to create lambda client", err))
+		return
+	}
+
+	err = updateFunctionVpcConfig(r.Context(), client, state.FunctionArn, functionVpcConfig(state.SubnetIds, state.OriginalSecurityGroups, state.Ipv6AllowedForDualStack))
+	if err != nil {
+		exthttp.WriteError(w, extension_kit.ToError("Failed to restore security groups", err))
+		return
+	}
+
+	exthttp.WriteBody(w, action_kit_api.StopResult{})
+}
+
+// updateFunctionVpcConfig waits for pending updates to finish, changes the VPC config and waits until it is applied.
+func updateFunctionVpcConfig(ctx context.Context, client *lambda.Client, functionArn string, vpcConfig *types.VpcConfig) error {
+	current, err := waitForFunctionUpdated(ctx, client, functionArn)
+	if err != nil {
+		return err
+	}
+
+	_, err = client.UpdateFunctionConfiguration(ctx, &lambda.UpdateFunctionConfigurationInput{
+		FunctionName: extutil.Ptr(functionArn),
+		VpcConfig:    vpcConfig,
+		RevisionId:   current.RevisionId,
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = waitForFunctionUpdated(ctx, client, functionArn)
+	return err
+}
+
+// functionVpcConfig builds a complete VPC config, fields left out of an update are reset by Lambda.
+func functionVpcConfig(subnetIds []string, securityGroupIds []string, ipv6AllowedForDualStack *bool) *types.VpcConfig {
+	return &types.VpcConfig{
+		SubnetIds:               subnetIds,
+		SecurityGroupIds:        securityGroupIds,
+		Ipv6AllowedForDualStack: ipv6AllowedForDualStack,
+	}
+}
+
+// ensureBlackholeSecurityGroup looks up or creates the blackhole security group of the VPC and removes any egress rule from it.
+func ensureBlackholeSecurityGroup(ctx context.Context, client *ec2.Client, vpcId string) (string, error) {
+	output, err := client.DescribeSecurityGroups(ctx, &ec2.DescribeSecurityGroupsInput{
+		Filters: []ec2types.Filter{
+			{Name: extutil.Ptr("vpc-id"), Values: []string{vpcId}},
+			{Name: extutil.Ptr("group-name"), Values: []string{blackholeSecurityGroupName}},
+		},
+	})
+	if err != nil {
+		return "", err
+	}
+
+	var group ec2types.SecurityGroup
+	if len(output.SecurityGroups) > 0 {
+		group = output.SecurityGroups[0]
+	} else {
+		created, err := client.CreateSecurityGroup(ctx, &ec2.CreateSecurityGroupInput{
+			GroupName:   extutil.Ptr(blackholeSecurityGroupName),
+			Description: extutil.Ptr("lambda egress blackhole - created by steadybit"),
+			VpcId:       extutil.Ptr(vpcId),
+			TagSpecifications: []ec2types.TagSpecification{{
+				ResourceType: ec2types.ResourceTypeSecurityGroup,
+				Tags:         []ec2types.Tag{{Key: extutil.Ptr("created-by"), Value: extutil.Ptr("steadybit")}},
+			}},
+		})
+		if err != nil {
+			return "", err
+		}
+		// New security groups come with default egress rules, e.g. allow-all for IPv4 and, in dual stack VPCs, for IPv6
+		described, err := client.DescribeSecurityGroups(ctx, &ec2.DescribeSecurityGroupsInput{
+			GroupIds: []string{aws.ToString(created.GroupId)},
+		})
+		if err != nil {
+			return "", err
+		}
+		if len(described.SecurityGroups) == 0 {
+			return "", fmt.Errorf("created security group %s not found", aws.ToString(created.GroupId))
+		}
+		group = described.SecurityGroups[0]
+	}
+
+	if len(group.IpPermissionsEgress) > 0 {
+		_, err = client.RevokeSecurityGroupEgress(ctx, &ec2.RevokeSecurityGroupEgressInput{
+			GroupId:       group.GroupId,
+			IpPermissions: group.IpPermissionsEgress,
+		})
+		if err != nil {
+			return "", err
+		}
+	}
+	return aws.ToString(group.GroupId), nil
+}
+
+func createEc2Client(ctx context.Context) (*ec2.Client, error) {
+	awsConfig, err := config.LoadDefaultConfig(ctx)
+	if err != nil {
+		return nil, err
+	}
+	client := ec2.NewFromConfig(awsConfig)
+	return client, err
+}
@@ -0,0 +1,77 @@
+/*
+ * Copyright 2023 steadybit GmbH. All rights reserved.
+ */
+
+package extlambda
+
+import (
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/lambda/types"
+	"github.com/steadybit/extension-kit/extutil"
+	"reflect"
+	"testing"
+)
+
+func TestEgressBlackholeState(t *testing.T) {
+	functionArn := "arn:aws:lambda:eu-central-1:123456789012:function:my-function"
+	tests := []struct {
+		name      string
+		vpcConfig *types.VpcConfigResponse
+		want      *EgressBlackholeActionState
+		wantErr   bool
+	}{
+		{name: "no vpc config", vpcConfig: nil, wantErr: true},
+		{name: "not attached to a vpc", vpcConfig: &types.VpcConfigResponse{VpcId: extutil.Ptr("")}, wantErr: true},
+		{
+			name: "records the original security groups",
+			vpcConfig: &types.VpcConfigResponse{
+				VpcId:            extutil.Ptr("vpc-1"),
+				SubnetIds:        []string{"subnet-a", "subnet-b"},
+				SecurityGroupIds: []string{"sg-1", "sg-2"},
+			},
+			want: &EgressBlackholeActionState{
+				FunctionArn:            functionArn,
+				VpcId:                  "vpc-1",
+				SubnetIds:              []string{"subnet-a", "subnet-b"},
+				OriginalSecurityGroups: []string{"sg-1", "sg-2"},
+			},
+		},
+		{
+			name: "records dual stack",
+			vpcConfig: &types.VpcConfigResponse{
+				VpcId:                   extutil.Ptr("vpc-1"),
+				SubnetIds:               []string{"subnet-a"},
+				SecurityGroupIds:        []string{"sg-1"},
+				Ipv6AllowedForDualStack: aws.Bool(true),
+			},
+			want: &EgressBlackholeActionState{
+				FunctionArn:             functionArn,
+				VpcId:                   "vpc-1",
+				SubnetIds:               []string{"subnet-a"},
+				OriginalSecurityGroups:  []string{"sg-1"},
+				Ipv6AllowedForDualStack: aws.Bool(true),
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, extErr := egressBlackholeState(functionArn, tt.vpcConfig)
+			if (extErr != nil) != tt.wantErr {
+				t.Fatalf("egressBlackholeState() error = %v, want error %v", extErr, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("egressBlackholeState() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFunctionVpcConfig(t *testing.T) {
+	for _, ipv6 := range []*bool{nil, aws.Bool(false), aws.Bool(true)} {
+		got := functionVpcConfig([]string{"subnet-a"}, []string{"sg-1"}, ipv6)
+		want := &types.VpcConfig{SubnetIds: []string{"subnet-a"}, SecurityGroupIds: []string{"sg-1"}, Ipv6AllowedForDualStack: ipv6}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("functionVpcConfig() = %+v, want %+v", got, want)
+		}
+	}
+}
@@ -0,0 +1,431 @@
+/*
+ * Copyright 2023 steadybit GmbH. All rights reserved.
+ */
+
+package extlambda
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/lambda"
+	"github.com/aws/aws-sdk-go-v2/service/lambda/types"
+	"github.com/rs/zerolog/log"
+	"github.com/steadybit/action-kit/go/action_kit_api/v2"
+	extension_kit "github.com/steadybit/extension-kit"
+	"github.com/steadybit/extension-kit/extbuild"
+	"github.com/steadybit/extension-kit/extconversion"
+	"github.com/steadybit/extension-kit/exthttp"
+	"github.com/steadybit/extension-kit/extutil"
+	"net/http"
+	"time"
+)
+
+const esmConfigActionBasePath = basePath + "/actions/event-source-mapping-config"
+
+func registerEsmConfigActionHandlers() {
+	exthttp.RegisterHttpHandler(esmConfigActionBasePath, exthttp.GetterAsHandler(getEsmConfigActionDescription))
+	exthttp.RegisterHttpHandler(esmConfigActionBasePath+"/prepare", prepareEsmConfig)
+	exthttp.RegisterHttpHandler(esmConfigActionBasePath+"/start", startEsmConfig)
+	exthttp.RegisterHttpHandler(esmConfigActionBasePath+"/stop", stopEsmConfig)
+}
+
+func getEsmConfigActionDescription() action_kit_api.ActionDescription {
+	return action_kit_api.ActionDescription{
+		Id:          fmt.Sprintf("%s.eventSourceMappingConfig", targetID),
+		Version:     extbuild.GetSemverVersionStringOrUnknown(),
+		Label:       "Skew Event Source Mapping",
+		Description: "Temporarily changes batching, concurrency and retry settings of the function's event source mappings.",
+		Icon:        extutil.Ptr(targetIcon),
+		TargetType:  extutil.Ptr(targetID),
+		TargetSelectionTemplates: extutil.Ptr([]action_kit_api.TargetSelectionTemplate{
+			{
+				Label: "by function name",
+				Query: "aws.lambda.function-name=\"\"",
+			},
+		}),
+		Category:    extutil.Ptr("cloud"),
+		Kind:        action_kit_api.Attack,
+		TimeControl: action_kit_api.External,
+		Parameters: []action_kit_api.ActionParameter{
+			{
+				Label:        "Duration",
+				Name:         "duration",
+				Type:         action_kit_api.Duration,
+				Description:  extutil.Ptr("The duration of the attack."),
+				Advanced:     extutil.Ptr(false),
+				Required:     extutil.Ptr(true),
+				DefaultValue: extutil.Ptr("30s"),
+				Order:        extutil.Ptr(0),
+			},
+			{
+				Name:        "eventSourceArn",
+				Label:       "Event Source ARN",
+				Description: extutil.Ptr("Only change the mapping of this event source. Leave empty to change all mappings of the function."),
+				Type:        action_kit_api.String,
+				Required:    extutil.Ptr(false),
+				Order:       extutil.Ptr(1),
+			},
+			{
+				Name:        "batchSize",
+				Label:       "Batch Size",
+				Description: extutil.Ptr("The maximum number of records in each batch. Leave empty to keep the current value."),
+				Type:        action_kit_api.Integer,
+				Required:    extutil.Ptr(false),
+				Order:       extutil.Ptr(2),
+			},
+			{
+				Name:        "maximumBatchingWindow",
+				Label:       "Maximum Batching Window (s)",
+				Description: extutil.Ptr("The maximum amount of time to gather records before invoking the function. Leave empty to keep the current value."),
+				Type:        action_kit_api.Integer,
+				Required:    extutil.Ptr(false),
+				Order:       extutil.Ptr(3),
+			},
+			{
+				Name:        "maximumConcurrency",
+				Label:       "Maximum Concurrency",
+				Description: extutil.Ptr("The maximum number of concurrent functions an SQS event source can invoke. Leave empty to keep the current value."),
+				Type:        action_kit_api.Integer,
+				Required:    extutil.Ptr(false),
+				Order:       extutil.Ptr(4),
+			},
+			{
+				Name:        "maximumRetryAttempts",
+				Label:       "Maximum Retry Attempts",
+				Description: extutil.Ptr("Discard stream records after the given number of retries, -1 retries infinitely. Leave empty to keep the current value."),
+				Type:        action_kit_api.Integer,
+				Required:    extutil.Ptr(false),
+				Advanced:    extutil.Ptr(true),
+				Order:       extutil.Ptr(5),
+			},
+			{
+				Name:         "bisectBatchOnFunctionError",
+				Label:        "Bisect Batch on Function Error",
+				Description:  extutil.Ptr("Split stream batches in two and retry if the function returns an error."),
+				Type:         action_kit_api.String,
+				DefaultValue: extutil.Ptr("unchanged"),
+				Required:     extutil.Ptr(true),
+				Advanced:     extutil.Ptr(true),
+				Order:        extutil.Ptr(6),
+				Options: extutil.Ptr([]action_kit_api.ParameterOption{
+					action_kit_api.ExplicitParameterOption{Label: "Unchanged", Value: "unchanged"},
+					action_kit_api.ExplicitParameterOption{Label: "Enabled", Value: "true"},
+					action_kit_api.ExplicitParameterOption{Label: "Disabled", Value: "false"},
+				}),
+			},
+		},
+		Prepare: action_kit_api.MutatingEndpointReference{
+			Method: "POST",
+			Path:   esmConfigActionBasePath + "/prepare",
+		},
+		Start: action_kit_api.MutatingEndpointReference{
+			Method: "POST",
+			Path:   esmConfigActionBasePath + "/start",
+		},
+		Stop: extutil.Ptr(action_kit_api.MutatingEndpointReference{
+			Method: "POST",
+			Path:   esmConfigActionBasePath + "/stop",
+		}),
+	}
+}
+
+// esmSettings holds the subset of event source mapping settings touched by the attack. Nil values are left untouched.
+type esmSettings struct {
+	BatchSize                      *int32 `json:"batchSize,omitempty"`
+	MaximumBatchingWindowInSeconds *int32 `json:"maximumBatchingWindowInSeconds,omitempty"`
+	MaximumConcurrency             *int32 `json:"maximumConcurrency,omitempty"`
+	MaximumRetryAttempts           *int32 `json:"maximumRetryAttempts,omitempty"`
+	BisectBatchOnFunctionError     *bool  `json:"bisectBatchOnFunctionError,omitempty"`
+}
+
+type esmConfigChange struct {
+	UUID           string      `json:"uuid"`
+	EventSourceArn string      `json:"eventSourceArn"`
+	Original       esmSettings `json:"original"`
+}
+
+type EsmConfigActionState struct {
+	FunctionArn string            `json:"functionArn"`
+	Attack      esmSettings       `json:"attack"`
+	Mappings    []esmConfigChange `json:"mappings"`
+}
+
+func prepareEsmConfig(w http.ResponseWriter, r *http.Request, body []byte) {
+	var request action_kit_api.PrepareActionRequestBody
+	err := json.Unmarshal(body, &request)
+	if err != nil {
+		exthttp.WriteError(w, extension_kit.ToError("Failed to parse request body", err))
+		return
+	}
+
+	state, extErr := prepareEsmConfigState(r.Context(), &request)
+	if extErr != nil {
+		exthttp.WriteError(w, *extErr)
+		return
+	}
+
+	var convertedState action_kit_api.ActionState
+	err = extconversion.Convert(state, &convertedState)
+	if err != nil {
+		exthttp.WriteError(w, extension_kit.ToError("Failed to encode action state", err))
+		return
+	}
+
+	exthttp.WriteBody(w, action_kit_api.PrepareResult{
+		State: convertedState,
+	})
+}
+
+func prepareEsmConfigState(ctx context.Context, request *action_kit_api.PrepareActionRequestBody) (*EsmConfigActionState, *extension_kit.ExtensionError) {
+	functionArn := targetAttribute(request.Target, "aws.arn")
+	if functionArn == "" {
+		return nil, extutil.Ptr(extension_kit.ToError("Target is missing the 'aws.arn' attribute.", nil))
+	}
+
+	attack := esmSettings{
+		BatchSize:                      configInt32(request.Config, "batchSize"),
+		MaximumBatchingWindowInSeconds: configInt32(request.Config, "maximumBatchingWindow"),
+		MaximumConcurrency:             configInt32(request.Config, "maximumConcurrency"),
+		MaximumRetryAttempts:           configInt32(request.Config, "maximumRetryAttempts"),
+	}
+	switch configString(request.Config, "bisectBatchOnFunctionError") {
+	case "true":
+		attack.BisectBatchOnFunctionError = extutil.Ptr(true)
+	case "false":
+		attack.BisectBatchOnFunctionError = extutil.Ptr(false)
+	}
+	if attack == (esmSettings{}) {
+		return nil, extutil.Ptr(extension_kit.ToError("At least one event source mapping setting has to be changed.", nil))
+	}
+
+	client, err := createLambdaClient(ctx)
+	if err != nil {
+		return nil, extutil.Ptr(extension_kit.ToError("Failed to create lambda client", err))
+	}
+
+	mappings, err := listEventSourceMappings(ctx, client, functionArn, configString(request.Config, "eventSourceArn"))
+	if err != nil {
+		return nil, extutil.Ptr(extension_kit.ToError("Failed to list event source mappings", err))
+	}
+	if len(mappings) == 0 {
+		return nil, extutil.Ptr(extension_kit.ToError(fmt.Sprintf("Function %s has no matching event source mappings.", functionArn), nil))
+	}
+
+	state := &EsmConfigActionState{
+		FunctionArn: functionArn,
+		Attack:      attack,
+		Mappings:    make([]esmConfigChange, 0, len(mappings)),
+	}
+	// Maximum concurrency only applies to SQS sources, retries and bisecting only to streams, other mappings would be left unchanged
+	concurrencyOnly := attack == esmSettings{MaximumConcurrency: attack.MaximumConcurrency}
+	streamOnly := attack == esmSettings{MaximumRetryAttempts: attack.MaximumRetryAttempts, BisectBatchOnFunctionError: attack.BisectBatchOnFunctionError}
+	for _, mapping := range mappings {
+		if concurrencyOnly && arnService(aws.ToString(mapping.EventSourceArn)) != "sqs" {
+			continue
+		}
+		if streamOnly && !isStreamEventSource(aws.ToString(mapping.EventSourceArn)) {
+			continue
+		}
+		if !isEsmStable(aws.ToString(mapping.State)) {
+			return nil, extutil.Ptr(extension_kit.ToError(fmt.Sprintf("Event source mapping %s is in state %s.", aws.ToString(mapping.UUID), aws.ToString(mapping.State)), nil))
+		}
+		original := esmSettings{
+			BatchSize:                      mapping.BatchSize,
+			MaximumBatchingWindowInSeconds: mapping.MaximumBatchingWindowInSeconds,
+			MaximumRetryAttempts:           mapping.MaximumRetryAttempts,
+			BisectBatchOnFunctionError:     mapping.BisectBatchOnFunctionError,
+		}
+		if mapping.ScalingConfig != nil {
+			original.MaximumConcurrency = mapping.ScalingConfig.MaximumConcurrency
+		}
+		state.Mappings = append(state.Mappings, esmConfigChange{
+			UUID:           aws.ToString(mapping.UUID),
+			EventSourceArn: aws.ToString(mapping.EventSourceArn),
+			Original:       original,
+		})
+	}
+	if len(state.Mappings) == 0 && streamOnly {
+		return nil, extutil.Ptr(extension_kit.ToError(fmt.Sprintf("Function %s has no Kinesis or DynamoDB event source mapping to change retries or bisecting of.", functionArn), nil))
+	}
+	if len(state.Mappings) == 0 {
+		return nil, extutil.Ptr(extension_kit.ToError(fmt.Sprintf("Function %s has no SQS event source mapping to limit the concurrency of.", functionArn), nil))
+	}
+	return state, nil
+}
+
+func startEsmConfig(w http.ResponseWriter, r *http.Request, body []byte) {
+	var request action_kit_api.StartActionRequestBody
+	err := json.Unmarshal(body, &request)
+	if err != nil {
+		exthttp.WriteError(w, extension_kit.ToError("Failed to parse request body", err))
+		return
+	}
+
+	var state EsmConfigActionState
+	err = extconversion.Convert(request.State, &state)
+	if err != nil {
+		exthttp.WriteError(w, extension_kit.ToError("Failed to convert action state", err))
+		return
+	}
+
+	client, err := createLambdaClient(r.Context())
+	if err != nil {
+		exthttp.WriteError(w, extension_kit.ToError("Failed to create lambda client", err))
+		return
+	}
+
+	for i, mapping := range state.Mappings {
+		err = updateEsmSettings(r.Context(), client, mapping, state.Attack, mapping.Original)
+		if err != nil {
+			// Roll back the mappings changed so far, as stop is not called for a failed start
+			for _, changed := range state.Mappings[:i] {
+				if err := restoreEsmSettings(r.Context(), client, changed, state.Attack); err != nil {
+					log.Error().Err(err).Msgf("Failed to roll back event source mapping %s.", changed.UUID)
+				}
+			}
+			exthttp.WriteError(w, extension_kit.ToError(fmt.Sprintf("Failed to update event source mapping %s", mapping.UUID), err))
+			return
+		}
+	}
+
+	exthttp.WriteBody(w, action_kit_api.StartResult{})
+}
+
+func stopEsmConfig(w http.ResponseWriter, r *http.Request, body []byte) {
+	var request action_kit_api.StopActionRequestBody
+	err := json.Unmarshal(body, &request)
+	if err != nil {
+		exthttp.WriteError(w, extension_kit.ToError("Failed to parse request body", err))
+		return
+	}
+
+	var state EsmConfigActionState
+	err = extconversion.Convert(request.State, &state)
+	if err != nil {
+		exthttp.WriteError(w, extension_kit.ToError("Failed to convert action state", err))
+		return
+	}
+
+	client, err := createLambdaClient(r.Context())
+	if err != nil {
+		exthttp.WriteError(w, extension_kit.ToError("Failed to create lambda client", err))
+		return
+	}
+
+	for _, mapping := range state.Mappings {
+		err = restoreEsmSettings(r.Context(), client, mapping, state.Attack)
+		if err != nil {
+			exthttp.WriteError(w, extension_kit.ToError(fmt.Sprintf("Failed to restore event source mapping %s", mapping.UUID), err))
+			return
+		}
+	}
+
+	exthttp.WriteBody(w, action_kit_api.StopResult{})
+}
+
+func restoreEsmSettings(ctx context.Context, client *lambda.Client, mapping esmConfigChange, attack esmSettings) error {
+	current, err := waitForEsmStable(ctx, client, mapping.UUID)
+	if err != nil {
+		var notFound *types.ResourceNotFoundException
+		if errors.As(err, &notFound) {
+			log.Warn().Msgf("Event source mapping %s no longer exists, skipping restore.", mapping.UUID)
+			return nil
+		}
+		return err
+	}
+	if aws.ToString(current.State) == "Deleting" {
+		log.Warn().Msgf("Event source mapping %s is being deleted, skipping restore.", mapping.UUID)
+		return nil
+	}
+	return updateEsmSettings(ctx, client, mapping, mapping.Original, attack)
+}
+
+// updateEsmSettings applies all settings of target that were changed by the attack. The reference settings
+// decide whether an unset maximum concurrency has to be reset explicitly.
+func updateEsmSettings(ctx context.Context, client *lambda.Client, mapping esmConfigChange, target esmSettings, attack esmSettings) error {
+	_, err := client.UpdateEventSourceMapping(ctx, esmUpdateInput(mapping, target, attack))
+	return err
+}
+
+func esmUpdateInput(mapping esmConfigChange, target esmSettings, attack esmSettings) *lambda.UpdateEventSourceMappingInput {
+	input := &lambda.UpdateEventSourceMappingInput{UUID: extutil.Ptr(mapping.UUID)}
+	if attack.BatchSize != nil {
+		input.BatchSize = target.BatchSize
+	}
+	if attack.MaximumBatchingWindowInSeconds != nil {
+		input.MaximumBatchingWindowInSeconds = extutil.Ptr(aws.ToInt32(target.MaximumBatchingWindowInSeconds))
+	}
+	// Lambda rejects retry and bisect settings for sources other than streams
+	if attack.MaximumRetryAttempts != nil && isStreamEventSource(mapping.EventSourceArn) {
+		input.MaximumRetryAttempts = target.MaximumRetryAttempts
+	}
+	if attack.BisectBatchOnFunctionError != nil && isStreamEventSource(mapping.EventSourceArn) {
+		input.BisectBatchOnFunctionError = extutil.Ptr(aws.ToBool(target.BisectBatchOnFunctionError))
+	}
+	if attack.MaximumConcurrency != nil && arnService(mapping.EventSourceArn) == "sqs" {
+		// An empty scaling config removes the concurrency limit. Lambda rejects scaling configs for other sources.
+		input.ScalingConfig = &types.ScalingConfig{MaximumConcurrency: target.MaximumConcurrency}
+	}
+	return input
+}
+
+// isStreamEventSource reports whether the event source is a Kinesis or DynamoDB stream.
+func isStreamEventSource(eventSourceArn string) bool {
+	service := arnService(eventSourceArn)
+	return service == "kinesis" || service == "dynamodb"
+}
+
+func listEventSourceMappings(ctx context.Context, client *lambda.Client, functionArn string, eventSourceArn string) ([]types.EventSourceMappingConfiguration, error) {
+	result := make([]types.EventSourceMappingConfiguration, 0)
+	var marker *string = nil
+	for {
+		input := &lambda.ListEventSourceMappingsInput{
+			FunctionName: extutil.Ptr(functionArn),
+			Marker:       marker,
+		}
+		if eventSourceArn != "" {
+			input.EventSourceArn = extutil.Ptr(eventSourceArn)
+		}
+		output, err := client.ListEventSourceMappings(ctx, input)
+		if err != nil {
+			return result, err
+		}
+		result = append(result, output.EventSourceMappings...)
+
+		if output.NextMarker == nil {
+			break
+		} else {
+			marker = output.NextMarker
+		}
+	}
+	return result, nil
+}
+
+func isEsmStable(state string) bool {
+	return state == "Enabled" || state == "Disabled"
+}
+
+// waitForEsmStable polls the event source mapping until it left any transitional state (Creating, Enabling, Updating, ...).
+func waitForEsmStable(ctx context.Context, client *lambda.Client, uuid string) (*lambda.GetEventSourceMappingOutput, error) {
+	ctx, cancel := context.WithTimeout(ctx, 2*time.Minute)
+	defer cancel()
+	for {
+		output, err := client.GetEventSourceMapping(ctx, &lambda.GetEventSourceMappingInput{UUID: extutil.Ptr(uuid)})
+		if err != nil {
+			return nil, err
+		}
+		state := aws.ToString(output.State)
+		if isEsmStable(state) || state == "Deleting" {
+			return output, nil
+		}
+		log.Debug().Msgf("Event source mapping %s is in state %s, waiting.", uuid, state)
+		select {
+		case <-ctx.Done():
+			return nil, fmt.Errorf("event source mapping %s did not leave state %s: %w", uuid, state, ctx.Err())
+		case <-time.After(2 * time.Second):
+		}
+	}
+}
@@ -0,0 +1,115 @@
+/*
+ * Copyright 2023 steadybit GmbH. All rights reserved.
+ */
+
+package extlambda
+
+import (
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"testing"
+)
+
+func TestEsmUpdateInput(t *testing.T) {
+	sqsMapping := esmConfigChange{UUID: "sqs", EventSourceArn: "arn:aws:sqs:eu-central-1:123456789012:queue"}
+	kinesisMapping := esmConfigChange{UUID: "kinesis", EventSourceArn: "arn:aws:kinesis:eu-central-1:123456789012:stream/s"}
+
+	tests := []struct {
+		name            string
+		mapping         esmConfigChange
+		target          esmSettings
+		attack          esmSettings
+		wantBatchSize   *int32
+		wantScaling     bool
+		wantConcurrency *int32
+		wantRetries     *int32
+		wantBisect      bool
+	}{
+		{
+			name:            "limits concurrency of sqs sources",
+			mapping:         sqsMapping,
+			target:          esmSettings{MaximumConcurrency: int32Ptr(2)},
+			attack:          esmSettings{MaximumConcurrency: int32Ptr(2)},
+			wantScaling:     true,
+			wantConcurrency: int32Ptr(2),
+		},
+		{
+			name:        "removes the concurrency limit on restore",
+			mapping:     sqsMapping,
+			target:      esmSettings{},
+			attack:      esmSettings{MaximumConcurrency: int32Ptr(2)},
+			wantScaling: true,
+		},
+		{
+			name:          "never sends scaling configs to other sources",
+			mapping:       kinesisMapping,
+			target:        esmSettings{BatchSize: int32Ptr(1), MaximumConcurrency: int32Ptr(2)},
+			attack:        esmSettings{BatchSize: int32Ptr(1), MaximumConcurrency: int32Ptr(2)},
+			wantBatchSize: int32Ptr(1),
+		},
+		{
+			name:        "sets retries and bisecting for streams",
+			mapping:     kinesisMapping,
+			target:      esmSettings{MaximumRetryAttempts: int32Ptr(0), BisectBatchOnFunctionError: aws.Bool(true)},
+			attack:      esmSettings{MaximumRetryAttempts: int32Ptr(0), BisectBatchOnFunctionError: aws.Bool(true)},
+			wantRetries: int32Ptr(0),
+			wantBisect:  true,
+		},
+		{
+			name:          "never sends retries or bisecting to sqs sources",
+			mapping:       sqsMapping,
+			target:        esmSettings{BatchSize: int32Ptr(1), MaximumRetryAttempts: int32Ptr(0), BisectBatchOnFunctionError: aws.Bool(true)},
+			attack:        esmSettings{BatchSize: int32Ptr(1), MaximumRetryAttempts: int32Ptr(0), BisectBatchOnFunctionError: aws.Bool(true)},
+			wantBatchSize: int32Ptr(1),
+		},
+		{
+			name:    "leaves untouched settings unset",
+			mapping: sqsMapping,
+			target:  esmSettings{BatchSize: int32Ptr(10), MaximumConcurrency: int32Ptr(5)},
+			attack:  esmSettings{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			input := esmUpdateInput(tt.mapping, tt.target, tt.attack)
+			if aws.ToString(input.UUID) != tt.mapping.UUID {
+				t.Errorf("UUID = %q, want %q", aws.ToString(input.UUID), tt.mapping.UUID)
+			}
+			if deref(input.BatchSize) != deref(tt.wantBatchSize) {
+				t.Errorf("BatchSize = %v, want %v", deref(input.BatchSize), deref(tt.wantBatchSize))
+			}
+			if (input.MaximumRetryAttempts != nil) != (tt.wantRetries != nil) || deref(input.MaximumRetryAttempts) != deref(tt.wantRetries) {
+				t.Errorf("MaximumRetryAttempts = %v, want %v", input.MaximumRetryAttempts, tt.wantRetries)
+			}
+			if (input.BisectBatchOnFunctionError != nil) != tt.wantBisect {
+				t.Errorf("BisectBatchOnFunctionError = %v, want present %v", input.BisectBatchOnFunctionError, tt.wantBisect)
+			}
+			if (input.ScalingConfig != nil) != tt.wantScaling {
+				t.Fatalf("ScalingConfig = %v, want present %v", input.ScalingConfig, tt.wantScaling)
+			}
+			if input.ScalingConfig != nil && deref(input.ScalingConfig.MaximumConcurrency) != deref(tt.wantConcurrency) {
+				t.Errorf("MaximumConcurrency = %v, want %v", deref(input.ScalingConfig.MaximumConcurrency), deref(tt.wantConcurrency))
+			}
+		})
+	}
+}
+
+func TestIsStreamEventSource(t *testing.T) {
+	for arn, want := range map[string]bool{
+		"arn:aws:kinesis:eu-central-1:123456789012:stream/s":                                 true,
+		"arn:aws-cn:dynamodb:cn-north-1:123456789012:table/t/stream/2023-01-01T00:00:00.000": true,
+		"arn:aws:sqs:eu-central-1:123456789012:queue":                                        false,
+		"arn:aws:kafka:eu-central-1:123456789012:cluster/c/1":                                false,
+	} {
+		if got := isStreamEventSource(arn); got != want {
+			t.Errorf("isStreamEventSource(%q) = %v, want %v", arn, got, want)
+		}
+	}
+}
+
+func TestIsEsmStable(t *testing.T) {
+	for state, want := range map[string]bool{"Enabled": true, "Disabled": true, "Updating": false, "Creating": false, "Deleting": false} {
+		if got := isEsmStable(state); got != want {
+			t.Errorf("isEsmStable(%q) = %v, want %v", state, got, want)
+		}
+	}
+}
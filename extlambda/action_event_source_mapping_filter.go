@@ -0,0 +1,282 @@
+/*
+ * Copyright 2023 steadybit GmbH. All rights reserved.
+ */
+
+package extlambda
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/lambda"
+	"github.com/aws/aws-sdk-go-v2/service/lambda/types"
+	"github.com/rs/zerolog/log"
+	"github.com/steadybit/action-kit/go/action_kit_api/v2"
+	extension_kit "github.com/steadybit/extension-kit"
+	"github.com/steadybit/extension-kit/extbuild"
+	"github.com/steadybit/extension-kit/extconversion"
+	"github.com/steadybit/extension-kit/exthttp"
+	"github.com/steadybit/extension-kit/extutil"
+	"net/http"
+)
+
+const esmFilterActionBasePath = basePath + "/actions/event-source-mapping-filter"
+
+// dropAllFilterPattern matches on a key that no event carries, thus every record is dropped.
+const dropAllFilterPattern = `{"steadybit-drop-all": [{"exists": true}]}`
+
+func registerEsmFilterActionHandlers() {
+	exthttp.RegisterHttpHandler(esmFilterActionBasePath, exthttp.GetterAsHandler(getEsmFilterActionDescription))
+	exthttp.RegisterHttpHandler(esmFilterActionBasePath+"/prepare", prepareEsmFilter)
+	exthttp.RegisterHttpHandler(esmFilterActionBasePath+"/start", startEsmFilter)
+	exthttp.RegisterHttpHandler(esmFilterActionBasePath+"/stop", stopEsmFilter)
+}
+
+func getEsmFilterActionDescription() action_kit_api.ActionDescription {
+	return action_kit_api.ActionDescription{
+		Id:          fmt.Sprintf("%s.eventSourceMappingFilter", targetID),
+		Version:     extbuild.GetSemverVersionStringOrUnknown(),
+		Label:       "Drop Events",
+		Description: "Installs a filter on the function's event source mappings, so that all or some events are silently dropped.",
+		Icon:        extutil.Ptr(targetIcon),
+		TargetType:  extutil.Ptr(targetID),
+		TargetSelectionTemplates: extutil.Ptr([]action_kit_api.TargetSelectionTemplate{
+			{
+				Label: "by function name",
+				Query: "aws.lambda.function-name=\"\"",
+			},
+		}),
+		Category:    extutil.Ptr("cloud"),
+		Kind:        action_kit_api.Attack,
+		TimeControl: action_kit_api.External,
+		Parameters: []action_kit_api.ActionParameter{
+			{
+				Label:        "Duration",
+				Name:         "duration",
+				Type:         action_kit_api.Duration,
+				Description:  extutil.Ptr("The duration of the attack."),
+				Advanced:     extutil.Ptr(false),
+				Required:     extutil.Ptr(true),
+				DefaultValue: extutil.Ptr("30s"),
+				Order:        extutil.Ptr(0),
+			},
+			{
+				Name:        "eventSourceArn",
+				Label:       "Event Source ARN",
+				Description: extutil.Ptr("Only filter the mapping of this event source. Leave empty to filter all mappings of the function."),
+				Type:        action_kit_api.String,
+				Required:    extutil.Ptr(false),
+				Order:       extutil.Ptr(1),
+			},
+			{
+				Name:        "pattern",
+				Label:       "Filter Pattern",
+				Description: extutil.Ptr("Only events matching this filter pattern are delivered. Leave empty to drop all events."),
+				Type:        action_kit_api.String,
+				Required:    extutil.Ptr(false),
+				Order:       extutil.Ptr(2),
+			},
+		},
+		Prepare: action_kit_api.MutatingEndpointReference{
+			Method: "POST",
+			Path:   esmFilterActionBasePath + "/prepare",
+		},
+		Start: action_kit_api.MutatingEndpointReference{
+			Method: "POST",
+			Path:   esmFilterActionBasePath + "/start",
+		},
+		Stop: extutil.Ptr(action_kit_api.MutatingEndpointReference{
+			Method: "POST",
+			Path:   esmFilterActionBasePath + "/stop",
+		}),
+	}
+}
+
+type esmFilterChange struct {
+	UUID             string   `json:"uuid"`
+	EventSourceArn   string   `json:"eventSourceArn"`
+	OriginalPatterns []string `json:"originalPatterns"`
+}
+
+type EsmFilterActionState struct {
+	FunctionArn string            `json:"functionArn"`
+	Pattern     string            `json:"pattern"`
+	Mappings    []esmFilterChange `json:"mappings"`
+}
+
+func prepareEsmFilter(w http.ResponseWriter, r *http.Request, body []byte) {
+	var request action_kit_api.PrepareActionRequestBody
+	err := json.Unmarshal(body, &request)
+	if err != nil {
+		exthttp.WriteError(w, extension_kit.ToError("Failed to parse request body", err))
+		return
+	}
+
+	state, extErr := prepareEsmFilterState(r.Context(), &request)
+	if extErr != nil {
+		exthttp.WriteError(w, *extErr)
+		return
+	}
+
+	var convertedState action_kit_api.ActionState
+	err = extconversion.Convert(state, &convertedState)
+	if err != nil {
+		exthttp.WriteError(w, extension_kit.ToError("Failed to encode action state", err))
+		return
+	}
+
+	exthttp.WriteBody(w, action_kit_api.PrepareResult{
+		State: convertedState,
+	})
+}
+
+func prepareEsmFilterState(ctx context.Context, request *action_kit_api.PrepareActionRequestBody) (*EsmFilterActionState, *extension_kit.ExtensionError) {
+	functionArn := targetAttribute(request.Target, "aws.arn")
+	if functionArn == "" {
+		return nil, extutil.Ptr(extension_kit.ToError("Target is missing the 'aws.arn' attribute.", nil))
+	}
+
+	pattern := configString(request.Config, "pattern")
+	if pattern == "" {
+		pattern = dropAllFilterPattern
+	} else if !json.Valid([]byte(pattern)) {
+		return nil, extutil.Ptr(extension_kit.ToError("The filter pattern is not valid JSON.", nil))
+	}
+
+	client, err := createLambdaClient(ctx)
+	if err != nil {
+		return nil, extutil.Ptr(extension_kit.ToError("Failed to create lambda client", err))
+	}
+
+	mappings, err := listEventSourceMappings(ctx, client, functionArn, configString(request.Config, "eventSourceArn"))
+	if err != nil {
+		return nil, extutil.Ptr(extension_kit.ToError("Failed to list event source mappings", err))
+	}
+	if len(mappings) == 0 {
+		return nil, extutil.Ptr(extension_kit.ToError(fmt.Sprintf("Function %s has no matching event source mappings.", functionArn), nil))
+	}
+
+	state := &EsmFilterActionState{
+		FunctionArn: functionArn,
+		Pattern:     pattern,
+		Mappings:    make([]esmFilterChange, 0, len(mappings)),
+	}
+	for _, mapping := range mappings {
+		if !isEsmStable(aws.ToString(mapping.State)) {
+			return nil, extutil.Ptr(extension_kit.ToError(fmt.Sprintf("Event source mapping %s is in state %s.", aws.ToString(mapping.UUID), aws.ToString(mapping.State)), nil))
+		}
+		patterns := make([]string, 0)
+		if mapping.FilterCriteria != nil {
+			for _, filter := range mapping.FilterCriteria.Filters {
+				patterns = append(patterns, aws.ToString(filter.Pattern))
+			}
+		}
+		state.Mappings = append(state.Mappings, esmFilterChange{
+			UUID:             aws.ToString(mapping.UUID),
+			EventSourceArn:   aws.ToString(mapping.EventSourceArn),
+			OriginalPatterns: patterns,
+		})
+	}
+	return state, nil
+}
+
+func startEsmFilter(w http.ResponseWriter, r *http.Request, body []byte) {
+	var request action_kit_api.StartActionRequestBody
+	err := json.Unmarshal(body, &request)
+	if err != nil {
+		exthttp.WriteError(w, extension_kit.ToError("Failed to parse request body", err))
+		return
+	}
+
+	var state EsmFilterActionState
+	err = extconversion.Convert(request.State, &state)
+	if err != nil {
+		exthttp.WriteError(w, extension_kit.ToError("Failed to convert action state", err))
+		return
+	}
+
+	client, err := createLambdaClient(r.Context())
+	if err != nil {
+		exthttp.WriteError(w, extension_kit.ToError("Failed to create lambda client", err))
+		return
+	}
+
+	for i, mapping := range state.Mappings {
+		err = updateEsmFilter(r.Context(), client, mapping.UUID, []string{state.Pattern})
+		if err != nil {
+			// Roll back the mappings changed so far, as stop is not called for a failed start
+			for _, changed := range state.Mappings[:i] {
+				if _, err := waitForEsmStable(r.Context(), client, changed.UUID); err != nil {
+					log.Error().Err(err).Msgf("Failed to roll back filter of event source mapping %s.", changed.UUID)
+				} else if err := updateEsmFilter(r.Context(), client, changed.UUID, changed.OriginalPatterns); err != nil {
+					log.Error().Err(err).Msgf("Failed to roll back filter of event source mapping %s.", changed.UUID)
+				}
+			}
+			exthttp.WriteError(w, extension_kit.ToError(fmt.Sprintf("Failed to update filter of event source mapping %s", mapping.UUID), err))
+			return
+		}
+	}
+
+	exthttp.WriteBody(w, action_kit_api.StartResult{})
+}
+
+func stopEsmFilter(w http.ResponseWriter, r *http.Request, body []byte) {
+	var request action_kit_api.StopActionRequestBody
+	err := json.Unmarshal(body, &request)
+	if err != nil {
+		exthttp.WriteError(w, extension_kit.ToError("Failed to parse request body", err))
+		return
+	}
+
+	var state EsmFilterActionState
+	err = extconversion.Convert(request.State, &state)
+	if err != nil {
+		exthttp.WriteError(w, extension_kit.ToError("Failed to convert action state", err))
+		return
+	}
+
+	client, err := createLambdaClient(r.Context())
+	if err != nil {
+		exthttp.WriteError(w, extension_kit.ToError("Failed to create lambda client", err))
+		return
+	}
+
+	for _, mapping := range state.Mappings {
+		current, err := waitForEsmStable(r.Context(), client, mapping.UUID)
+		if err != nil {
+			var notFound *types.ResourceNotFoundException
+			if errors.As(err, &notFound) {
+				log.Warn().Msgf("Event source mapping %s no longer exists, skipping restore.", mapping.UUID)
+				continue
+			}
+			exthttp.WriteError(w, extension_kit.ToError(fmt.Sprintf("Failed to restore filter of event source mapping %s", mapping.UUID), err))
+			return
+		}
+		if aws.ToString(current.State) == "Deleting" {
+			continue
+		}
+
+		err = updateEsmFilter(r.Context(), client, mapping.UUID, mapping.OriginalPatterns)
+		if err != nil {
+			exthttp.WriteError(w, extension_kit.ToError(fmt.Sprintf("Failed to restore filter of event source mapping %s", mapping.UUID), err))
+			return
+		}
+	}
+
+	exthttp.WriteBody(w, action_kit_api.StopResult{})
+}
+
+// updateEsmFilter replaces all filters of the mapping. An empty list of patterns removes the filter criteria.
+func updateEsmFilter(ctx context.Context, client *lambda.Client, uuid string, patterns []string) error {
+	filters := make([]types.Filter, len(patterns))
+	for i, pattern := range patterns {
+		filters[i] = types.Filter{Pattern: extutil.Ptr(pattern)}
+	}
+	_, err := client.UpdateEventSourceMapping(ctx, &lambda.UpdateEventSourceMappingInput{
+		UUID:           extutil.Ptr(uuid),
+		FilterCriteria: &types.FilterCriteria{Filters: filters},
+	})
+	return err
+}
@@ -0,0 +1,480 @@
+/*
+ * Copyright 2023 steadybit GmbH. All rights reserved.
+ */
+
+package extlambda
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/fis"
+	fistypes "github.com/aws/aws-sdk-go-v2/service/fis/types"
+	"github.com/aws/aws-sdk-go-v2/service/lambda"
+	"github.com/rs/zerolog/log"
+	"github.com/steadybit/action-kit/go/action_kit_api/v2"
+	"github.com/steadybit/extension-blog/extconfig"
+	extension_kit "github.com/steadybit/extension-kit"
+	"github.com/steadybit/extension-kit/extbuild"
+	"github.com/steadybit/extension-kit/extconversion"
+	"github.com/steadybit/extension-kit/exthttp"
+	"github.com/steadybit/extension-kit/extutil"
+	"net/http"
+	"strconv"
+)
+
+const (
+	fisDelayActionBasePath        = basePath + "/actions/fis-invocation-delay"
+	fisErrorActionBasePath        = basePath + "/actions/fis-invocation-error"
+	fisHttpResponseActionBasePath = basePath + "/actions/fis-invocation-http-response"
+	// fisConfigurationVariable is set on functions instrumented with the FIS Lambda extension.
+	fisConfigurationVariable = "AWS_FIS_CONFIGURATION_LOCATION"
+	fisTargetName            = "Functions"
+)
+
+func registerFisActionHandlers() {
+	registerFisAction(fisDelayActionBasePath, "aws:lambda:invocation-add-delay", getFisDelayActionDescription)
+	registerFisAction(fisErrorActionBasePath, "aws:lambda:invocation-error", getFisErrorActionDescription)
+	registerFisAction(fisHttpResponseActionBasePath, "aws:lambda:invocation-http-integration-response", getFisHttpResponseActionDescription)
+}
+
+func registerFisAction(path string, fisActionId string, description func() action_kit_api.ActionDescription) {
+	exthttp.RegisterHttpHandler(path, exthttp.GetterAsHandler(description))
+	exthttp.RegisterHttpHandler(path+"/prepare", prepareFis(fisActionId))
+	exthttp.RegisterHttpHandler(path+"/start", startFis)
+	exthttp.RegisterHttpHandler(path+"/status", statusFis)
+	exthttp.RegisterHttpHandler(path+"/stop", stopFis)
+}
+
+func getFisDelayActionDescription() action_kit_api.ActionDescription {
+	return getFisActionDescription(fisDelayActionBasePath, "fisInvocationDelay", "Delay Invocations via FIS",
+		"Runs an AWS FIS experiment delaying the start of the function's invocations.",
+		[]action_kit_api.ActionParameter{
+			{
+				Name:         "delay",
+				Label:        "Startup Delay",
+				Description:  extutil.Ptr("The delay added before the function's handler is invoked."),
+				Type:         action_kit_api.Duration,
+				DefaultValue: extutil.Ptr("1s"),
+				Required:     extutil.Ptr(true),
+				Order:        extutil.Ptr(2),
+			},
+		})
+}
+
+func getFisErrorActionDescription() action_kit_api.ActionDescription {
+	return getFisActionDescription(fisErrorActionBasePath, "fisInvocationError", "Fail Invocations via FIS",
+		"Runs an AWS FIS experiment marking the function's invocations as failed.",
+		[]action_kit_api.ActionParameter{
+			preventExecutionParameter(),
+		})
+}
+
+func getFisHttpResponseActionDescription() action_kit_api.ActionDescription {
+	return getFisActionDescription(fisHttpResponseActionBasePath, "fisInvocationHttpResponse", "Inject HTTP Response via FIS",
+		"Runs an AWS FIS experiment replacing the responses of invocations from an ALB, API Gateway or VPC Lattice integration.",
+		[]action_kit_api.ActionParameter{
+			preventExecutionParameter(),
+			{
+				Name:         "statusCode",
+				Label:        "Status Code",
+				Description:  extutil.Ptr("The status code of the injected response."),
+				Type:         action_kit_api.Integer,
+				DefaultValue: extutil.Ptr("500"),
+				Required:     extutil.Ptr(true),
+				Order:        extutil.Ptr(3),
+			},
+			{
+				Name:         "contentType",
+				Label:        "Content Type",
+				Description:  extutil.Ptr("The content type header of the injected response."),
+				Type:         action_kit_api.String,
+				DefaultValue: extutil.Ptr("application/json"),
+				Required:     extutil.Ptr(false),
+				Advanced:     extutil.Ptr(true),
+				Order:        extutil.Ptr(4),
+			},
+		})
+}
+
+func preventExecutionParameter() action_kit_api.ActionParameter {
+	return action_kit_api.ActionParameter{
+		Name:         "preventExecution",
+		Label:        "Prevent Execution",
+		Description:  extutil.Ptr("Skip the function's handler for affected invocations."),
+		Type:         action_kit_api.Boolean,
+		DefaultValue: extutil.Ptr("true"),
+		Required:     extutil.Ptr(true),
+		Order:        extutil.Ptr(2),
+	}
+}
+
+func getFisActionDescription(path string, id string, label string, description string, parameters []action_kit_api.ActionParameter) action_kit_api.ActionDescription {
+	return action_kit_api.ActionDescription{
+		Id:          fmt.Sprintf("%s.%s", targetID, id),
+		Version:     extbuild.GetSemverVersionStringOrUnknown(),
+		Label:       label,
+		Description: description + " The function has to use the AWS FIS Lambda extension.",
+		Icon:        extutil.Ptr(targetIcon),
+		TargetType:  extutil.Ptr(targetID),
+		TargetSelectionTemplates: extutil.Ptr([]action_kit_api.TargetSelectionTemplate{
+			{
+				Label: "by function name",
+				Query: "aws.lambda.function-name=\"\"",
+			},
+		}),
+		Category:    extutil.Ptr("cloud"),
+		Kind:        action_kit_api.Attack,
+		TimeControl: action_kit_api.External,
+		Parameters: append([]action_kit_api.ActionParameter{
+			{
+				Label:        "Duration",
+				Name:         "duration",
+				Type:         action_kit_api.Duration,
+				Description:  extutil.Ptr("The duration of the attack."),
+				Advanced:     extutil.Ptr(false),
+				Required:     extutil.Ptr(true),
+				DefaultValue: extutil.Ptr("60s"),
+				Order:        extutil.Ptr(0),
+			},
+			{
+				Name:         "rate",
+				Label:        "Rate",
+				Description:  extutil.Ptr("The percentage of invocations affected."),
+				Type:         action_kit_api.Percentage,
+				DefaultValue: extutil.Ptr("100"),
+				Required:     extutil.Ptr(true),
+				Order:        extutil.Ptr(1),
+			},
+		}, parameters...),
+		Prepare: action_kit_api.MutatingEndpointReference{
+			Method: "POST",
+			Path:   path + "/prepare",
+		},
+		Start: action_kit_api.MutatingEndpointReference{
+			Method: "POST",
+			Path:   path + "/start",
+		},
+		Status: extutil.Ptr(action_kit_api.MutatingEndpointReferenceWithCallInterval{
+			Method:       "POST",
+			Path:         path + "/status",
+			CallInterval: extutil.Ptr("5s"),
+		}),
+		Stop: extutil.Ptr(action_kit_api.MutatingEndpointReference{
+			Method: "POST",
+			Path:   path + "/stop",
+		}),
+	}
+}
+
+type FisActionState struct {
+	FunctionArn string            `json:"functionArn"`
+	ActionId    string            `json:"actionId"`
+	Parameters  map[string]string `json:"parameters"`
+	// ClientToken makes creating the template and starting the experiment idempotent per execution, target and action
+	ClientToken  string `json:"clientToken"`
+	TemplateId   string `json:"templateId,omitempty"`
+	ExperimentId string `json:"experimentId,omitempty"`
+}
+
+func prepareFis(fisActionId string) func(w http.ResponseWriter, r *http.Request, body []byte) {
+	return func(w http.ResponseWriter, r *http.Request, body []byte) {
+		var request action_kit_api.PrepareActionRequestBody
+		err := json.Unmarshal(body, &request)
+		if err != nil {
+			exthttp.WriteError(w, extension_kit.ToError("Failed to parse request body", err))
+			return
+		}
+
+		state, messages, extErr := prepareFisState(r.Context(), &request, fisActionId)
+		if extErr != nil {
+			exthttp.WriteError(w, *extErr)
+			return
+		}
+
+		var convertedState action_kit_api.ActionState
+		err = extconversion.Convert(state, &convertedState)
+		if err != nil {
+			exthttp.WriteError(w, extension_kit.ToError("Failed to encode action state", err))
+			return
+		}
+
+		exthttp.WriteBody(w, action_kit_api.PrepareResult{
+			State:    convertedState,
+			Messages: extutil.Ptr(messages),
+		})
+	}
+}
+
+func prepareFisState(ctx context.Context, request *action_kit_api.PrepareActionRequestBody, fisActionId string) (*FisActionState, []action_kit_api.Message, *extension_kit.ExtensionError) {
+	if extconfig.Config.FisRoleArn == "" {
+		return nil, nil, extutil.Ptr(extension_kit.ToError("No FIS role is configured. Set STEADYBIT_EXTENSION_FIS_ROLE_ARN.", nil))
+	}
+	functionArn := targetAttribute(request.Target, "aws.arn")
+	if functionArn == "" {
+		return nil, nil, extutil.Ptr(extension_kit.ToError("Target is missing the 'aws.arn' attribute.", nil))
+	}
+
+	// FIS rounds to full seconds and rejects experiments shorter than a second
+	seconds := int64(configDuration(request.Config, "duration").Seconds())
+	if seconds < 1 {
+		seconds = 1
+	}
+	parameters := map[string]string{
+		"duration":             fmt.Sprintf("PT%dS", seconds),
+		"invocationPercentage": strconv.Itoa(int(aws.ToInt32(configInt32(request.Config, "rate")))),
+	}
+	switch fisActionId {
+	case "aws:lambda:invocation-add-delay":
+		parameters["startupDelayMilliseconds"] = strconv.FormatInt(configDuration(request.Config, "delay").Milliseconds(), 10)
+	case "aws:lambda:invocation-error":
+		parameters["preventExecution"] = strconv.FormatBool(configBool(request.Config, "preventExecution"))
+	case "aws:lambda:invocation-http-integration-response":
+		parameters["preventExecution"] = strconv.FormatBool(configBool(request.Config, "preventExecution"))
+		parameters["statusCode"] = strconv.Itoa(int(aws.ToInt32(configInt32(request.Config, "statusCode"))))
+		if contentType := configString(request.Config, "contentType"); contentType != "" {
+			parameters["contentTypeHeader"] = contentType
+		}
+	}
+
+	client, err := createLambdaClient(ctx)
+	if err != nil {
+		return nil, nil, extutil.Ptr(extension_kit.ToError("Failed to create lambda client", err))
+	}
+	function, err := client.GetFunctionConfiguration(ctx, &lambda.GetFunctionConfigurationInput{
+		FunctionName: extutil.Ptr(functionArn),
+	})
+	if err != nil {
+		return nil, nil, extutil.Ptr(extension_kit.ToError("Failed to get function configuration", err))
+	}
+	messages := make([]action_kit_api.Message, 0)
+	if function.Environment == nil || function.Environment.Variables[fisConfigurationVariable] == "" {
+		messages = append(messages, action_kit_api.Message{
+			Level:   extutil.Ptr(action_kit_api.Warn),
+			Message: fmt.Sprintf("Function %s does not set %s, the FIS Lambda extension will not pick up the experiment.", functionArn, fisConfigurationVariable),
+		})
+	}
+
+	return &FisActionState{
+		FunctionArn: functionArn,
+		ActionId:    fisActionId,
+		Parameters:  parameters,
+		ClientToken: fisClientToken(request.ExecutionId.String(), functionArn, fisActionId),
+	}, messages, nil
+}
+
+// fisClientToken derives a client token unique per execution, function and action. FIS accepts at most 64 characters.
+func fisClientToken(executionId string, functionArn string, actionId string) string {
+	hash := sha256.Sum256([]byte(executionId + "/" + functionArn + "/" + actionId))
+	return hex.EncodeToString(hash[:])
+}
+
+func startFis(w http.ResponseWriter, r *http.Request, body []byte) {
+	var request action_kit_api.StartActionRequestBody
+	err := json.Unmarshal(body, &request)
+	if err != nil {
+		exthttp.WriteError(w, extension_kit.ToError("Failed to parse request body", err))
+		return
+	}
+
+	var state FisActionState
+	err = extconversion.Convert(request.State, &state)
+	if err != nil {
+		exthttp.WriteError(w, extension_kit.ToError("Failed to convert action state", err))
+		return
+	}
+
+	client, err := createFisClient(r.Context())
+	if err != nil {
+		exthttp.WriteError(w, extension_kit.ToError("Failed to create fis client", err))
+		return
+	}
+
+	template, err := client.CreateExperimentTemplate(r.Context(), &fis.CreateExperimentTemplateInput{
+		ClientToken: extutil.Ptr(state.ClientToken),
+		Description: extutil.Ptr(fmt.Sprintf("%s on %s - created by steadybit", state.ActionId, state.FunctionArn)),
+		RoleArn:     extutil.Ptr(extconfig.Config.FisRoleArn),
+		StopConditions: []fistypes.CreateExperimentTemplateStopConditionInput{
+			{Source: extutil.Ptr("none")},
+		},
+		Targets: map[string]fistypes.CreateExperimentTemplateTargetInput{
+			fisTargetName: {
+				ResourceType:  extutil.Ptr("aws:lambda:function"),
+				ResourceArns:  []string{state.FunctionArn},
+				SelectionMode: extutil.Ptr("ALL"),
+			},
+		},
+		Actions: map[string]fistypes.CreateExperimentTemplateActionInput{
+			"steadybit": {
+				ActionId:   extutil.Ptr(state.ActionId),
+				Parameters: state.Parameters,
+				Targets:    map[string]string{fisTargetName: fisTargetName},
+			},
+		},
+		Tags: map[string]string{"created-by": "steadybit"},
+	})
+	if err != nil {
+		exthttp.WriteError(w, extension_kit.ToError("Failed to create FIS experiment template", err))
+		return
+	}
+	state.TemplateId = aws.ToString(template.ExperimentTemplate.Id)
+
+	experiment, err := client.StartExperiment(r.Context(), &fis.StartExperimentInput{
+		ClientToken:          extutil.Ptr(state.ClientToken),
+		ExperimentTemplateId: extutil.Ptr(state.TemplateId),
+		Tags:                 map[string]string{"created-by": "steadybit"},
+	})
+	if err != nil {
+		deleteFisExperimentTemplate(r.Context(), client, state.TemplateId)
+		exthttp.WriteError(w, extension_kit.ToError("Failed to start FIS experiment", err))
+		return
+	}
+	state.ExperimentId = aws.ToString(experiment.Experiment.Id)
+
+	var convertedState action_kit_api.ActionState
+	err = extconversion.Convert(state, &convertedState)
+	if err != nil {
+		exthttp.WriteError(w, extension_kit.ToError("Failed to encode action state", err))
+		return
+	}
+
+	exthttp.WriteBody(w, action_kit_api.StartResult{
+		State: &convertedState,
+		Messages: extutil.Ptr([]action_kit_api.Message{
+			{
+				Level:   extutil.Ptr(action_kit_api.Info),
+				Message: fmt.Sprintf("Started FIS experiment %s.", state.ExperimentId),
+			},
+		}),
+	})
+}
+
+func statusFis(w http.ResponseWriter, r *http.Request, body []byte) {
+	var request action_kit_api.ActionStatusRequestBody
+	err := json.Unmarshal(body, &request)
+	if err != nil {
+		exthttp.WriteError(w, extension_kit.ToError("Failed to parse request body", err))
+		return
+	}
+
+	var state FisActionState
+	err = extconversion.Convert(request.State, &state)
+	if err != nil {
+		exthttp.WriteError(w, extension_kit.ToError("Failed to convert action state", err))
+		return
+	}
+
+	client, err := createFisClient(r.Context())
+	if err != nil {
+		exthttp.WriteError(w, extension_kit.ToError("Failed to create fis client", err))
+		return
+	}
+
+	experiment, err := client.GetExperiment(r.Context(), &fis.GetExperimentInput{
+		Id: extutil.Ptr(state.ExperimentId),
+	})
+	if err != nil {
+		exthttp.WriteError(w, extension_kit.ToError("Failed to get FIS experiment", err))
+		return
+	}
+
+	status := experiment.Experiment.State.Status
+	reason := aws.ToString(experiment.Experiment.State.Reason)
+	switch status {
+	case fistypes.ExperimentStatusFailed:
+		exthttp.WriteBody(w, action_kit_api.StatusResult{
+			Completed: true,
+			Error: &action_kit_api.ActionKitError{
+				Title:  fmt.Sprintf("FIS experiment %s failed: %s", state.ExperimentId, reason),
+				Status: extutil.Ptr(action_kit_api.Errored),
+			},
+		})
+	case fistypes.ExperimentStatusCompleted, fistypes.ExperimentStatusStopped:
+		exthttp.WriteBody(w, action_kit_api.StatusResult{
+			Completed: true,
+			Messages: extutil.Ptr([]action_kit_api.Message{
+				{
+					Level:   extutil.Ptr(action_kit_api.Info),
+					Message: fmt.Sprintf("FIS experiment %s %s.", state.ExperimentId, status),
+				},
+			}),
+		})
+	default:
+		exthttp.WriteBody(w, action_kit_api.StatusResult{Completed: false})
+	}
+}
+
+func stopFis(w http.ResponseWriter, r *http.Request, body []byte) {
+	var request action_kit_api.StopActionRequestBody
+	err := json.Unmarshal(body, &request)
+	if err != nil {
+		exthttp.WriteError(w, extension_kit.ToError("Failed to parse request body", err))
+		return
+	}
+
+	var state FisActionState
+	err = extconversion.Convert(request.State, &state)
+	if err != nil {
+		exthttp.WriteError(w, extension_kit.ToError("Failed to convert action state", err))
+		return
+	}
+
+	client, err := createFisClient(r.Context())
+	if err != nil {
+		exthttp.WriteError(w, extension_kit.ToError("Failed to create fis client", err))
+		return
+	}
+
+	if state.ExperimentId != "" {
+		experiment, err := client.GetExperiment(r.Context(), &fis.GetExperimentInput{
+			Id: extutil.Ptr(state.ExperimentId),
+		})
+		if err != nil {
+			exthttp.WriteError(w, extension_kit.ToError("Failed to get FIS experiment", err))
+			return
+		}
+		if !isFisExperimentEnded(experiment.Experiment.State.Status) {
+			_, err = client.StopExperiment(r.Context(), &fis.StopExperimentInput{
+				Id: extutil.Ptr(state.ExperimentId),
+			})
+			if err != nil {
+				exthttp.WriteError(w, extension_kit.ToError("Failed to stop FIS experiment", err))
+				return
+			}
+		}
+	}
+	if state.TemplateId != "" {
+		deleteFisExperimentTemplate(r.Context(), client, state.TemplateId)
+	}
+
+	exthttp.WriteBody(w, action_kit_api.StopResult{})
+}
+
+func isFisExperimentEnded(status fistypes.ExperimentStatus) bool {
+	return status == fistypes.ExperimentStatusCompleted ||
+		status == fistypes.ExperimentStatusStopped ||
+		status == fistypes.ExperimentStatusFailed
+}
+
+// deleteFisExperimentTemplate removes the template created for the attack. Leftover templates are harmless, so failures are only logged.
+func deleteFisExperimentTemplate(ctx context.Context, client *fis.Client, templateId string) {
+	_, err := client.DeleteExperimentTemplate(ctx, &fis.DeleteExperimentTemplateInput{
+		Id: extutil.Ptr(templateId),
+	})
+	if err != nil {
+		log.Warn().Err(err).Msgf("Failed to delete FIS experiment template %s.", templateId)
+	}
+}
+
+func createFisClient(ctx context.Context) (*fis.Client, error) {
+	awsConfig, err := config.LoadDefaultConfig(ctx)
+	if err != nil {
+		return nil, err
+	}
+	client := fis.NewFromConfig(awsConfig)
+	return client, err
+}
@@ -0,0 +1,29 @@
+/*
+ * Copyright 2023 steadybit GmbH. All rights reserved.
+ */
+
+package extlambda
+
+import "testing"
+
+func TestFisClientToken(t *testing.T) {
+	executionId := "2f0f1f4e-6a7e-4b43-9b1e-3d2c0e5a8f11"
+	functionArn := "arn:aws:lambda:eu-central-1:123456789012:function:my-function"
+	token := fisClientToken(executionId, functionArn, "aws:lambda:invocation-add-delay")
+
+	if len(token) > 64 {
+		t.Errorf("fisClientToken() has %d characters, want at most 64", len(token))
+	}
+	if token != fisClientToken(executionId, functionArn, "aws:lambda:invocation-add-delay") {
+		t.Errorf("fisClientToken() is not stable")
+	}
+	if token == fisClientToken(executionId, functionArn+"-v2", "aws:lambda:invocation-add-delay") {
+		t.Errorf("fisClientToken() is shared across functions")
+	}
+	if token == fisClientToken(executionId, functionArn, "aws:lambda:invocation-error") {
+		t.Errorf("fisClientToken() is shared across actions")
+	}
+	if token == fisClientToken("5b1c2d3e-0000-4b43-9b1e-3d2c0e5a8f11", functionArn, "aws:lambda:invocation-add-delay") {
+		t.Errorf("fisClientToken() is shared across executions")
+	}
+}
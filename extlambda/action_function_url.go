@@ -0,0 +1,332 @@
+/*
+ * Copyright 2023 steadybit GmbH. All rights reserved.
+ */
+
+package extlambda
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/lambda"
+	"github.com/aws/aws-sdk-go-v2/service/lambda/types"
+	"github.com/rs/zerolog/log"
+	"github.com/steadybit/action-kit/go/action_kit_api/v2"
+	extension_kit "github.com/steadybit/extension-kit"
+	"github.com/steadybit/extension-kit/extbuild"
+	"github.com/steadybit/extension-kit/extconversion"
+	"github.com/steadybit/extension-kit/exthttp"
+	"github.com/steadybit/extension-kit/extutil"
+	"net/http"
+	"strings"
+)
+
+const functionUrlActionBasePath = basePath + "/actions/function-url"
+
+func registerFunctionUrlActionHandlers() {
+	exthttp.RegisterHttpHandler(functionUrlActionBasePath, exthttp.GetterAsHandler(getFunctionUrlActionDescription))
+	exthttp.RegisterHttpHandler(functionUrlActionBasePath+"/prepare", prepareFunctionUrl)
+	exthttp.RegisterHttpHandler(functionUrlActionBasePath+"/start", startFunctionUrl)
+	exthttp.RegisterHttpHandler(functionUrlActionBasePath+"/stop", stopFunctionUrl)
+}
+
+func getFunctionUrlActionDescription() action_kit_api.ActionDescription {
+	return action_kit_api.ActionDescription{
+		Id:          fmt.Sprintf("%s.functionUrl", targetID),
+		Version:     extbuild.GetSemverVersionStringOrUnknown(),
+		Label:       "Disrupt Function URL",
+		Description: "Requires IAM authentication for the function's URLs, so that clients get 403 responses. Alternatively the URLs are deleted for 404 responses, but they are re-created with a new address on stop.",
+		Icon:        extutil.Ptr(targetIcon),
+		TargetType:  extutil.Ptr(targetID),
+		TargetSelectionTemplates: extutil.Ptr([]action_kit_api.TargetSelectionTemplate{
+			{
+				Label: "by function name",
+				Query: "aws.lambda.function-name=\"\"",
+			},
+		}),
+		Category:    extutil.Ptr("cloud"),
+		Kind:        action_kit_api.Attack,
+		TimeControl: action_kit_api.External,
+		Parameters: []action_kit_api.ActionParameter{
+			{
+				Label:        "Duration",
+				Name:         "duration",
+				Type:         action_kit_api.Duration,
+				Description:  extutil.Ptr("The duration of the attack."),
+				Advanced:     extutil.Ptr(false),
+				Required:     extutil.Ptr(true),
+				DefaultValue: extutil.Ptr("60s"),
+				Order:        extutil.Ptr(0),
+			},
+			{
+				Name:         "mode",
+				Label:        "Mode",
+				Description:  extutil.Ptr("How the function URLs are disrupted. Deleted URLs get a new address when they are re-created, so clients have to be reconfigured."),
+				Type:         action_kit_api.String,
+				DefaultValue: extutil.Ptr("iam"),
+				Required:     extutil.Ptr(true),
+				Order:        extutil.Ptr(1),
+				Options: extutil.Ptr([]action_kit_api.ParameterOption{
+					action_kit_api.ExplicitParameterOption{Label: "Require IAM authentication (403)", Value: "iam"},
+					action_kit_api.ExplicitParameterOption{Label: "Delete URL (404, new URL after stop)", Value: "delete"},
+				}),
+			},
+			{
+				Name:        "qualifier",
+				Label:       "Alias",
+				Description: extutil.Ptr("Only disrupt the URL of this alias. Leave empty to disrupt all URLs of the function."),
+				Type:        action_kit_api.String,
+				Required:    extutil.Ptr(false),
+				Advanced:    extutil.Ptr(true),
+				Order:       extutil.Ptr(2),
+			},
+		},
+		Prepare: action_kit_api.MutatingEndpointReference{
+			Method: "POST",
+			Path:   functionUrlActionBasePath + "/prepare",
+		},
+		Start: action_kit_api.MutatingEndpointReference{
+			Method: "POST",
+			Path:   functionUrlActionBasePath + "/start",
+		},
+		Stop: extutil.Ptr(action_kit_api.MutatingEndpointReference{
+			Method: "POST",
+			Path:   functionUrlActionBasePath + "/stop",
+		}),
+	}
+}
+
+type functionUrl struct {
+	// Qualifier is empty for the URL of the unqualified function
+	Qualifier string      `json:"qualifier,omitempty"`
+	Url       string      `json:"url"`
+	AuthType  string      `json:"authType"`
+	Cors      *types.Cors `json:"cors,omitempty"`
+	// InvokeMode is BUFFERED or RESPONSE_STREAM, empty for URLs created before response streaming existed
+	InvokeMode string `json:"invokeMode,omitempty"`
+}
+
+type FunctionUrlActionState struct {
+	FunctionArn string        `json:"functionArn"`
+	Mode        string        `json:"mode"`
+	Urls        []functionUrl `json:"urls"`
+}
+
+func prepareFunctionUrl(w http.ResponseWriter, r *http.Request, body []byte) {
+	var request action_kit_api.PrepareActionRequestBody
+	err := json.Unmarshal(body, &request)
+	if err != nil {
+		exthttp.WriteError(w, extension_kit.ToError("Failed to parse request body", err))
+		return
+	}
+
+	state, extErr := prepareFunctionUrlState(r.Context(), &request)
+	if extErr != nil {
+		exthttp.WriteError(w, *extErr)
+		return
+	}
+
+	var convertedState action_kit_api.ActionState
+	err = extconversion.Convert(state, &convertedState)
+	if err != nil {
+		exthttp.WriteError(w, extension_kit.ToError("Failed to encode action state", err))
+		return
+	}
+
+	messages := make([]action_kit_api.Message, 0)
+	if state.Mode == "delete" {
+		for _, url := range state.Urls {
+			messages = append(messages, action_kit_api.Message{
+				Level:   extutil.Ptr(action_kit_api.Warn),
+				Message: fmt.Sprintf("Function URL %s is deleted and re-created with a new address on stop, clients using it have to be reconfigured.", url.Url),
+			})
+		}
+	}
+
+	exthttp.WriteBody(w, action_kit_api.PrepareResult{
+		State:    convertedState,
+		Messages: extutil.Ptr(messages),
+	})
+}
+
+func prepareFunctionUrlState(ctx context.Context, request *action_kit_api.PrepareActionRequestBody) (*FunctionUrlActionState, *extension_kit.ExtensionError) {
+	functionArn := targetAttribute(request.Target, "aws.arn")
+	if functionArn == "" {
+		return nil, extutil.Ptr(extension_kit.ToError("Target is missing the 'aws.arn' attribute.", nil))
+	}
+	mode := configString(request.Config, "mode")
+	if mode != "iam" && mode != "delete" {
+		return nil, extutil.Ptr(extension_kit.ToError(fmt.Sprintf("Unknown mode '%s'.", mode), nil))
+	}
+	qualifier := configString(request.Config, "qualifier")
+
+	client, err := createLambdaClient(ctx)
+	if err != nil {
+		return nil, extutil.Ptr(extension_kit.ToError("Failed to create lambda client", err))
+	}
+
+	configs, err := listFunctionUrlConfigs(ctx, client, functionArn)
+	if err != nil {
+		return nil, extutil.Ptr(extension_kit.ToError("Failed to list function URLs", err))
+	}
+
+	state := &FunctionUrlActionState{
+		FunctionArn: functionArn,
+		Mode:        mode,
+		Urls:        make([]functionUrl, 0, len(configs)),
+	}
+	for _, config := range configs {
+		url := functionUrl{
+			Qualifier:  strings.TrimPrefix(strings.TrimPrefix(aws.ToString(config.FunctionArn), functionArn), ":"),
+			Url:        aws.ToString(config.FunctionUrl),
+			AuthType:   string(config.AuthType),
+			Cors:       config.Cors,
+			InvokeMode: string(config.InvokeMode),
+		}
+		if qualifier != "" && url.Qualifier != qualifier {
+			continue
+		}
+		if mode == "iam" && config.AuthType == types.FunctionUrlAuthTypeAwsIam {
+			continue
+		}
+		state.Urls = append(state.Urls, url)
+	}
+	if len(state.Urls) == 0 {
+		return nil, extutil.Ptr(extension_kit.ToError(fmt.Sprintf("Function %s has no matching URL to disrupt.", functionArn), nil))
+	}
+	return state, nil
+}
+
+func startFunctionUrl(w http.ResponseWriter, r *http.Request, body []byte) {
+	var request action_kit_api.StartActionRequestBody
+	err := json.Unmarshal(body, &request)
+	if err != nil {
+		exthttp.WriteError(w, extension_kit.ToError("Failed to parse request body", err))
+		return
+	}
+
+	var state FunctionUrlActionState
+	err = extconversion.Convert(request.State, &state)
+	if err != nil {
+		exthttp.WriteError(w, extension_kit.ToError("Failed to convert action state", err))
+		return
+	}
+
+	client, err := createLambdaClient(r.Context())
+	if err != nil {
+		exthttp.WriteError(w, extension_kit.ToError("Failed to create lambda client", err))
+		return
+	}
+
+	for i, url := range state.Urls {
+		if state.Mode == "delete" {
+			_, err = client.DeleteFunctionUrlConfig(r.Context(), &lambda.DeleteFunctionUrlConfigInput{
+				FunctionName: extutil.Ptr(state.FunctionArn),
+				Qualifier:    qualifierPtr(url.Qualifier),
+			})
+		} else {
+			_, err = client.UpdateFunctionUrlConfig(r.Context(), &lambda.UpdateFunctionUrlConfigInput{
+				FunctionName: extutil.Ptr(state.FunctionArn),
+				Qualifier:    qualifierPtr(url.Qualifier),
+				AuthType:     types.FunctionUrlAuthTypeAwsIam,
+			})
+		}
+		if err != nil {
+			// Roll back the URLs disrupted so far, as stop is not called for a failed start
+			for _, disrupted := range state.Urls[:i] {
+				if _, err := restoreFunctionUrl(r.Context(), client, state.FunctionArn, disrupted); err != nil {
+					log.Error().Err(err).Msgf("Failed to roll back function URL %s.", disrupted.Url)
+				}
+			}
+			exthttp.WriteError(w, extension_kit.ToError(fmt.Sprintf("Failed to disrupt function URL %s", url.Url), err))
+			return
+		}
+	}
+
+	exthttp.WriteBody(w, action_kit_api.StartResult{})
+}
+
+func stopFunctionUrl(w http.ResponseWriter, r *http.Request, body []byte) {
+	var request action_kit_api.StopActionRequestBody
+	err := json.Unmarshal(body, &request)
+	if err != nil {
+		exthttp.WriteError(w, extension_kit.ToError("Failed to parse request body", err))
+		return
+	}
+
+	var state FunctionUrlActionState
+	err = extconversion.Convert(request.State, &state)
+	if err != nil {
+		exthttp.WriteError(w, extension_kit.ToError("Failed to convert action state", err))
+		return
+	}
+
+	client, err := createLambdaClient(r.Context())
+	if err != nil {
+		exthttp.WriteError(w, extension_kit.ToError("Failed to create lambda client", err))
+		return
+	}
+
+	messages := make([]action_kit_api.Message, 0)
+	for _, url := range state.Urls {
+		restoredUrl, err := restoreFunctionUrl(r.Context(), client, state.FunctionArn, url)
+		if err != nil {
+			exthttp.WriteError(w, extension_kit.ToError(fmt.Sprintf("Failed to restore function URL %s", url.Url), err))
+			return
+		}
+		if restoredUrl != url.Url {
+			messages = append(messages, action_kit_api.Message{
+				Level:   extutil.Ptr(action_kit_api.Warn),
+				Message: fmt.Sprintf("Function URL %s was re-created as %s.", url.Url, restoredUrl),
+			})
+		}
+	}
+
+	exthttp.WriteBody(w, action_kit_api.StopResult{
+		Messages: extutil.Ptr(messages),
+	})
+}
+
+// restoreFunctionUrl re-creates a deleted URL config or reverts an updated one and returns the resulting URL.
+func restoreFunctionUrl(ctx context.Context, client *lambda.Client, functionArn string, url functionUrl) (string, error) {
+	created, err := client.CreateFunctionUrlConfig(ctx, &lambda.CreateFunctionUrlConfigInput{
+		FunctionName: extutil.Ptr(functionArn),
+		Qualifier:    qualifierPtr(url.Qualifier),
+		AuthType:     types.FunctionUrlAuthType(url.AuthType),
+		Cors:         url.Cors,
+		InvokeMode:   types.InvokeMode(url.InvokeMode),
+	})
+	if err == nil {
+		return aws.ToString(created.FunctionUrl), nil
+	}
+	var conflict *types.ResourceConflictException
+	if !errors.As(err, &conflict) {
+		return "", err
+	}
+
+	// The URL config still exists, so it only needs to be reverted
+	input := &lambda.UpdateFunctionUrlConfigInput{
+		FunctionName: extutil.Ptr(functionArn),
+		Qualifier:    qualifierPtr(url.Qualifier),
+		AuthType:     types.FunctionUrlAuthType(url.AuthType),
+		Cors:         url.Cors,
+		InvokeMode:   types.InvokeMode(url.InvokeMode),
+	}
+	if input.Cors == nil {
+		input.Cors = &types.Cors{}
+	}
+	updated, err := client.UpdateFunctionUrlConfig(ctx, input)
+	if err != nil {
+		return "", err
+	}
+	return aws.ToString(updated.FunctionUrl), nil
+}
+
+func qualifierPtr(qualifier string) *string {
+	if qualifier == "" {
+		return nil
+	}
+	return extutil.Ptr(qualifier)
+}
@@ -0,0 +1,303 @@
+/*
+ * Copyright 2023 steadybit GmbH. All rights reserved.
+ */
+
+package extlambda
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/lambda"
+	"github.com/steadybit/action-kit/go/action_kit_api/v2"
+	extension_kit "github.com/steadybit/extension-kit"
+	"github.com/steadybit/extension-kit/extbuild"
+	"github.com/steadybit/extension-kit/extconversion"
+	"github.com/steadybit/extension-kit/exthttp"
+	"github.com/steadybit/extension-kit/extutil"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+const layerSwapActionBasePath = basePath + "/actions/layer-swap"
+
+func registerLayerSwapActionHandlers() {
+	exthttp.RegisterHttpHandler(layerSwapActionBasePath, exthttp.GetterAsHandler(getLayerSwapActionDescription))
+	exthttp.RegisterHttpHandler(layerSwapActionBasePath+"/prepare", prepareLayerSwap)
+	exthttp.RegisterHttpHandler(layerSwapActionBasePath+"/start", startLayerSwap)
+	exthttp.RegisterHttpHandler(layerSwapActionBasePath+"/stop", stopLayerSwap)
+}
+
+func getLayerSwapActionDescription() action_kit_api.ActionDescription {
+	return action_kit_api.ActionDescription{
+		Id:          fmt.Sprintf("%s.layerSwap", targetID),
+		Version:     extbuild.GetSemverVersionStringOrUnknown(),
+		Label:       "Swap Layer Version",
+		Description: "Replaces one of the function's layers with an older or a given layer version.",
+		Icon:        extutil.Ptr(targetIcon),
+		TargetType:  extutil.Ptr(targetID),
+		TargetSelectionTemplates: extutil.Ptr([]action_kit_api.TargetSelectionTemplate{
+			{
+				Label: "by function name",
+				Query: "aws.lambda.function-name=\"\"",
+			},
+		}),
+		Category:    extutil.Ptr("cloud"),
+		Kind:        action_kit_api.Attack,
+		TimeControl: action_kit_api.External,
+		Parameters: []action_kit_api.ActionParameter{
+			{
+				Label:        "Duration",
+				Name:         "duration",
+				Type:         action_kit_api.Duration,
+				Description:  extutil.Ptr("The duration of the attack."),
+				Advanced:     extutil.Ptr(false),
+				Required:     extutil.Ptr(true),
+				DefaultValue: extutil.Ptr("60s"),
+				Order:        extutil.Ptr(0),
+			},
+			{
+				Name:        "layerName",
+				Label:       "Layer Name",
+				Description: extutil.Ptr("The name of the layer to replace."),
+				Type:        action_kit_api.String,
+				Required:    extutil.Ptr(true),
+				Order:       extutil.Ptr(1),
+			},
+			{
+				Name:        "layerVersionArn",
+				Label:       "Layer Version ARN",
+				Description: extutil.Ptr("The layer version to use instead. Leave empty to use the version preceding the current one."),
+				Type:        action_kit_api.String,
+				Required:    extutil.Ptr(false),
+				Order:       extutil.Ptr(2),
+			},
+		},
+		Prepare: action_kit_api.MutatingEndpointReference{
+			Method: "POST",
+			Path:   layerSwapActionBasePath + "/prepare",
+		},
+		Start: action_kit_api.MutatingEndpointReference{
+			Method: "POST",
+			Path:   layerSwapActionBasePath + "/start",
+		},
+		Stop: extutil.Ptr(action_kit_api.MutatingEndpointReference{
+			Method: "POST",
+			Path:   layerSwapActionBasePath + "/stop",
+		}),
+	}
+}
+
+type LayerSwapActionState struct {
+	FunctionArn    string   `json:"functionArn"`
+	OriginalLayers []string `json:"originalLayers"`
+	Layers         []string `json:"layers"`
+}
+
+func prepareLayerSwap(w http.ResponseWriter, r *http.Request, body []byte) {
+	var request action_kit_api.PrepareActionRequestBody
+	err := json.Unmarshal(body, &request)
+	if err != nil {
+		exthttp.WriteError(w, extension_kit.ToError("Failed to parse request body", err))
+		return
+	}
+
+	state, extErr := prepareLayerSwapState(r.Context(), &request)
+	if extErr != nil {
+		exthttp.WriteError(w, *extErr)
+		return
+	}
+
+	var convertedState action_kit_api.ActionState
+	err = extconversion.Convert(state, &convertedState)
+	if err != nil {
+		exthttp.WriteError(w, extension_kit.ToError("Failed to encode action state", err))
+		return
+	}
+
+	exthttp.WriteBody(w, action_kit_api.PrepareResult{
+		State: convertedState,
+	})
+}
+
+func prepareLayerSwapState(ctx context.Context, request *action_kit_api.PrepareActionRequestBody) (*LayerSwapActionState, *extension_kit.ExtensionError) {
+	functionArn := targetAttribute(request.Target, "aws.arn")
+	if functionArn == "" {
+		return nil, extutil.Ptr(extension_kit.ToError("Target is missing the 'aws.arn' attribute.", nil))
+	}
+	layerName := configString(request.Config, "layerName")
+	if layerName == "" {
+		return nil, extutil.Ptr(extension_kit.ToError("Layer name is required.", nil))
+	}
+	replacement := configString(request.Config, "layerVersionArn")
+
+	client, err := createLambdaClient(ctx)
+	if err != nil {
+		return nil, extutil.Ptr(extension_kit.ToError("Failed to create lambda client", err))
+	}
+
+	function, err := client.GetFunctionConfiguration(ctx, &lambda.GetFunctionConfigurationInput{
+		FunctionName: extutil.Ptr(functionArn),
+	})
+	if err != nil {
+		return nil, extutil.Ptr(extension_kit.ToError("Failed to get function configuration", err))
+	}
+
+	originalLayers := make([]string, 0, len(function.Layers))
+	layers := make([]string, 0, len(function.Layers))
+	found := false
+	for _, layer := range function.Layers {
+		layerVersionArn := aws.ToString(layer.Arn)
+		originalLayers = append(originalLayers, layerVersionArn)
+		layerArn, name, version := parseLayerVersionArn(layerVersionArn)
+		if name != layerName {
+			layers = append(layers, layerVersionArn)
+			continue
+		}
+
+		found = true
+		if replacement == "" {
+			replacement, err = previousLayerVersion(ctx, client, layerArn, version)
+			if err != nil {
+				return nil, extutil.Ptr(extension_kit.ToError(fmt.Sprintf("Failed to find previous version of layer %s", layerName), err))
+			}
+			if replacement == "" {
+				return nil, extutil.Ptr(extension_kit.ToError(fmt.Sprintf("Layer %s has no version older than %d.", layerName, version), nil))
+			}
+		}
+		layers = append(layers, replacement)
+	}
+	if !found {
+		return nil, extutil.Ptr(extension_kit.ToError(fmt.Sprintf("Function %s does not use layer %s.", functionArn, layerName), nil))
+	}
+
+	return &LayerSwapActionState{
+		FunctionArn:    functionArn,
+		OriginalLayers: originalLayers,
+		Layers:         layers,
+	}, nil
+}
+
+// parseLayerVersionArn splits 'arn:aws:lambda:<region>:<account>:layer:<name>:<version>' into the layer ARN, name and version.
+// Layer ARNs without a version are returned with version 0.
+func parseLayerVersionArn(layerVersionArn string) (string, string, int64) {
+	layerArn := layerVersionArn
+	var version int64 = 0
+	separator := strings.LastIndex(layerVersionArn, ":")
+	if parsed, err := strconv.ParseInt(layerVersionArn[separator+1:], 10, 64); separator >= 0 && err == nil {
+		layerArn = layerVersionArn[:separator]
+		version = parsed
+	}
+	return layerArn, layerArn[strings.LastIndex(layerArn, ":")+1:], version
+}
+
+// previousLayerVersion returns the ARN of the highest layer version below the given one, or an empty string if there is none.
+func previousLayerVersion(ctx context.Context, client *lambda.Client, layerArn string, version int64) (string, error) {
+	result := ""
+	var resultVersion int64 = 0
+	var marker *string = nil
+	for {
+		output, err := client.ListLayerVersions(ctx, &lambda.ListLayerVersionsInput{
+			LayerName: extutil.Ptr(layerArn),
+			Marker:    marker,
+		})
+		if err != nil {
+			return "", err
+		}
+
+		for _, layerVersion := range output.LayerVersions {
+			if layerVersion.Version < version && layerVersion.Version > resultVersion {
+				result = aws.ToString(layerVersion.LayerVersionArn)
+				resultVersion = layerVersion.Version
+			}
+		}
+
+		if output.NextMarker == nil {
+			break
+		} else {
+			marker = output.NextMarker
+		}
+	}
+	return result, nil
+}
+
+func startLayerSwap(w http.ResponseWriter, r *http.Request, body []byte) {
+	var request action_kit_api.StartActionRequestBody
+	err := json.Unmarshal(body, &request)
+	if err != nil {
+		exthttp.WriteError(w, extension_kit.ToError("Failed to parse request body", err))
+		return
+	}
+
+	var state LayerSwapActionState
+	err = extconversion.Convert(request.State, &state)
+	if err != nil {
+		exthttp.WriteError(w, extension_kit.ToError("Failed to convert action state", err))
+		return
+	}
+
+	client, err := createLambdaClient(r.Context())
+	if err != nil {
+		exthttp.WriteError(w, extension_kit.ToError("Failed to create lambda client", err))
+		return
+	}
+
+	err = updateFunctionLayers(r.Context(), client, state.FunctionArn, state.Layers)
+	if err != nil {
+		exthttp.WriteError(w, extension_kit.ToError("Failed to swap layer", err))
+		return
+	}
+
+	exthttp.WriteBody(w, action_kit_api.StartResult{})
+}
+
+func stopLayerSwap(w http.ResponseWriter, r *http.Request, body []byte) {
+	var request action_kit_api.StopActionRequestBody
+	err := json.Unmarshal(body, &request)
+	if err != nil {
+		exthttp.WriteError(w, extension_kit.ToError("Failed to parse request body", err))
+		return
+	}
+
+	var state LayerSwapActionState
+	err = extconversion.Convert(request.State, &state)
+	if err != nil {
+		exthttp.WriteError(w, extension_kit.ToError("Failed to convert action state", err))
+		return
+	}
+
+	client, err := createLambdaClient(r.Context())
+	if err != nil {
+		exthttp.WriteError(w, extension_kit.ToError("Failed to create lambda client", err))
+		return
+	}
+
+	err = updateFunctionLayers(r.Context(), client, state.FunctionArn, state.OriginalLayers)
+	if err != nil {
+		exthttp.WriteError(w, extension_kit.ToError("Failed to restore layers", err))
+		return
+	}
+
+	exthttp.WriteBody(w, action_kit_api.StopResult{})
+}
+
+func updateFunctionLayers(ctx context.Context, client *lambda.Client, functionArn string, layers []string) error {
+	current, err := waitForFunctionUpdated(ctx, client, functionArn)
+	if err != nil {
+		return err
+	}
+
+	_, err = client.UpdateFunctionConfiguration(ctx, &lambda.UpdateFunctionConfigurationInput{
+		FunctionName: extutil.Ptr(functionArn),
+		Layers:       layers,
+		RevisionId:   current.RevisionId,
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = waitForFunctionUpdated(ctx, client, functionArn)
+	return err
+}
@@ -0,0 +1,49 @@
+/*
+ * Copyright 2023 steadybit GmbH. All rights reserved.
+ */
+
+package extlambda
+
+import "testing"
+
+func TestParseLayerVersionArn(t *testing.T) {
+	tests := []struct {
+		arn         string
+		wantArn     string
+		wantName    string
+		wantVersion int64
+	}{
+		{
+			arn:         "arn:aws:lambda:eu-central-1:123456789012:layer:failure-lambda:12",
+			wantArn:     "arn:aws:lambda:eu-central-1:123456789012:layer:failure-lambda",
+			wantName:    "failure-lambda",
+			wantVersion: 12,
+		},
+		{
+			arn:         "arn:aws-cn:lambda:cn-north-1:123456789012:layer:shared:1",
+			wantArn:     "arn:aws-cn:lambda:cn-north-1:123456789012:layer:shared",
+			wantName:    "shared",
+			wantVersion: 1,
+		},
+		{
+			arn:         "arn:aws:lambda:eu-central-1:123456789012:layer:failure-lambda",
+			wantArn:     "arn:aws:lambda:eu-central-1:123456789012:layer:failure-lambda",
+			wantName:    "failure-lambda",
+			wantVersion: 0,
+		},
+		{
+			arn:         "failure-lambda",
+			wantArn:     "failure-lambda",
+			wantName:    "failure-lambda",
+			wantVersion: 0,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.arn, func(t *testing.T) {
+			layerArn, name, version := parseLayerVersionArn(tt.arn)
+			if layerArn != tt.wantArn || name != tt.wantName || version != tt.wantVersion {
+				t.Errorf("parseLayerVersionArn() = (%q, %q, %d), want (%q, %q, %d)", layerArn, name, version, tt.wantArn, tt.wantName, tt.wantVersion)
+			}
+		})
+	}
+}
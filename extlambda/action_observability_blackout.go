@@ -0,0 +1,308 @@
+/*
+ * Copyright 2023 steadybit GmbH. All rights reserved.
+ */
+
+package extlambda
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/aws/aws-sdk-go-v2/service/lambda"
+	"github.com/aws/aws-sdk-go-v2/service/lambda/types"
+	"github.com/steadybit/action-kit/go/action_kit_api/v2"
+	extension_kit "github.com/steadybit/extension-kit"
+	"github.com/steadybit/extension-kit/extbuild"
+	"github.com/steadybit/extension-kit/extconversion"
+	"github.com/steadybit/extension-kit/exthttp"
+	"github.com/steadybit/extension-kit/extutil"
+	"net/http"
+)
+
+const observabilityBlackoutActionBasePath = basePath + "/actions/observability-blackout"
+
+// blackoutLoggingConfig keeps only fatal application logs and warnings of the runtime. Log levels require the JSON format.
+var blackoutLoggingConfig = types.LoggingConfig{
+	LogFormat:           types.LogFormatJson,
+	ApplicationLogLevel: types.ApplicationLogLevelFatal,
+	SystemLogLevel:      types.SystemLogLevelWarn,
+}
+
+func registerObservabilityBlackoutActionHandlers() {
+	exthttp.RegisterHttpHandler(observabilityBlackoutActionBasePath, exthttp.GetterAsHandler(getObservabilityBlackoutActionDescription))
+	exthttp.RegisterHttpHandler(observabilityBlackoutActionBasePath+"/prepare", prepareObservabilityBlackout)
+	exthttp.RegisterHttpHandler(observabilityBlackoutActionBasePath+"/start", startObservabilityBlackout)
+	exthttp.RegisterHttpHandler(observabilityBlackoutActionBasePath+"/stop", stopObservabilityBlackout)
+}
+
+func getObservabilityBlackoutActionDescription() action_kit_api.ActionDescription {
+	return action_kit_api.ActionDescription{
+		Id:          fmt.Sprintf("%s.observabilityBlackout", targetID),
+		Version:     extbuild.GetSemverVersionStringOrUnknown(),
+		Label:       "Observability Blackout",
+		Description: "Suppresses the function's logs by raising its log levels and disables active X-Ray tracing. Functions logging as text are switched to JSON for the duration of the attack, as log levels require it.",
+		Icon:        extutil.Ptr(targetIcon),
+		TargetType:  extutil.Ptr(targetID),
+		TargetSelectionTemplates: extutil.Ptr([]action_kit_api.TargetSelectionTemplate{
+			{
+				Label: "by function name",
+				Query: "aws.lambda.function-name=\"\"",
+			},
+		}),
+		Category:    extutil.Ptr("cloud"),
+		Kind:        action_kit_api.Attack,
+		TimeControl: action_kit_api.External,
+		Parameters: []action_kit_api.ActionParameter{
+			{
+				Label:        "Duration",
+				Name:         "duration",
+				Type:         action_kit_api.Duration,
+				Description:  extutil.Ptr("The duration of the attack."),
+				Advanced:     extutil.Ptr(false),
+				Required:     extutil.Ptr(true),
+				DefaultValue: extutil.Ptr("60s"),
+				Order:        extutil.Ptr(0),
+			},
+			{
+				Name:         "logging",
+				Label:        "Suppress Logs",
+				Description:  extutil.Ptr("Only keep fatal application logs and runtime warnings."),
+				Type:         action_kit_api.Boolean,
+				DefaultValue: extutil.Ptr("true"),
+				Required:     extutil.Ptr(true),
+				Order:        extutil.Ptr(1),
+			},
+			{
+				Name:         "tracing",
+				Label:        "Disable Tracing",
+				Description:  extutil.Ptr("Set the tracing mode to PassThrough."),
+				Type:         action_kit_api.Boolean,
+				DefaultValue: extutil.Ptr("true"),
+				Required:     extutil.Ptr(true),
+				Order:        extutil.Ptr(2),
+			},
+		},
+		Prepare: action_kit_api.MutatingEndpointReference{
+			Method: "POST",
+			Path:   observabilityBlackoutActionBasePath + "/prepare",
+		},
+		Start: action_kit_api.MutatingEndpointReference{
+			Method: "POST",
+			Path:   observabilityBlackoutActionBasePath + "/start",
+		},
+		Stop: extutil.Ptr(action_kit_api.MutatingEndpointReference{
+			Method: "POST",
+			Path:   observabilityBlackoutActionBasePath + "/stop",
+		}),
+	}
+}
+
+type ObservabilityBlackoutActionState struct {
+	FunctionArn string `json:"functionArn"`
+	// OriginalLogging is nil if the logging config is left untouched
+	OriginalLogging *types.LoggingConfig `json:"originalLogging,omitempty"`
+	// OriginalTracingMode is empty if the tracing config is left untouched
+	OriginalTracingMode types.TracingMode `json:"originalTracingMode,omitempty"`
+}
+
+func prepareObservabilityBlackout(w http.ResponseWriter, r *http.Request, body []byte) {
+	var request action_kit_api.PrepareActionRequestBody
+	err := json.Unmarshal(body, &request)
+	if err != nil {
+		exthttp.WriteError(w, extension_kit.ToError("Failed to parse request body", err))
+		return
+	}
+
+	state, extErr := prepareObservabilityBlackoutState(r.Context(), &request)
+	if extErr != nil {
+		exthttp.WriteError(w, *extErr)
+		return
+	}
+
+	var convertedState action_kit_api.ActionState
+	err = extconversion.Convert(state, &convertedState)
+	if err != nil {
+		exthttp.WriteError(w, extension_kit.ToError("Failed to encode action state", err))
+		return
+	}
+
+	exthttp.WriteBody(w, action_kit_api.PrepareResult{
+		State: convertedState,
+	})
+}
+
+func prepareObservabilityBlackoutState(ctx context.Context, request *action_kit_api.PrepareActionRequestBody) (*ObservabilityBlackoutActionState, *extension_kit.ExtensionError) {
+	functionArn := targetAttribute(request.Target, "aws.arn")
+	if functionArn == "" {
+		return nil, extutil.Ptr(extension_kit.ToError("Target is missing the 'aws.arn' attribute.", nil))
+	}
+
+	client, err := createLambdaClient(ctx)
+	if err != nil {
+		return nil, extutil.Ptr(extension_kit.ToError("Failed to create lambda client", err))
+	}
+
+	function, err := client.GetFunctionConfiguration(ctx, &lambda.GetFunctionConfigurationInput{
+		FunctionName: extutil.Ptr(functionArn),
+	})
+	if err != nil {
+		return nil, extutil.Ptr(extension_kit.ToError("Failed to get function configuration", err))
+	}
+
+	state := &ObservabilityBlackoutActionState{FunctionArn: functionArn}
+	if configBool(request.Config, "logging") {
+		original := types.LoggingConfig{LogFormat: types.LogFormatText}
+		if function.LoggingConfig != nil {
+			original = *function.LoggingConfig
+		}
+		if !isBlackoutLoggingConfig(&original) {
+			state.OriginalLogging = &original
+		}
+	}
+	if configBool(request.Config, "tracing") && function.TracingConfig != nil && function.TracingConfig.Mode == types.TracingModeActive {
+		state.OriginalTracingMode = function.TracingConfig.Mode
+	}
+	if state.OriginalLogging == nil && state.OriginalTracingMode == "" {
+		return nil, extutil.Ptr(extension_kit.ToError(fmt.Sprintf("Function %s has neither logs nor tracing to suppress.", functionArn), nil))
+	}
+	return state, nil
+}
+
+func isBlackoutLoggingConfig(config *types.LoggingConfig) bool {
+	return config != nil &&
+		config.LogFormat == blackoutLoggingConfig.LogFormat &&
+		config.ApplicationLogLevel == blackoutLoggingConfig.ApplicationLogLevel &&
+		config.SystemLogLevel == blackoutLoggingConfig.SystemLogLevel
+}
+
+func startObservabilityBlackout(w http.ResponseWriter, r *http.Request, body []byte) {
+	var request action_kit_api.StartActionRequestBody
+	err := json.Unmarshal(body, &request)
+	if err != nil {
+		exthttp.WriteError(w, extension_kit.ToError("Failed to parse request body", err))
+		return
+	}
+
+	var state ObservabilityBlackoutActionState
+	err = extconversion.Convert(request.State, &state)
+	if err != nil {
+		exthttp.WriteError(w, extension_kit.ToError("Failed to convert action state", err))
+		return
+	}
+
+	client, err := createLambdaClient(r.Context())
+	if err != nil {
+		exthttp.WriteError(w, extension_kit.ToError("Failed to create lambda client", err))
+		return
+	}
+
+	current, err := waitForFunctionUpdated(r.Context(), client, state.FunctionArn)
+	if err != nil {
+		exthttp.WriteError(w, extension_kit.ToError("Failed to wait for function to be updated", err))
+		return
+	}
+
+	input := &lambda.UpdateFunctionConfigurationInput{
+		FunctionName: extutil.Ptr(state.FunctionArn),
+		RevisionId:   current.RevisionId,
+	}
+	if state.OriginalLogging != nil {
+		logging := blackoutLoggingConfig
+		logging.LogGroup = state.OriginalLogging.LogGroup
+		input.LoggingConfig = &logging
+	}
+	if state.OriginalTracingMode != "" {
+		input.TracingConfig = &types.TracingConfig{Mode: types.TracingModePassThrough}
+	}
+	_, err = client.UpdateFunctionConfiguration(r.Context(), input)
+	if err != nil {
+		exthttp.WriteError(w, extension_kit.ToError("Failed to update function configuration", err))
+		return
+	}
+
+	_, err = waitForFunctionUpdated(r.Context(), client, state.FunctionArn)
+	if err != nil {
+		exthttp.WriteError(w, extension_kit.ToError("Failed to wait for function to be updated", err))
+		return
+	}
+
+	exthttp.WriteBody(w, action_kit_api.StartResult{})
+}
+
+func stopObservabilityBlackout(w http.ResponseWriter, r *http.Request, body []byte) {
+	var request action_kit_api.StopActionRequestBody
+	err := json.Unmarshal(body, &request)
+	if err != nil {
+		exthttp.WriteError(w, extension_kit.ToError("Failed to parse request body", err))
+		return
+	}
+
+	var state ObservabilityBlackoutActionState
+	err = extconversion.Convert(request.State, &state)
+	if err != nil {
+		exthttp.WriteError(w, extension_kit.ToError("Failed to convert action state", err))
+		return
+	}
+
+	client, err := createLambdaClient(r.Context())
+	if err != nil {
+		exthttp.WriteError(w, extension_kit.ToError("Failed to create lambda client", err))
+		return
+	}
+
+	current, err := waitForFunctionUpdated(r.Context(), client, state.FunctionArn)
+	if err != nil {
+		exthttp.WriteError(w, extension_kit.ToError("Failed to wait for function to be updated", err))
+		return
+	}
+
+	// Settings changed by someone else during the attack are kept
+	messages := make([]action_kit_api.Message, 0)
+	input := &lambda.UpdateFunctionConfigurationInput{
+		FunctionName: extutil.Ptr(state.FunctionArn),
+		RevisionId:   current.RevisionId,
+	}
+	if state.OriginalLogging != nil {
+		if isBlackoutLoggingConfig(current.LoggingConfig) {
+			input.LoggingConfig = originalLoggingConfig(*state.OriginalLogging)
+		} else {
+			messages = append(messages, action_kit_api.Message{
+				Level:   extutil.Ptr(action_kit_api.Warn),
+				Message: "The logging config was changed during the attack and is not restored.",
+			})
+		}
+	}
+	if state.OriginalTracingMode != "" {
+		if current.TracingConfig != nil && current.TracingConfig.Mode == types.TracingModePassThrough {
+			input.TracingConfig = &types.TracingConfig{Mode: state.OriginalTracingMode}
+		} else {
+			messages = append(messages, action_kit_api.Message{
+				Level:   extutil.Ptr(action_kit_api.Warn),
+				Message: "The tracing config was changed during the attack and is not restored.",
+			})
+		}
+	}
+
+	if input.LoggingConfig != nil || input.TracingConfig != nil {
+		_, err = client.UpdateFunctionConfiguration(r.Context(), input)
+		if err != nil {
+			exthttp.WriteError(w, extension_kit.ToError("Failed to restore function configuration", err))
+			return
+		}
+	}
+
+	exthttp.WriteBody(w, action_kit_api.StopResult{
+		Messages: extutil.Ptr(messages),
+	})
+}
+
+// originalLoggingConfig returns the config to restore. Log levels are only accepted together with the JSON format.
+func originalLoggingConfig(original types.LoggingConfig) *types.LoggingConfig {
+	if original.LogFormat == types.LogFormatJson {
+		return &original
+	}
+	return &types.LoggingConfig{
+		LogFormat: original.LogFormat,
+		LogGroup:  original.LogGroup,
+	}
+}
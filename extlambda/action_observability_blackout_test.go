@@ -0,0 +1,109 @@
+/*
+ * Copyright 2023 steadybit GmbH. All rights reserved.
+ */
+
+package extlambda
+
+import (
+	"github.com/aws/aws-sdk-go-v2/service/lambda/types"
+	"github.com/steadybit/extension-kit/extutil"
+	"reflect"
+	"testing"
+)
+
+func TestIsBlackoutLoggingConfig(t *testing.T) {
+	tests := []struct {
+		name   string
+		config *types.LoggingConfig
+		want   bool
+	}{
+		{name: "nil", config: nil, want: false},
+		{name: "blackout", config: &blackoutLoggingConfig, want: true},
+		{
+			name: "blackout with log group",
+			config: &types.LoggingConfig{
+				LogFormat:           types.LogFormatJson,
+				ApplicationLogLevel: types.ApplicationLogLevelFatal,
+				SystemLogLevel:      types.SystemLogLevelWarn,
+				LogGroup:            extutil.Ptr("/aws/lambda/my-function"),
+			},
+			want: true,
+		},
+		{
+			name: "other application log level",
+			config: &types.LoggingConfig{
+				LogFormat:           types.LogFormatJson,
+				ApplicationLogLevel: types.ApplicationLogLevelInfo,
+				SystemLogLevel:      types.SystemLogLevelWarn,
+			},
+			want: false,
+		},
+		{
+			name: "other system log level",
+			config: &types.LoggingConfig{
+				LogFormat:           types.LogFormatJson,
+				ApplicationLogLevel: types.ApplicationLogLevelFatal,
+				SystemLogLevel:      types.SystemLogLevelInfo,
+			},
+			want: false,
+		},
+		{name: "text", config: &types.LoggingConfig{LogFormat: types.LogFormatText}, want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := isBlackoutLoggingConfig(tt.config); got != tt.want {
+				t.Errorf("isBlackoutLoggingConfig() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestOriginalLoggingConfig(t *testing.T) {
+	logGroup := extutil.Ptr("/aws/lambda/my-function")
+	tests := []struct {
+		name     string
+		original types.LoggingConfig
+		want     *types.LoggingConfig
+	}{
+		{
+			name: "json keeps log levels",
+			original: types.LoggingConfig{
+				LogFormat:           types.LogFormatJson,
+				ApplicationLogLevel: types.ApplicationLogLevelDebug,
+				SystemLogLevel:      types.SystemLogLevelInfo,
+				LogGroup:            logGroup,
+			},
+			want: &types.LoggingConfig{
+				LogFormat:           types.LogFormatJson,
+				ApplicationLogLevel: types.ApplicationLogLevelDebug,
+				SystemLogLevel:      types.SystemLogLevelInfo,
+				LogGroup:            logGroup,
+			},
+		},
+		{
+			name: "text drops log levels",
+			original: types.LoggingConfig{
+				LogFormat:           types.LogFormatText,
+				ApplicationLogLevel: types.ApplicationLogLevelInfo,
+				SystemLogLevel:      types.SystemLogLevelInfo,
+				LogGroup:            logGroup,
+			},
+			want: &types.LoggingConfig{
+				LogFormat: types.LogFormatText,
+				LogGroup:  logGroup,
+			},
+		},
+		{
+			name:     "unset",
+			original: types.LoggingConfig{},
+			want:     &types.LoggingConfig{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := originalLoggingConfig(tt.original); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("originalLoggingConfig() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,462 @@
+/*
+ * Copyright 2023 steadybit GmbH. All rights reserved.
+ */
+
+package extlambda
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/lambda"
+	"github.com/aws/aws-sdk-go-v2/service/lambda/types"
+	"github.com/steadybit/action-kit/go/action_kit_api/v2"
+	extension_kit "github.com/steadybit/extension-kit"
+	"github.com/steadybit/extension-kit/extbuild"
+	"github.com/steadybit/extension-kit/extconversion"
+	"github.com/steadybit/extension-kit/exthttp"
+	"github.com/steadybit/extension-kit/extutil"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	payloadFuzzingActionBasePath = basePath + "/actions/payload-fuzzing"
+	// fuzzingBatchTime bounds the invocations started by a single status call. A running invocation is awaited up to fuzzingInvocationTimeout.
+	fuzzingBatchTime = 10 * time.Second
+	// fuzzingInvocationTimeout bounds a single invocation, so that a hanging function cannot stall the status endpoint.
+	fuzzingInvocationTimeout = 30 * time.Second
+	oversizedFieldLen        = 100000
+)
+
+func registerPayloadFuzzingActionHandlers() {
+	exthttp.RegisterHttpHandler(payloadFuzzingActionBasePath, exthttp.GetterAsHandler(getPayloadFuzzingActionDescription))
+	exthttp.RegisterHttpHandler(payloadFuzzingActionBasePath+"/prepare", preparePayloadFuzzing)
+	exthttp.RegisterHttpHandler(payloadFuzzingActionBasePath+"/start", startPayloadFuzzing)
+	exthttp.RegisterHttpHandler(payloadFuzzingActionBasePath+"/status", statusPayloadFuzzing)
+	exthttp.RegisterHttpHandler(payloadFuzzingActionBasePath+"/stop", stopPayloadFuzzing)
+}
+
+func getPayloadFuzzingActionDescription() action_kit_api.ActionDescription {
+	return action_kit_api.ActionDescription{
+		Id:          fmt.Sprintf("%s.payloadFuzzing", targetID),
+		Version:     extbuild.GetSemverVersionStringOrUnknown(),
+		Label:       "Fuzz Invocation Payload",
+		Description: "Invokes the function with malformed variants of a sample event, like truncated JSON, wrong types, missing and oversized fields. Invocations not answered within 30s count as timeouts. Crashes, timeouts and unhandled errors are attached as fuzzing_results.json.",
+		Icon:        extutil.Ptr(targetIcon),
+		TargetType:  extutil.Ptr(targetID),
+		TargetSelectionTemplates: extutil.Ptr([]action_kit_api.TargetSelectionTemplate{
+			{
+				Label: "by function name",
+				Query: "aws.lambda.function-name=\"\"",
+			},
+		}),
+		Category:    extutil.Ptr("cloud"),
+		Kind:        action_kit_api.Attack,
+		TimeControl: action_kit_api.Internal,
+		Parameters: []action_kit_api.ActionParameter{
+			{
+				Name:         "sampleEvent",
+				Label:        "Sample Event",
+				Description:  extutil.Ptr("A valid JSON event of the function the malformed payloads are derived from."),
+				Type:         action_kit_api.String,
+				DefaultValue: extutil.Ptr("{}"),
+				Required:     extutil.Ptr(true),
+				Order:        extutil.Ptr(0),
+			},
+			{
+				Name:         "maxCases",
+				Label:        "Maximum Invocations",
+				Description:  extutil.Ptr("The maximum number of malformed payloads the function is invoked with."),
+				Type:         action_kit_api.Integer,
+				DefaultValue: extutil.Ptr("50"),
+				Required:     extutil.Ptr(true),
+				Order:        extutil.Ptr(1),
+			},
+			{
+				Name:        "qualifier",
+				Label:       "Qualifier",
+				Description: extutil.Ptr("The version or alias to invoke. Leave empty to invoke $LATEST."),
+				Type:        action_kit_api.String,
+				Required:    extutil.Ptr(false),
+				Advanced:    extutil.Ptr(true),
+				Order:       extutil.Ptr(2),
+			},
+		},
+		Prepare: action_kit_api.MutatingEndpointReference{
+			Method: "POST",
+			Path:   payloadFuzzingActionBasePath + "/prepare",
+		},
+		Start: action_kit_api.MutatingEndpointReference{
+			Method: "POST",
+			Path:   payloadFuzzingActionBasePath + "/start",
+		},
+		Status: extutil.Ptr(action_kit_api.MutatingEndpointReferenceWithCallInterval{
+			Method:       "POST",
+			Path:         payloadFuzzingActionBasePath + "/status",
+			CallInterval: extutil.Ptr("1s"),
+		}),
+		Stop: extutil.Ptr(action_kit_api.MutatingEndpointReference{
+			Method: "POST",
+			Path:   payloadFuzzingActionBasePath + "/stop",
+		}),
+	}
+}
+
+type fuzzingCase struct {
+	Name    string
+	Payload string
+}
+
+type fuzzingResult struct {
+	Case string `json:"case"`
+	// Outcome is one of ok, handled-error, unhandled-error, timeout, crash or invoke-error
+	Outcome      string `json:"outcome"`
+	ErrorType    string `json:"errorType,omitempty"`
+	ErrorMessage string `json:"errorMessage,omitempty"`
+	DurationMs   int64  `json:"durationMs"`
+}
+
+// PayloadFuzzingActionState only holds the sample event, the cases are derived from it again with every call to keep the state small.
+type PayloadFuzzingActionState struct {
+	FunctionArn string          `json:"functionArn"`
+	Qualifier   string          `json:"qualifier,omitempty"`
+	SampleEvent string          `json:"sampleEvent"`
+	MaxCases    int             `json:"maxCases"`
+	Results     []fuzzingResult `json:"results"`
+}
+
+func preparePayloadFuzzing(w http.ResponseWriter, _ *http.Request, body []byte) {
+	var request action_kit_api.PrepareActionRequestBody
+	err := json.Unmarshal(body, &request)
+	if err != nil {
+		exthttp.WriteError(w, extension_kit.ToError("Failed to parse request body", err))
+		return
+	}
+
+	functionArn := targetAttribute(request.Target, "aws.arn")
+	if functionArn == "" {
+		exthttp.WriteError(w, extension_kit.ToError("Target is missing the 'aws.arn' attribute.", nil))
+		return
+	}
+	sampleEvent := configString(request.Config, "sampleEvent")
+	if !json.Valid([]byte(sampleEvent)) {
+		exthttp.WriteError(w, extension_kit.ToError("The sample event is no valid JSON.", nil))
+		return
+	}
+	maxCases := int(aws.ToInt32(configInt32(request.Config, "maxCases")))
+	if maxCases < 1 {
+		maxCases = 1
+	}
+
+	state := PayloadFuzzingActionState{
+		FunctionArn: functionArn,
+		Qualifier:   configString(request.Config, "qualifier"),
+		SampleEvent: sampleEvent,
+		MaxCases:    maxCases,
+		Results:     make([]fuzzingResult, 0),
+	}
+
+	var convertedState action_kit_api.ActionState
+	err = extconversion.Convert(state, &convertedState)
+	if err != nil {
+		exthttp.WriteError(w, extension_kit.ToError("Failed to encode action state", err))
+		return
+	}
+
+	exthttp.WriteBody(w, action_kit_api.PrepareResult{
+		State: convertedState,
+	})
+}
+
+func startPayloadFuzzing(w http.ResponseWriter, _ *http.Request, _ []byte) {
+	// The invocations are done by the status endpoint, so that a slow function does not block the start.
+	exthttp.WriteBody(w, action_kit_api.StartResult{})
+}
+
+func statusPayloadFuzzing(w http.ResponseWriter, r *http.Request, body []byte) {
+	var request action_kit_api.ActionStatusRequestBody
+	err := json.Unmarshal(body, &request)
+	if err != nil {
+		exthttp.WriteError(w, extension_kit.ToError("Failed to parse request body", err))
+		return
+	}
+
+	var state PayloadFuzzingActionState
+	err = extconversion.Convert(request.State, &state)
+	if err != nil {
+		exthttp.WriteError(w, extension_kit.ToError("Failed to convert action state", err))
+		return
+	}
+
+	cases, err := generateFuzzingCases(state.SampleEvent, state.MaxCases)
+	if err != nil {
+		exthttp.WriteError(w, extension_kit.ToError("Failed to generate payloads", err))
+		return
+	}
+
+	client, err := createLambdaClient(r.Context())
+	if err != nil {
+		exthttp.WriteError(w, extension_kit.ToError("Failed to create lambda client", err))
+		return
+	}
+
+	batchStart := time.Now()
+	for len(state.Results) < len(cases) && time.Since(batchStart) < fuzzingBatchTime {
+		fuzzingCase := cases[len(state.Results)]
+		invocationStart := time.Now()
+		ctx, cancel := context.WithTimeout(r.Context(), fuzzingInvocationTimeout)
+		output, err := client.Invoke(ctx, &lambda.InvokeInput{
+			FunctionName:   extutil.Ptr(state.FunctionArn),
+			Qualifier:      qualifierPtr(state.Qualifier),
+			InvocationType: types.InvocationTypeRequestResponse,
+			Payload:        []byte(fuzzingCase.Payload),
+		})
+		cancel()
+		result := classifyInvocation(output, err)
+		result.Case = fuzzingCase.Name
+		result.DurationMs = time.Since(invocationStart).Milliseconds()
+		state.Results = append(state.Results, result)
+	}
+
+	var convertedState action_kit_api.ActionState
+	err = extconversion.Convert(state, &convertedState)
+	if err != nil {
+		exthttp.WriteError(w, extension_kit.ToError("Failed to encode action state", err))
+		return
+	}
+
+	exthttp.WriteBody(w, action_kit_api.StatusResult{
+		Completed: len(state.Results) >= len(cases),
+		State:     &convertedState,
+	})
+}
+
+// classifyInvocation maps the invocation response to an outcome. Errors of the runtime are reported with FunctionError 'Unhandled'
+// and a payload holding errorType and errorMessage. Invocations exceeding fuzzingInvocationTimeout count as timeouts.
+func classifyInvocation(output *lambda.InvokeOutput, err error) fuzzingResult {
+	if errors.Is(err, context.DeadlineExceeded) {
+		return fuzzingResult{Outcome: "timeout", ErrorMessage: fmt.Sprintf("No response within %s.", fuzzingInvocationTimeout)}
+	}
+	if err != nil {
+		return fuzzingResult{Outcome: "invoke-error", ErrorMessage: err.Error()}
+	}
+	if output.FunctionError == nil {
+		return fuzzingResult{Outcome: "ok"}
+	}
+
+	var payload struct {
+		ErrorType    string `json:"errorType"`
+		ErrorMessage string `json:"errorMessage"`
+	}
+	_ = json.Unmarshal(output.Payload, &payload)
+	result := fuzzingResult{
+		Outcome:      "unhandled-error",
+		ErrorType:    payload.ErrorType,
+		ErrorMessage: payload.ErrorMessage,
+	}
+	switch {
+	case aws.ToString(output.FunctionError) == "Handled":
+		result.Outcome = "handled-error"
+	case strings.Contains(payload.ErrorMessage, "Task timed out"):
+		result.Outcome = "timeout"
+	case strings.HasPrefix(payload.ErrorType, "Runtime."):
+		// e.g. Runtime.ExitError or Runtime.OutOfMemory
+		result.Outcome = "crash"
+	}
+	return result
+}
+
+func stopPayloadFuzzing(w http.ResponseWriter, _ *http.Request, body []byte) {
+	var request action_kit_api.StopActionRequestBody
+	err := json.Unmarshal(body, &request)
+	if err != nil {
+		exthttp.WriteError(w, extension_kit.ToError("Failed to parse request body", err))
+		return
+	}
+
+	var state PayloadFuzzingActionState
+	err = extconversion.Convert(request.State, &state)
+	if err != nil {
+		exthttp.WriteError(w, extension_kit.ToError("Failed to convert action state", err))
+		return
+	}
+
+	outcomes := make(map[string]int)
+	for _, result := range state.Results {
+		outcomes[result.Outcome]++
+	}
+	report, err := json.MarshalIndent(struct {
+		FunctionArn string          `json:"functionArn"`
+		Qualifier   string          `json:"qualifier,omitempty"`
+		Outcomes    map[string]int  `json:"outcomes"`
+		Results     []fuzzingResult `json:"results"`
+	}{state.FunctionArn, state.Qualifier, outcomes, state.Results}, "", "  ")
+	if err != nil {
+		exthttp.WriteError(w, extension_kit.ToError("Failed to encode fuzzing results", err))
+		return
+	}
+
+	failures := outcomes["unhandled-error"] + outcomes["timeout"] + outcomes["crash"]
+	level := action_kit_api.Info
+	if failures > 0 {
+		level = action_kit_api.Warn
+	}
+
+	exthttp.WriteBody(w, action_kit_api.StopResult{
+		Artifacts: extutil.Ptr([]action_kit_api.Artifact{
+			{
+				Label: "fuzzing_results.json",
+				Data:  base64.StdEncoding.EncodeToString(report),
+			},
+		}),
+		Messages: extutil.Ptr([]action_kit_api.Message{
+			{
+				Level:   extutil.Ptr(level),
+				Message: fmt.Sprintf("%d of %d malformed payloads caused crashes, timeouts or unhandled errors.", failures, len(state.Results)),
+			},
+		}),
+	})
+}
+
+// generateFuzzingCases derives malformed payloads from the sample event. The cases are deterministic, as the status
+// endpoint regenerates them with every call.
+func generateFuzzingCases(sampleEvent string, maxCases int) ([]fuzzingCase, error) {
+	var sample interface{}
+	err := json.Unmarshal([]byte(sampleEvent), &sample)
+	if err != nil {
+		return nil, err
+	}
+
+	cases := []fuzzingCase{
+		{Name: "truncated", Payload: sampleEvent[:len(sampleEvent)/2]},
+		{Name: "truncated-last-char", Payload: sampleEvent[:len(sampleEvent)-1]},
+		{Name: "empty-object", Payload: "{}"},
+		{Name: "null", Payload: "null"},
+		{Name: "array", Payload: "[]"},
+		{Name: "string", Payload: "\"steadybit\""},
+		{Name: "number", Payload: "0"},
+	}
+
+	for _, path := range jsonFieldPaths(sample, "$") {
+		for _, mutation := range fuzzingMutations(path.value) {
+			mutated, err := json.Marshal(replaceJsonPath(sample, path.keys, mutation.value, mutation.remove))
+			if err != nil {
+				return nil, err
+			}
+			cases = append(cases, fuzzingCase{Name: mutation.name + ":" + path.name, Payload: string(mutated)})
+		}
+	}
+
+	if len(cases) > maxCases {
+		cases = cases[:maxCases]
+	}
+	return cases, nil
+}
+
+type jsonPath struct {
+	name  string
+	keys  []interface{}
+	value interface{}
+}
+
+// jsonFieldPaths lists all fields of the event including objects and arrays, sorted by key to stay deterministic.
+func jsonFieldPaths(value interface{}, name string) []jsonPath {
+	result := make([]jsonPath, 0)
+	switch typed := value.(type) {
+	case map[string]interface{}:
+		keys := make([]string, 0, len(typed))
+		for key := range typed {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			childName := name + "." + key
+			result = append(result, jsonPath{name: childName, keys: []interface{}{key}, value: typed[key]})
+			for _, child := range jsonFieldPaths(typed[key], childName) {
+				child.keys = append([]interface{}{key}, child.keys...)
+				result = append(result, child)
+			}
+		}
+	case []interface{}:
+		if len(typed) > 0 {
+			// The first element stands for all elements, as arrays usually hold records of the same shape
+			childName := name + "[0]"
+			for _, child := range jsonFieldPaths(typed[0], childName) {
+				child.keys = append([]interface{}{0}, child.keys...)
+				result = append(result, child)
+			}
+		}
+	}
+	return result
+}
+
+type fuzzingMutation struct {
+	name   string
+	value  interface{}
+	remove bool
+}
+
+func fuzzingMutations(value interface{}) []fuzzingMutation {
+	mutations := []fuzzingMutation{
+		{name: "missing", remove: true},
+		{name: "null", value: nil},
+	}
+	switch value.(type) {
+	case string:
+		mutations = append(mutations,
+			fuzzingMutation{name: "wrong-type", value: 12345},
+			fuzzingMutation{name: "oversized", value: strings.Repeat("A", oversizedFieldLen)})
+	case float64:
+		mutations = append(mutations,
+			fuzzingMutation{name: "wrong-type", value: "steadybit"},
+			fuzzingMutation{name: "oversized", value: 1e308})
+	case bool:
+		mutations = append(mutations, fuzzingMutation{name: "wrong-type", value: "true"})
+	case map[string]interface{}:
+		mutations = append(mutations, fuzzingMutation{name: "wrong-type", value: []interface{}{}})
+	case []interface{}:
+		mutations = append(mutations,
+			fuzzingMutation{name: "wrong-type", value: map[string]interface{}{}},
+			fuzzingMutation{name: "empty", value: []interface{}{}})
+	}
+	return mutations
+}
+
+// replaceJsonPath returns a copy of the value with the element at the path replaced or removed. The value itself is not modified.
+func replaceJsonPath(value interface{}, keys []interface{}, replacement interface{}, remove bool) interface{} {
+	if len(keys) == 0 {
+		return replacement
+	}
+	switch typed := value.(type) {
+	case map[string]interface{}:
+		key := keys[0].(string)
+		result := make(map[string]interface{}, len(typed))
+		for k, v := range typed {
+			result[k] = v
+		}
+		if len(keys) == 1 && remove {
+			delete(result, key)
+		} else {
+			result[key] = replaceJsonPath(typed[key], keys[1:], replacement, remove)
+		}
+		return result
+	case []interface{}:
+		index := keys[0].(int)
+		result := make([]interface{}, 0, len(typed))
+		for i, v := range typed {
+			if i != index {
+				result = append(result, v)
+			} else if len(keys) > 1 || !remove {
+				result = append(result, replaceJsonPath(v, keys[1:], replacement, remove))
+			}
+		}
+		return result
+	}
+	return value
+}
@@ -0,0 +1,149 @@
+/*
+ * Copyright 2023 steadybit GmbH. All rights reserved.
+ */
+
+package extlambda
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/lambda"
+	"github.com/steadybit/extension-kit/extutil"
+	"reflect"
+	"testing"
+)
+
+func TestGenerateFuzzingCases(t *testing.T) {
+	sampleEvent := "{\"id\":\"42\",\"items\":[{\"count\":1}],\"valid\":true}"
+
+	cases, err := generateFuzzingCases(sampleEvent, 100)
+	if err != nil {
+		t.Fatalf("generateFuzzingCases() error = %v", err)
+	}
+	payloads := make(map[string]string)
+	for _, fuzzingCase := range cases {
+		if _, ok := payloads[fuzzingCase.Name]; ok {
+			t.Errorf("generateFuzzingCases() returned case %s twice", fuzzingCase.Name)
+		}
+		payloads[fuzzingCase.Name] = fuzzingCase.Payload
+	}
+
+	want := map[string]string{
+		"truncated-last-char":         sampleEvent[:len(sampleEvent)-1],
+		"empty-object":                "{}",
+		"missing:$.id":                "{\"items\":[{\"count\":1}],\"valid\":true}",
+		"null:$.id":                   "{\"id\":null,\"items\":[{\"count\":1}],\"valid\":true}",
+		"wrong-type:$.id":             "{\"id\":12345,\"items\":[{\"count\":1}],\"valid\":true}",
+		"empty:$.items":               "{\"id\":\"42\",\"items\":[],\"valid\":true}",
+		"wrong-type:$.items[0].count": "{\"id\":\"42\",\"items\":[{\"count\":\"steadybit\"}],\"valid\":true}",
+		"wrong-type:$.valid":          "{\"id\":\"42\",\"items\":[{\"count\":1}],\"valid\":\"true\"}",
+	}
+	for name, payload := range want {
+		if got, ok := payloads[name]; !ok {
+			t.Errorf("generateFuzzingCases() is missing case %s", name)
+		} else if got != payload {
+			t.Errorf("generateFuzzingCases() case %s = %s, want %s", name, got, payload)
+		}
+	}
+
+	again, _ := generateFuzzingCases(sampleEvent, 100)
+	if !reflect.DeepEqual(cases, again) {
+		t.Errorf("generateFuzzingCases() is not deterministic")
+	}
+
+	limited, _ := generateFuzzingCases(sampleEvent, 3)
+	if !reflect.DeepEqual(limited, cases[:3]) {
+		t.Errorf("generateFuzzingCases() with maxCases 3 = %v, want %v", limited, cases[:3])
+	}
+
+	if _, err := generateFuzzingCases("{", 10); err == nil {
+		t.Errorf("generateFuzzingCases() accepted an invalid sample event")
+	}
+}
+
+func TestReplaceJsonPath(t *testing.T) {
+	tests := []struct {
+		name        string
+		keys        []interface{}
+		replacement interface{}
+		remove      bool
+		want        string
+	}{
+		{name: "replace field", keys: []interface{}{"id"}, replacement: "x", want: "{\"id\":\"x\",\"items\":[{\"count\":1},{\"count\":2}]}"},
+		{name: "remove field", keys: []interface{}{"id"}, remove: true, want: "{\"items\":[{\"count\":1},{\"count\":2}]}"},
+		{name: "replace nested field", keys: []interface{}{"items", 0, "count"}, replacement: nil, want: "{\"id\":\"42\",\"items\":[{\"count\":null},{\"count\":2}]}"},
+		{name: "remove nested field", keys: []interface{}{"items", 1, "count"}, remove: true, want: "{\"id\":\"42\",\"items\":[{\"count\":1},{}]}"},
+		{name: "remove array element", keys: []interface{}{"items", 0}, remove: true, want: "{\"id\":\"42\",\"items\":[{\"count\":2}]}"},
+		{name: "replace root", keys: []interface{}{}, replacement: "x", want: "\"x\""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var value interface{}
+			_ = json.Unmarshal([]byte("{\"id\":\"42\",\"items\":[{\"count\":1},{\"count\":2}]}"), &value)
+
+			got, _ := json.Marshal(replaceJsonPath(value, tt.keys, tt.replacement, tt.remove))
+			if string(got) != tt.want {
+				t.Errorf("replaceJsonPath() = %s, want %s", got, tt.want)
+			}
+			original, _ := json.Marshal(value)
+			if string(original) != "{\"id\":\"42\",\"items\":[{\"count\":1},{\"count\":2}]}" {
+				t.Errorf("replaceJsonPath() modified the value to %s", original)
+			}
+		})
+	}
+}
+
+func TestClassifyInvocation(t *testing.T) {
+	tests := []struct {
+		name        string
+		output      *lambda.InvokeOutput
+		err         error
+		wantOutcome string
+		wantType    string
+	}{
+		{name: "ok", output: &lambda.InvokeOutput{Payload: []byte("{}")}, wantOutcome: "ok"},
+		{
+			name:        "handled error",
+			output:      &lambda.InvokeOutput{FunctionError: extutil.Ptr("Handled"), Payload: []byte("{\"errorType\":\"ValidationError\",\"errorMessage\":\"bad input\"}")},
+			wantOutcome: "handled-error",
+			wantType:    "ValidationError",
+		},
+		{
+			name:        "unhandled error",
+			output:      &lambda.InvokeOutput{FunctionError: extutil.Ptr("Unhandled"), Payload: []byte("{\"errorType\":\"TypeError\",\"errorMessage\":\"Cannot read properties of undefined\"}")},
+			wantOutcome: "unhandled-error",
+			wantType:    "TypeError",
+		},
+		{
+			name:        "function timeout",
+			output:      &lambda.InvokeOutput{FunctionError: extutil.Ptr("Unhandled"), Payload: []byte("{\"errorMessage\":\"2023-06-01T12:00:00.000Z 1234 Task timed out after 3.00 seconds\"}")},
+			wantOutcome: "timeout",
+		},
+		{
+			name:        "crash",
+			output:      &lambda.InvokeOutput{FunctionError: extutil.Ptr("Unhandled"), Payload: []byte("{\"errorType\":\"Runtime.ExitError\",\"errorMessage\":\"exit status 1\"}")},
+			wantOutcome: "crash",
+			wantType:    "Runtime.ExitError",
+		},
+		{
+			name:        "invocation deadline",
+			err:         fmt.Errorf("operation error Lambda: Invoke, %w", &aws.RequestCanceledError{Err: context.DeadlineExceeded}),
+			wantOutcome: "timeout",
+		},
+		{name: "invoke error", err: errors.New("AccessDeniedException"), wantOutcome: "invoke-error"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := classifyInvocation(tt.output, tt.err)
+			if got.Outcome != tt.wantOutcome {
+				t.Errorf("classifyInvocation() outcome = %s, want %s", got.Outcome, tt.wantOutcome)
+			}
+			if got.ErrorType != tt.wantType {
+				t.Errorf("classifyInvocation() errorType = %s, want %s", got.ErrorType, tt.wantType)
+			}
+		})
+	}
+}
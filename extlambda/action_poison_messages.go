@@ -0,0 +1,501 @@
+/*
+ * Copyright 2023 steadybit GmbH. All rights reserved.
+ */
+
+package extlambda
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/kinesis"
+	"github.com/aws/aws-sdk-go-v2/service/sns"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	"github.com/rs/zerolog/log"
+	"github.com/steadybit/action-kit/go/action_kit_api/v2"
+	extension_kit "github.com/steadybit/extension-kit"
+	"github.com/steadybit/extension-kit/extbuild"
+	"github.com/steadybit/extension-kit/extconversion"
+	"github.com/steadybit/extension-kit/exthttp"
+	"github.com/steadybit/extension-kit/extutil"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const (
+	poisonMessagesActionBasePath = basePath + "/actions/poison-messages"
+	// maxPoisonMessagesPerStatus caps the messages sent by a single status call, so slow sources cannot stall the status endpoint.
+	// With a status call per second it is also the highest supported rate.
+	maxPoisonMessagesPerStatus = 50
+)
+
+// malformedPayloads are sent if no payloads are configured. They cover broken JSON, unexpected types, wrong schemas
+// and a payload just below the 256 KB limit of SQS and SNS.
+var malformedPayloads = []string{
+	"{",
+	"null",
+	"not json",
+	"[]",
+	"{\"Records\": \"steadybit\"}",
+	strings.Repeat("steadybit ", 25000),
+}
+
+func registerPoisonMessagesActionHandlers() {
+	exthttp.RegisterHttpHandler(poisonMessagesActionBasePath, exthttp.GetterAsHandler(getPoisonMessagesActionDescription))
+	exthttp.RegisterHttpHandler(poisonMessagesActionBasePath+"/prepare", preparePoisonMessages)
+	exthttp.RegisterHttpHandler(poisonMessagesActionBasePath+"/start", startPoisonMessages)
+	exthttp.RegisterHttpHandler(poisonMessagesActionBasePath+"/status", statusPoisonMessages)
+	exthttp.RegisterHttpHandler(poisonMessagesActionBasePath+"/stop", stopPoisonMessages)
+}
+
+func getPoisonMessagesActionDescription() action_kit_api.ActionDescription {
+	return action_kit_api.ActionDescription{
+		Id:          fmt.Sprintf("%s.poisonMessages", targetID),
+		Version:     extbuild.GetSemverVersionStringOrUnknown(),
+		Label:       "Inject Poison Messages",
+		Description: "Sends malformed or given payloads to the SQS queues and Kinesis streams of the function's event source mappings and to the SNS topics it is subscribed to.",
+		Icon:        extutil.Ptr(targetIcon),
+		TargetType:  extutil.Ptr(targetID),
+		TargetSelectionTemplates: extutil.Ptr([]action_kit_api.TargetSelectionTemplate{
+			{
+				Label: "by function name",
+				Query: "aws.lambda.function-name=\"\"",
+			},
+		}),
+		Category:    extutil.Ptr("cloud"),
+		Kind:        action_kit_api.Attack,
+		TimeControl: action_kit_api.External,
+		Parameters: []action_kit_api.ActionParameter{
+			{
+				Label:        "Duration",
+				Name:         "duration",
+				Type:         action_kit_api.Duration,
+				Description:  extutil.Ptr("The duration of the attack."),
+				Advanced:     extutil.Ptr(false),
+				Required:     extutil.Ptr(true),
+				DefaultValue: extutil.Ptr("60s"),
+				Order:        extutil.Ptr(0),
+			},
+			{
+				Name:         "rate",
+				Label:        "Messages per Second",
+				Description:  extutil.Ptr(fmt.Sprintf("The number of messages sent per second, spread across all sources, at most %d.", maxPoisonMessagesPerStatus)),
+				Type:         action_kit_api.Integer,
+				DefaultValue: extutil.Ptr("1"),
+				Required:     extutil.Ptr(true),
+				Order:        extutil.Ptr(1),
+			},
+			{
+				Name:        "payloads",
+				Label:       "Payloads",
+				Description: extutil.Ptr("The payloads sent in turn. Leave empty to send built-in malformed payloads."),
+				Type:        action_kit_api.StringArray,
+				Required:    extutil.Ptr(false),
+				Order:       extutil.Ptr(2),
+			},
+			{
+				Name:         "sqs",
+				Label:        "SQS Queues",
+				Type:         action_kit_api.Boolean,
+				DefaultValue: extutil.Ptr("true"),
+				Required:     extutil.Ptr(true),
+				Advanced:     extutil.Ptr(true),
+				Order:        extutil.Ptr(3),
+			},
+			{
+				Name:         "kinesis",
+				Label:        "Kinesis Streams",
+				Description:  extutil.Ptr("Records put to a stream are read by all of its consumers, not only the function."),
+				Type:         action_kit_api.Boolean,
+				DefaultValue: extutil.Ptr("true"),
+				Required:     extutil.Ptr(true),
+				Advanced:     extutil.Ptr(true),
+				Order:        extutil.Ptr(4),
+			},
+			{
+				Name:         "sns",
+				Label:        "SNS Topics",
+				Description:  extutil.Ptr("Messages published to a topic reach all of its subscribers, not only the function."),
+				Type:         action_kit_api.Boolean,
+				DefaultValue: extutil.Ptr("false"),
+				Required:     extutil.Ptr(true),
+				Advanced:     extutil.Ptr(true),
+				Order:        extutil.Ptr(5),
+			},
+		},
+		Prepare: action_kit_api.MutatingEndpointReference{
+			Method: "POST",
+			Path:   poisonMessagesActionBasePath + "/prepare",
+		},
+		Start: action_kit_api.MutatingEndpointReference{
+			Method: "POST",
+			Path:   poisonMessagesActionBasePath + "/start",
+		},
+		Status: extutil.Ptr(action_kit_api.MutatingEndpointReferenceWithCallInterval{
+			Method:       "POST",
+			Path:         poisonMessagesActionBasePath + "/status",
+			CallInterval: extutil.Ptr("1s"),
+		}),
+		Stop: extutil.Ptr(action_kit_api.MutatingEndpointReference{
+			Method: "POST",
+			Path:   poisonMessagesActionBasePath + "/stop",
+		}),
+	}
+}
+
+type poisonSource struct {
+	// Type is one of sqs, kinesis or sns
+	Type string `json:"type"`
+	Arn  string `json:"arn"`
+	// QueueUrl is only set for SQS queues
+	QueueUrl string `json:"queueUrl,omitempty"`
+}
+
+type PoisonMessagesActionState struct {
+	FunctionArn string `json:"functionArn"`
+	Rate        int32  `json:"rate"`
+	// Payloads is empty if the built-in malformed payloads are sent
+	Payloads  []string       `json:"payloads"`
+	Sources   []poisonSource `json:"sources"`
+	StartedAt int64          `json:"startedAt"`
+	Sent      int            `json:"sent"`
+	Failed    int            `json:"failed"`
+}
+
+func preparePoisonMessages(w http.ResponseWriter, r *http.Request, body []byte) {
+	var request action_kit_api.PrepareActionRequestBody
+	err := json.Unmarshal(body, &request)
+	if err != nil {
+		exthttp.WriteError(w, extension_kit.ToError("Failed to parse request body", err))
+		return
+	}
+
+	state, messages, extErr := preparePoisonMessagesState(r.Context(), &request)
+	if extErr != nil {
+		exthttp.WriteError(w, *extErr)
+		return
+	}
+
+	var convertedState action_kit_api.ActionState
+	err = extconversion.Convert(state, &convertedState)
+	if err != nil {
+		exthttp.WriteError(w, extension_kit.ToError("Failed to encode action state", err))
+		return
+	}
+
+	exthttp.WriteBody(w, action_kit_api.PrepareResult{
+		State:    convertedState,
+		Messages: extutil.Ptr(messages),
+	})
+}
+
+func preparePoisonMessagesState(ctx context.Context, request *action_kit_api.PrepareActionRequestBody) (*PoisonMessagesActionState, []action_kit_api.Message, *extension_kit.ExtensionError) {
+	functionArn := targetAttribute(request.Target, "aws.arn")
+	if functionArn == "" {
+		return nil, nil, extutil.Ptr(extension_kit.ToError("Target is missing the 'aws.arn' attribute.", nil))
+	}
+
+	state := &PoisonMessagesActionState{
+		FunctionArn: functionArn,
+		Rate:        aws.ToInt32(configInt32(request.Config, "rate")),
+		Payloads:    configStrings(request.Config, "payloads"),
+		Sources:     make([]poisonSource, 0),
+	}
+	if state.Rate < 1 {
+		state.Rate = 1
+	}
+	if state.Rate > maxPoisonMessagesPerStatus {
+		return nil, nil, extutil.Ptr(extension_kit.ToError(fmt.Sprintf("At most %d messages per second are supported.", maxPoisonMessagesPerStatus), nil))
+	}
+
+	if configBool(request.Config, "sqs") || configBool(request.Config, "kinesis") {
+		sources, err := findEventSourceMappingSources(ctx, functionArn, configBool(request.Config, "sqs"), configBool(request.Config, "kinesis"))
+		if err != nil {
+			return nil, nil, extutil.Ptr(extension_kit.ToError("Failed to find event sources", err))
+		}
+		state.Sources = append(state.Sources, sources...)
+	}
+	if configBool(request.Config, "sns") {
+		subscriptions, err := findLambdaSubscriptions(ctx, functionArn)
+		if err != nil {
+			return nil, nil, extutil.Ptr(extension_kit.ToError("Failed to find SNS subscriptions", err))
+		}
+		for _, subscription := range subscriptions {
+			state.Sources = append(state.Sources, poisonSource{Type: "sns", Arn: subscription.TopicArn})
+		}
+	}
+
+	if len(state.Sources) == 0 {
+		return nil, nil, extutil.Ptr(extension_kit.ToError(fmt.Sprintf("Function %s has no SQS, Kinesis or SNS source to send messages to.", functionArn), nil))
+	}
+	return state, poisonMessagesBlastRadius(state.Sources), nil
+}
+
+// poisonMessagesBlastRadius warns about sources whose messages reach more than the function.
+func poisonMessagesBlastRadius(sources []poisonSource) []action_kit_api.Message {
+	messages := make([]action_kit_api.Message, 0)
+	for _, source := range sources {
+		switch source.Type {
+		case "sns":
+			messages = append(messages, action_kit_api.Message{
+				Level:   extutil.Ptr(action_kit_api.Warn),
+				Message: fmt.Sprintf("Poison messages published to topic %s reach all of its subscribers, not only the function.", source.Arn),
+			})
+		case "kinesis":
+			messages = append(messages, action_kit_api.Message{
+				Level:   extutil.Ptr(action_kit_api.Warn),
+				Message: fmt.Sprintf("Poison records put to stream %s are read by all of its consumers, not only the function.", source.Arn),
+			})
+		}
+	}
+	return messages
+}
+
+func findEventSourceMappingSources(ctx context.Context, functionArn string, includeSqs bool, includeKinesis bool) ([]poisonSource, error) {
+	client, err := createLambdaClient(ctx)
+	if err != nil {
+		return nil, err
+	}
+	mappings, err := listEventSourceMappings(ctx, client, functionArn, "")
+	if err != nil {
+		return nil, err
+	}
+
+	var sqsClient *sqs.Client
+	result := make([]poisonSource, 0)
+	for _, mapping := range mappings {
+		eventSourceArn := aws.ToString(mapping.EventSourceArn)
+		switch {
+		case includeSqs && arnService(eventSourceArn) == "sqs":
+			if sqsClient == nil {
+				sqsClient, err = createSqsClient(ctx)
+				if err != nil {
+					return nil, err
+				}
+			}
+			// arn:<partition>:sqs:<region>:<account>:<queue>
+			parts := strings.Split(eventSourceArn, ":")
+			output, err := sqsClient.GetQueueUrl(ctx, &sqs.GetQueueUrlInput{
+				QueueName:              extutil.Ptr(parts[len(parts)-1]),
+				QueueOwnerAWSAccountId: extutil.Ptr(parts[len(parts)-2]),
+			})
+			if err != nil {
+				return nil, fmt.Errorf("failed to get URL of queue %s: %w", eventSourceArn, err)
+			}
+			result = append(result, poisonSource{Type: "sqs", Arn: eventSourceArn, QueueUrl: aws.ToString(output.QueueUrl)})
+		case includeKinesis && arnService(eventSourceArn) == "kinesis":
+			// Mappings of enhanced fan-out consumers point to arn:<partition>:kinesis:<region>:<account>:stream/<stream>/consumer/<consumer>
+			if index := strings.Index(eventSourceArn, "/consumer/"); index >= 0 {
+				eventSourceArn = eventSourceArn[:index]
+			}
+			result = append(result, poisonSource{Type: "kinesis", Arn: eventSourceArn})
+		}
+	}
+	return result, nil
+}
+
+func startPoisonMessages(w http.ResponseWriter, r *http.Request, body []byte) {
+	var request action_kit_api.StartActionRequestBody
+	err := json.Unmarshal(body, &request)
+	if err != nil {
+		exthttp.WriteError(w, extension_kit.ToError("Failed to parse request body", err))
+		return
+	}
+
+	var state PoisonMessagesActionState
+	err = extconversion.Convert(request.State, &state)
+	if err != nil {
+		exthttp.WriteError(w, extension_kit.ToError("Failed to convert action state", err))
+		return
+	}
+	state.StartedAt = time.Now().UnixMilli()
+
+	var convertedState action_kit_api.ActionState
+	err = extconversion.Convert(state, &convertedState)
+	if err != nil {
+		exthttp.WriteError(w, extension_kit.ToError("Failed to encode action state", err))
+		return
+	}
+
+	exthttp.WriteBody(w, action_kit_api.StartResult{
+		State: &convertedState,
+	})
+}
+
+func statusPoisonMessages(w http.ResponseWriter, r *http.Request, body []byte) {
+	var request action_kit_api.ActionStatusRequestBody
+	err := json.Unmarshal(body, &request)
+	if err != nil {
+		exthttp.WriteError(w, extension_kit.ToError("Failed to parse request body", err))
+		return
+	}
+
+	var state PoisonMessagesActionState
+	err = extconversion.Convert(request.State, &state)
+	if err != nil {
+		exthttp.WriteError(w, extension_kit.ToError("Failed to convert action state", err))
+		return
+	}
+
+	elapsed := time.Now().UnixMilli() - state.StartedAt
+	due := int(elapsed*int64(state.Rate)/1000) + 1 - state.Sent - state.Failed
+	if due > maxPoisonMessagesPerStatus {
+		due = maxPoisonMessagesPerStatus
+	}
+	if due <= 0 {
+		exthttp.WriteBody(w, action_kit_api.StatusResult{Completed: false})
+		return
+	}
+
+	sender, err := newPoisonSender(r.Context())
+	if err != nil {
+		exthttp.WriteError(w, extension_kit.ToError("Failed to create clients", err))
+		return
+	}
+	payloads := state.Payloads
+	if len(payloads) == 0 {
+		payloads = malformedPayloads
+	}
+	for i := 0; i < due; i++ {
+		index := state.Sent + state.Failed
+		source, payload := poisonMessageAt(state.Sources, payloads, index)
+		err = sender.send(r.Context(), source, payload, index)
+		if err != nil {
+			log.Warn().Err(err).Msgf("Failed to send poison message to %s.", source.Arn)
+			state.Failed++
+		} else {
+			state.Sent++
+		}
+	}
+
+	var convertedState action_kit_api.ActionState
+	err = extconversion.Convert(state, &convertedState)
+	if err != nil {
+		exthttp.WriteError(w, extension_kit.ToError("Failed to encode action state", err))
+		return
+	}
+
+	exthttp.WriteBody(w, action_kit_api.StatusResult{
+		Completed: false,
+		State:     &convertedState,
+	})
+}
+
+// poisonMessageAt selects source and payload of the index-th message. Each payload goes to every source before the next one,
+// so all sources get all payloads even if the numbers of sources and payloads share a divisor.
+func poisonMessageAt(sources []poisonSource, payloads []string, index int) (poisonSource, string) {
+	return sources[index%len(sources)], payloads[(index/len(sources))%len(payloads)]
+}
+
+func stopPoisonMessages(w http.ResponseWriter, r *http.Request, body []byte) {
+	var request action_kit_api.StopActionRequestBody
+	err := json.Unmarshal(body, &request)
+	if err != nil {
+		exthttp.WriteError(w, extension_kit.ToError("Failed to parse request body", err))
+		return
+	}
+
+	var state PoisonMessagesActionState
+	err = extconversion.Convert(request.State, &state)
+	if err != nil {
+		exthttp.WriteError(w, extension_kit.ToError("Failed to convert action state", err))
+		return
+	}
+
+	messages := []action_kit_api.Message{
+		{
+			Level:   extutil.Ptr(action_kit_api.Info),
+			Message: fmt.Sprintf("Sent %d poison messages to %d sources.", state.Sent, len(state.Sources)),
+		},
+	}
+	if state.Failed > 0 {
+		messages = append(messages, action_kit_api.Message{
+			Level:   extutil.Ptr(action_kit_api.Warn),
+			Message: fmt.Sprintf("Failed to send %d poison messages.", state.Failed),
+		})
+	}
+
+	exthttp.WriteBody(w, action_kit_api.StopResult{
+		Messages: extutil.Ptr(messages),
+	})
+}
+
+type poisonSender struct {
+	sqsClient     *sqs.Client
+	kinesisClient *kinesis.Client
+	snsClient     *sns.Client
+}
+
+func newPoisonSender(ctx context.Context) (*poisonSender, error) {
+	sqsClient, err := createSqsClient(ctx)
+	if err != nil {
+		return nil, err
+	}
+	kinesisClient, err := createKinesisClient(ctx)
+	if err != nil {
+		return nil, err
+	}
+	snsClient, err := createSnsClient(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &poisonSender{sqsClient: sqsClient, kinesisClient: kinesisClient, snsClient: snsClient}, nil
+}
+
+// send delivers the payload to the source. The index makes deduplication IDs and partition keys unique per message.
+func (s *poisonSender) send(ctx context.Context, source poisonSource, payload string, index int) error {
+	id := fmt.Sprintf("steadybit-%d-%d", time.Now().UnixNano(), index)
+	switch source.Type {
+	case "sqs":
+		input := &sqs.SendMessageInput{
+			QueueUrl:    extutil.Ptr(source.QueueUrl),
+			MessageBody: extutil.Ptr(payload),
+		}
+		if strings.HasSuffix(source.QueueUrl, ".fifo") {
+			input.MessageGroupId = extutil.Ptr("steadybit")
+			input.MessageDeduplicationId = extutil.Ptr(id)
+		}
+		_, err := s.sqsClient.SendMessage(ctx, input)
+		return err
+	case "kinesis":
+		_, err := s.kinesisClient.PutRecord(ctx, &kinesis.PutRecordInput{
+			StreamARN:    extutil.Ptr(source.Arn),
+			PartitionKey: extutil.Ptr(id),
+			Data:         []byte(payload),
+		})
+		return err
+	case "sns":
+		input := &sns.PublishInput{
+			TopicArn: extutil.Ptr(source.Arn),
+			Message:  extutil.Ptr(payload),
+		}
+		if strings.HasSuffix(source.Arn, ".fifo") {
+			input.MessageGroupId = extutil.Ptr("steadybit")
+			input.MessageDeduplicationId = extutil.Ptr(id)
+		}
+		_, err := s.snsClient.Publish(ctx, input)
+		return err
+	}
+	return fmt.Errorf("unknown source type %s", source.Type)
+}
+
+func createSqsClient(ctx context.Context) (*sqs.Client, error) {
+	awsConfig, err := config.LoadDefaultConfig(ctx)
+	if err != nil {
+		return nil, err
+	}
+	client := sqs.NewFromConfig(awsConfig)
+	return client, err
+}
+
+func createKinesisClient(ctx context.Context) (*kinesis.Client, error) {
+	awsConfig, err := config.LoadDefaultConfig(ctx)
+	if err != nil {
+		return nil, err
+	}
+	client := kinesis.NewFromConfig(awsConfig)
+	return client, err
+}
@@ -0,0 +1,49 @@
+/*
+ * Copyright 2023 steadybit GmbH. All rights reserved.
+ */
+
+package extlambda
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestPoisonMessagesBlastRadius(t *testing.T) {
+	sources := []poisonSource{
+		{Type: "sqs", Arn: "arn:aws:sqs:eu-central-1:123456789012:orders", QueueUrl: "https://sqs.eu-central-1.amazonaws.com/123456789012/orders"},
+		{Type: "kinesis", Arn: "arn:aws-cn:kinesis:cn-north-1:123456789012:stream/events"},
+		{Type: "sns", Arn: "arn:aws-us-gov:sns:us-gov-west-1:123456789012:notifications"},
+	}
+
+	messages := poisonMessagesBlastRadius(sources)
+	if len(messages) != 2 {
+		t.Fatalf("poisonMessagesBlastRadius() returned %d messages, want 2", len(messages))
+	}
+	if !strings.Contains(messages[0].Message, sources[1].Arn) {
+		t.Errorf("poisonMessagesBlastRadius() = %q, want a warning about %s", messages[0].Message, sources[1].Arn)
+	}
+	if !strings.Contains(messages[1].Message, sources[2].Arn) {
+		t.Errorf("poisonMessagesBlastRadius() = %q, want a warning about %s", messages[1].Message, sources[2].Arn)
+	}
+}
+
+func TestPoisonMessageAt(t *testing.T) {
+	sources := []poisonSource{{Type: "sqs", Arn: "orders"}, {Type: "sns", Arn: "notifications"}}
+	payloads := []string{"a", "b", "c", "d"}
+
+	received := map[string][]string{}
+	for index := 0; index < len(sources)*len(payloads); index++ {
+		source, payload := poisonMessageAt(sources, payloads, index)
+		received[source.Arn] = append(received[source.Arn], payload)
+	}
+	for _, source := range sources {
+		if strings.Join(received[source.Arn], "") != "abcd" {
+			t.Errorf("poisonMessageAt() sends %v to %s, want every payload once", received[source.Arn], source.Arn)
+		}
+	}
+
+	if source, payload := poisonMessageAt(sources[:1], payloads, 5); source.Arn != "orders" || payload != "b" {
+		t.Errorf("poisonMessageAt() with one source = (%s, %s), want (orders, b)", source.Arn, payload)
+	}
+}
@@ -0,0 +1,308 @@
+/*
+ * Copyright 2023 steadybit GmbH. All rights reserved.
+ */
+
+package extlambda
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/lambda"
+	"github.com/aws/aws-sdk-go-v2/service/lambda/types"
+	"github.com/rs/zerolog/log"
+	"github.com/steadybit/action-kit/go/action_kit_api/v2"
+	extension_kit "github.com/steadybit/extension-kit"
+	"github.com/steadybit/extension-kit/extbuild"
+	"github.com/steadybit/extension-kit/extconversion"
+	"github.com/steadybit/extension-kit/exthttp"
+	"github.com/steadybit/extension-kit/extutil"
+	"net/http"
+)
+
+const removeDestinationsActionBasePath = basePath + "/actions/remove-destinations"
+
+func registerRemoveDestinationsActionHandlers() {
+	exthttp.RegisterHttpHandler(removeDestinationsActionBasePath, exthttp.GetterAsHandler(getRemoveDestinationsActionDescription))
+	exthttp.RegisterHttpHandler(removeDestinationsActionBasePath+"/prepare", prepareRemoveDestinations)
+	exthttp.RegisterHttpHandler(removeDestinationsActionBasePath+"/start", startRemoveDestinations)
+	exthttp.RegisterHttpHandler(removeDestinationsActionBasePath+"/stop", stopRemoveDestinations)
+}
+
+func getRemoveDestinationsActionDescription() action_kit_api.ActionDescription {
+	return action_kit_api.ActionDescription{
+		Id:          fmt.Sprintf("%s.removeDestinations", targetID),
+		Version:     extbuild.GetSemverVersionStringOrUnknown(),
+		Label:       "Remove Async Destinations",
+		Description: "Removes the dead-letter queue and the on-success/on-failure destinations of asynchronous invocations. Only the unqualified function is changed, destinations configured for aliases or versions stay in place.",
+		Icon:        extutil.Ptr(targetIcon),
+		TargetType:  extutil.Ptr(targetID),
+		TargetSelectionTemplates: extutil.Ptr([]action_kit_api.TargetSelectionTemplate{
+			{
+				Label: "by function name",
+				Query: "aws.lambda.function-name=\"\"",
+			},
+		}),
+		Category:    extutil.Ptr("cloud"),
+		Kind:        action_kit_api.Attack,
+		TimeControl: action_kit_api.External,
+		Parameters: []action_kit_api.ActionParameter{
+			{
+				Label:        "Duration",
+				Name:         "duration",
+				Type:         action_kit_api.Duration,
+				Description:  extutil.Ptr("The duration of the attack."),
+				Advanced:     extutil.Ptr(false),
+				Required:     extutil.Ptr(true),
+				DefaultValue: extutil.Ptr("60s"),
+				Order:        extutil.Ptr(0),
+			},
+		},
+		Prepare: action_kit_api.MutatingEndpointReference{
+			Method: "POST",
+			Path:   removeDestinationsActionBasePath + "/prepare",
+		},
+		Start: action_kit_api.MutatingEndpointReference{
+			Method: "POST",
+			Path:   removeDestinationsActionBasePath + "/start",
+		},
+		Stop: extutil.Ptr(action_kit_api.MutatingEndpointReference{
+			Method: "POST",
+			Path:   removeDestinationsActionBasePath + "/stop",
+		}),
+	}
+}
+
+// eventInvokeConfig holds the asynchronous invocation settings in the shape expected by PutFunctionEventInvokeConfig.
+type eventInvokeConfig struct {
+	OnSuccess                string `json:"onSuccess,omitempty"`
+	OnFailure                string `json:"onFailure,omitempty"`
+	MaximumEventAgeInSeconds *int32 `json:"maximumEventAgeInSeconds,omitempty"`
+	MaximumRetryAttempts     *int32 `json:"maximumRetryAttempts,omitempty"`
+}
+
+type RemoveDestinationsActionState struct {
+	FunctionArn       string             `json:"functionArn"`
+	DeadLetterArn     string             `json:"deadLetterArn,omitempty"`
+	EventInvokeConfig *eventInvokeConfig `json:"eventInvokeConfig,omitempty"`
+}
+
+func prepareRemoveDestinations(w http.ResponseWriter, r *http.Request, body []byte) {
+	var request action_kit_api.PrepareActionRequestBody
+	err := json.Unmarshal(body, &request)
+	if err != nil {
+		exthttp.WriteError(w, extension_kit.ToError("Failed to parse request body", err))
+		return
+	}
+
+	state, extErr := prepareRemoveDestinationsState(r.Context(), &request)
+	if extErr != nil {
+		exthttp.WriteError(w, *extErr)
+		return
+	}
+
+	var convertedState action_kit_api.ActionState
+	err = extconversion.Convert(state, &convertedState)
+	if err != nil {
+		exthttp.WriteError(w, extension_kit.ToError("Failed to encode action state", err))
+		return
+	}
+
+	exthttp.WriteBody(w, action_kit_api.PrepareResult{
+		State: convertedState,
+	})
+}
+
+func prepareRemoveDestinationsState(ctx context.Context, request *action_kit_api.PrepareActionRequestBody) (*RemoveDestinationsActionState, *extension_kit.ExtensionError) {
+	functionArn := targetAttribute(request.Target, "aws.arn")
+	if functionArn == "" {
+		return nil, extutil.Ptr(extension_kit.ToError("Target is missing the 'aws.arn' attribute.", nil))
+	}
+
+	client, err := createLambdaClient(ctx)
+	if err != nil {
+		return nil, extutil.Ptr(extension_kit.ToError("Failed to create lambda client", err))
+	}
+
+	function, err := client.GetFunctionConfiguration(ctx, &lambda.GetFunctionConfigurationInput{
+		FunctionName: extutil.Ptr(functionArn),
+	})
+	if err != nil {
+		return nil, extutil.Ptr(extension_kit.ToError("Failed to get function configuration", err))
+	}
+
+	state := &RemoveDestinationsActionState{
+		FunctionArn: functionArn,
+	}
+	if function.DeadLetterConfig != nil {
+		state.DeadLetterArn = aws.ToString(function.DeadLetterConfig.TargetArn)
+	}
+
+	invokeConfig, err := client.GetFunctionEventInvokeConfig(ctx, &lambda.GetFunctionEventInvokeConfigInput{
+		FunctionName: extutil.Ptr(functionArn),
+	})
+	if err != nil {
+		var notFound *types.ResourceNotFoundException
+		if !errors.As(err, &notFound) {
+			return nil, extutil.Ptr(extension_kit.ToError("Failed to get asynchronous invocation config", err))
+		}
+	} else {
+		state.EventInvokeConfig = toEventInvokeConfig(invokeConfig)
+	}
+
+	if state.DeadLetterArn == "" && state.EventInvokeConfig == nil {
+		return nil, extutil.Ptr(extension_kit.ToError(fmt.Sprintf("Function %s has neither a dead-letter queue nor destinations configured.", functionArn), nil))
+	}
+	return state, nil
+}
+
+// toEventInvokeConfig records the asynchronous invocation settings, or returns nil if there are no destinations to remove.
+func toEventInvokeConfig(invokeConfig *lambda.GetFunctionEventInvokeConfigOutput) *eventInvokeConfig {
+	if invokeConfig.DestinationConfig == nil {
+		return nil
+	}
+	config := &eventInvokeConfig{
+		MaximumEventAgeInSeconds: invokeConfig.MaximumEventAgeInSeconds,
+		MaximumRetryAttempts:     invokeConfig.MaximumRetryAttempts,
+	}
+	if invokeConfig.DestinationConfig.OnSuccess != nil {
+		config.OnSuccess = aws.ToString(invokeConfig.DestinationConfig.OnSuccess.Destination)
+	}
+	if invokeConfig.DestinationConfig.OnFailure != nil {
+		config.OnFailure = aws.ToString(invokeConfig.DestinationConfig.OnFailure.Destination)
+	}
+	if config.OnSuccess == "" && config.OnFailure == "" {
+		return nil
+	}
+	return config
+}
+
+// destinationConfig converts the recorded destinations back into the shape expected by PutFunctionEventInvokeConfig.
+func destinationConfig(config *eventInvokeConfig) *types.DestinationConfig {
+	destinations := &types.DestinationConfig{}
+	if config.OnSuccess != "" {
+		destinations.OnSuccess = &types.OnSuccess{Destination: extutil.Ptr(config.OnSuccess)}
+	}
+	if config.OnFailure != "" {
+		destinations.OnFailure = &types.OnFailure{Destination: extutil.Ptr(config.OnFailure)}
+	}
+	return destinations
+}
+
+func startRemoveDestinations(w http.ResponseWriter, r *http.Request, body []byte) {
+	var request action_kit_api.StartActionRequestBody
+	err := json.Unmarshal(body, &request)
+	if err != nil {
+		exthttp.WriteError(w, extension_kit.ToError("Failed to parse request body", err))
+		return
+	}
+
+	var state RemoveDestinationsActionState
+	err = extconversion.Convert(request.State, &state)
+	if err != nil {
+		exthttp.WriteError(w, extension_kit.ToError("Failed to convert action state", err))
+		return
+	}
+
+	client, err := createLambdaClient(r.Context())
+	if err != nil {
+		exthttp.WriteError(w, extension_kit.ToError("Failed to create lambda client", err))
+		return
+	}
+
+	if state.DeadLetterArn != "" {
+		err = setDeadLetterTarget(r.Context(), client, state.FunctionArn, "")
+		if err != nil {
+			exthttp.WriteError(w, extension_kit.ToError("Failed to remove dead-letter queue", err))
+			return
+		}
+	}
+
+	if state.EventInvokeConfig != nil {
+		// Put replaces the whole config, so omitting the destinations removes them while keeping the retry settings
+		_, err = client.PutFunctionEventInvokeConfig(r.Context(), &lambda.PutFunctionEventInvokeConfigInput{
+			FunctionName:             extutil.Ptr(state.FunctionArn),
+			MaximumEventAgeInSeconds: state.EventInvokeConfig.MaximumEventAgeInSeconds,
+			MaximumRetryAttempts:     state.EventInvokeConfig.MaximumRetryAttempts,
+		})
+		if err != nil {
+			// Roll back the dead-letter queue, as stop is not called for a failed start
+			if state.DeadLetterArn != "" {
+				if err := setDeadLetterTarget(r.Context(), client, state.FunctionArn, state.DeadLetterArn); err != nil {
+					log.Error().Err(err).Msgf("Failed to roll back dead-letter queue of %s.", state.FunctionArn)
+				}
+			}
+			exthttp.WriteError(w, extension_kit.ToError("Failed to remove destinations", err))
+			return
+		}
+	}
+
+	exthttp.WriteBody(w, action_kit_api.StartResult{})
+}
+
+func stopRemoveDestinations(w http.ResponseWriter, r *http.Request, body []byte) {
+	var request action_kit_api.StopActionRequestBody
+	err := json.Unmarshal(body, &request)
+	if err != nil {
+		exthttp.WriteError(w, extension_kit.ToError("Failed to parse request body", err))
+		return
+	}
+
+	var state RemoveDestinationsActionState
+	err = extconversion.Convert(request.State, &state)
+	if err != nil {
+		exthttp.WriteError(w, extension_kit.ToError("Failed to convert action state", err))
+		return
+	}
+
+	client, err := createLambdaClient(r.Context())
+	if err != nil {
+		exthttp.WriteError(w, extension_kit.ToError("Failed to create lambda client", err))
+		return
+	}
+
+	if state.DeadLetterArn != "" {
+		err = setDeadLetterTarget(r.Context(), client, state.FunctionArn, state.DeadLetterArn)
+		if err != nil {
+			exthttp.WriteError(w, extension_kit.ToError("Failed to restore dead-letter queue", err))
+			return
+		}
+	}
+
+	if state.EventInvokeConfig != nil {
+		_, err = client.PutFunctionEventInvokeConfig(r.Context(), &lambda.PutFunctionEventInvokeConfigInput{
+			FunctionName:             extutil.Ptr(state.FunctionArn),
+			DestinationConfig:        destinationConfig(state.EventInvokeConfig),
+			MaximumEventAgeInSeconds: state.EventInvokeConfig.MaximumEventAgeInSeconds,
+			MaximumRetryAttempts:     state.EventInvokeConfig.MaximumRetryAttempts,
+		})
+		if err != nil {
+			exthttp.WriteError(w, extension_kit.ToError("Failed to restore destinations", err))
+			return
+		}
+	}
+
+	exthttp.WriteBody(w, action_kit_api.StopResult{})
+}
+
+// setDeadLetterTarget updates the function's dead-letter target. An empty target removes the dead-letter queue.
+func setDeadLetterTarget(ctx context.Context, client *lambda.Client, functionArn string, targetArn string) error {
+	current, err := waitForFunctionUpdated(ctx, client, functionArn)
+	if err != nil {
+		return err
+	}
+
+	_, err = client.UpdateFunctionConfiguration(ctx, &lambda.UpdateFunctionConfigurationInput{
+		FunctionName:     extutil.Ptr(functionArn),
+		DeadLetterConfig: &types.DeadLetterConfig{TargetArn: extutil.Ptr(targetArn)},
+		RevisionId:       current.RevisionId,
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = waitForFunctionUpdated(ctx, client, functionArn)
+	return err
+}
@@ -0,0 +1,56 @@
+/*
+ * Copyright 2023 steadybit GmbH. All rights reserved.
+ */
+
+package extlambda
+
+import (
+	"github.com/aws/aws-sdk-go-v2/service/lambda"
+	"github.com/aws/aws-sdk-go-v2/service/lambda/types"
+	"github.com/steadybit/extension-kit/extutil"
+	"reflect"
+	"testing"
+)
+
+func TestToEventInvokeConfig(t *testing.T) {
+	queueArn := "arn:aws:sqs:eu-central-1:123456789012:failed"
+	topicArn := "arn:aws:sns:eu-central-1:123456789012:succeeded"
+	tests := []struct {
+		name   string
+		output *lambda.GetFunctionEventInvokeConfigOutput
+		want   *eventInvokeConfig
+	}{
+		{name: "no destinations", output: &lambda.GetFunctionEventInvokeConfigOutput{MaximumRetryAttempts: int32Ptr(1)}, want: nil},
+		{name: "empty destinations", output: &lambda.GetFunctionEventInvokeConfigOutput{DestinationConfig: &types.DestinationConfig{}}, want: nil},
+		{
+			name: "on failure keeps retry settings",
+			output: &lambda.GetFunctionEventInvokeConfigOutput{
+				DestinationConfig:        &types.DestinationConfig{OnFailure: &types.OnFailure{Destination: extutil.Ptr(queueArn)}},
+				MaximumEventAgeInSeconds: int32Ptr(3600),
+				MaximumRetryAttempts:     int32Ptr(0),
+			},
+			want: &eventInvokeConfig{OnFailure: queueArn, MaximumEventAgeInSeconds: int32Ptr(3600), MaximumRetryAttempts: int32Ptr(0)},
+		},
+		{
+			name: "both destinations",
+			output: &lambda.GetFunctionEventInvokeConfigOutput{
+				DestinationConfig: &types.DestinationConfig{
+					OnSuccess: &types.OnSuccess{Destination: extutil.Ptr(topicArn)},
+					OnFailure: &types.OnFailure{Destination: extutil.Ptr(queueArn)},
+				},
+			},
+			want: &eventInvokeConfig{OnSuccess: topicArn, OnFailure: queueArn},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := toEventInvokeConfig(tt.output)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("toEventInvokeConfig() = %+v, want %+v", got, tt.want)
+			}
+			if got != nil && !reflect.DeepEqual(destinationConfig(got), tt.output.DestinationConfig) {
+				t.Errorf("destinationConfig() = %+v, want %+v", destinationConfig(got), tt.output.DestinationConfig)
+			}
+		})
+	}
+}
@@ -0,0 +1,369 @@
+/*
+ * Copyright 2023 steadybit GmbH. All rights reserved.
+ */
+
+package extlambda
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/lambda"
+	"github.com/aws/aws-sdk-go-v2/service/lambda/types"
+	"github.com/rs/zerolog/log"
+	"github.com/steadybit/action-kit/go/action_kit_api/v2"
+	extension_kit "github.com/steadybit/extension-kit"
+	"github.com/steadybit/extension-kit/extbuild"
+	"github.com/steadybit/extension-kit/extconversion"
+	"github.com/steadybit/extension-kit/exthttp"
+	"github.com/steadybit/extension-kit/extutil"
+	"net/http"
+)
+
+const revokePermissionActionBasePath = basePath + "/actions/revoke-permission"
+
+func registerRevokePermissionActionHandlers() {
+	exthttp.RegisterHttpHandler(revokePermissionActionBasePath, exthttp.GetterAsHandler(getRevokePermissionActionDescription))
+	exthttp.RegisterHttpHandler(revokePermissionActionBasePath+"/prepare", prepareRevokePermission)
+	exthttp.RegisterHttpHandler(revokePermissionActionBasePath+"/start", startRevokePermission)
+	exthttp.RegisterHttpHandler(revokePermissionActionBasePath+"/stop", stopRevokePermission)
+}
+
+func getRevokePermissionActionDescription() action_kit_api.ActionDescription {
+	return action_kit_api.ActionDescription{
+		Id:          fmt.Sprintf("%s.revokePermission", targetID),
+		Version:     extbuild.GetSemverVersionStringOrUnknown(),
+		Label:       "Revoke Invoke Permission",
+		Description: "Removes statements from the function's resource-based policy, so that the affected callers can no longer invoke it. Statements AddPermission cannot re-create exactly, e.g. with array-valued conditions, are refused.",
+		Icon:        extutil.Ptr(targetIcon),
+		TargetType:  extutil.Ptr(targetID),
+		TargetSelectionTemplates: extutil.Ptr([]action_kit_api.TargetSelectionTemplate{
+			{
+				Label: "by function name",
+				Query: "aws.lambda.function-name=\"\"",
+			},
+		}),
+		Category:    extutil.Ptr("cloud"),
+		Kind:        action_kit_api.Attack,
+		TimeControl: action_kit_api.External,
+		Parameters: []action_kit_api.ActionParameter{
+			{
+				Label:        "Duration",
+				Name:         "duration",
+				Type:         action_kit_api.Duration,
+				Description:  extutil.Ptr("The duration of the attack."),
+				Advanced:     extutil.Ptr(false),
+				Required:     extutil.Ptr(true),
+				DefaultValue: extutil.Ptr("60s"),
+				Order:        extutil.Ptr(0),
+			},
+			{
+				Name:        "principals",
+				Label:       "Principals",
+				Description: extutil.Ptr("Remove the statements granting access to these principals, e.g. 'apigateway.amazonaws.com'. Leave empty to remove all statements."),
+				Type:        action_kit_api.StringArray,
+				Required:    extutil.Ptr(false),
+				Order:       extutil.Ptr(1),
+			},
+			{
+				Name:        "statementIds",
+				Label:       "Statement IDs",
+				Description: extutil.Ptr("Remove the statements with these IDs."),
+				Type:        action_kit_api.StringArray,
+				Required:    extutil.Ptr(false),
+				Advanced:    extutil.Ptr(true),
+				Order:       extutil.Ptr(2),
+			},
+		},
+		Prepare: action_kit_api.MutatingEndpointReference{
+			Method: "POST",
+			Path:   revokePermissionActionBasePath + "/prepare",
+		},
+		Start: action_kit_api.MutatingEndpointReference{
+			Method: "POST",
+			Path:   revokePermissionActionBasePath + "/start",
+		},
+		Stop: extutil.Ptr(action_kit_api.MutatingEndpointReference{
+			Method: "POST",
+			Path:   revokePermissionActionBasePath + "/stop",
+		}),
+	}
+}
+
+// permission holds a resource policy statement in the shape expected by AddPermission.
+type permission struct {
+	StatementId         string `json:"statementId"`
+	Action              string `json:"action"`
+	Principal           string `json:"principal"`
+	SourceArn           string `json:"sourceArn,omitempty"`
+	SourceAccount       string `json:"sourceAccount,omitempty"`
+	PrincipalOrgID      string `json:"principalOrgId,omitempty"`
+	EventSourceToken    string `json:"eventSourceToken,omitempty"`
+	FunctionUrlAuthType string `json:"functionUrlAuthType,omitempty"`
+}
+
+type RevokePermissionActionState struct {
+	FunctionArn string       `json:"functionArn"`
+	Permissions []permission `json:"permissions"`
+}
+
+type resourcePolicy struct {
+	Statement []resourcePolicyStatement `json:"Statement"`
+}
+
+// resourcePolicyStatement keeps values which may be a string or an array raw, to decode them per statement.
+type resourcePolicyStatement struct {
+	Sid       string                                `json:"Sid"`
+	Effect    string                                `json:"Effect"`
+	Action    json.RawMessage                       `json:"Action"`
+	Resource  json.RawMessage                       `json:"Resource"`
+	Principal interface{}                           `json:"Principal"`
+	Condition map[string]map[string]json.RawMessage `json:"Condition"`
+}
+
+// permissionConditions are the conditions AddPermission creates, by operator and key.
+var permissionConditions = map[string]map[string]func(p *permission, value string){
+	"ArnLike": {
+		"AWS:SourceArn": func(p *permission, value string) { p.SourceArn = value },
+	},
+	"StringEquals": {
+		"AWS:SourceAccount":          func(p *permission, value string) { p.SourceAccount = value },
+		"aws:PrincipalOrgID":         func(p *permission, value string) { p.PrincipalOrgID = value },
+		"lambda:EventSourceToken":    func(p *permission, value string) { p.EventSourceToken = value },
+		"lambda:FunctionUrlAuthType": func(p *permission, value string) { p.FunctionUrlAuthType = value },
+	},
+}
+
+func prepareRevokePermission(w http.ResponseWriter, r *http.Request, body []byte) {
+	var request action_kit_api.PrepareActionRequestBody
+	err := json.Unmarshal(body, &request)
+	if err != nil {
+		exthttp.WriteError(w, extension_kit.ToError("Failed to parse request body", err))
+		return
+	}
+
+	state, extErr := prepareRevokePermissionState(r.Context(), &request)
+	if extErr != nil {
+		exthttp.WriteError(w, *extErr)
+		return
+	}
+
+	var convertedState action_kit_api.ActionState
+	err = extconversion.Convert(state, &convertedState)
+	if err != nil {
+		exthttp.WriteError(w, extension_kit.ToError("Failed to encode action state", err))
+		return
+	}
+
+	exthttp.WriteBody(w, action_kit_api.PrepareResult{
+		State: convertedState,
+	})
+}
+
+func prepareRevokePermissionState(ctx context.Context, request *action_kit_api.PrepareActionRequestBody) (*RevokePermissionActionState, *extension_kit.ExtensionError) {
+	functionArn := targetAttribute(request.Target, "aws.arn")
+	if functionArn == "" {
+		return nil, extutil.Ptr(extension_kit.ToError("Target is missing the 'aws.arn' attribute.", nil))
+	}
+	principals := configStrings(request.Config, "principals")
+	statementIds := configStrings(request.Config, "statementIds")
+
+	client, err := createLambdaClient(ctx)
+	if err != nil {
+		return nil, extutil.Ptr(extension_kit.ToError("Failed to create lambda client", err))
+	}
+
+	output, err := client.GetPolicy(ctx, &lambda.GetPolicyInput{
+		FunctionName: extutil.Ptr(functionArn),
+	})
+	if err != nil {
+		var notFound *types.ResourceNotFoundException
+		if errors.As(err, &notFound) {
+			return nil, extutil.Ptr(extension_kit.ToError(fmt.Sprintf("Function %s has no resource-based policy.", functionArn), nil))
+		}
+		return nil, extutil.Ptr(extension_kit.ToError("Failed to get resource-based policy", err))
+	}
+
+	var policy resourcePolicy
+	err = json.Unmarshal([]byte(aws.ToString(output.Policy)), &policy)
+	if err != nil {
+		return nil, extutil.Ptr(extension_kit.ToError("Failed to parse resource-based policy", err))
+	}
+
+	permissions := make([]permission, 0)
+	for _, statement := range policy.Statement {
+		p, err := toPermission(functionArn, statement)
+		selected := len(principals) == 0 && len(statementIds) == 0
+		selected = selected || contains(principals, p.Principal) || contains(statementIds, p.StatementId)
+		if !selected {
+			continue
+		}
+		// Removed statements are re-added with AddPermission, which must not grant more than the original statement
+		if err != nil {
+			return nil, extutil.Ptr(extension_kit.ToError(fmt.Sprintf("Statement %s cannot be restored exactly, refusing to remove it", statement.Sid), err))
+		}
+		permissions = append(permissions, p)
+	}
+	if len(permissions) == 0 {
+		return nil, extutil.Ptr(extension_kit.ToError("No statement of the resource-based policy matches the given principals or statement IDs.", nil))
+	}
+
+	return &RevokePermissionActionState{
+		FunctionArn: functionArn,
+		Permissions: permissions,
+	}, nil
+}
+
+// toPermission converts a statement into the AddPermission parameters re-creating it. An error is returned together with
+// the partially converted permission if the statement uses anything AddPermission cannot reproduce.
+func toPermission(functionArn string, statement resourcePolicyStatement) (permission, error) {
+	p := permission{StatementId: statement.Sid}
+	switch principal := statement.Principal.(type) {
+	case string:
+		p.Principal = principal
+	case map[string]interface{}:
+		for _, key := range []string{"Service", "AWS"} {
+			if value, ok := principal[key].(string); ok {
+				p.Principal = value
+			}
+		}
+		if p.Principal == "" || len(principal) != 1 {
+			return p, fmt.Errorf("principal %v is not a single service or account", statement.Principal)
+		}
+	default:
+		return p, fmt.Errorf("principal %v is not supported", statement.Principal)
+	}
+
+	if statement.Effect != "Allow" {
+		return p, fmt.Errorf("effect %s is not supported", statement.Effect)
+	}
+	err := json.Unmarshal(statement.Action, &p.Action)
+	if err != nil {
+		return p, fmt.Errorf("action %s is not a single action", string(statement.Action))
+	}
+	var resource string
+	err = json.Unmarshal(statement.Resource, &resource)
+	if err != nil || resource != functionArn {
+		return p, fmt.Errorf("resource %s is not the unqualified function", string(statement.Resource))
+	}
+
+	for operator, conditions := range statement.Condition {
+		for key, raw := range conditions {
+			set, ok := permissionConditions[operator][key]
+			if !ok {
+				return p, fmt.Errorf("condition %s %s is not supported", operator, key)
+			}
+			var value string
+			err = json.Unmarshal(raw, &value)
+			if err != nil {
+				return p, fmt.Errorf("condition %s %s has more than one value", operator, key)
+			}
+			set(&p, value)
+		}
+	}
+	return p, nil
+}
+
+func startRevokePermission(w http.ResponseWriter, r *http.Request, body []byte) {
+	var request action_kit_api.StartActionRequestBody
+	err := json.Unmarshal(body, &request)
+	if err != nil {
+		exthttp.WriteError(w, extension_kit.ToError("Failed to parse request body", err))
+		return
+	}
+
+	var state RevokePermissionActionState
+	err = extconversion.Convert(request.State, &state)
+	if err != nil {
+		exthttp.WriteError(w, extension_kit.ToError("Failed to convert action state", err))
+		return
+	}
+
+	client, err := createLambdaClient(r.Context())
+	if err != nil {
+		exthttp.WriteError(w, extension_kit.ToError("Failed to create lambda client", err))
+		return
+	}
+
+	for i, p := range state.Permissions {
+		_, err = client.RemovePermission(r.Context(), &lambda.RemovePermissionInput{
+			FunctionName: extutil.Ptr(state.FunctionArn),
+			StatementId:  extutil.Ptr(p.StatementId),
+		})
+		if err != nil {
+			// Roll back the statements removed so far, as stop is not called for a failed start
+			for _, removed := range state.Permissions[:i] {
+				if err := addPermission(r.Context(), client, state.FunctionArn, removed); err != nil {
+					log.Error().Err(err).Msgf("Failed to roll back removal of statement %s of %s.", removed.StatementId, state.FunctionArn)
+				}
+			}
+			exthttp.WriteError(w, extension_kit.ToError(fmt.Sprintf("Failed to remove statement %s", p.StatementId), err))
+			return
+		}
+	}
+
+	exthttp.WriteBody(w, action_kit_api.StartResult{})
+}
+
+func stopRevokePermission(w http.ResponseWriter, r *http.Request, body []byte) {
+	var request action_kit_api.StopActionRequestBody
+	err := json.Unmarshal(body, &request)
+	if err != nil {
+		exthttp.WriteError(w, extension_kit.ToError("Failed to parse request body", err))
+		return
+	}
+
+	var state RevokePermissionActionState
+	err = extconversion.Convert(request.State, &state)
+	if err != nil {
+		exthttp.WriteError(w, extension_kit.ToError("Failed to convert action state", err))
+		return
+	}
+
+	client, err := createLambdaClient(r.Context())
+	if err != nil {
+		exthttp.WriteError(w, extension_kit.ToError("Failed to create lambda client", err))
+		return
+	}
+
+	for _, p := range state.Permissions {
+		err = addPermission(r.Context(), client, state.FunctionArn, p)
+		if err != nil {
+			var conflict *types.ResourceConflictException
+			if errors.As(err, &conflict) {
+				// The statement id is already in use, so the statement was never removed or re-added by someone else.
+				continue
+			}
+			exthttp.WriteError(w, extension_kit.ToError(fmt.Sprintf("Failed to re-add statement %s", p.StatementId), err))
+			return
+		}
+	}
+
+	exthttp.WriteBody(w, action_kit_api.StopResult{})
+}
+
+func addPermission(ctx context.Context, client *lambda.Client, functionArn string, p permission) error {
+	input := &lambda.AddPermissionInput{
+		FunctionName:        extutil.Ptr(functionArn),
+		StatementId:         extutil.Ptr(p.StatementId),
+		Action:              extutil.Ptr(p.Action),
+		Principal:           extutil.Ptr(p.Principal),
+		FunctionUrlAuthType: types.FunctionUrlAuthType(p.FunctionUrlAuthType),
+	}
+	if p.SourceArn != "" {
+		input.SourceArn = extutil.Ptr(p.SourceArn)
+	}
+	if p.SourceAccount != "" {
+		input.SourceAccount = extutil.Ptr(p.SourceAccount)
+	}
+	if p.PrincipalOrgID != "" {
+		input.PrincipalOrgID = extutil.Ptr(p.PrincipalOrgID)
+	}
+	if p.EventSourceToken != "" {
+		input.EventSourceToken = extutil.Ptr(p.EventSourceToken)
+	}
+	_, err := client.AddPermission(ctx, input)
+	return err
+}
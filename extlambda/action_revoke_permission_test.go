@@ -0,0 +1,98 @@
+/*
+ * Copyright 2023 steadybit GmbH. All rights reserved.
+ */
+
+package extlambda
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestToPermission(t *testing.T) {
+	functionArn := "arn:aws:lambda:eu-central-1:123456789012:function:my-function"
+	tests := []struct {
+		name      string
+		statement string
+		want      permission
+		wantErr   bool
+	}{
+		{
+			name:      "service principal with source arn and account",
+			statement: `{"Sid":"s3","Effect":"Allow","Principal":{"Service":"s3.amazonaws.com"},"Action":"lambda:InvokeFunction","Resource":"` + functionArn + `","Condition":{"StringEquals":{"AWS:SourceAccount":"123456789012"},"ArnLike":{"AWS:SourceArn":"arn:aws:s3:::bucket"}}}`,
+			want:      permission{StatementId: "s3", Action: "lambda:InvokeFunction", Principal: "s3.amazonaws.com", SourceArn: "arn:aws:s3:::bucket", SourceAccount: "123456789012"},
+		},
+		{
+			name:      "account principal",
+			statement: `{"Sid":"account","Effect":"Allow","Principal":{"AWS":"arn:aws:iam::210987654321:root"},"Action":"lambda:InvokeFunction","Resource":"` + functionArn + `"}`,
+			want:      permission{StatementId: "account", Action: "lambda:InvokeFunction", Principal: "arn:aws:iam::210987654321:root"},
+		},
+		{
+			name:      "function url",
+			statement: `{"Sid":"url","Effect":"Allow","Principal":"*","Action":"lambda:InvokeFunctionUrl","Resource":"` + functionArn + `","Condition":{"StringEquals":{"lambda:FunctionUrlAuthType":"NONE"}}}`,
+			want:      permission{StatementId: "url", Action: "lambda:InvokeFunctionUrl", Principal: "*", FunctionUrlAuthType: "NONE"},
+		},
+		{
+			name:      "organization and event source token",
+			statement: `{"Sid":"org","Effect":"Allow","Principal":"*","Action":"lambda:InvokeFunction","Resource":"` + functionArn + `","Condition":{"StringEquals":{"aws:PrincipalOrgID":"o-123","lambda:EventSourceToken":"token"}}}`,
+			want:      permission{StatementId: "org", Action: "lambda:InvokeFunction", Principal: "*", PrincipalOrgID: "o-123", EventSourceToken: "token"},
+		},
+		{
+			name:      "array valued condition",
+			statement: `{"Sid":"array","Effect":"Allow","Principal":{"Service":"s3.amazonaws.com"},"Action":"lambda:InvokeFunction","Resource":"` + functionArn + `","Condition":{"ArnLike":{"AWS:SourceArn":["arn:aws:s3:::a","arn:aws:s3:::b"]}}}`,
+			want:      permission{StatementId: "array", Action: "lambda:InvokeFunction", Principal: "s3.amazonaws.com"},
+			wantErr:   true,
+		},
+		{
+			name:      "unsupported condition",
+			statement: `{"Sid":"ip","Effect":"Allow","Principal":"*","Action":"lambda:InvokeFunction","Resource":"` + functionArn + `","Condition":{"IpAddress":{"aws:SourceIp":"10.0.0.0/8"}}}`,
+			want:      permission{StatementId: "ip", Action: "lambda:InvokeFunction", Principal: "*"},
+			wantErr:   true,
+		},
+		{
+			name:      "unsupported condition key of a supported operator",
+			statement: `{"Sid":"vpc","Effect":"Allow","Principal":"*","Action":"lambda:InvokeFunction","Resource":"` + functionArn + `","Condition":{"StringEquals":{"aws:SourceVpc":"vpc-1"}}}`,
+			want:      permission{StatementId: "vpc", Action: "lambda:InvokeFunction", Principal: "*"},
+			wantErr:   true,
+		},
+		{
+			name:      "multiple principals",
+			statement: `{"Sid":"multi","Effect":"Allow","Principal":{"AWS":["arn:aws:iam::1:root","arn:aws:iam::2:root"]},"Action":"lambda:InvokeFunction","Resource":"` + functionArn + `"}`,
+			want:      permission{StatementId: "multi"},
+			wantErr:   true,
+		},
+		{
+			name:      "multiple actions",
+			statement: `{"Sid":"actions","Effect":"Allow","Principal":"*","Action":["lambda:InvokeFunction","lambda:GetFunction"],"Resource":"` + functionArn + `"}`,
+			want:      permission{StatementId: "actions", Principal: "*"},
+			wantErr:   true,
+		},
+		{
+			name:      "deny statement",
+			statement: `{"Sid":"deny","Effect":"Deny","Principal":"*","Action":"lambda:InvokeFunction","Resource":"` + functionArn + `"}`,
+			want:      permission{StatementId: "deny", Principal: "*"},
+			wantErr:   true,
+		},
+		{
+			name:      "qualified resource",
+			statement: `{"Sid":"alias","Effect":"Allow","Principal":"*","Action":"lambda:InvokeFunction","Resource":"` + functionArn + `:live"}`,
+			want:      permission{StatementId: "alias", Action: "lambda:InvokeFunction", Principal: "*"},
+			wantErr:   true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var statement resourcePolicyStatement
+			if err := json.Unmarshal([]byte(tt.statement), &statement); err != nil {
+				t.Fatalf("failed to parse statement: %v", err)
+			}
+			got, err := toPermission(functionArn, statement)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("toPermission() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("toPermission() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,534 @@
+/*
+ * Copyright 2023 steadybit GmbH. All rights reserved.
+ */
+
+package extlambda
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	s3types "github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/aws/aws-sdk-go-v2/service/sns"
+	"github.com/rs/zerolog/log"
+	"github.com/steadybit/action-kit/go/action_kit_api/v2"
+	extension_kit "github.com/steadybit/extension-kit"
+	"github.com/steadybit/extension-kit/extbuild"
+	"github.com/steadybit/extension-kit/extconversion"
+	"github.com/steadybit/extension-kit/exthttp"
+	"github.com/steadybit/extension-kit/extutil"
+	"net/http"
+	"strings"
+)
+
+const suspendPushTriggersActionBasePath = basePath + "/actions/suspend-push-triggers"
+
+// restorableSubscriptionAttributes are the subscription attributes which can be passed to Subscribe.
+var restorableSubscriptionAttributes = []string{"DeliveryPolicy", "FilterPolicy", "FilterPolicyScope", "RawMessageDelivery", "RedrivePolicy", "SubscriptionRoleArn"}
+
+func registerSuspendPushTriggersActionHandlers() {
+	exthttp.RegisterHttpHandler(suspendPushTriggersActionBasePath, exthttp.GetterAsHandler(getSuspendPushTriggersActionDescription))
+	exthttp.RegisterHttpHandler(suspendPushTriggersActionBasePath+"/prepare", prepareSuspendPushTriggers)
+	exthttp.RegisterHttpHandler(suspendPushTriggersActionBasePath+"/start", startSuspendPushTriggers)
+	exthttp.RegisterHttpHandler(suspendPushTriggersActionBasePath+"/stop", stopSuspendPushTriggers)
+}
+
+func getSuspendPushTriggersActionDescription() action_kit_api.ActionDescription {
+	return action_kit_api.ActionDescription{
+		Id:          fmt.Sprintf("%s.suspendPushTriggers", targetID),
+		Version:     extbuild.GetSemverVersionStringOrUnknown(),
+		Label:       "Suspend SNS and S3 Triggers",
+		Description: "Removes the SNS subscriptions and S3 bucket notifications invoking the function.",
+		Icon:        extutil.Ptr(targetIcon),
+		TargetType:  extutil.Ptr(targetID),
+		TargetSelectionTemplates: extutil.Ptr([]action_kit_api.TargetSelectionTemplate{
+			{
+				Label: "by function name",
+				Query: "aws.lambda.function-name=\"\"",
+			},
+		}),
+		Category:    extutil.Ptr("cloud"),
+		Kind:        action_kit_api.Attack,
+		TimeControl: action_kit_api.External,
+		Parameters: []action_kit_api.ActionParameter{
+			{
+				Label:        "Duration",
+				Name:         "duration",
+				Type:         action_kit_api.Duration,
+				Description:  extutil.Ptr("The duration of the attack."),
+				Advanced:     extutil.Ptr(false),
+				Required:     extutil.Ptr(true),
+				DefaultValue: extutil.Ptr("60s"),
+				Order:        extutil.Ptr(0),
+			},
+			{
+				Name:         "sns",
+				Label:        "SNS Subscriptions",
+				Description:  extutil.Ptr("Remove SNS subscriptions invoking the function."),
+				Type:         action_kit_api.Boolean,
+				DefaultValue: extutil.Ptr("true"),
+				Required:     extutil.Ptr(true),
+				Order:        extutil.Ptr(1),
+			},
+			{
+				Name:         "s3",
+				Label:        "S3 Notifications",
+				Description:  extutil.Ptr("Remove S3 bucket notifications invoking the function."),
+				Type:         action_kit_api.Boolean,
+				DefaultValue: extutil.Ptr("true"),
+				Required:     extutil.Ptr(true),
+				Order:        extutil.Ptr(2),
+			},
+			{
+				Name:        "buckets",
+				Label:       "Buckets",
+				Description: extutil.Ptr("Only look for notifications in these buckets. Leave empty to check all buckets of the account."),
+				Type:        action_kit_api.StringArray,
+				Required:    extutil.Ptr(false),
+				Advanced:    extutil.Ptr(true),
+				Order:       extutil.Ptr(3),
+			},
+		},
+		Prepare: action_kit_api.MutatingEndpointReference{
+			Method: "POST",
+			Path:   suspendPushTriggersActionBasePath + "/prepare",
+		},
+		Start: action_kit_api.MutatingEndpointReference{
+			Method: "POST",
+			Path:   suspendPushTriggersActionBasePath + "/start",
+		},
+		Stop: extutil.Ptr(action_kit_api.MutatingEndpointReference{
+			Method: "POST",
+			Path:   suspendPushTriggersActionBasePath + "/stop",
+		}),
+	}
+}
+
+type snsSubscription struct {
+	SubscriptionArn string            `json:"subscriptionArn"`
+	TopicArn        string            `json:"topicArn"`
+	Endpoint        string            `json:"endpoint"`
+	Attributes      map[string]string `json:"attributes"`
+}
+
+type bucketNotification struct {
+	Bucket string `json:"bucket"`
+	// Removed holds the configurations invoking the function, filled when the attack starts
+	Removed []s3types.LambdaFunctionConfiguration `json:"removed,omitempty"`
+}
+
+type SuspendPushTriggersActionState struct {
+	FunctionArn   string               `json:"functionArn"`
+	Subscriptions []snsSubscription    `json:"subscriptions"`
+	Notifications []bucketNotification `json:"notifications"`
+}
+
+func prepareSuspendPushTriggers(w http.ResponseWriter, r *http.Request, body []byte) {
+	var request action_kit_api.PrepareActionRequestBody
+	err := json.Unmarshal(body, &request)
+	if err != nil {
+		exthttp.WriteError(w, extension_kit.ToError("Failed to parse request body", err))
+		return
+	}
+
+	state, extErr := prepareSuspendPushTriggersState(r.Context(), &request)
+	if extErr != nil {
+		exthttp.WriteError(w, *extErr)
+		return
+	}
+
+	var convertedState action_kit_api.ActionState
+	err = extconversion.Convert(state, &convertedState)
+	if err != nil {
+		exthttp.WriteError(w, extension_kit.ToError("Failed to encode action state", err))
+		return
+	}
+
+	exthttp.WriteBody(w, action_kit_api.PrepareResult{
+		State: convertedState,
+	})
+}
+
+func prepareSuspendPushTriggersState(ctx context.Context, request *action_kit_api.PrepareActionRequestBody) (*SuspendPushTriggersActionState, *extension_kit.ExtensionError) {
+	functionArn := targetAttribute(request.Target, "aws.arn")
+	if functionArn == "" {
+		return nil, extutil.Ptr(extension_kit.ToError("Target is missing the 'aws.arn' attribute.", nil))
+	}
+
+	state := &SuspendPushTriggersActionState{
+		FunctionArn:   functionArn,
+		Subscriptions: make([]snsSubscription, 0),
+		Notifications: make([]bucketNotification, 0),
+	}
+
+	if configBool(request.Config, "sns") {
+		subscriptions, err := findLambdaSubscriptions(ctx, functionArn)
+		if err != nil {
+			return nil, extutil.Ptr(extension_kit.ToError("Failed to find SNS subscriptions of the function", err))
+		}
+		state.Subscriptions = subscriptions
+	}
+
+	if configBool(request.Config, "s3") {
+		notifications, err := findBucketNotifications(ctx, functionArn, configStrings(request.Config, "buckets"))
+		if err != nil {
+			return nil, extutil.Ptr(extension_kit.ToError("Failed to find S3 notifications of the function", err))
+		}
+		state.Notifications = notifications
+	}
+
+	if len(state.Subscriptions) == 0 && len(state.Notifications) == 0 {
+		return nil, extutil.Ptr(extension_kit.ToError(fmt.Sprintf("No SNS subscription or S3 notification invokes %s.", functionArn), nil))
+	}
+	return state, nil
+}
+
+func isFunctionOrQualifier(arn string, functionArn string) bool {
+	return arn == functionArn || strings.HasPrefix(arn, functionArn+":")
+}
+
+func findLambdaSubscriptions(ctx context.Context, functionArn string) ([]snsSubscription, error) {
+	client, err := createSnsClient(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]snsSubscription, 0)
+	var nextToken *string = nil
+	for {
+		output, err := client.ListSubscriptions(ctx, &sns.ListSubscriptionsInput{NextToken: nextToken})
+		if err != nil {
+			return nil, err
+		}
+		for _, subscription := range output.Subscriptions {
+			if aws.ToString(subscription.Protocol) != "lambda" || !isFunctionOrQualifier(aws.ToString(subscription.Endpoint), functionArn) {
+				continue
+			}
+			attributes, err := client.GetSubscriptionAttributes(ctx, &sns.GetSubscriptionAttributesInput{
+				SubscriptionArn: subscription.SubscriptionArn,
+			})
+			if err != nil {
+				return nil, err
+			}
+			restorable := make(map[string]string)
+			for _, key := range restorableSubscriptionAttributes {
+				if value, ok := attributes.Attributes[key]; ok {
+					restorable[key] = value
+				}
+			}
+			result = append(result, snsSubscription{
+				SubscriptionArn: aws.ToString(subscription.SubscriptionArn),
+				TopicArn:        aws.ToString(subscription.TopicArn),
+				Endpoint:        aws.ToString(subscription.Endpoint),
+				Attributes:      restorable,
+			})
+		}
+		if output.NextToken == nil {
+			break
+		} else {
+			nextToken = output.NextToken
+		}
+	}
+	return result, nil
+}
+
+func findBucketNotifications(ctx context.Context, functionArn string, buckets []string) ([]bucketNotification, error) {
+	client, err := createS3Client(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	scanAll := len(buckets) == 0
+	if scanAll {
+		output, err := client.ListBuckets(ctx, &s3.ListBucketsInput{})
+		if err != nil {
+			return nil, err
+		}
+		for _, bucket := range output.Buckets {
+			buckets = append(buckets, aws.ToString(bucket.Name))
+		}
+	}
+
+	result := make([]bucketNotification, 0)
+	for _, bucket := range buckets {
+		output, err := client.GetBucketNotificationConfiguration(ctx, &s3.GetBucketNotificationConfigurationInput{
+			Bucket: extutil.Ptr(bucket),
+		})
+		if err != nil {
+			if scanAll {
+				// Buckets of other regions or without access are skipped when scanning the whole account
+				log.Debug().Err(err).Msgf("Skipping notifications of bucket %s.", bucket)
+				continue
+			}
+			return nil, err
+		}
+		for _, lambdaConfig := range output.LambdaFunctionConfigurations {
+			if isFunctionOrQualifier(aws.ToString(lambdaConfig.LambdaFunctionArn), functionArn) {
+				result = append(result, bucketNotification{Bucket: bucket})
+				break
+			}
+		}
+	}
+	return result, nil
+}
+
+func startSuspendPushTriggers(w http.ResponseWriter, r *http.Request, body []byte) {
+	var request action_kit_api.StartActionRequestBody
+	err := json.Unmarshal(body, &request)
+	if err != nil {
+		exthttp.WriteError(w, extension_kit.ToError("Failed to parse request body", err))
+		return
+	}
+
+	var state SuspendPushTriggersActionState
+	err = extconversion.Convert(request.State, &state)
+	if err != nil {
+		exthttp.WriteError(w, extension_kit.ToError("Failed to convert action state", err))
+		return
+	}
+
+	// The state of a failed start is not kept, so the subscriptions removed so far are re-created right away
+	var snsClient *sns.Client
+	unsubscribed := make([]snsSubscription, 0)
+	resubscribe := func() {
+		for _, subscription := range unsubscribed {
+			if _, err := subscribeFunction(r.Context(), snsClient, subscription); err != nil {
+				log.Error().Err(err).Msgf("Failed to restore subscription %s to %s.", subscription.SubscriptionArn, subscription.TopicArn)
+			}
+		}
+	}
+
+	if len(state.Subscriptions) > 0 {
+		snsClient, err = createSnsClient(r.Context())
+		if err != nil {
+			exthttp.WriteError(w, extension_kit.ToError("Failed to create sns client", err))
+			return
+		}
+		for _, subscription := range state.Subscriptions {
+			_, err = snsClient.Unsubscribe(r.Context(), &sns.UnsubscribeInput{
+				SubscriptionArn: extutil.Ptr(subscription.SubscriptionArn),
+			})
+			if err != nil {
+				resubscribe()
+				exthttp.WriteError(w, extension_kit.ToError(fmt.Sprintf("Failed to remove subscription %s", subscription.SubscriptionArn), err))
+				return
+			}
+			unsubscribed = append(unsubscribed, subscription)
+		}
+	}
+
+	if len(state.Notifications) > 0 {
+		client, err := createS3Client(r.Context())
+		if err != nil {
+			resubscribe()
+			exthttp.WriteError(w, extension_kit.ToError("Failed to create s3 client", err))
+			return
+		}
+		for i, notification := range state.Notifications {
+			removed, err := suspendBucketNotification(r.Context(), client, notification.Bucket, state.FunctionArn)
+			if err != nil {
+				// The state of a failed start is not kept, so the buckets suspended so far are restored right away
+				for _, suspended := range state.Notifications[:i] {
+					if restoreErr := restoreBucketNotification(r.Context(), client, suspended); restoreErr != nil {
+						log.Error().Err(restoreErr).Msgf("Failed to restore notifications of bucket %s.", suspended.Bucket)
+					}
+				}
+				resubscribe()
+				exthttp.WriteError(w, extension_kit.ToError(fmt.Sprintf("Failed to remove notifications of bucket %s", notification.Bucket), err))
+				return
+			}
+			state.Notifications[i].Removed = removed
+		}
+	}
+
+	var convertedState action_kit_api.ActionState
+	err = extconversion.Convert(state, &convertedState)
+	if err != nil {
+		exthttp.WriteError(w, extension_kit.ToError("Failed to encode action state", err))
+		return
+	}
+
+	exthttp.WriteBody(w, action_kit_api.StartResult{
+		State: &convertedState,
+	})
+}
+
+// suspendBucketNotification removes the configurations invoking the function from the bucket and returns them.
+func suspendBucketNotification(ctx context.Context, client *s3.Client, bucket string, functionArn string) ([]s3types.LambdaFunctionConfiguration, error) {
+	current, err := getBucketNotificationConfiguration(ctx, client, bucket)
+	if err != nil {
+		return nil, err
+	}
+	suspended, removed := withoutFunctionConfigurations(*current, functionArn)
+	if len(removed) == 0 {
+		return removed, nil
+	}
+	_, err = client.PutBucketNotificationConfiguration(ctx, &s3.PutBucketNotificationConfigurationInput{
+		Bucket:                    extutil.Ptr(bucket),
+		NotificationConfiguration: &suspended,
+	})
+	return removed, err
+}
+
+// restoreBucketNotification re-adds the removed configurations. Other changes to the bucket's notifications during the attack are kept.
+func restoreBucketNotification(ctx context.Context, client *s3.Client, notification bucketNotification) error {
+	if len(notification.Removed) == 0 {
+		return nil
+	}
+	current, err := getBucketNotificationConfiguration(ctx, client, notification.Bucket)
+	if err != nil {
+		return err
+	}
+	restored, changed := withFunctionConfigurations(*current, notification.Removed)
+	if !changed {
+		return nil
+	}
+	_, err = client.PutBucketNotificationConfiguration(ctx, &s3.PutBucketNotificationConfigurationInput{
+		Bucket:                    extutil.Ptr(notification.Bucket),
+		NotificationConfiguration: &restored,
+	})
+	return err
+}
+
+func getBucketNotificationConfiguration(ctx context.Context, client *s3.Client, bucket string) (*s3types.NotificationConfiguration, error) {
+	output, err := client.GetBucketNotificationConfiguration(ctx, &s3.GetBucketNotificationConfigurationInput{
+		Bucket: extutil.Ptr(bucket),
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &s3types.NotificationConfiguration{
+		EventBridgeConfiguration:     output.EventBridgeConfiguration,
+		LambdaFunctionConfigurations: output.LambdaFunctionConfigurations,
+		QueueConfigurations:          output.QueueConfigurations,
+		TopicConfigurations:          output.TopicConfigurations,
+	}, nil
+}
+
+// withoutFunctionConfigurations splits the configurations invoking the function off the bucket's notification configuration.
+func withoutFunctionConfigurations(config s3types.NotificationConfiguration, functionArn string) (s3types.NotificationConfiguration, []s3types.LambdaFunctionConfiguration) {
+	kept := make([]s3types.LambdaFunctionConfiguration, 0, len(config.LambdaFunctionConfigurations))
+	removed := make([]s3types.LambdaFunctionConfiguration, 0)
+	for _, lambdaConfig := range config.LambdaFunctionConfigurations {
+		if isFunctionOrQualifier(aws.ToString(lambdaConfig.LambdaFunctionArn), functionArn) {
+			removed = append(removed, lambdaConfig)
+		} else {
+			kept = append(kept, lambdaConfig)
+		}
+	}
+	config.LambdaFunctionConfigurations = kept
+	return config, removed
+}
+
+// withFunctionConfigurations adds the removed configurations back, except for those re-added by someone else in the meantime.
+func withFunctionConfigurations(config s3types.NotificationConfiguration, removed []s3types.LambdaFunctionConfiguration) (s3types.NotificationConfiguration, bool) {
+	result := make([]s3types.LambdaFunctionConfiguration, 0, len(config.LambdaFunctionConfigurations)+len(removed))
+	result = append(result, config.LambdaFunctionConfigurations...)
+	changed := false
+	for _, lambdaConfig := range removed {
+		present := false
+		for _, existing := range config.LambdaFunctionConfigurations {
+			if aws.ToString(existing.Id) == aws.ToString(lambdaConfig.Id) {
+				present = true
+				break
+			}
+		}
+		if !present {
+			result = append(result, lambdaConfig)
+			changed = true
+		}
+	}
+	config.LambdaFunctionConfigurations = result
+	return config, changed
+}
+
+func stopSuspendPushTriggers(w http.ResponseWriter, r *http.Request, body []byte) {
+	var request action_kit_api.StopActionRequestBody
+	err := json.Unmarshal(body, &request)
+	if err != nil {
+		exthttp.WriteError(w, extension_kit.ToError("Failed to parse request body", err))
+		return
+	}
+
+	var state SuspendPushTriggersActionState
+	err = extconversion.Convert(request.State, &state)
+	if err != nil {
+		exthttp.WriteError(w, extension_kit.ToError("Failed to convert action state", err))
+		return
+	}
+
+	messages := make([]action_kit_api.Message, 0)
+	if len(state.Subscriptions) > 0 {
+		client, err := createSnsClient(r.Context())
+		if err != nil {
+			exthttp.WriteError(w, extension_kit.ToError("Failed to create sns client", err))
+			return
+		}
+		for _, subscription := range state.Subscriptions {
+			subscriptionArn, err := subscribeFunction(r.Context(), client, subscription)
+			if err != nil {
+				exthttp.WriteError(w, extension_kit.ToError(fmt.Sprintf("Failed to restore subscription to %s", subscription.TopicArn), err))
+				return
+			}
+			messages = append(messages, action_kit_api.Message{
+				Level:   extutil.Ptr(action_kit_api.Info),
+				Message: fmt.Sprintf("Subscription %s was re-created as %s.", subscription.SubscriptionArn, subscriptionArn),
+			})
+		}
+	}
+
+	if len(state.Notifications) > 0 {
+		client, err := createS3Client(r.Context())
+		if err != nil {
+			exthttp.WriteError(w, extension_kit.ToError("Failed to create s3 client", err))
+			return
+		}
+		for _, notification := range state.Notifications {
+			err = restoreBucketNotification(r.Context(), client, notification)
+			if err != nil {
+				exthttp.WriteError(w, extension_kit.ToError(fmt.Sprintf("Failed to restore notifications of bucket %s", notification.Bucket), err))
+				return
+			}
+		}
+	}
+
+	exthttp.WriteBody(w, action_kit_api.StopResult{
+		Messages: extutil.Ptr(messages),
+	})
+}
+
+// subscribeFunction re-creates a removed subscription with its original attributes and returns the ARN of the new subscription.
+func subscribeFunction(ctx context.Context, client *sns.Client, subscription snsSubscription) (string, error) {
+	output, err := client.Subscribe(ctx, &sns.SubscribeInput{
+		TopicArn:              extutil.Ptr(subscription.TopicArn),
+		Protocol:              extutil.Ptr("lambda"),
+		Endpoint:              extutil.Ptr(subscription.Endpoint),
+		Attributes:            subscription.Attributes,
+		ReturnSubscriptionArn: true,
+	})
+	if err != nil {
+		return "", err
+	}
+	return aws.ToString(output.SubscriptionArn), nil
+}
+
+func createSnsClient(ctx context.Context) (*sns.Client, error) {
+	awsConfig, err := config.LoadDefaultConfig(ctx)
+	if err != nil {
+		return nil, err
+	}
+	client := sns.NewFromConfig(awsConfig)
+	return client, err
+}
+
+func createS3Client(ctx context.Context) (*s3.Client, error) {
+	awsConfig, err := config.LoadDefaultConfig(ctx)
+	if err != nil {
+		return nil, err
+	}
+	client := s3.NewFromConfig(awsConfig)
+	return client, err
+}
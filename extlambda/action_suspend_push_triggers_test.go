@@ -0,0 +1,74 @@
+/*
+ * Copyright 2023 steadybit GmbH. All rights reserved.
+ */
+
+package extlambda
+
+import (
+	s3types "github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/steadybit/extension-kit/extutil"
+	"reflect"
+	"testing"
+)
+
+func TestIsFunctionOrQualifier(t *testing.T) {
+	functionArn := "arn:aws:lambda:eu-central-1:123456789012:function:orders"
+	tests := []struct {
+		arn  string
+		want bool
+	}{
+		{arn: functionArn, want: true},
+		{arn: functionArn + ":7", want: true},
+		{arn: functionArn + ":live", want: true},
+		{arn: functionArn + ":$LATEST", want: true},
+		{arn: functionArn + "-v2", want: false},
+		{arn: "arn:aws:lambda:eu-central-1:123456789012:function:order", want: false},
+		{arn: "arn:aws:sqs:eu-central-1:123456789012:orders", want: false},
+	}
+	for _, tt := range tests {
+		if got := isFunctionOrQualifier(tt.arn, functionArn); got != tt.want {
+			t.Errorf("isFunctionOrQualifier(%q) = %v, want %v", tt.arn, got, tt.want)
+		}
+	}
+}
+
+func TestBucketNotificationSuspendAndRestore(t *testing.T) {
+	functionArn := "arn:aws:lambda:eu-central-1:123456789012:function:orders"
+	own := s3types.LambdaFunctionConfiguration{Id: extutil.Ptr("own"), LambdaFunctionArn: extutil.Ptr(functionArn + ":live"), Events: []s3types.Event{"s3:ObjectCreated:*"}}
+	other := s3types.LambdaFunctionConfiguration{Id: extutil.Ptr("other"), LambdaFunctionArn: extutil.Ptr("arn:aws:lambda:eu-central-1:123456789012:function:audit")}
+	queue := s3types.QueueConfiguration{Id: extutil.Ptr("queue"), QueueArn: extutil.Ptr("arn:aws:sqs:eu-central-1:123456789012:q")}
+
+	original := s3types.NotificationConfiguration{
+		LambdaFunctionConfigurations: []s3types.LambdaFunctionConfiguration{own, other},
+		QueueConfigurations:          []s3types.QueueConfiguration{queue},
+	}
+	suspended, removed := withoutFunctionConfigurations(original, functionArn)
+	if !reflect.DeepEqual(removed, []s3types.LambdaFunctionConfiguration{own}) {
+		t.Errorf("removed = %v", removed)
+	}
+	if !reflect.DeepEqual(suspended.LambdaFunctionConfigurations, []s3types.LambdaFunctionConfiguration{other}) || len(suspended.QueueConfigurations) != 1 {
+		t.Errorf("suspended = %+v", suspended)
+	}
+	if len(original.LambdaFunctionConfigurations) != 2 {
+		t.Errorf("the original configuration was modified")
+	}
+
+	// A queue configuration added during the attack is kept
+	added := s3types.QueueConfiguration{Id: extutil.Ptr("added"), QueueArn: extutil.Ptr("arn:aws:sqs:eu-central-1:123456789012:added")}
+	suspended.QueueConfigurations = append(suspended.QueueConfigurations, added)
+	restored, changed := withFunctionConfigurations(suspended, removed)
+	if !changed {
+		t.Errorf("restore reported no change")
+	}
+	if !reflect.DeepEqual(restored.LambdaFunctionConfigurations, []s3types.LambdaFunctionConfiguration{other, own}) {
+		t.Errorf("restored lambda configurations = %v", restored.LambdaFunctionConfigurations)
+	}
+	if !reflect.DeepEqual(restored.QueueConfigurations, []s3types.QueueConfiguration{queue, added}) {
+		t.Errorf("restored queue configurations = %v", restored.QueueConfigurations)
+	}
+
+	again, changed := withFunctionConfigurations(restored, removed)
+	if changed || len(again.LambdaFunctionConfigurations) != 2 {
+		t.Errorf("configurations re-added by someone else are added twice: %v", again.LambdaFunctionConfigurations)
+	}
+}
@@ -0,0 +1,260 @@
+/*
+ * Copyright 2023 steadybit GmbH. All rights reserved.
+ */
+
+package extlambda
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/lambda"
+	"github.com/steadybit/action-kit/go/action_kit_api/v2"
+	extension_kit "github.com/steadybit/extension-kit"
+	"github.com/steadybit/extension-kit/extbuild"
+	"github.com/steadybit/extension-kit/extconversion"
+	"github.com/steadybit/extension-kit/exthttp"
+	"github.com/steadybit/extension-kit/extutil"
+	"net/http"
+)
+
+const zoneOutageActionBasePath = basePath + "/actions/zone-outage"
+
+func registerZoneOutageActionHandlers() {
+	exthttp.RegisterHttpHandler(zoneOutageActionBasePath, exthttp.GetterAsHandler(getZoneOutageActionDescription))
+	exthttp.RegisterHttpHandler(zoneOutageActionBasePath+"/prepare", prepareZoneOutage)
+	exthttp.RegisterHttpHandler(zoneOutageActionBasePath+"/start", startZoneOutage)
+	exthttp.RegisterHttpHandler(zoneOutageActionBasePath+"/stop", stopZoneOutage)
+}
+
+func getZoneOutageActionDescription() action_kit_api.ActionDescription {
+	return action_kit_api.ActionDescription{
+		Id:          fmt.Sprintf("%s.zoneOutage", targetID),
+		Version:     extbuild.GetSemverVersionStringOrUnknown(),
+		Label:       "Degrade Availability Zone",
+		Description: "Removes subnets from a VPC function, so that it only runs in the remaining availability zones.",
+		Icon:        extutil.Ptr(targetIcon),
+		TargetType:  extutil.Ptr(targetID),
+		TargetSelectionTemplates: extutil.Ptr([]action_kit_api.TargetSelectionTemplate{
+			{
+				Label: "by function name",
+				Query: "aws.lambda.function-name=\"\"",
+			},
+			{
+				Label: "by availability zone",
+				Query: "aws.zone=\"\"",
+			},
+		}),
+		Category:    extutil.Ptr("cloud"),
+		Kind:        action_kit_api.Attack,
+		TimeControl: action_kit_api.External,
+		Parameters: []action_kit_api.ActionParameter{
+			{
+				Label:        "Duration",
+				Name:         "duration",
+				Type:         action_kit_api.Duration,
+				Description:  extutil.Ptr("The duration of the attack."),
+				Advanced:     extutil.Ptr(false),
+				Required:     extutil.Ptr(true),
+				DefaultValue: extutil.Ptr("60s"),
+				Order:        extutil.Ptr(0),
+			},
+			{
+				Name:        "zone",
+				Label:       "Availability Zone",
+				Description: extutil.Ptr("Remove all subnets in this availability zone."),
+				Type:        action_kit_api.String,
+				Required:    extutil.Ptr(false),
+				Order:       extutil.Ptr(1),
+				Options: extutil.Ptr([]action_kit_api.ParameterOption{
+					action_kit_api.ParameterOptionsFromTargetAttribute{Attribute: "aws.zone"},
+				}),
+			},
+			{
+				Name:        "keepSubnets",
+				Label:       "Subnets to Keep",
+				Description: extutil.Ptr("Remove all but these subnets. Used when no availability zone is given."),
+				Type:        action_kit_api.StringArray,
+				Required:    extutil.Ptr(false),
+				Order:       extutil.Ptr(2),
+				Options: extutil.Ptr([]action_kit_api.ParameterOption{
+					action_kit_api.ParameterOptionsFromTargetAttribute{Attribute: "aws.lambda.subnet-id"},
+				}),
+			},
+		},
+		Prepare: action_kit_api.MutatingEndpointReference{
+			Method: "POST",
+			Path:   zoneOutageActionBasePath + "/prepare",
+		},
+		Start: action_kit_api.MutatingEndpointReference{
+			Method: "POST",
+			Path:   zoneOutageActionBasePath + "/start",
+		},
+		Stop: extutil.Ptr(action_kit_api.MutatingEndpointReference{
+			Method: "POST",
+			Path:   zoneOutageActionBasePath + "/stop",
+		}),
+	}
+}
+
+type ZoneOutageActionState struct {
+	FunctionArn       string   `json:"functionArn"`
+	SecurityGroupIds  []string `json:"securityGroupIds"`
+	OriginalSubnetIds []string `json:"originalSubnetIds"`
+	SubnetIds         []string `json:"subnetIds"`
+	// Ipv6AllowedForDualStack is passed along with every VPC config update, as omitting it disables dual stack
+	Ipv6AllowedForDualStack *bool `json:"ipv6AllowedForDualStack,omitempty"`
+}
+
+func prepareZoneOutage(w http.ResponseWriter, r *http.Request, body []byte) {
+	var request action_kit_api.PrepareActionRequestBody
+	err := json.Unmarshal(body, &request)
+	if err != nil {
+		exthttp.WriteError(w, extension_kit.ToError("Failed to parse request body", err))
+		return
+	}
+
+	state, extErr := prepareZoneOutageState(r.Context(), &request)
+	if extErr != nil {
+		exthttp.WriteError(w, *extErr)
+		return
+	}
+
+	var convertedState action_kit_api.ActionState
+	err = extconversion.Convert(state, &convertedState)
+	if err != nil {
+		exthttp.WriteError(w, extension_kit.ToError("Failed to encode action state", err))
+		return
+	}
+
+	exthttp.WriteBody(w, action_kit_api.PrepareResult{
+		State: convertedState,
+	})
+}
+
+func prepareZoneOutageState(ctx context.Context, request *action_kit_api.PrepareActionRequestBody) (*ZoneOutageActionState, *extension_kit.ExtensionError) {
+	functionArn := targetAttribute(request.Target, "aws.arn")
+	if functionArn == "" {
+		return nil, extutil.Ptr(extension_kit.ToError("Target is missing the 'aws.arn' attribute.", nil))
+	}
+	zone := configString(request.Config, "zone")
+	keepSubnets := configStrings(request.Config, "keepSubnets")
+	if zone == "" && len(keepSubnets) == 0 {
+		return nil, extutil.Ptr(extension_kit.ToError("Either an availability zone or the subnets to keep have to be given.", nil))
+	}
+
+	client, err := createLambdaClient(ctx)
+	if err != nil {
+		return nil, extutil.Ptr(extension_kit.ToError("Failed to create lambda client", err))
+	}
+
+	function, err := client.GetFunctionConfiguration(ctx, &lambda.GetFunctionConfigurationInput{
+		FunctionName: extutil.Ptr(functionArn),
+	})
+	if err != nil {
+		return nil, extutil.Ptr(extension_kit.ToError("Failed to get function configuration", err))
+	}
+	if function.VpcConfig == nil || aws.ToString(function.VpcConfig.VpcId) == "" {
+		return nil, extutil.Ptr(extension_kit.ToError(fmt.Sprintf("Function %s is not attached to a VPC.", functionArn), nil))
+	}
+	originalSubnetIds := function.VpcConfig.SubnetIds
+
+	var zones map[string]string
+	if zone != "" {
+		zones, err = getSubnetZones(ctx, originalSubnetIds)
+		if err != nil {
+			return nil, extutil.Ptr(extension_kit.ToError("Failed to resolve availability zones of the function's subnets", err))
+		}
+	}
+	remaining, extErr := remainingSubnets(originalSubnetIds, zones, zone, keepSubnets)
+	if extErr != nil {
+		return nil, extErr
+	}
+
+	return &ZoneOutageActionState{
+		FunctionArn:             functionArn,
+		SecurityGroupIds:        function.VpcConfig.SecurityGroupIds,
+		OriginalSubnetIds:       originalSubnetIds,
+		SubnetIds:               remaining,
+		Ipv6AllowedForDualStack: function.VpcConfig.Ipv6AllowedForDualStack,
+	}, nil
+}
+
+// remainingSubnets returns the subnets outside the zone, or the subnets to keep if no zone is given.
+func remainingSubnets(subnetIds []string, zones map[string]string, zone string, keepSubnets []string) ([]string, *extension_kit.ExtensionError) {
+	remaining := make([]string, 0, len(subnetIds))
+	for _, subnetId := range subnetIds {
+		if (zone != "" && zones[subnetId] != zone) || (zone == "" && contains(keepSubnets, subnetId)) {
+			remaining = append(remaining, subnetId)
+		}
+	}
+
+	if len(remaining) == 0 {
+		return nil, extutil.Ptr(extension_kit.ToError("The function needs at least one remaining subnet.", nil))
+	}
+	if len(remaining) == len(subnetIds) {
+		return nil, extutil.Ptr(extension_kit.ToError("No subnet of the function would be removed.", nil))
+	}
+	return remaining, nil
+}
+
+func startZoneOutage(w http.ResponseWriter, r *http.Request, body []byte) {
+	var request action_kit_api.StartActionRequestBody
+	err := json.Unmarshal(body, &request)
+	if err != nil {
+		exthttp.WriteError(w, extension_kit.ToError("Failed to parse request body", err))
+		return
+	}
+
+	var state ZoneOutageActionState
+	err = extconversion.Convert(request.State, &state)
+	if err != nil {
+		exthttp.WriteError(w, extension_kit.ToError("Failed to convert action state", err))
+		return
+	}
+
+	client, err := createLambdaClient(r.Context())
+	if err != nil {
+		exthttp.WriteError(w, extension_kit.ToError("Failed to create lambda client", err))
+		return
+	}
+
+	err = updateFunctionVpcConfig(r.Context(), client, state.FunctionArn, functionVpcConfig(state.SubnetIds, state.SecurityGroupIds, state.Ipv6AllowedForDualStack))
+	if err != nil {
+		exthttp.WriteError(w, extension_kit.ToError("Failed to remove subnets", err))
+		return
+	}
+
+	exthttp.WriteBody(w, action_kit_api.StartResult{})
+}
+
+func stopZoneOutage(w http.ResponseWriter, r *http.Request, body []byte) {
+	var request action_kit_api.StopActionRequestBody
+	err := json.Unmarshal(body, &request)
+	if err != nil {
+		exthttp.WriteError(w, extension_kit.ToError("Failed to parse request body", err))
+		return
+	}
+
+	var state ZoneOutageActionState
+	err = extconversion.Convert(request.State, &state)
+	if err != nil {
+		exthttp.WriteError(w, extension_kit.ToError("Failed to convert action state", err))
+		return
+	}
+
+	client, err := createLambdaClient(r.Context())
+	if err != nil {
+		exthttp.WriteError(w, extension_kit.ToError("Failed to create lambda client", err))
+		return
+	}
+
+	err = updateFunctionVpcConfig(r.Context(), client, state.FunctionArn, functionVpcConfig(state.OriginalSubnetIds, state.SecurityGroupIds, state.Ipv6AllowedForDualStack))
+	if err != nil {
+		exthttp.WriteError(w, extension_kit.ToError("Failed to restore subnets", err))
+		return
+	}
+
+	exthttp.WriteBody(w, action_kit_api.StopResult{})
+}
@@ -0,0 +1,41 @@
+/*
+ * Copyright 2023 steadybit GmbH. All rights reserved.
+ */
+
+package extlambda
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestRemainingSubnets(t *testing.T) {
+	subnetIds := []string{"subnet-a", "subnet-b", "subnet-c"}
+	zones := map[string]string{"subnet-a": "eu-central-1a", "subnet-b": "eu-central-1b", "subnet-c": "eu-central-1a"}
+	tests := []struct {
+		name        string
+		zone        string
+		keepSubnets []string
+		want        []string
+		wantErr     bool
+	}{
+		{name: "removes the subnets of the zone", zone: "eu-central-1a", want: []string{"subnet-b"}},
+		{name: "keeps the given subnets", keepSubnets: []string{"subnet-a", "subnet-c"}, want: []string{"subnet-a", "subnet-c"}},
+		{name: "zone takes precedence over subnets to keep", zone: "eu-central-1b", keepSubnets: []string{"subnet-b"}, want: []string{"subnet-a", "subnet-c"}},
+		{name: "ignores unknown subnets to keep", keepSubnets: []string{"subnet-b", "subnet-x"}, want: []string{"subnet-b"}},
+		{name: "rejects removing all subnets", keepSubnets: []string{"subnet-x"}, wantErr: true},
+		{name: "rejects removing no subnet", zone: "eu-central-1c", wantErr: true},
+		{name: "rejects keeping all subnets", keepSubnets: subnetIds, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, extErr := remainingSubnets(subnetIds, zones, tt.zone, tt.keepSubnets)
+			if (extErr != nil) != tt.wantErr {
+				t.Fatalf("remainingSubnets() error = %v, want error %v", extErr, tt.wantErr)
+			}
+			if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("remainingSubnets() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -1,7 +1,145 @@
 package extlambda
 
+import (
+	"context"
+	"github.com/aws/aws-sdk-go-v2/service/lambda"
+	"github.com/aws/aws-sdk-go-v2/service/lambda/types"
+	"github.com/steadybit/action-kit/go/action_kit_api/v2"
+	"github.com/steadybit/extension-kit/extutil"
+	"strconv"
+	"strings"
+	"time"
+)
+
 const (
 	targetID   = "com.github.steadybit.aws.lambda"
 	targetIcon = "data:image/svg+xml;base64,PD94bWwgdmVyc2lvbj0iMS4wIiBlbmNvZGluZz0iVVRGLTgiIHN0YW5kYWxvbmU9Im5vIj8+CjwhLS0gQ3JlYXRlZCB3aXRoIElua3NjYXBlIChodHRwOi8vd3d3Lmlua3NjYXBlLm9yZy8pIC0tPgo8c3ZnIHhtbG5zOmlua3NjYXBlPSJodHRwOi8vd3d3Lmlua3NjYXBlLm9yZy9uYW1lc3BhY2VzL2lua3NjYXBlIiB4bWxuczpzb2RpcG9kaT0iaHR0cDovL3NvZGlwb2RpLnNvdXJjZWZvcmdlLm5ldC9EVEQvc29kaXBvZGktMC5kdGQiIHhtbG5zPSJodHRwOi8vd3d3LnczLm9yZy8yMDAwL3N2ZyIgeG1sbnM6c3ZnPSJodHRwOi8vd3d3LnczLm9yZy8yMDAwL3N2ZyIgd2lkdGg9Ijc5LjM3NW1tIiBoZWlnaHQ9Ijc5LjM3NW1tIiB2aWV3Qm94PSIwIDAgNzkuMzc1IDc5LjM3NSIgdmVyc2lvbj0iMS4xIiBpZD0ic3ZnNSIgaW5rc2NhcGU6dmVyc2lvbj0iMS4xLjEgKDNiZjVhZTBkMjUsIDIwMjEtMDktMjApIiBzb2RpcG9kaTpkb2NuYW1lPSJBbWF6b24gTGFtYmRhIGFyY2hpdGVjdHVyZSBsb2dvLnN2ZyI+CiAgPHNvZGlwb2RpOm5hbWVkdmlldyBpZD0ibmFtZWR2aWV3NyIgcGFnZWNvbG9yPSIjZmZmZmZmIiBib3JkZXJjb2xvcj0iIzExMTExMSIgYm9yZGVyb3BhY2l0eT0iMSIgaW5rc2NhcGU6cGFnZXNoYWRvdz0iMCIgaW5rc2NhcGU6cGFnZW9wYWNpdHk9IjAiIGlua3NjYXBlOnBhZ2VjaGVja2VyYm9hcmQ9IjEiIGlua3NjYXBlOmRvY3VtZW50LXVuaXRzPSJtbSIgc2hvd2dyaWQ9InRydWUiIGZpdC1tYXJnaW4tdG9wPSIwIiBmaXQtbWFyZ2luLWxlZnQ9IjAiIGZpdC1tYXJnaW4tcmlnaHQ9IjAiIGZpdC1tYXJnaW4tYm90dG9tPSIwIiBpbmtzY2FwZTp6b29tPSIxLjQ0Njc0NTIiIGlua3NjYXBlOmN4PSIxMzMuNzQ4NSIgaW5rc2NhcGU6Y3k9Ijg5LjUxMTI2OCIgaW5rc2NhcGU6d2luZG93LXdpZHRoPSIxOTIwIiBpbmtzY2FwZTp3aW5kb3ctaGVpZ2h0PSIxMDAxIiBpbmtzY2FwZTp3aW5kb3cteD0iLTkiIGlua3NjYXBlOndpbmRvdy15PSItOSIgaW5rc2NhcGU6d2luZG93LW1heGltaXplZD0iMSIgaW5rc2NhcGU6Y3VycmVudC1sYXllcj0ibGF5ZXIxIj4KICAgIDxpbmtzY2FwZTpncmlkIHR5cGU9Inh5Z3JpZCIgaWQ9ImdyaWQ4MzYiIGVuYWJsZWQ9InRydWUiLz4KICA8L3NvZGlwb2RpOm5hbWVkdmlldz4KICA8ZGVmcyBpZD0iZGVmczIiLz4KICA8ZyBpbmtzY2FwZTpsYWJlbD0iTGF5ZXIgMSIgaW5rc2NhcGU6Z3JvdXBtb2RlPSJsYXllciIgaWQ9ImxheWVyMSIgdHJhbnNmb3JtPSJ0cmFuc2xhdGUoLTU5LjgwMDE4MiwtNTguNzAyODkyKSI+CiAgICAKICAgIDxwYXRoIHN0eWxlPSJmaWxsOiNkODY2MTM7ZmlsbC1vcGFjaXR5OjAuOTkyMTU3O3N0cm9rZTojZDg2NjEzO3N0cm9rZS13aWR0aDoyLjA1O3N0cm9rZS1saW5lY2FwOnJvdW5kO3N0cm9rZS1saW5lam9pbjpyb3VuZDtzdHJva2UtbWl0ZXJsaW1pdDo0O3N0cm9rZS1kYXNoYXJyYXk6bm9uZTtzdG9wLWNvbG9yOiMwMDAwMDAiIGQ9Im0gODMuNzExOTc0LDczLjA4MTk3MSB2IDExLjU0NDQxMiBoIDguMTM4MjM3IEwgMTEwLjgyNDIsMTIzLjcxNzM2IGggMTMuMDMwMzIgdiAtMTEuNDc1ODMgaCAtNS4wNTIxMSBMIDk5LjgwNTU3LDczLjA4MTk3MSBaIiBpZD0icGF0aDEyMTIiLz4KICAgIDxwYXRoIHN0eWxlPSJmaWxsOiNkODY2MTM7ZmlsbC1vcGFjaXR5OjE7c3Ryb2tlOiNkODY2MTM7c3Ryb2tlLXdpZHRoOjIuMDU7c3Ryb2tlLWxpbmVjYXA6cm91bmQ7c3Ryb2tlLWxpbmVqb2luOnJvdW5kO3N0cm9rZS1taXRlcmxpbWl0OjQ7c3Ryb2tlLWRhc2hhcnJheTpub25lO3N0b3AtY29sb3I6IzAwMDAwMCIgZD0iTSA4OS41NDMwNzksOTMuNTcwNzAyIDk2LjMzMjIxOCwxMDcuNTU2MzIgODguOTYxMTUyLDEyMy42MiBIIDc1LjM1MDU0NCBaIiBpZD0icGF0aDEyMTQiLz4KICA8L2c+Cjwvc3ZnPgo="
 	basePath   = "/lambda"
 )
+
+// configInt32 reads an optional integer parameter from the action config. Absent or empty values yield nil.
+func configInt32(config map[string]interface{}, key string) *int32 {
+	switch value := config[key].(type) {
+	case float64:
+		return extutil.Ptr(int32(value))
+	case string:
+		if parsed, err := strconv.ParseInt(value, 10, 32); err == nil {
+			return extutil.Ptr(int32(parsed))
+		}
+	}
+	return nil
+}
+
+// configString reads an optional string parameter from the action config. Absent values yield "".
+func configString(config map[string]interface{}, key string) string {
+	if value, ok := config[key].(string); ok {
+		return value
+	}
+	return ""
+}
+
+// configBool reads an optional boolean parameter from the action config. Absent values yield false.
+func configBool(config map[string]interface{}, key string) bool {
+	switch value := config[key].(type) {
+	case bool:
+		return value
+	case string:
+		return value == "true"
+	}
+	return false
+}
+
+// targetAttribute returns the first value of a target attribute or "" if the target does not have it.
+func targetAttribute(target *action_kit_api.Target, key string) string {
+	if target == nil || len(target.Attributes[key]) == 0 {
+		return ""
+	}
+	return target.Attributes[key][0]
+}
+
+// arnService returns the service of an ARN like arn:<partition>:<service>:<region>:<account>:<resource>, or "" if it is none.
+func arnService(arn string) string {
+	parts := strings.SplitN(arn, ":", 4)
+	if len(parts) < 4 || parts[0] != "arn" {
+		return ""
+	}
+	return parts[2]
+}
+
+// waitForFunctionUpdated blocks until the function's LastUpdateStatus is Successful and returns the resulting configuration.
+func waitForFunctionUpdated(ctx context.Context, client *lambda.Client, functionName string) (*lambda.GetFunctionConfigurationOutput, error) {
+	return lambda.NewFunctionUpdatedWaiter(client).WaitForOutput(ctx, &lambda.GetFunctionConfigurationInput{
+		FunctionName: extutil.Ptr(functionName),
+	}, 5*time.Minute)
+}
+
+// configDuration reads an optional duration parameter, which the agent passes in milliseconds.
+func configDuration(config map[string]interface{}, key string) time.Duration {
+	if value, ok := config[key].(float64); ok {
+		return time.Duration(value) * time.Millisecond
+	}
+	return 0
+}
+
+// configStrings reads an optional string array parameter from the action config.
+func configStrings(config map[string]interface{}, key string) []string {
+	result := make([]string, 0)
+	if values, ok := config[key].([]interface{}); ok {
+		for _, value := range values {
+			if s, ok := value.(string); ok && s != "" {
+				result = append(result, s)
+			}
+		}
+	}
+	return result
+}
+
+func contains[T comparable](values []T, value T) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// setEnvironmentVariables sets the given variables or, if a value is nil, removes them while keeping all other variables.
+// The function is left untouched if nothing changes.
+func setEnvironmentVariables(ctx context.Context, client *lambda.Client, functionArn string, values map[string]*string) error {
+	current, err := client.GetFunctionConfiguration(ctx, &lambda.GetFunctionConfigurationInput{
+		FunctionName: extutil.Ptr(functionArn),
+	})
+	if err != nil {
+		return err
+	}
+
+	variables := map[string]string{}
+	if current.Environment != nil {
+		for key, existing := range current.Environment.Variables {
+			variables[key] = existing
+		}
+	}
+	changed := false
+	for key, value := range values {
+		existing, ok := variables[key]
+		if value == nil {
+			if ok {
+				delete(variables, key)
+				changed = true
+			}
+		} else if !ok || existing != *value {
+			variables[key] = *value
+			changed = true
+		}
+	}
+	if !changed {
+		return nil
+	}
+
+	_, err = client.UpdateFunctionConfiguration(ctx, &lambda.UpdateFunctionConfigurationInput{
+		FunctionName: extutil.Ptr(functionArn),
+		Environment:  &types.Environment{Variables: variables},
+		RevisionId:   current.RevisionId,
+	})
+	return err
+}
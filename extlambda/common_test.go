@@ -0,0 +1,148 @@
+/*
+ * Copyright 2023 steadybit GmbH. All rights reserved.
+ */
+
+package extlambda
+
+import (
+	"github.com/steadybit/action-kit/go/action_kit_api/v2"
+	"reflect"
+	"testing"
+)
+
+func TestConfigInt32(t *testing.T) {
+	tests := []struct {
+		name  string
+		value interface{}
+		want  *int32
+	}{
+		{name: "number", value: float64(42), want: int32Ptr(42)},
+		{name: "numeric string", value: "17", want: int32Ptr(17)},
+		{name: "negative string", value: "-1", want: int32Ptr(-1)},
+		{name: "empty string", value: "", want: nil},
+		{name: "invalid string", value: "abc", want: nil},
+		{name: "absent", value: nil, want: nil},
+		{name: "boolean", value: true, want: nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := map[string]interface{}{}
+			if tt.value != nil {
+				config["key"] = tt.value
+			}
+			got := configInt32(config, "key")
+			if (got == nil) != (tt.want == nil) || (got != nil && *got != *tt.want) {
+				t.Errorf("configInt32() = %v, want %v", deref(got), deref(tt.want))
+			}
+		})
+	}
+}
+
+func TestConfigString(t *testing.T) {
+	config := map[string]interface{}{"string": "value", "number": float64(1)}
+	if got := configString(config, "string"); got != "value" {
+		t.Errorf("configString(string) = %q", got)
+	}
+	if got := configString(config, "number"); got != "" {
+		t.Errorf("configString(number) = %q", got)
+	}
+	if got := configString(config, "absent"); got != "" {
+		t.Errorf("configString(absent) = %q", got)
+	}
+}
+
+func TestConfigBool(t *testing.T) {
+	tests := []struct {
+		value interface{}
+		want  bool
+	}{
+		{value: true, want: true},
+		{value: false, want: false},
+		{value: "true", want: true},
+		{value: "false", want: false},
+		{value: "yes", want: false},
+		{value: nil, want: false},
+	}
+	for _, tt := range tests {
+		if got := configBool(map[string]interface{}{"key": tt.value}, "key"); got != tt.want {
+			t.Errorf("configBool(%v) = %v, want %v", tt.value, got, tt.want)
+		}
+	}
+}
+
+func TestTargetAttribute(t *testing.T) {
+	target := &action_kit_api.Target{Attributes: map[string][]string{
+		"aws.arn":   {"arn:aws:lambda:eu-central-1:123456789012:function:a", "ignored"},
+		"aws.empty": {},
+	}}
+	if got := targetAttribute(target, "aws.arn"); got != "arn:aws:lambda:eu-central-1:123456789012:function:a" {
+		t.Errorf("targetAttribute(aws.arn) = %q", got)
+	}
+	if got := targetAttribute(target, "aws.empty"); got != "" {
+		t.Errorf("targetAttribute(aws.empty) = %q", got)
+	}
+	if got := targetAttribute(nil, "aws.arn"); got != "" {
+		t.Errorf("targetAttribute(nil) = %q", got)
+	}
+}
+
+func TestArnService(t *testing.T) {
+	tests := []struct {
+		arn  string
+		want string
+	}{
+		{arn: "arn:aws:sqs:eu-central-1:123456789012:queue", want: "sqs"},
+		{arn: "arn:aws-cn:kinesis:cn-north-1:123456789012:stream/s", want: "kinesis"},
+		{arn: "arn:aws-us-gov:sns:us-gov-west-1:123456789012:topic", want: "sns"},
+		{arn: "arn:aws:dynamodb:eu-central-1:123456789012:table/t/stream/2023", want: "dynamodb"},
+		{arn: "https://sqs.eu-central-1.amazonaws.com/123456789012/queue", want: ""},
+		{arn: "", want: ""},
+	}
+	for _, tt := range tests {
+		if got := arnService(tt.arn); got != tt.want {
+			t.Errorf("arnService(%q) = %q, want %q", tt.arn, got, tt.want)
+		}
+	}
+}
+
+func TestConfigStrings(t *testing.T) {
+	tests := []struct {
+		name  string
+		value interface{}
+		want  []string
+	}{
+		{name: "strings", value: []interface{}{"eu-central-1a", "eu-central-1b"}, want: []string{"eu-central-1a", "eu-central-1b"}},
+		{name: "skips empty and non-string values", value: []interface{}{"", float64(1), "eu-central-1a"}, want: []string{"eu-central-1a"}},
+		{name: "single string", value: "eu-central-1a", want: []string{}},
+		{name: "absent", value: nil, want: []string{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := configStrings(map[string]interface{}{"key": tt.value}, "key")
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("configStrings() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestContains(t *testing.T) {
+	values := []string{"subnet-1", "subnet-2"}
+	if !contains(values, "subnet-2") {
+		t.Errorf("contains(subnet-2) = false")
+	}
+	if contains(values, "subnet-3") || contains(nil, "subnet-1") {
+		t.Errorf("contains() found a missing value")
+	}
+}
+
+func int32Ptr(value int32) *int32 {
+	return &value
+}
+
+func deref(value *int32) interface{} {
+	if value == nil {
+		return nil
+	}
+	return *value
+}
@@ -6,20 +6,38 @@ package extlambda
 
 import (
 	"context"
+	"fmt"
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	ec2types "github.com/aws/aws-sdk-go-v2/service/ec2/types"
 	"github.com/aws/aws-sdk-go-v2/service/lambda"
 	"github.com/aws/aws-sdk-go-v2/service/lambda/types"
+	"github.com/rs/zerolog/log"
 	"github.com/steadybit/discovery-kit/go/discovery_kit_api"
 	extension_kit "github.com/steadybit/extension-kit"
 	"github.com/steadybit/extension-kit/extbuild"
 	"github.com/steadybit/extension-kit/exthttp"
 	"github.com/steadybit/extension-kit/extutil"
+	"math/rand"
 	"net/http"
 	"strconv"
+	"sync"
+	"time"
 )
 
-const discoveryBasePath = basePath + "/discovery"
+const (
+	discoveryBasePath = basePath + "/discovery"
+	// functionDetailsTtl is how long attributes needing an extra API call per function are reused by the discovery
+	functionDetailsTtl = 15 * time.Minute
+	// maxFunctionDetailsRefreshes limits the extra API calls of one discovery run, remaining functions are refreshed in later runs
+	maxFunctionDetailsRefreshes = 50
+)
+
+var (
+	aliasAttributesCache       = newAttributesCache()
+	functionUrlAttributesCache = newAttributesCache()
+)
 
 func RegisterDiscoveryHandlers() {
 	exthttp.RegisterHttpHandler(discoveryBasePath, exthttp.GetterAsHandler(getDiscoveryDescription))
@@ -190,6 +208,48 @@ func getAttributeDescriptions() discovery_kit_api.AttributeDescriptions {
 					One:   "Failure Injection SSM Parameter",
 					Other: "Failure Injection SSM Parameters",
 				},
+			}, {
+				Attribute: "aws.vpc.id",
+				Label: discovery_kit_api.PluralLabel{
+					One:   "VPC ID",
+					Other: "VPC IDs",
+				},
+			}, {
+				Attribute: "aws.lambda.subnet-id",
+				Label: discovery_kit_api.PluralLabel{
+					One:   "Subnet ID",
+					Other: "Subnet IDs",
+				},
+			}, {
+				Attribute: "aws.zone",
+				Label: discovery_kit_api.PluralLabel{
+					One:   "Availability Zone",
+					Other: "Availability Zones",
+				},
+			}, {
+				Attribute: "aws.lambda.alias",
+				Label: discovery_kit_api.PluralLabel{
+					One:   "Alias",
+					Other: "Aliases",
+				},
+			}, {
+				Attribute: "aws.lambda.alias-version",
+				Label: discovery_kit_api.PluralLabel{
+					One:   "Alias Version",
+					Other: "Alias Versions",
+				},
+			}, {
+				Attribute: "aws.lambda.function-url",
+				Label: discovery_kit_api.PluralLabel{
+					One:   "Function URL",
+					Other: "Function URLs",
+				},
+			}, {
+				Attribute: "aws.lambda.function-url-auth-type",
+				Label: discovery_kit_api.PluralLabel{
+					One:   "Function URL Auth Type",
+					Other: "Function URL Auth Types",
+				},
 			},
 		},
 	}
@@ -211,6 +271,7 @@ func getAllLambdaFunctions(ctx context.Context) ([]discovery_kit_api.Target, err
 	}
 
 	result := make([]discovery_kit_api.Target, 0, 20)
+	refreshes := maxFunctionDetailsRefreshes
 	var marker *string = nil
 	//Listing all lambda functions and using the marker for pagination
 	for {
@@ -222,7 +283,14 @@ func getAllLambdaFunctions(ctx context.Context) ([]discovery_kit_api.Target, err
 		}
 
 		for _, function := range output.Functions {
-			result = append(result, toTarget(function))
+			target := toTarget(function)
+			aliasAttributesCache.apply(&target, &refreshes, func() (map[string][]string, error) {
+				return getAliasAttributes(ctx, client, target.Id)
+			})
+			functionUrlAttributesCache.apply(&target, &refreshes, func() (map[string][]string, error) {
+				return getFunctionUrlAttributes(ctx, client, target.Id)
+			})
+			result = append(result, target)
 		}
 
 		if output.NextMarker == nil {
@@ -232,9 +300,76 @@ func getAllLambdaFunctions(ctx context.Context) ([]discovery_kit_api.Target, err
 		}
 	}
 
+	aliasAttributesCache.retain(result)
+	functionUrlAttributesCache.retain(result)
+	addZoneAttributes(ctx, result)
 	return result, nil
 }
 
+type attributesCacheEntry struct {
+	attributes map[string][]string
+	expires    time.Time
+}
+
+// attributesCache keeps per function attributes between discovery runs.
+type attributesCache struct {
+	mutex   sync.Mutex
+	entries map[string]attributesCacheEntry
+	now     func() time.Time
+}
+
+func newAttributesCache() *attributesCache {
+	return &attributesCache{entries: map[string]attributesCacheEntry{}, now: time.Now}
+}
+
+// apply adds the cached attributes to the target. Expired or missing attributes are loaded while refreshes are left,
+// otherwise outdated attributes are used until a later run refreshes them.
+func (c *attributesCache) apply(target *discovery_kit_api.Target, refreshes *int, load func() (map[string][]string, error)) {
+	c.mutex.Lock()
+	entry, ok := c.entries[target.Id]
+	c.mutex.Unlock()
+
+	now := c.now()
+	if (!ok || now.After(entry.expires)) && *refreshes > 0 {
+		*refreshes--
+		attributes, err := load()
+		if err != nil {
+			log.Warn().Err(err).Msgf("Failed to load attributes of %s.", target.Id)
+		} else {
+			// The jitter spreads the refreshes of functions discovered at the same time over several runs
+			entry = attributesCacheEntry{
+				attributes: attributes,
+				expires:    now.Add(functionDetailsTtl + time.Duration(rand.Int63n(int64(functionDetailsTtl/2)))),
+			}
+			ok = true
+			c.mutex.Lock()
+			c.entries[target.Id] = entry
+			c.mutex.Unlock()
+		}
+	}
+
+	if ok {
+		for key, values := range entry.attributes {
+			target.Attributes[key] = values
+		}
+	}
+}
+
+// retain drops the entries of functions which no longer exist.
+func (c *attributesCache) retain(targets []discovery_kit_api.Target) {
+	ids := make(map[string]bool, len(targets))
+	for _, target := range targets {
+		ids[target.Id] = true
+	}
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	for id := range c.entries {
+		if !ids[id] {
+			delete(c.entries, id)
+		}
+	}
+}
+
 func createLambdaClient(ctx context.Context) (*lambda.Client, error) {
 	awsConfig, err := config.LoadDefaultConfig(ctx)
 	if err != nil {
@@ -267,7 +402,12 @@ func toTarget(function types.FunctionConfiguration) discovery_kit_api.Target {
 	attributes["aws.lambda.revision-id"] = []string{aws.ToString(function.RevisionId)}
 	attributes["aws.lambda.package-type"] = []string{string(function.PackageType)}
 	if function.Environment != nil && function.Environment.Variables != nil {
-		attributes["aws.lambda.failure-injection-param"] = []string{function.Environment.Variables["FAILURE_INJECTION_PARAM"]}
+		attributes["aws.lambda.failure-injection-param"] = []string{function.Environment.Variables[failureInjectionParamVariable]}
+	}
+
+	if function.VpcConfig != nil && aws.ToString(function.VpcConfig.VpcId) != "" {
+		attributes["aws.vpc.id"] = []string{aws.ToString(function.VpcConfig.VpcId)}
+		attributes["aws.lambda.subnet-id"] = function.VpcConfig.SubnetIds
 	}
 
 	architectures := make([]string, len(function.Architectures))
@@ -283,3 +423,151 @@ func toTarget(function types.FunctionConfiguration) discovery_kit_api.Target {
 		Attributes: attributes,
 	}
 }
+
+// getAliasAttributes lists the function's aliases and the versions they currently point to, e.g. "live:12".
+func getAliasAttributes(ctx context.Context, client *lambda.Client, functionArn string) (map[string][]string, error) {
+	aliases := make([]string, 0)
+	aliasVersions := make([]string, 0)
+	var marker *string = nil
+	for {
+		output, err := client.ListAliases(ctx, &lambda.ListAliasesInput{
+			FunctionName: extutil.Ptr(functionArn),
+			Marker:       marker,
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		for _, alias := range output.Aliases {
+			aliases = append(aliases, aws.ToString(alias.Name))
+			aliasVersions = append(aliasVersions, fmt.Sprintf("%s:%s", aws.ToString(alias.Name), aws.ToString(alias.FunctionVersion)))
+		}
+
+		if output.NextMarker == nil {
+			break
+		} else {
+			marker = output.NextMarker
+		}
+	}
+
+	attributes := map[string][]string{}
+	if len(aliases) > 0 {
+		attributes["aws.lambda.alias"] = aliases
+		attributes["aws.lambda.alias-version"] = aliasVersions
+	}
+	return attributes, nil
+}
+
+// getFunctionUrlAttributes lists the URLs of the function and its aliases together with their auth type.
+func getFunctionUrlAttributes(ctx context.Context, client *lambda.Client, functionArn string) (map[string][]string, error) {
+	configs, err := listFunctionUrlConfigs(ctx, client, functionArn)
+	if err != nil {
+		return nil, err
+	}
+
+	urls := make([]string, 0, len(configs))
+	authTypes := make([]string, 0, len(configs))
+	for _, config := range configs {
+		urls = append(urls, aws.ToString(config.FunctionUrl))
+		if !contains(authTypes, string(config.AuthType)) {
+			authTypes = append(authTypes, string(config.AuthType))
+		}
+	}
+
+	attributes := map[string][]string{}
+	if len(urls) > 0 {
+		attributes["aws.lambda.function-url"] = urls
+		attributes["aws.lambda.function-url-auth-type"] = authTypes
+	}
+	return attributes, nil
+}
+
+func listFunctionUrlConfigs(ctx context.Context, client *lambda.Client, functionArn string) ([]types.FunctionUrlConfig, error) {
+	result := make([]types.FunctionUrlConfig, 0)
+	var marker *string = nil
+	for {
+		output, err := client.ListFunctionUrlConfigs(ctx, &lambda.ListFunctionUrlConfigsInput{
+			FunctionName: extutil.Ptr(functionArn),
+			Marker:       marker,
+		})
+		if err != nil {
+			return result, err
+		}
+		result = append(result, output.FunctionUrlConfigs...)
+
+		if output.NextMarker == nil {
+			break
+		} else {
+			marker = output.NextMarker
+		}
+	}
+	return result, nil
+}
+
+// addZoneAttributes resolves the availability zones of all subnets the functions are attached to.
+func addZoneAttributes(ctx context.Context, targets []discovery_kit_api.Target) {
+	subnetIds := make([]string, 0)
+	for _, target := range targets {
+		subnetIds = append(subnetIds, target.Attributes["aws.lambda.subnet-id"]...)
+	}
+	if len(subnetIds) == 0 {
+		return
+	}
+
+	zones, err := getSubnetZones(ctx, subnetIds)
+	if err != nil {
+		log.Warn().Err(err).Msg("Failed to resolve availability zones of lambda subnets.")
+		return
+	}
+
+	for _, target := range targets {
+		targetZones := make([]string, 0)
+		for _, subnetId := range target.Attributes["aws.lambda.subnet-id"] {
+			zone, ok := zones[subnetId]
+			if ok && !contains(targetZones, zone) {
+				targetZones = append(targetZones, zone)
+			}
+		}
+		if len(targetZones) > 0 {
+			target.Attributes["aws.zone"] = targetZones
+		}
+	}
+}
+
+// getSubnetZones maps the given subnet ids to their availability zone.
+func getSubnetZones(ctx context.Context, subnetIds []string) (map[string]string, error) {
+	client, err := createEc2Client(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	unique := make([]string, 0, len(subnetIds))
+	for _, subnetId := range subnetIds {
+		if !contains(unique, subnetId) {
+			unique = append(unique, subnetId)
+		}
+	}
+
+	result := make(map[string]string)
+	var nextToken *string = nil
+	for {
+		output, err := client.DescribeSubnets(ctx, &ec2.DescribeSubnetsInput{
+			Filters:   []ec2types.Filter{{Name: extutil.Ptr("subnet-id"), Values: unique}},
+			NextToken: nextToken,
+		})
+		if err != nil {
+			return result, err
+		}
+
+		for _, subnet := range output.Subnets {
+			result[aws.ToString(subnet.SubnetId)] = aws.ToString(subnet.AvailabilityZone)
+		}
+
+		if output.NextToken == nil {
+			break
+		} else {
+			nextToken = output.NextToken
+		}
+	}
+	return result, nil
+}
@@ -0,0 +1,77 @@
+/*
+ * Copyright 2023 steadybit GmbH. All rights reserved.
+ */
+
+package extlambda
+
+import (
+	"errors"
+	"github.com/steadybit/discovery-kit/go/discovery_kit_api"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestAttributesCache(t *testing.T) {
+	now := time.Date(2023, 6, 1, 12, 0, 0, 0, time.UTC)
+	cache := newAttributesCache()
+	cache.now = func() time.Time { return now }
+
+	loads := 0
+	load := func(values ...string) func() (map[string][]string, error) {
+		return func() (map[string][]string, error) {
+			loads++
+			return map[string][]string{"aws.lambda.alias": values}, nil
+		}
+	}
+	target := func(id string) discovery_kit_api.Target {
+		return discovery_kit_api.Target{Id: id, Attributes: map[string][]string{}}
+	}
+
+	first := target("a")
+	refreshes := 1
+	cache.apply(&first, &refreshes, load("live"))
+	if loads != 1 || refreshes != 0 || !reflect.DeepEqual(first.Attributes["aws.lambda.alias"], []string{"live"}) {
+		t.Fatalf("first run: loads = %d, refreshes = %d, attributes = %v", loads, refreshes, first.Attributes)
+	}
+
+	withoutRefreshes := target("b")
+	cache.apply(&withoutRefreshes, &refreshes, load("live"))
+	if loads != 1 || len(withoutRefreshes.Attributes) != 0 {
+		t.Errorf("exhausted refreshes must not load: loads = %d, attributes = %v", loads, withoutRefreshes.Attributes)
+	}
+
+	cached := target("a")
+	refreshes = 1
+	cache.apply(&cached, &refreshes, load("changed"))
+	if loads != 1 || refreshes != 1 || !reflect.DeepEqual(cached.Attributes["aws.lambda.alias"], []string{"live"}) {
+		t.Errorf("cached run: loads = %d, attributes = %v", loads, cached.Attributes)
+	}
+
+	now = now.Add(2 * functionDetailsTtl)
+	stale := target("a")
+	refreshes = 0
+	cache.apply(&stale, &refreshes, load("changed"))
+	if loads != 1 || !reflect.DeepEqual(stale.Attributes["aws.lambda.alias"], []string{"live"}) {
+		t.Errorf("expired entries must be used until refreshed: loads = %d, attributes = %v", loads, stale.Attributes)
+	}
+
+	failed := target("a")
+	refreshes = 1
+	cache.apply(&failed, &refreshes, func() (map[string][]string, error) { return nil, errors.New("throttled") })
+	if !reflect.DeepEqual(failed.Attributes["aws.lambda.alias"], []string{"live"}) {
+		t.Errorf("failed refreshes must keep the expired entry: attributes = %v", failed.Attributes)
+	}
+
+	refreshed := target("a")
+	refreshes = 1
+	cache.apply(&refreshed, &refreshes, load("changed"))
+	if loads != 2 || !reflect.DeepEqual(refreshed.Attributes["aws.lambda.alias"], []string{"changed"}) {
+		t.Errorf("refreshed run: loads = %d, attributes = %v", loads, refreshed.Attributes)
+	}
+
+	cache.retain([]discovery_kit_api.Target{target("b")})
+	if len(cache.entries) != 0 {
+		t.Errorf("retain kept entries of removed functions: %v", cache.entries)
+	}
+}
@@ -0,0 +1,184 @@
+/*
+ * Copyright 2023 steadybit GmbH. All rights reserved.
+ */
+
+package extlambda
+
+import (
+	"context"
+	"fmt"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/lambda"
+	"github.com/aws/aws-sdk-go-v2/service/lambda/types"
+	"github.com/steadybit/action-kit/go/action_kit_api/v2"
+	"github.com/steadybit/extension-blog/extconfig"
+	extension_kit "github.com/steadybit/extension-kit"
+	"github.com/steadybit/extension-kit/extutil"
+)
+
+const (
+	failureInjectionParamVariable = "FAILURE_INJECTION_PARAM"
+	execWrapperVariable           = "AWS_LAMBDA_EXEC_WRAPPER"
+	maxLayersPerFunction          = 5
+)
+
+// failureInjectionAttachment describes the failure injection layer and wrapper temporarily added to a function.
+type failureInjectionAttachment struct {
+	FunctionArn string `json:"functionArn"`
+	LayerArn    string `json:"layerArn"`
+	Wrapper     string `json:"wrapper"`
+	Param       string `json:"param"`
+	// LayerPresent is set if the function already used the layer, which is kept on detach then
+	LayerPresent bool `json:"layerPresent,omitempty"`
+}
+
+// prepareFailureInjectionAttachment checks whether the configured layer can be attached to the target function.
+func prepareFailureInjectionAttachment(ctx context.Context, target *action_kit_api.Target) (*failureInjectionAttachment, *extension_kit.ExtensionError) {
+	if extconfig.Config.FailureInjectionLayerArn == "" {
+		return nil, extutil.Ptr(extension_kit.ToError("No failure injection layer is configured. Set STEADYBIT_EXTENSION_FAILURE_INJECTION_LAYER_ARN.", nil))
+	}
+	functionArn := targetAttribute(target, "aws.arn")
+	if functionArn == "" {
+		return nil, extutil.Ptr(extension_kit.ToError("Target is missing the 'aws.arn' attribute.", nil))
+	}
+
+	client, err := createLambdaClient(ctx)
+	if err != nil {
+		return nil, extutil.Ptr(extension_kit.ToError("Failed to create lambda client", err))
+	}
+
+	function, err := client.GetFunctionConfiguration(ctx, &lambda.GetFunctionConfigurationInput{
+		FunctionName: extutil.Ptr(functionArn),
+	})
+	if err != nil {
+		return nil, extutil.Ptr(extension_kit.ToError("Failed to get function configuration", err))
+	}
+	if function.PackageType == types.PackageTypeImage {
+		return nil, extutil.Ptr(extension_kit.ToError("Layers cannot be attached to functions packaged as container image.", nil))
+	}
+	layerPresent := false
+	for _, layer := range function.Layers {
+		if aws.ToString(layer.Arn) == extconfig.Config.FailureInjectionLayerArn {
+			layerPresent = true
+		}
+	}
+	if !layerPresent && len(function.Layers) >= maxLayersPerFunction {
+		return nil, extutil.Ptr(extension_kit.ToError(fmt.Sprintf("Function %s already uses %d layers.", functionArn, len(function.Layers)), nil))
+	}
+	if function.Environment != nil && function.Environment.Variables[execWrapperVariable] != "" {
+		return nil, extutil.Ptr(extension_kit.ToError(fmt.Sprintf("Function %s already uses the wrapper %s.", functionArn, function.Environment.Variables[execWrapperVariable]), nil))
+	}
+
+	layer, err := client.GetLayerVersionByArn(ctx, &lambda.GetLayerVersionByArnInput{
+		Arn: extutil.Ptr(extconfig.Config.FailureInjectionLayerArn),
+	})
+	if err != nil {
+		return nil, extutil.Ptr(extension_kit.ToError("Failed to get failure injection layer", err))
+	}
+	if len(layer.CompatibleRuntimes) > 0 && !contains(layer.CompatibleRuntimes, function.Runtime) {
+		return nil, extutil.Ptr(extension_kit.ToError(fmt.Sprintf("The failure injection layer does not support runtime %s.", function.Runtime), nil))
+	}
+	for _, architecture := range function.Architectures {
+		if len(layer.CompatibleArchitectures) > 0 && !contains(layer.CompatibleArchitectures, architecture) {
+			return nil, extutil.Ptr(extension_kit.ToError(fmt.Sprintf("The failure injection layer does not support architecture %s.", architecture), nil))
+		}
+	}
+
+	return &failureInjectionAttachment{
+		FunctionArn:  functionArn,
+		LayerArn:     extconfig.Config.FailureInjectionLayerArn,
+		Wrapper:      extconfig.Config.FailureInjectionWrapper,
+		Param:        onboardedParam(aws.ToString(function.FunctionName)),
+		LayerPresent: layerPresent,
+	}, nil
+}
+
+// attachFailureInjection adds the layer and points the wrapper to the failure injection parameter.
+func attachFailureInjection(ctx context.Context, attachment failureInjectionAttachment) error {
+	client, err := createLambdaClient(ctx)
+	if err != nil {
+		return err
+	}
+
+	current, err := waitForFunctionUpdated(ctx, client, attachment.FunctionArn)
+	if err != nil {
+		return err
+	}
+
+	layers := make([]string, 0, len(current.Layers)+1)
+	for _, layer := range current.Layers {
+		if aws.ToString(layer.Arn) != attachment.LayerArn {
+			layers = append(layers, aws.ToString(layer.Arn))
+		}
+	}
+	layers = append(layers, attachment.LayerArn)
+
+	variables := map[string]string{}
+	if current.Environment != nil {
+		for key, value := range current.Environment.Variables {
+			variables[key] = value
+		}
+	}
+	variables[execWrapperVariable] = attachment.Wrapper
+	variables[failureInjectionParamVariable] = attachment.Param
+
+	_, err = client.UpdateFunctionConfiguration(ctx, &lambda.UpdateFunctionConfigurationInput{
+		FunctionName: extutil.Ptr(attachment.FunctionArn),
+		Layers:       layers,
+		Environment:  &types.Environment{Variables: variables},
+		RevisionId:   current.RevisionId,
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = waitForFunctionUpdated(ctx, client, attachment.FunctionArn)
+	return err
+}
+
+// detachFailureInjection removes the layer and the variables again. Variables changed by someone else in the meantime are kept,
+// as is the layer if the function used it before.
+func detachFailureInjection(ctx context.Context, attachment failureInjectionAttachment) error {
+	client, err := createLambdaClient(ctx)
+	if err != nil {
+		return err
+	}
+
+	current, err := waitForFunctionUpdated(ctx, client, attachment.FunctionArn)
+	if err != nil {
+		return err
+	}
+
+	layers := make([]string, 0, len(current.Layers))
+	for _, layer := range current.Layers {
+		if attachment.LayerPresent || aws.ToString(layer.Arn) != attachment.LayerArn {
+			layers = append(layers, aws.ToString(layer.Arn))
+		}
+	}
+
+	variables := map[string]string{}
+	if current.Environment != nil {
+		for key, value := range current.Environment.Variables {
+			variables[key] = value
+		}
+	}
+	if variables[execWrapperVariable] == attachment.Wrapper {
+		delete(variables, execWrapperVariable)
+	}
+	if variables[failureInjectionParamVariable] == attachment.Param {
+		delete(variables, failureInjectionParamVariable)
+	}
+
+	_, err = client.UpdateFunctionConfiguration(ctx, &lambda.UpdateFunctionConfigurationInput{
+		FunctionName: extutil.Ptr(attachment.FunctionArn),
+		Layers:       layers,
+		Environment:  &types.Environment{Variables: variables},
+		RevisionId:   current.RevisionId,
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = waitForFunctionUpdated(ctx, client, attachment.FunctionArn)
+	return err
+}
@@ -0,0 +1,260 @@
+/*
+ * Copyright 2023 steadybit GmbH. All rights reserved.
+ */
+
+package extlambda
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/lambda"
+	"github.com/aws/aws-sdk-go-v2/service/lambda/types"
+	"github.com/aws/aws-sdk-go-v2/service/ssm"
+	ssmtypes "github.com/aws/aws-sdk-go-v2/service/ssm/types"
+	"github.com/rs/zerolog/log"
+	"github.com/steadybit/extension-blog/extconfig"
+	extension_kit "github.com/steadybit/extension-kit"
+	"github.com/steadybit/extension-kit/exthttp"
+	"github.com/steadybit/extension-kit/extutil"
+	"net/http"
+	"os"
+)
+
+const onboardingBasePath = basePath + "/onboarding"
+
+type OnboardingRequest struct {
+	// Functions are the names or ARNs of the functions to onboard.
+	Functions []string `json:"functions"`
+	// All onboards every function of the account and region instead of the given functions.
+	All bool `json:"all"`
+}
+
+type OnboardingResult struct {
+	Functions []OnboardedFunction `json:"functions"`
+}
+
+type OnboardedFunction struct {
+	FunctionArn string `json:"functionArn"`
+	Param       string `json:"param,omitempty"`
+	// ParamCreated is false if the parameter existed before and was left untouched
+	ParamCreated bool `json:"paramCreated"`
+	// VariableSet is false if the function already pointed to a parameter
+	VariableSet    bool   `json:"variableSet"`
+	WrapperMissing bool   `json:"wrapperMissing"`
+	Error          string `json:"error,omitempty"`
+}
+
+func RegisterOnboardingHandlers() {
+	exthttp.RegisterHttpHandler(onboardingBasePath, handleOnboarding)
+}
+
+func handleOnboarding(w http.ResponseWriter, r *http.Request, body []byte) {
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	var request OnboardingRequest
+	if len(body) > 0 {
+		err := json.Unmarshal(body, &request)
+		if err != nil {
+			exthttp.WriteError(w, extension_kit.ToError("Failed to parse request body", err))
+			return
+		}
+	}
+
+	result, err := Onboard(r.Context(), request.Functions, request.All)
+	if err != nil {
+		exthttp.WriteError(w, extension_kit.ToError("Failed to onboard functions", err))
+		return
+	}
+	exthttp.WriteBody(w, result)
+}
+
+// RunOnboardingCommand onboards the functions given as arguments, or all functions given "--all", and prints the result to stdout.
+func RunOnboardingCommand(args []string) {
+	all := len(args) == 1 && args[0] == "--all"
+	if all {
+		args = nil
+	}
+	result, err := Onboard(context.Background(), args, all)
+	if err != nil {
+		log.Fatal().Err(err).Msg("Failed to onboard functions.")
+	}
+
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	err = encoder.Encode(result)
+	if err != nil {
+		log.Fatal().Err(err).Msg("Failed to print onboarding result.")
+	}
+	for _, function := range result.Functions {
+		if function.Error != "" {
+			os.Exit(1)
+		}
+	}
+}
+
+// Onboard prepares the selected functions for failure injection: Each function gets an SSM parameter named after the
+// configured prefix and the function name holding a disabled config, which is referenced by FAILURE_INJECTION_PARAM.
+// Existing parameters and variables are never overwritten. Onboarding every function of the account has to be requested explicitly with all.
+func Onboard(ctx context.Context, selection []string, all bool) (*OnboardingResult, error) {
+	if len(selection) == 0 && !all {
+		return nil, errors.New("no functions selected, select all explicitly to onboard every function of the account and region")
+	}
+	if len(selection) > 0 && all {
+		return nil, errors.New("functions must not be selected when onboarding all functions")
+	}
+
+	client, err := createLambdaClient(ctx)
+	if err != nil {
+		return nil, err
+	}
+	ssmClient, err := createSsmClient(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	functions, err := listFunctions(ctx, client)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &OnboardingResult{Functions: make([]OnboardedFunction, 0)}
+	found := make(map[string]bool)
+	for _, function := range functions {
+		name := aws.ToString(function.FunctionName)
+		arn := aws.ToString(function.FunctionArn)
+		if !all && !contains(selection, name) && !contains(selection, arn) {
+			continue
+		}
+		found[name] = true
+		found[arn] = true
+
+		onboarded := onboardFunction(ctx, client, ssmClient, function)
+		if onboarded.Error != "" {
+			log.Warn().Msgf("Failed to onboard %s: %s", arn, onboarded.Error)
+		}
+		result.Functions = append(result.Functions, onboarded)
+	}
+
+	for _, selected := range selection {
+		if !found[selected] {
+			result.Functions = append(result.Functions, OnboardedFunction{
+				FunctionArn: selected,
+				Error:       "function not found",
+			})
+		}
+	}
+	return result, nil
+}
+
+func onboardFunction(ctx context.Context, client *lambda.Client, ssmClient *ssm.Client, function types.FunctionConfiguration) OnboardedFunction {
+	arn := aws.ToString(function.FunctionArn)
+	result := OnboardedFunction{
+		FunctionArn:    arn,
+		WrapperMissing: !hasFailureInjectionWrapper(function),
+	}
+
+	if function.Environment != nil && function.Environment.Variables[failureInjectionParamVariable] != "" {
+		result.Param = function.Environment.Variables[failureInjectionParamVariable]
+	} else {
+		result.Param = onboardedParam(aws.ToString(function.FunctionName))
+		result.VariableSet = true
+	}
+
+	created, err := createBaselineParameter(ctx, ssmClient, result.Param)
+	if err != nil {
+		result.Error = fmt.Sprintf("failed to create parameter %s: %s", result.Param, err.Error())
+		return result
+	}
+	result.ParamCreated = created
+
+	if result.VariableSet {
+		_, err = waitForFunctionUpdated(ctx, client, arn)
+		if err == nil {
+			err = setEnvironmentVariables(ctx, client, arn, map[string]*string{failureInjectionParamVariable: extutil.Ptr(result.Param)})
+		}
+		if err != nil {
+			result.VariableSet = false
+			result.Error = fmt.Sprintf("failed to set %s: %s", failureInjectionParamVariable, err.Error())
+		}
+	}
+	return result
+}
+
+// onboardedParam is the parameter the onboarding creates for the function. It is kept after attacks.
+func onboardedParam(functionName string) string {
+	return extconfig.Config.FailureInjectionParamPrefix + functionName
+}
+
+// hasFailureInjectionWrapper reports whether the function's $LATEST uses the configured failure injection wrapper.
+// The layer alone does not inject failures, and other exec wrappers, e.g. of tracing agents, do not either.
+func hasFailureInjectionWrapper(function types.FunctionConfiguration) bool {
+	wrapper := extconfig.Config.FailureInjectionWrapper
+	return wrapper != "" && function.Environment != nil && function.Environment.Variables[execWrapperVariable] == wrapper
+}
+
+// createBaselineParameter creates the parameter with a disabled config. It returns false if the parameter already existed.
+func createBaselineParameter(ctx context.Context, client *ssm.Client, param string) (bool, error) {
+	return putBaselineParameter(ctx, client, param, false)
+}
+
+// putBaselineParameter writes the disabled config. Without overwrite, existing parameters are kept and false is returned.
+func putBaselineParameter(ctx context.Context, client *ssm.Client, param string, overwrite bool) (bool, error) {
+	value, err := json.Marshal(failureInjectionConfig{
+		FailureMode: "statuscode",
+		Rate:        1,
+		StatusCode:  500,
+		IsEnabled:   false,
+	})
+	if err != nil {
+		return false, err
+	}
+
+	input := &ssm.PutParameterInput{
+		Name:        extutil.Ptr(param),
+		Value:       extutil.Ptr(string(value)),
+		Type:        ssmtypes.ParameterTypeString,
+		DataType:    extutil.Ptr("text"),
+		Description: extutil.Ptr("lambda failure injection config - set by steadybit"),
+		Overwrite:   extutil.Ptr(overwrite),
+	}
+	if !overwrite {
+		// Tags can only be passed when creating a parameter
+		input.Tags = []ssmtypes.Tag{{Key: extutil.Ptr("created-by"), Value: extutil.Ptr("steadybit")}}
+	}
+	_, err = client.PutParameter(ctx, input)
+	if err != nil {
+		var alreadyExists *ssmtypes.ParameterAlreadyExists
+		if errors.As(err, &alreadyExists) {
+			return false, nil
+		}
+		return false, err
+	}
+	return true, nil
+}
+
+func listFunctions(ctx context.Context, client *lambda.Client) ([]types.FunctionConfiguration, error) {
+	result := make([]types.FunctionConfiguration, 0)
+	var marker *string = nil
+	for {
+		output, err := client.ListFunctions(ctx, &lambda.ListFunctionsInput{
+			Marker: marker,
+		})
+		if err != nil {
+			return result, err
+		}
+		result = append(result, output.Functions...)
+
+		if output.NextMarker == nil {
+			break
+		} else {
+			marker = output.NextMarker
+		}
+	}
+	return result, nil
+}
@@ -0,0 +1,72 @@
+/*
+ * Copyright 2023 steadybit GmbH. All rights reserved.
+ */
+
+package extlambda
+
+import (
+	"context"
+	"github.com/aws/aws-sdk-go-v2/service/lambda/types"
+	"github.com/steadybit/extension-blog/extconfig"
+	"github.com/steadybit/extension-kit/extutil"
+	"testing"
+)
+
+func TestOnboardRequiresExplicitSelection(t *testing.T) {
+	if _, err := Onboard(context.Background(), nil, false); err == nil {
+		t.Errorf("Onboard() without selection must fail")
+	}
+	if _, err := Onboard(context.Background(), []string{"my-function"}, true); err == nil {
+		t.Errorf("Onboard() with selection and all must fail")
+	}
+}
+
+func TestHasFailureInjectionWrapper(t *testing.T) {
+	original := extconfig.Config
+	defer func() { extconfig.Config = original }()
+	extconfig.Config.FailureInjectionWrapper = "/opt/failure-injection-wrapper"
+	extconfig.Config.FailureInjectionLayerArn = "arn:aws:lambda:eu-central-1:123456789012:layer:failure-injection:3"
+
+	withWrapper := func(wrapper string) *types.EnvironmentResponse {
+		return &types.EnvironmentResponse{Variables: map[string]string{execWrapperVariable: wrapper}}
+	}
+	tests := []struct {
+		name     string
+		function types.FunctionConfiguration
+		want     bool
+	}{
+		{name: "failure injection wrapper", function: types.FunctionConfiguration{Environment: withWrapper("/opt/failure-injection-wrapper")}, want: true},
+		{name: "other wrapper", function: types.FunctionConfiguration{Environment: withWrapper("/opt/otel-instrument")}, want: false},
+		{name: "no wrapper", function: types.FunctionConfiguration{}, want: false},
+		{
+			name:     "failure injection layer without wrapper",
+			function: types.FunctionConfiguration{Layers: []types.Layer{{Arn: extutil.Ptr("arn:aws:lambda:eu-central-1:123456789012:layer:failure-injection:2")}}},
+			want:     false,
+		},
+		{
+			name: "failure injection layer and wrapper",
+			function: types.FunctionConfiguration{
+				Layers:      []types.Layer{{Arn: extutil.Ptr("arn:aws:lambda:eu-central-1:123456789012:layer:failure-injection:3")}},
+				Environment: withWrapper("/opt/failure-injection-wrapper"),
+			},
+			want: true,
+		},
+		{
+			name:     "other layer",
+			function: types.FunctionConfiguration{Layers: []types.Layer{{Arn: extutil.Ptr("arn:aws:lambda:eu-central-1:464622532012:layer:Datadog-Extension:45")}}},
+			want:     false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := hasFailureInjectionWrapper(tt.function); got != tt.want {
+				t.Errorf("hasFailureInjectionWrapper() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	extconfig.Config.FailureInjectionWrapper = ""
+	if hasFailureInjectionWrapper(types.FunctionConfiguration{Environment: &types.EnvironmentResponse{Variables: map[string]string{}}}) {
+		t.Errorf("an unset wrapper config must not match functions without wrapper")
+	}
+}
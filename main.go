@@ -8,6 +8,7 @@ import (
 	"github.com/steadybit/extension-blog/extlambda"
 	"github.com/steadybit/extension-kit/exthttp"
 	"github.com/steadybit/extension-kit/extlogging"
+	"os"
 )
 
 func main() {
@@ -25,6 +26,12 @@ func main() {
 	extconfig.ParseConfiguration()
 	extconfig.ValidateConfiguration()
 
+	// Instead of serving the extension, "onboard <function...|--all>" prepares functions for failure injection and exits.
+	if len(os.Args) > 1 && os.Args[1] == "onboard" {
+		extlambda.RunOnboardingCommand(os.Args[2:])
+		return
+	}
+
 	// This call registers a handler for the extension's root path. This is the path initially accessed
 	// by the Steadybit agent to obtain the extension's capabilities.
 	exthttp.RegisterHttpHandler("/", exthttp.GetterAsHandler(getExtensionList))
@@ -34,6 +41,7 @@ func main() {
 	// you do not have a need for all of them.
 	extlambda.RegisterDiscoveryHandlers()
 	extlambda.RegisterActionHandlers()
+	extlambda.RegisterOnboardingHandlers()
 
 	exthttp.Listen(exthttp.ListenOpts{
 		// This is the default port under which your extension is accessible.